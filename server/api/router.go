@@ -141,6 +141,7 @@ func createRouter(prefix string, svr *server.Server) *mux.Router {
 	registerFunc(apiRouter, "/schedulers", schedulerHandler.CreateScheduler, setMethods(http.MethodPost))
 	registerFunc(apiRouter, "/schedulers/{name}", schedulerHandler.DeleteScheduler, setMethods(http.MethodDelete))
 	registerFunc(apiRouter, "/schedulers/{name}", schedulerHandler.PauseOrResumeScheduler, setMethods(http.MethodPost))
+	registerFunc(apiRouter, "/schedulers/{name}/simulate", schedulerHandler.SimulateScheduler, setMethods(http.MethodPost))
 
 	schedulerConfigHandler := newSchedulerConfigHandler(svr, rd)
 	registerPrefix(apiRouter, "/scheduler-config", schedulerConfigHandler.GetSchedulerConfig)
@@ -263,6 +264,13 @@ func createRouter(prefix string, svr *server.Server) *mux.Router {
 	registerFunc(clusterRouter, "/regions/split", regionsHandler.SplitRegions, setMethods(http.MethodPost), setAuditBackend(localLog))
 	registerFunc(clusterRouter, "/regions/range-holes", regionsHandler.GetRangeHoles, setMethods(http.MethodGet))
 	registerFunc(clusterRouter, "/regions/replicated", regionsHandler.CheckRegionsReplicated, setMethods(http.MethodGet), setQueries("startKey", "{startKey}", "endKey", "{endKey}"))
+	registerFunc(clusterRouter, "/regions/{id}/rules", rulesHandler.ExplainRulesByRegion, setMethods(http.MethodGet))
+	registerFunc(clusterRouter, "/regions/{id}/rules/override", rulesHandler.GetRegionRuleOverride, setMethods(http.MethodGet))
+	registerFunc(clusterRouter, "/regions/{id}/rules/override", rulesHandler.SetRegionRuleOverride, setMethods(http.MethodPut), setAuditBackend(localLog))
+	registerFunc(clusterRouter, "/regions/{id}/rules/override", rulesHandler.DeleteRegionRuleOverride, setMethods(http.MethodDelete), setAuditBackend(localLog))
+	registerFunc(clusterRouter, "/regions/fit/dump", rulesHandler.DumpRegionFits, setMethods(http.MethodGet))
+	registerFunc(clusterRouter, "/regions/{id}/fit", rulesHandler.GetRegionFit, setMethods(http.MethodGet))
+	registerFunc(clusterRouter, "/regions/{id}/isolation", rulesHandler.GetRegionIsolation, setMethods(http.MethodGet))
 
 	registerFunc(apiRouter, "/version", newVersionHandler(rd).GetVersion, setMethods(http.MethodGet))
 	registerFunc(apiRouter, "/status", newStatusHandler(svr, rd).GetPDStatus, setMethods(http.MethodGet))
@@ -16,18 +16,24 @@ package api
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
 	"github.com/tikv/pd/pkg/apiutil"
 	"github.com/tikv/pd/pkg/errs"
 	"github.com/tikv/pd/server"
+	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/schedule/placement"
 	"github.com/unrolled/render"
+	"go.uber.org/zap"
 )
 
 var errPlacementDisabled = errors.New("placement rules feature is disabled")
@@ -125,24 +131,264 @@ func (h *ruleHandler) GetRuleByGroup(w http.ResponseWriter, r *http.Request) {
 // @Failure  412  {string}  string  "Placement rules feature is disabled."
 // @Router   /config/rules/region/{region} [get]
 func (h *ruleHandler) GetRulesByRegion(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["region"])
+	if region == nil {
+		return
+	}
+	rules := getCluster(r).GetRuleManager().GetRulesForApplyRegion(region)
+	h.rd.JSON(w, http.StatusOK, rules)
+}
+
+// @Tags     rule
+// @Summary  Explain the rules applied to a region, whether they are overridden for the region, and the rules covering the region but not applied.
+// @Param    id  path  integer  true  "Region Id"
+// @Produce  json
+// @Success  200  {object}  placement.AppliedRules
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Router   /regions/{id}/rules [get]
+func (h *ruleHandler) ExplainRulesByRegion(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, getCluster(r).GetRuleManager().ExplainRulesForApplyRegion(region))
+}
+
+type regionFitStats struct {
+	SearchIterations int     `json:"search_iterations"`
+	DurationMs       float64 `json:"duration_ms"`
+	Truncated        bool    `json:"truncated"`
+}
+
+type regionFit struct {
+	*placement.RegionFit
+	Stats *regionFitStats `json:"stats,omitempty"`
+}
+
+// @Tags     rule
+// @Summary  Get the fit of a region to the placement rules.
+// @Param    id     path   integer  true   "Region Id"
+// @Param    stats  query  boolean  false  "Whether to include the cost of fitting the region"
+// @Produce  json
+// @Success  200  {object}  regionFit
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Router   /regions/{id}/fit [get]
+func (h *ruleHandler) GetRegionFit(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	cluster := getCluster(r)
+	fit, stats := cluster.GetRuleManager().FitRegionWithStats(cluster, region)
+	resp := regionFit{RegionFit: fit}
+	if withStats, _ := strconv.ParseBool(r.URL.Query().Get("stats")); withStats {
+		resp.Stats = &regionFitStats{
+			SearchIterations: stats.SearchIterations,
+			DurationMs:       float64(stats.Duration) / float64(time.Millisecond),
+			Truncated:        fit.Truncated,
+		}
+	}
+	h.rd.JSON(w, http.StatusOK, resp)
+}
+
+type regionIsolation struct {
+	Total float64                   `json:"total"`
+	Rules []placement.RuleIsolation `json:"rules"`
+}
+
+// @Tags     rule
+// @Summary  Get the isolation scores of a region's current placement.
+// @Param    id  path  integer  true  "Region Id"
+// @Produce  json
+// @Success  200  {object}  regionIsolation
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Router   /regions/{id}/isolation [get]
+func (h *ruleHandler) GetRegionIsolation(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	cluster := getCluster(r)
+	fit := cluster.GetRuleManager().FitRegion(cluster, region)
+	h.rd.JSON(w, http.StatusOK, regionIsolation{Total: fit.TotalIsolationScore(), Rules: fit.IsolationBreakdown()})
+}
+
+// @Tags     rule
+// @Summary  Get the rules overriding the placement of a region.
+// @Param    id  path  integer  true  "Region Id"
+// @Produce  json
+// @Success  200  {array}   placement.Rule
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist or is not overridden."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Router   /regions/{id}/rules/override [get]
+func (h *ruleHandler) GetRegionRuleOverride(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	rules := getCluster(r).GetRuleManager().GetRuleOverride(region.GetID())
+	if rules == nil {
+		h.rd.JSON(w, http.StatusNotFound, fmt.Sprintf("region %d is not overridden", region.GetID()))
+		return
+	}
+	h.rd.JSON(w, http.StatusOK, rules)
+}
+
+// @Tags     rule
+// @Summary  Override the rules applied to a region.
+// @Accept   json
+// @Param    id     path  integer           true  "Region Id"
+// @Param    rules  body  []placement.Rule  true  "Rules replacing the ones applied by key range"
+// @Produce  json
+// @Success  200  {string}  string  "Override rules successfully."
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Failure  500  {string}  string  "PD server failed to proceed the request."
+// @Router   /regions/{id}/rules/override [put]
+func (h *ruleHandler) SetRegionRuleOverride(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	var rules []*placement.Rule
+	if err := apiutil.ReadJSONRespondError(h.rd, w, r.Body, &rules); err != nil {
+		return
+	}
+	cluster := getCluster(r)
+	if err := cluster.GetRuleManager().SetKeyType(h.svr.GetConfig().PDServerCfg.KeyType).
+		SetRuleOverride(region.GetID(), rules); err != nil {
+		if errs.ErrRuleContent.Equal(err) || errs.ErrHexDecodingString.Equal(err) {
+			h.rd.JSON(w, http.StatusBadRequest, err.Error())
+		} else {
+			h.rd.JSON(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	cluster.AddSuspectRegions(region.GetID())
+	h.rd.JSON(w, http.StatusOK, "Override rules successfully.")
+}
+
+// @Tags     rule
+// @Summary  Remove the rule override of a region.
+// @Param    id  path  integer  true  "Region Id"
+// @Produce  json
+// @Success  200  {string}  string  "Remove rule override successfully."
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  404  {string}  string  "The region does not exist."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Failure  500  {string}  string  "PD server failed to proceed the request."
+// @Router   /regions/{id}/rules/override [delete]
+func (h *ruleHandler) DeleteRegionRuleOverride(w http.ResponseWriter, r *http.Request) {
+	region := h.preCheckForRegion(w, r, mux.Vars(r)["id"])
+	if region == nil {
+		return
+	}
+	cluster := getCluster(r)
+	if err := cluster.GetRuleManager().DeleteRuleOverride(region.GetID()); err != nil {
+		h.rd.JSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cluster.AddSuspectRegions(region.GetID())
+	h.rd.JSON(w, http.StatusOK, "Remove rule override successfully.")
+}
+
+type regionFitDump struct {
+	RegionID uint64               `json:"region_id"`
+	Fit      *placement.RegionFit `json:"fit"`
+}
+
+// @Tags     rule
+// @Summary  Dump the fits of all regions to the placement rules.
+// @Param    format  query  string  false  "The format of the dump, json or pb"  default(json)
+// @Produce  json
+// @Produce  application/octet-stream
+// @Success  200  {array}   regionFitDump
+// @Failure  400  {string}  string  "The input is invalid."
+// @Failure  412  {string}  string  "Placement rules feature is disabled."
+// @Failure  500  {string}  string  "PD server failed to proceed the request."
+// @Router   /regions/fit/dump [get]
+func (h *ruleHandler) DumpRegionFits(w http.ResponseWriter, r *http.Request) {
 	cluster := getCluster(r)
 	if !cluster.GetOpts().IsPlacementRulesEnabled() {
 		h.rd.JSON(w, http.StatusPreconditionFailed, errPlacementDisabled.Error())
 		return
 	}
-	regionStr := mux.Vars(r)["region"]
+	manager := cluster.GetRuleManager()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		// The array is streamed element by element, so that the dump is never
+		// held in memory as a whole.
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		if _, err := io.WriteString(w, "["); err != nil {
+			return
+		}
+		for i, region := range cluster.GetRegions() {
+			if r.Context().Err() != nil {
+				return
+			}
+			if i > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return
+				}
+			}
+			if err := enc.Encode(regionFitDump{RegionID: region.GetID(), Fit: manager.FitRegion(cluster, region)}); err != nil {
+				log.Warn("failed to write the region fit", zap.Uint64("region-id", region.GetID()), errs.ZapError(err))
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		_, _ = io.WriteString(w, "]")
+	case "pb":
+		// The RegionFit messages are streamed one by one, each prefixed with
+		// its length, so that the dump is never held in memory as a whole.
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, region := range cluster.GetRegions() {
+			if r.Context().Err() != nil {
+				return
+			}
+			if err := placement.WriteRegionFit(w, region.GetID(), manager.FitRegion(cluster, region)); err != nil {
+				log.Warn("failed to write the region fit", zap.Uint64("region-id", region.GetID()), errs.ZapError(err))
+				return
+			}
+		}
+	default:
+		h.rd.JSON(w, http.StatusBadRequest, fmt.Sprintf("unsupported format %q", format))
+	}
+}
+
+// preCheckForRegion returns the region if placement rules are enabled and the
+// region exists. Otherwise, it writes the error response and returns nil.
+func (h *ruleHandler) preCheckForRegion(w http.ResponseWriter, r *http.Request, regionStr string) *core.RegionInfo {
+	cluster := getCluster(r)
+	if !cluster.GetOpts().IsPlacementRulesEnabled() {
+		h.rd.JSON(w, http.StatusPreconditionFailed, errPlacementDisabled.Error())
+		return nil
+	}
 	regionID, err := strconv.ParseUint(regionStr, 10, 64)
 	if err != nil {
 		h.rd.JSON(w, http.StatusBadRequest, "invalid region id")
-		return
+		return nil
 	}
 	region := cluster.GetRegion(regionID)
 	if region == nil {
 		h.rd.JSON(w, http.StatusNotFound, server.ErrRegionNotFound(regionID).Error())
-		return
+		return nil
 	}
-	rules := cluster.GetRuleManager().GetRulesForApplyRegion(region)
-	h.rd.JSON(w, http.StatusOK, rules)
+	return region
 }
 
 // @Tags     rule
@@ -213,7 +459,6 @@ func (h *ruleHandler) SetRule(w http.ResponseWriter, r *http.Request) {
 	if err := apiutil.ReadJSONRespondError(h.rd, w, r.Body, &rule); err != nil {
 		return
 	}
-	oldRule := cluster.GetRuleManager().GetRule(rule.GroupID, rule.ID)
 	if err := h.syncReplicateConfigWithDefaultRule(&rule); err != nil {
 		h.rd.JSON(w, http.StatusBadRequest, err.Error())
 		return
@@ -227,10 +472,6 @@ func (h *ruleHandler) SetRule(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	cluster.AddSuspectKeyRange(rule.StartKey, rule.EndKey)
-	if oldRule != nil {
-		cluster.AddSuspectKeyRange(oldRule.StartKey, oldRule.EndKey)
-	}
 	h.rd.JSON(w, http.StatusOK, "Update rule successfully.")
 }
 
@@ -263,15 +504,10 @@ func (h *ruleHandler) DeleteRuleByGroup(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	group, id := mux.Vars(r)["group"], mux.Vars(r)["id"]
-	rule := cluster.GetRuleManager().GetRule(group, id)
 	if err := cluster.GetRuleManager().DeleteRule(group, id); err != nil {
 		h.rd.JSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if rule != nil {
-		cluster.AddSuspectKeyRange(rule.StartKey, rule.EndKey)
-	}
-
 	h.rd.JSON(w, http.StatusOK, "Delete rule successfully.")
 }
 
@@ -350,12 +586,13 @@ func (h *ruleHandler) SetGroupConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := cluster.GetRuleManager().SetRuleGroup(&ruleGroup); err != nil {
-		h.rd.JSON(w, http.StatusInternalServerError, err.Error())
+		if errs.ErrRuleContent.Equal(err) {
+			h.rd.JSON(w, http.StatusBadRequest, err.Error())
+		} else {
+			h.rd.JSON(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
-	for _, r := range cluster.GetRuleManager().GetRulesByGroup(ruleGroup.ID) {
-		cluster.AddSuspectKeyRange(r.StartKey, r.EndKey)
-	}
 	h.rd.JSON(w, http.StatusOK, "Update rule group successfully.")
 }
 
@@ -379,9 +616,6 @@ func (h *ruleHandler) DeleteGroupConfig(w http.ResponseWriter, r *http.Request)
 		h.rd.JSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	for _, r := range cluster.GetRuleManager().GetRulesByGroup(id) {
-		cluster.AddSuspectKeyRange(r.StartKey, r.EndKey)
-	}
 	h.rd.JSON(w, http.StatusOK, "Delete rule group successfully.")
 }
 
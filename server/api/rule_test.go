@@ -15,17 +15,21 @@
 package api
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"testing"
 
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/pd/pkg/apiutil"
 	tu "github.com/tikv/pd/pkg/testutil"
 	"github.com/tikv/pd/server"
+	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/schedule/placement"
 )
 
@@ -443,6 +447,187 @@ func (suite *ruleTestSuite) TestGetAllByRegion() {
 	}
 }
 
+func (suite *ruleTestSuite) TestExplainRulesByRegion() {
+	re := suite.Require()
+	rule1 := placement.Rule{GroupID: "h", ID: "10", StartKeyHex: "4444", EndKeyHex: "5555", Role: "voter", Count: 1}
+	rule2 := placement.Rule{GroupID: "h", ID: "20", StartKeyHex: "6666", EndKeyHex: "7777", Role: "voter", Count: 1}
+	for _, rule := range []placement.Rule{rule1, rule2} {
+		data, err := json.Marshal(rule)
+		suite.NoError(err)
+		suite.NoError(tu.CheckPostJSON(testDialClient, suite.urlPrefix+"/rule", data, tu.StatusOK(re)))
+	}
+
+	r := newTestRegionInfo(6, 1, []byte{0x44, 0x44}, []byte{0x45, 0x45})
+	mustRegionHeartbeat(re, suite.svr, r)
+
+	urlPrefix := fmt.Sprintf("%s%s/api/v1/regions", suite.svr.GetAddr(), apiPrefix)
+	var resp placement.AppliedRules
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix+"/6/rules", &resp))
+	// rule2 does not cover the region.
+	suite.False(resp.Overridden)
+	suite.Len(resp.Rules, 2)
+	suite.Empty(resp.Shadowed)
+	for _, r := range resp.Rules {
+		if r.GroupID == "h" {
+			suite.compareRule(r, &rule1)
+		} else {
+			suite.Equal("pd", r.GroupID)
+		}
+	}
+
+	// The rules covering the region are shadowed by the override.
+	suite.NoError(suite.svr.GetRaftCluster().GetRuleManager().SetRuleOverride(6, []*placement.Rule{{GroupID: "pd", ID: "meta", Role: "voter", Count: 5}}))
+	defer func() {
+		suite.NoError(suite.svr.GetRaftCluster().GetRuleManager().DeleteRuleOverride(6))
+	}()
+	resp = placement.AppliedRules{}
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix+"/6/rules", &resp))
+	suite.True(resp.Overridden)
+	suite.Len(resp.Rules, 1)
+	suite.Equal("meta", resp.Rules[0].ID)
+	suite.Len(resp.Shadowed, 2)
+
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"/abc/rules", nil, tu.Status(re, http.StatusBadRequest)))
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"/7/rules", nil, tu.Status(re, http.StatusNotFound)))
+}
+
+func (suite *ruleTestSuite) TestGetRegionFit() {
+	re := suite.Require()
+	r := newTestRegionInfo(8, 1, []byte{0x55, 0x55}, []byte{0x56, 0x56})
+	mustRegionHeartbeat(re, suite.svr, r)
+
+	urlPrefix := fmt.Sprintf("%s%s/api/v1/regions", suite.svr.GetAddr(), apiPrefix)
+	var resp map[string]interface{}
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix+"/8/fit", &resp))
+	suite.Len(resp["RuleFits"], 1)
+	suite.NotContains(resp, "stats")
+
+	resp = nil
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix+"/8/fit?stats=true", &resp))
+	suite.Len(resp["RuleFits"], 1)
+	stats, ok := resp["stats"].(map[string]interface{})
+	suite.True(ok)
+	suite.GreaterOrEqual(stats["search_iterations"], float64(1))
+	suite.GreaterOrEqual(stats["duration_ms"], float64(0))
+	suite.Equal(false, stats["truncated"])
+
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"/9/fit", nil, tu.Status(re, http.StatusNotFound)))
+}
+
+func (suite *ruleTestSuite) TestGetRegionIsolation() {
+	re := suite.Require()
+	for id, zone := range map[uint64]string{11: "z1", 12: "z2", 13: "z2"} {
+		mustPutStore(re, suite.svr, id, metapb.StoreState_Up, metapb.NodeState_Serving, []*metapb.StoreLabel{{Key: "zone", Value: zone}})
+	}
+	rule := placement.Rule{GroupID: "pd", ID: "default", Role: "voter", Count: 3, LocationLabels: []string{"zone"}}
+	data, err := json.Marshal(rule)
+	suite.NoError(err)
+	suite.NoError(tu.CheckPostJSON(testDialClient, suite.urlPrefix+"/rule", data, tu.StatusOK(re)))
+
+	r := newTestRegionInfo(10, 11, []byte{0x57, 0x57}, []byte{0x58, 0x58}, core.SetPeers([]*metapb.Peer{
+		{Id: 10, StoreId: 11},
+		{Id: 101, StoreId: 12},
+		{Id: 102, StoreId: 13},
+	}))
+	mustRegionHeartbeat(re, suite.svr, r)
+
+	urlPrefix := fmt.Sprintf("%s%s/api/v1/regions", suite.svr.GetAddr(), apiPrefix)
+	var resp regionIsolation
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix+"/10/isolation", &resp))
+	// The peers on 11 and 12, 11 and 13 are in different zones.
+	suite.Equal(2.0, resp.Total)
+	suite.Equal([]placement.RuleIsolation{{GroupID: "pd", ID: "default", Score: 2}}, resp.Rules)
+
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"/abc/isolation", nil, tu.Status(re, http.StatusBadRequest)))
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"/11/isolation", nil, tu.Status(re, http.StatusNotFound)))
+}
+
+func (suite *ruleTestSuite) TestRegionRuleOverride() {
+	re := suite.Require()
+	r := newTestRegionInfo(50, 1, []byte{0x60}, []byte{0x61})
+	mustRegionHeartbeat(re, suite.svr, r)
+	url := fmt.Sprintf("%s%s/api/v1/regions/50/rules/override", suite.svr.GetAddr(), apiPrefix)
+	suite.NoError(tu.CheckGetJSON(testDialClient, url, nil, tu.Status(re, http.StatusNotFound)))
+
+	put := func(rules []*placement.Rule) int {
+		data, err := json.Marshal(rules)
+		suite.NoError(err)
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
+		suite.NoError(err)
+		resp, err := testDialClient.Do(req)
+		suite.NoError(err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	suite.Equal(http.StatusBadRequest, put(nil))
+	suite.Equal(http.StatusBadRequest, put([]*placement.Rule{{GroupID: "pd", ID: "meta", Role: "learner", Count: 1}}))
+	suite.Equal(http.StatusOK, put([]*placement.Rule{{GroupID: "pd", ID: "meta", Role: "voter", Count: 5}}))
+
+	var rules []*placement.Rule
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, url, &rules))
+	suite.Len(rules, 1)
+	suite.Equal("meta", rules[0].ID)
+	var fit map[string]interface{}
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, fmt.Sprintf("%s%s/api/v1/regions/50/fit", suite.svr.GetAddr(), apiPrefix), &fit))
+	suite.Len(fit["RuleFits"], 1)
+
+	statusCode, err := apiutil.DoDelete(testDialClient, url)
+	suite.NoError(err)
+	suite.Equal(http.StatusOK, statusCode)
+	suite.NoError(tu.CheckGetJSON(testDialClient, url, nil, tu.Status(re, http.StatusNotFound)))
+}
+
+func (suite *ruleTestSuite) TestDumpRegionFits() {
+	re := suite.Require()
+	for _, id := range []uint64{11, 12} {
+		mustPutStore(re, suite.svr, id, metapb.StoreState_Up, metapb.NodeState_Serving, nil)
+	}
+	for _, id := range []uint64{30, 40} {
+		r := newTestRegionInfo(id, 11, []byte{byte(id)}, []byte{byte(id + 1)}, core.SetPeers([]*metapb.Peer{
+			{Id: id, StoreId: 11},
+			{Id: id + 1, StoreId: 12},
+		}))
+		mustRegionHeartbeat(re, suite.svr, r)
+	}
+	urlPrefix := fmt.Sprintf("%s%s/api/v1/regions/fit/dump", suite.svr.GetAddr(), apiPrefix)
+
+	var dump []regionFitDump
+	suite.NoError(tu.ReadGetJSON(re, testDialClient, urlPrefix, &dump))
+	jsonPeers := make(map[uint64]int)
+	for _, d := range dump {
+		jsonPeers[d.RegionID] = len(d.Fit.OrphanPeers)
+		for _, rf := range d.Fit.RuleFits {
+			jsonPeers[d.RegionID] += len(rf.Peers)
+		}
+	}
+	suite.Equal(2, jsonPeers[30])
+	suite.Equal(2, jsonPeers[40])
+
+	// The binary dump has the same content.
+	resp, err := testDialClient.Get(urlPrefix + "?format=pb")
+	suite.NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal("application/octet-stream", resp.Header.Get("Content-Type"))
+	stream, err := io.ReadAll(resp.Body)
+	suite.NoError(err)
+	pbPeers := make(map[uint64]int)
+	for len(stream) > 0 {
+		var msg []byte
+		msg, stream, err = placement.NextDelimited(stream)
+		suite.NoError(err)
+		id, fit, err := placement.DecodeRegionFit(msg)
+		suite.NoError(err)
+		pbPeers[id] = len(fit.OrphanPeers)
+		for _, rf := range fit.RuleFits {
+			pbPeers[id] += len(rf.Peers)
+		}
+	}
+	suite.Equal(jsonPeers, pbPeers)
+
+	suite.NoError(tu.CheckGetJSON(testDialClient, urlPrefix+"?format=xml", nil, tu.Status(re, http.StatusBadRequest)))
+}
+
 func (suite *ruleTestSuite) TestGetAllByKey() {
 	rule := placement.Rule{GroupID: "f", ID: "40", StartKeyHex: "8888", EndKeyHex: "9111", Role: "voter", Count: 1}
 	data, err := json.Marshal(rule)
@@ -345,6 +345,45 @@ func (h *schedulerHandler) PauseOrResumeScheduler(w http.ResponseWriter, r *http
 	h.r.JSON(w, http.StatusOK, "Pause or resume the scheduler successfully.")
 }
 
+// maxSimulateRounds is the max rounds of a scheduler simulation.
+const maxSimulateRounds = 100
+
+type simulateSchedulerInput struct {
+	Rounds int      `json:"rounds"`
+	Args   []string `json:"args"`
+}
+
+// @Tags     scheduler
+// @Summary  Preview the operators a scheduler would create without adding them. If the scheduler is not added, a temporary one is created by the name and args.
+// @Accept   json
+// @Param    name  path  string  true   "The name of the scheduler."
+// @Param    body  body  object  false  "json params"
+// @Produce  json
+// @Success  200  {array}   string  "The operators the scheduler would create."
+// @Failure  400  {string}  string  "Bad format request."
+// @Failure  404  {string}  string  "The scheduler is not found."
+// @Failure  500  {string}  string  "PD server failed to proceed the request."
+// @Router   /schedulers/{name}/simulate [post]
+func (h *schedulerHandler) SimulateScheduler(w http.ResponseWriter, r *http.Request) {
+	input := simulateSchedulerInput{Rounds: 1}
+	if r.ContentLength != 0 {
+		if err := apiutil.ReadJSONRespondError(h.r, w, r.Body, &input); err != nil {
+			return
+		}
+	}
+	if input.Rounds <= 0 || input.Rounds > maxSimulateRounds {
+		h.r.JSON(w, http.StatusBadRequest, fmt.Sprintf("rounds should be in [1, %d]", maxSimulateRounds))
+		return
+	}
+
+	ops, err := h.Handler.SimulateScheduler(mux.Vars(r)["name"], input.Rounds, input.Args...)
+	if err != nil {
+		h.handleErr(w, err)
+		return
+	}
+	h.r.JSON(w, http.StatusOK, ops)
+}
+
 type schedulerConfigHandler struct {
 	svr *server.Server
 	rd  *render.Render
@@ -28,6 +28,7 @@ import (
 	tu "github.com/tikv/pd/pkg/testutil"
 	"github.com/tikv/pd/server"
 	"github.com/tikv/pd/server/config"
+	"github.com/tikv/pd/server/core"
 	_ "github.com/tikv/pd/server/schedulers"
 )
 
@@ -453,6 +454,43 @@ func (suite *scheduleTestSuite) TestDisable() {
 	suite.deleteScheduler(name)
 }
 
+func (suite *scheduleTestSuite) TestSimulate() {
+	re := suite.Require()
+	region := newTestRegionInfo(100, 1, []byte("a"), []byte("b"), core.WithAddPeer(&metapb.Peer{Id: 101, StoreId: 2}))
+	mustRegionHeartbeat(re, suite.svr, region)
+	rc := suite.svr.GetRaftCluster()
+
+	// The evict leader scheduler is not added, so a temporary one is simulated.
+	simulateURL := fmt.Sprintf("%s/%s/simulate", suite.urlPrefix, "evict-leader-scheduler")
+	// The later rounds pick the same region, whose operator is kept once.
+	body, err := json.Marshal(map[string]interface{}{"rounds": 3, "args": []string{"1"}})
+	suite.NoError(err)
+	var ops []string
+	suite.NoError(tu.CheckPostJSON(testDialClient, simulateURL, body, tu.StatusOK(re), tu.ExtractJSON(re, &ops)))
+	suite.Len(ops, 1)
+	suite.Contains(ops[0], "region:100(")
+	suite.Contains(ops[0], "evict leader: from store 1 to one in [2]")
+	suite.Empty(rc.GetSchedulers())
+	suite.Nil(rc.GetOperatorController().GetOperator(100))
+
+	// The scheduler already added is simulated.
+	input := map[string]interface{}{"name": "balance-leader-scheduler"}
+	body, err = json.Marshal(input)
+	suite.NoError(err)
+	suite.addScheduler(body)
+	simulateURL = fmt.Sprintf("%s/%s/simulate", suite.urlPrefix, "balance-leader-scheduler")
+	suite.NoError(tu.CheckPostJSON(testDialClient, simulateURL, nil, tu.StatusOK(re)))
+	suite.deleteScheduler("balance-leader-scheduler")
+
+	for _, rounds := range []int{0, maxSimulateRounds + 1} {
+		body, err = json.Marshal(map[string]interface{}{"rounds": rounds})
+		suite.NoError(err)
+		suite.NoError(tu.CheckPostJSON(testDialClient, simulateURL, body, tu.Status(re, http.StatusBadRequest)))
+	}
+	simulateURL = fmt.Sprintf("%s/%s/simulate", suite.urlPrefix, "foo-scheduler")
+	suite.NoError(tu.CheckPostJSON(testDialClient, simulateURL, nil, tu.Status(re, http.StatusNotFound)))
+}
+
 func (suite *scheduleTestSuite) addScheduler(body []byte) {
 	err := tu.CheckPostJSON(testDialClient, suite.urlPrefix, body, tu.StatusOK(suite.Require()))
 	suite.NoError(err)
@@ -15,6 +15,7 @@
 package cluster
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math"
@@ -48,6 +49,7 @@ import (
 	"github.com/tikv/pd/server/schedule/checker"
 	"github.com/tikv/pd/server/schedule/hbstream"
 	"github.com/tikv/pd/server/schedule/labeler"
+	"github.com/tikv/pd/server/schedule/operator"
 	"github.com/tikv/pd/server/schedule/placement"
 	"github.com/tikv/pd/server/schedulers"
 	"github.com/tikv/pd/server/statistics"
@@ -80,6 +82,10 @@ const (
 	persistLimitWaitTime   = 100 * time.Millisecond
 	removingAction         = "removing"
 	preparingAction        = "preparing"
+	// placementRulesAuditBudget is the time budget of the placement rules audit at startup.
+	placementRulesAuditBudget = time.Minute
+	// placementRulesAuditSamples is the max count of unsatisfied regions logged by the audit.
+	placementRulesAuditSamples = 20
 )
 
 // Server is the interface for cluster.
@@ -270,7 +276,7 @@ func (c *RaftCluster) Start(s Server) error {
 	c.regionStats = statistics.NewRegionStatistics(c.opt, c.ruleManager, c.storeConfigManager)
 	c.limiter = NewStoreLimiter(s.GetPersistOptions())
 
-	c.wg.Add(8)
+	c.wg.Add(9)
 	go c.runCoordinator()
 	go c.runMetricsCollectionJob()
 	go c.runNodeStateCheckJob()
@@ -279,6 +285,11 @@ func (c *RaftCluster) Start(s Server) error {
 	go c.runReplicationMode()
 	go c.runMinResolvedTSJob()
 	go c.runSyncConfig()
+	go c.runPlacementRulesSampling()
+	if c.opt.IsPlacementRulesEnabled() && c.opt.IsPlacementRulesAuditEnabled() {
+		c.wg.Add(1)
+		go c.runPlacementRulesAudit()
+	}
 	c.running = true
 
 	return nil
@@ -543,6 +554,12 @@ func (c *RaftCluster) PauseOrResumeScheduler(name string, t int64) error {
 	return c.coordinator.pauseOrResumeScheduler(name, t)
 }
 
+// SimulateScheduler runs a scheduler in dry run for the rounds, and returns
+// the operators it would create without adding them.
+func (c *RaftCluster) SimulateScheduler(name string, rounds int, args ...string) ([]*operator.Operator, error) {
+	return c.coordinator.simulateScheduler(name, rounds, args...)
+}
+
 // IsSchedulerPaused checks if a scheduler is paused.
 func (c *RaftCluster) IsSchedulerPaused(name string) (bool, error) {
 	return c.coordinator.isSchedulerPaused(name)
@@ -874,6 +891,8 @@ func (c *RaftCluster) processRegionHeartbeat(region *core.RegionInfo) error {
 		}
 	}
 
+	c.dropStaleRuleOverrides(origin, region, overlaps)
+
 	if saveKV || needSync {
 		select {
 		case changedRegions <- region:
@@ -884,6 +903,29 @@ func (c *RaftCluster) processRegionHeartbeat(region *core.RegionInfo) error {
 	return nil
 }
 
+// dropStaleRuleOverrides drops the rule overrides of the regions merged away,
+// and of the region whose key range is changed by a split or merge.
+func (c *RaftCluster) dropStaleRuleOverrides(origin, region *core.RegionInfo, overlaps []*core.RegionInfo) {
+	if c.ruleManager == nil {
+		return
+	}
+	regionIDs := make([]uint64, 0, len(overlaps)+1)
+	for _, item := range overlaps {
+		regionIDs = append(regionIDs, item.GetID())
+	}
+	if origin != nil && (!bytes.Equal(origin.GetStartKey(), region.GetStartKey()) || !bytes.Equal(origin.GetEndKey(), region.GetEndKey())) {
+		regionIDs = append(regionIDs, region.GetID())
+	}
+	if len(regionIDs) == 0 {
+		return
+	}
+	if err := c.ruleManager.DropRuleOverrides(regionIDs...); err != nil {
+		log.Error("failed to drop the rule overrides of the merged or split regions",
+			zap.Uint64s("region-ids", regionIDs),
+			errs.ZapError(err))
+	}
+}
+
 func (c *RaftCluster) updateStoreStatusLocked(id uint64) {
 	leaderCount := c.core.GetStoreLeaderCount(id)
 	regionCount := c.core.GetStoreRegionCount(id)
@@ -2151,6 +2193,83 @@ func (c *RaftCluster) runMinResolvedTSJob() {
 	}
 }
 
+// runPlacementRulesAudit checks all regions against the placement rules once,
+// to find out the regions left unsatisfied by the previous leader.
+func (c *RaftCluster) runPlacementRulesAudit() {
+	defer logutil.LogPanic()
+	defer c.wg.Done()
+	c.auditPlacementRules(c.ctx)
+}
+
+// runPlacementRulesSampling periodically samples regions to update the ratio of
+// regions satisfying the placement rules.
+func (c *RaftCluster) runPlacementRulesSampling() {
+	defer logutil.LogPanic()
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opt.GetPlacementRulesSampleInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			log.Info("placement rules sampling has been stopped")
+			return
+		case <-ticker.C:
+			if c.opt.IsPlacementRulesEnabled() {
+				c.samplePlacementRules()
+			}
+			ticker.Reset(c.opt.GetPlacementRulesSampleInterval())
+		}
+	}
+}
+
+// samplePlacementRules fits the randomly sampled regions and returns the ratio
+// of regions satisfying the placement rules. The regions are sampled from the
+// leaders of each store, so a region is sampled at most once.
+func (c *RaftCluster) samplePlacementRules() float64 {
+	size := c.opt.GetPlacementRulesSampleSize()
+	stores := c.GetStores()
+	if len(stores) == 0 {
+		return 0
+	}
+	perStore := (size + len(stores) - 1) / len(stores)
+	sampled := make(map[uint64]struct{}, size)
+	regions := make([]*core.RegionInfo, 0, size)
+	for _, store := range stores {
+		for _, region := range c.core.RandLeaderRegions(store.GetID(), []core.KeyRange{core.NewKeyRange("", "")}, perStore) {
+			if _, ok := sampled[region.GetID()]; ok || len(regions) >= size {
+				continue
+			}
+			sampled[region.GetID()] = struct{}{}
+			regions = append(regions, region)
+		}
+	}
+	return c.ruleManager.SampleSatisfiedRatio(c.core, regions)
+}
+
+func (c *RaftCluster) auditPlacementRules(ctx context.Context) *placement.AuditResult {
+	start := time.Now()
+	res := c.ruleManager.AuditRegions(ctx, c.core, func(startKey []byte, limit int) []*core.RegionInfo {
+		return c.ScanRegions(startKey, nil, limit)
+	}, placement.AuditOptions{
+		Budget:         placementRulesAuditBudget,
+		MaxSamples:     placementRulesAuditSamples,
+		Workers:        c.opt.GetPlacementRulesAuditWorkers(),
+		SampleFraction: c.opt.GetPlacementRulesAuditSampleFraction(),
+	})
+	log.Info("placement rules audit finished",
+		zap.Int("total", res.Total),
+		zap.Int("satisfied", res.Satisfied),
+		zap.Int("unsatisfied", res.Unsatisfied),
+		zap.Int("with-orphans", res.WithOrphans),
+		zap.Uint64s("unsatisfied-samples", res.Samples),
+		zap.Int("skipped", res.Skipped),
+		zap.Bool("truncated", res.Truncated),
+		zap.Bool("canceled", res.Canceled),
+		zap.Duration("cost", time.Since(start)))
+	return res
+}
+
 func (c *RaftCluster) loadMinResolvedTS() {
 	// Use `c.GetStorage()` here to prevent from the data race in test.
 	minResolvedTS, err := c.GetStorage().LoadMinResolvedTS()
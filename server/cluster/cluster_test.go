@@ -1158,6 +1158,45 @@ func TestHeartbeatSplit(t *testing.T) {
 	checkRegion(re, cluster.GetRegionByKey([]byte("n")), region3)
 }
 
+func TestDropRuleOverridesOnSplitAndMerge(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, opt, err := newTestScheduleConfig()
+	re.NoError(err)
+	opt.SetPlacementRuleEnabled(true)
+	cluster := newTestRaftCluster(ctx, mockid.NewIDAllocator(), opt, storage.NewStorageWithMemoryBackend(), core.NewBasicCluster())
+	cluster.coordinator = newCoordinator(ctx, cluster, nil)
+	override := []*placement.Rule{{GroupID: "pd", ID: "meta", Role: placement.Voter, Count: 3}}
+
+	// 1: [nil, m) 2: [m, nil)
+	region1 := core.NewRegionInfo(&metapb.Region{Id: 1, EndKey: []byte("m"), RegionEpoch: &metapb.RegionEpoch{Version: 1, ConfVer: 1}}, nil)
+	region2 := core.NewRegionInfo(&metapb.Region{Id: 2, StartKey: []byte("m"), RegionEpoch: &metapb.RegionEpoch{Version: 1, ConfVer: 1}}, nil)
+	re.NoError(cluster.processRegionHeartbeat(region1))
+	re.NoError(cluster.processRegionHeartbeat(region2))
+	re.NoError(cluster.ruleManager.SetRuleOverride(1, override))
+	re.NoError(cluster.ruleManager.SetRuleOverride(2, override))
+
+	// The override is kept while the key range is not changed.
+	region1 = region1.Clone(core.WithIncConfVer())
+	re.NoError(cluster.processRegionHeartbeat(region1))
+	re.NotNil(cluster.ruleManager.GetRuleOverride(1))
+
+	// split 1 to 3: [nil, g) 1: [g, m)
+	region1 = region1.Clone(core.WithStartKey([]byte("g")), core.WithIncVersion())
+	re.NoError(cluster.processRegionHeartbeat(region1))
+	re.Nil(cluster.ruleManager.GetRuleOverride(1))
+	re.NotNil(cluster.ruleManager.GetRuleOverride(2))
+
+	// merge 2 into 1: 1: [g, nil)
+	re.NoError(cluster.ruleManager.SetRuleOverride(1, override))
+	region1 = region1.Clone(core.WithEndKey(nil), core.WithIncVersion())
+	re.NoError(cluster.processRegionHeartbeat(region1))
+	re.Nil(cluster.ruleManager.GetRuleOverride(1))
+	re.Nil(cluster.ruleManager.GetRuleOverride(2))
+}
+
 func TestRegionSplitAndMerge(t *testing.T) {
 	re := require.New(t)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -1670,6 +1709,88 @@ type testCluster struct {
 	*RaftCluster
 }
 
+func TestAuditPlacementRules(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, opt, err := newTestScheduleConfig()
+	re.NoError(err)
+	opt.SetPlacementRuleEnabled(true)
+	opt.SetPlacementRulesAuditEnabled(true)
+	cluster := newTestRaftCluster(ctx, mockid.NewIDAllocator(), opt, storage.NewStorageWithMemoryBackend(), core.NewBasicCluster())
+	for _, store := range newTestStores(4, "2.0.0") {
+		re.NoError(cluster.PutStore(store.GetMeta()))
+	}
+
+	// Regions 1~4 are satisfied, regions 5~7 lack a peer, and regions 8~9
+	// have an extra orphan peer.
+	storeIDs := [][]uint64{nil, {1, 2, 3}, {1, 2, 3}, {2, 3, 4}, {1, 3, 4}, {1, 2}, {2, 3}, {3, 4}, {1, 2, 3, 4}, {1, 2, 3, 4}}
+	for id := uint64(1); id < uint64(len(storeIDs)); id++ {
+		meta := newTestRegionMeta(id)
+		for _, storeID := range storeIDs[id] {
+			meta.Peers = append(meta.Peers, &metapb.Peer{Id: id*10 + storeID, StoreId: storeID})
+		}
+		re.NoError(cluster.putRegion(core.NewRegionInfo(meta, meta.Peers[0])))
+	}
+
+	res := cluster.auditPlacementRules(ctx)
+	re.Equal(9, res.Total)
+	re.Equal(4, res.Satisfied)
+	re.Equal(5, res.Unsatisfied)
+	re.Equal(2, res.WithOrphans)
+	re.Equal([]uint64{5, 6, 7, 8, 9}, res.Samples)
+	re.False(res.Truncated)
+
+	// Half of the regions are checked by multiple workers.
+	cfg := opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesAuditWorkers = 4
+	cfg.PlacementRulesAuditSampleFraction = 0.5
+	opt.SetReplicationConfig(cfg)
+	res = cluster.auditPlacementRules(ctx)
+	re.Equal(4, res.Total)
+	re.Equal(5, res.Skipped)
+	re.Equal(2, res.Satisfied)
+	re.Equal([]uint64{6, 8}, res.Samples)
+
+	// The audit stops once the context is canceled.
+	canceledCtx, cancelAudit := context.WithCancel(ctx)
+	cancelAudit()
+	res = cluster.auditPlacementRules(canceledCtx)
+	re.True(res.Canceled)
+	re.Zero(res.Total)
+}
+
+func TestSamplePlacementRules(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, opt, err := newTestScheduleConfig()
+	re.NoError(err)
+	opt.SetPlacementRuleEnabled(true)
+	cfg := opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesSampleSize = 1000
+	opt.SetReplicationConfig(cfg)
+	cluster := newTestRaftCluster(ctx, mockid.NewIDAllocator(), opt, storage.NewStorageWithMemoryBackend(), core.NewBasicCluster())
+	for _, store := range newTestStores(4, "2.0.0") {
+		re.NoError(cluster.PutStore(store.GetMeta()))
+	}
+	re.Zero(cluster.samplePlacementRules())
+
+	// Regions 1~4 are satisfied, and regions 5~6 lack a peer.
+	storeIDs := [][]uint64{nil, {1, 2, 3}, {1, 2, 3}, {2, 3, 4}, {1, 3, 4}, {1, 2}, {2, 3}}
+	for id := uint64(1); id < uint64(len(storeIDs)); id++ {
+		meta := newTestRegionMeta(id)
+		for _, storeID := range storeIDs[id] {
+			meta.Peers = append(meta.Peers, &metapb.Peer{Id: id*10 + storeID, StoreId: storeID})
+		}
+		re.NoError(cluster.putRegion(core.NewRegionInfo(meta, meta.Peers[0])))
+	}
+	// The sample size is large enough to cover all regions.
+	re.InDelta(4.0/6, cluster.samplePlacementRules(), 1e-9)
+}
+
 func newTestScheduleConfig() (*config.ScheduleConfig, *config.PersistOptions, error) {
 	cfg := config.NewConfig()
 	cfg.Schedule.TolerantSizeRatio = 5
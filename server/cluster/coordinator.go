@@ -78,7 +78,7 @@ type coordinator struct {
 func newCoordinator(ctx context.Context, cluster *RaftCluster, hbStreams *hbstream.HeartbeatStreams) *coordinator {
 	ctx, cancel := context.WithCancel(ctx)
 	opController := schedule.NewOperatorController(ctx, cluster, hbStreams)
-	return &coordinator{
+	c := &coordinator{
 		ctx:             ctx,
 		cancel:          cancel,
 		cluster:         cluster,
@@ -91,6 +91,16 @@ func newCoordinator(ctx context.Context, cluster *RaftCluster, hbStreams *hbstre
 		hbStreams:       hbStreams,
 		pluginInterface: schedule.NewPluginInterface(),
 	}
+	if cluster.ruleManager != nil {
+		// The rule checker checks the regions in the key ranges of the changed
+		// rules again.
+		cluster.ruleManager.SetRefitHandler(func(_ uint64, keyRanges []core.KeyRange) {
+			for _, r := range keyRanges {
+				c.checkers.AddSuspectKeyRange(r.StartKey, r.EndKey)
+			}
+		})
+	}
+	return c
 }
 
 func (c *coordinator) GetWaitingRegions() []*cache.Item {
@@ -672,6 +682,11 @@ func (c *coordinator) removeScheduler(name string) error {
 		return err
 	}
 
+	if err := c.cluster.storage.RemoveScheduleState(name); err != nil {
+		log.Error("can not remove the scheduler state", errs.ZapError(err))
+		return err
+	}
+
 	s.Stop()
 	schedulerStatusGauge.DeleteLabelValues(name, "allow")
 	delete(c.schedulers, name)
@@ -733,6 +748,60 @@ func (c *coordinator) pauseOrResumeScheduler(name string, t int64) error {
 	return err
 }
 
+// simulateScheduler runs the scheduler in dry run for the rounds, and returns
+// the operators it would create without adding them. A scheduler already added
+// is simulated with its runtime state, such as the cooldowns, and does not run
+// meanwhile. Otherwise a temporary one of the type found by the name is created
+// with the args, and dropped afterwards.
+func (c *coordinator) simulateScheduler(name string, rounds int, args ...string) ([]*operator.Operator, error) {
+	c.RLock()
+	if c.cluster == nil {
+		c.RUnlock()
+		return nil, errs.ErrNotBootstrapped.FastGenByArgs()
+	}
+	sc, ok := c.schedulers[name]
+	c.RUnlock()
+	if ok {
+		return sc.Simulate(rounds), nil
+	}
+
+	typ := schedule.FindSchedulerTypeByName(name)
+	if typ == "" {
+		return nil, errs.ErrSchedulerNotFound.FastGenByArgs()
+	}
+	// The config of the temporary scheduler is not persisted.
+	s, err := schedule.CreateScheduler(typ, c.opController, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(typ, args))
+	if err != nil {
+		return nil, err
+	}
+	// The temporary scheduler is not prepared, and its cleanup may undo what
+	// the added schedulers do to the cluster, so only its subscriptions are
+	// dropped.
+	if u, ok := s.(schedule.OutcomeUnsubscriber); ok {
+		defer u.UnsubscribeOperatorOutcomes()
+	}
+	return simulateRounds(s, c.cluster, rounds), nil
+}
+
+// simulateRounds runs the scheduler in dry run for the rounds. The operators
+// are not applied between the rounds, so a later round only adds the operators
+// of the regions not picked yet, e.g. by a scheduler picking the regions
+// randomly. The first operator of each region is kept.
+func simulateRounds(s schedule.Scheduler, cluster *RaftCluster, rounds int) []*operator.Operator {
+	var ops []*operator.Operator
+	picked := make(map[uint64]struct{})
+	for i := 0; i < rounds; i++ {
+		created, _ := s.Schedule(newCacheCluster(cluster), true)
+		for _, op := range created {
+			if _, ok := picked[op.RegionID()]; !ok {
+				picked[op.RegionID()] = struct{}{}
+				ops = append(ops, op)
+			}
+		}
+	}
+	return ops
+}
+
 // isSchedulerAllowed returns whether a scheduler is allowed to schedule, a scheduler is not allowed to schedule if it is paused or blocked by unsafe recovery.
 func (c *coordinator) isSchedulerAllowed(name string) (bool, error) {
 	c.RLock()
@@ -852,6 +921,9 @@ func (c *coordinator) isCheckerPaused(name string) (bool, error) {
 // scheduleController is used to manage a scheduler to schedule.
 type scheduleController struct {
 	schedule.Scheduler
+	// mu serializes the rounds of the scheduler, so that a simulation does not
+	// race with the scheduling.
+	mu           syncutil.Mutex
 	cluster      *RaftCluster
 	opController *schedule.OperatorController
 	nextInterval time.Duration
@@ -859,6 +931,9 @@ type scheduleController struct {
 	cancel       context.CancelFunc
 	delayAt      int64
 	delayUntil   int64
+	lastRunAt    time.Time
+	// now returns the current time. It can be replaced in tests.
+	now func() time.Time
 }
 
 // newScheduleController creates a new scheduleController.
@@ -871,6 +946,7 @@ func newScheduleController(c *coordinator, s schedule.Scheduler) *scheduleContro
 		nextInterval: s.GetMinInterval(),
 		ctx:          ctx,
 		cancel:       cancel,
+		now:          time.Now,
 	}
 }
 
@@ -883,6 +959,16 @@ func (s *scheduleController) Stop() {
 }
 
 func (s *scheduleController) Schedule() []*operator.Operator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.Scheduler.(schedule.RunIntervalLimiter); ok {
+		now := s.now()
+		// Skip the scheduler if it is invoked sooner than its min run interval.
+		if interval := l.GetMinRunInterval(); interval > 0 && now.Sub(s.lastRunAt) < interval {
+			return nil
+		}
+		s.lastRunAt = now
+	}
 	for i := 0; i < maxScheduleRetries; i++ {
 		// no need to retry if schedule should stop to speed exit
 		select {
@@ -901,13 +987,24 @@ func (s *scheduleController) Schedule() []*operator.Operator {
 	return nil
 }
 
+// Simulate runs the scheduler in dry run for the rounds, see simulateRounds.
+func (s *scheduleController) Simulate(rounds int) []*operator.Operator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return simulateRounds(s.Scheduler, s.cluster, rounds)
+}
+
 // GetInterval returns the interval of scheduling for a scheduler.
 func (s *scheduleController) GetInterval() time.Duration {
 	return s.nextInterval
 }
 
-// AllowSchedule returns if a scheduler is allowed to schedule.
+// AllowSchedule returns if a scheduler is allowed to schedule. No scheduler is
+// allowed in the placement observer mode, in which the placement is read-only.
 func (s *scheduleController) AllowSchedule() bool {
+	if s.cluster.GetOpts().IsPlacementObserverModeEnabled() {
+		return false
+	}
 	return s.Scheduler.IsScheduleAllowed(s.cluster) && !s.IsPaused() && !s.cluster.GetUnsafeRecoveryController().IsRunning()
 }
 
@@ -37,6 +37,7 @@ import (
 	"github.com/tikv/pd/server/schedule/hbstream"
 	"github.com/tikv/pd/server/schedule/labeler"
 	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/plan"
 	"github.com/tikv/pd/server/schedulers"
 	"github.com/tikv/pd/server/statistics"
 	"github.com/tikv/pd/server/storage"
@@ -851,6 +852,7 @@ func TestRemoveScheduler(t *testing.T) {
 	sches, _, err := storage.LoadAllScheduleConfig()
 	re.NoError(err)
 	re.Len(sches, 5)
+	re.NoError(storage.SaveScheduleState(schedulers.GrantLeaderName, []byte("{}")))
 
 	// remove all schedulers
 	re.NoError(co.removeScheduler(schedulers.BalanceLeaderName))
@@ -862,6 +864,9 @@ func TestRemoveScheduler(t *testing.T) {
 	sches, _, err = storage.LoadAllScheduleConfig()
 	re.NoError(err)
 	re.Len(sches, 0)
+	state, err := storage.LoadScheduleState(schedulers.GrantLeaderName)
+	re.NoError(err)
+	re.Empty(state)
 	re.Len(co.schedulers, 0)
 	re.NoError(co.cluster.opt.Persist(co.cluster.storage))
 	co.stop()
@@ -1275,6 +1280,76 @@ func TestInterval(t *testing.T) {
 	}
 }
 
+type mockRunIntervalScheduler struct {
+	schedule.Scheduler
+	interval time.Duration
+	runs     int
+}
+
+func (s *mockRunIntervalScheduler) GetMinRunInterval() time.Duration {
+	return s.interval
+}
+
+func (s *mockRunIntervalScheduler) Schedule(cluster schedule.Cluster, dryRun bool) ([]*operator.Operator, []plan.Plan) {
+	s.runs++
+	return nil, nil
+}
+
+func TestMinRunInterval(t *testing.T) {
+	re := require.New(t)
+
+	_, co, cleanup := prepare(nil, nil, nil, re)
+	defer cleanup()
+
+	lb, err := schedule.CreateScheduler(schedulers.BalanceLeaderType, co.opController, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(schedulers.BalanceLeaderType, []string{"", ""}))
+	re.NoError(err)
+	s := &mockRunIntervalScheduler{Scheduler: lb, interval: time.Minute}
+	sc := newScheduleController(co, s)
+	now := time.Now()
+	sc.now = func() time.Time { return now }
+
+	sc.Schedule()
+	runs := s.runs
+	re.Positive(runs)
+	// The scheduler is skipped within its interval.
+	now = now.Add(30 * time.Second)
+	sc.Schedule()
+	re.Equal(runs, s.runs)
+	now = now.Add(30 * time.Second)
+	sc.Schedule()
+	re.Greater(s.runs, runs)
+
+	// No limit if the interval is zero.
+	s.interval = 0
+	runs = s.runs
+	sc.Schedule()
+	re.Greater(s.runs, runs)
+}
+
+func TestObserverMode(t *testing.T) {
+	re := require.New(t)
+
+	tc, co, cleanup := prepare(nil, nil, nil, re)
+	defer cleanup()
+	re.NoError(tc.addLeaderStore(1, 1))
+	re.NoError(tc.addLeaderStore(2, 0))
+
+	for _, typ := range []string{schedulers.ShuffleLeaderType, schedulers.RandomMergeType, schedulers.BalanceLeaderType} {
+		s, err := schedule.CreateScheduler(typ, co.opController, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(typ, []string{"", ""}))
+		re.NoError(err)
+		sc := newScheduleController(co, s)
+		re.True(sc.AllowSchedule())
+
+		cfg := co.cluster.opt.GetReplicationConfig().Clone()
+		cfg.EnablePlacementObserverMode = true
+		co.cluster.opt.SetReplicationConfig(cfg)
+		re.False(sc.AllowSchedule())
+		cfg = cfg.Clone()
+		cfg.EnablePlacementObserverMode = false
+		co.cluster.opt.SetReplicationConfig(cfg)
+	}
+}
+
 func waitAddLearner(re *require.Assertions, stream mockhbstream.HeartbeatStream, region *core.RegionInfo, storeID uint64) *core.RegionInfo {
 	var res *pdpb.RegionHeartbeatResponse
 	testutil.Eventually(re, func() bool {
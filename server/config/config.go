@@ -243,6 +243,15 @@ const (
 	defaultEnableGRPCGateway    = true
 	defaultDisableErrorVerbose  = true
 
+	defaultPlacementRulesSampleSize     = 128
+	defaultPlacementRulesSampleInterval = time.Minute
+
+	defaultPlacementRulesAuditWorkers        = 1
+	defaultPlacementRulesAuditSampleFraction = 1.0
+
+	defaultPlacementRulesFitMaxExactCandidates = 20
+	defaultPlacementRulesEmptyLabelPolicy      = "excluded"
+
 	defaultDashboardAddress = "auto"
 
 	defaultDRWaitStoreTimeout    = time.Minute
@@ -1057,6 +1066,89 @@ type ReplicationConfig struct {
 	// EnablePlacementRuleCache controls whether use cache during rule checker
 	EnablePlacementRulesCache bool `toml:"enable-placement-rules-cache" json:"enable-placement-rules-cache,string"`
 
+	// PlacementRulesCacheSize is the max count of regions whose fits are cached.
+	// The least recently used one is evicted once it is exceeded. Zero means
+	// the default size. It takes effect after PD restarts.
+	PlacementRulesCacheSize int `toml:"placement-rules-cache-size" json:"placement-rules-cache-size"`
+
+	// EnablePlacementRulesAudit controls whether to check all regions against the rules once the
+	// cluster is started, to find the regions unsatisfied before the leader changes.
+	EnablePlacementRulesAudit bool `toml:"enable-placement-rules-audit" json:"enable-placement-rules-audit,string"`
+	// PlacementRulesAuditWorkers is the count of goroutines fitting regions
+	// concurrently in the audit.
+	PlacementRulesAuditWorkers int `toml:"placement-rules-audit-workers" json:"placement-rules-audit-workers"`
+	// PlacementRulesAuditSampleFraction is the fraction of regions checked by
+	// the audit, in [0, 1]. All regions are checked if it is 0 or 1.
+	PlacementRulesAuditSampleFraction float64 `toml:"placement-rules-audit-sample-fraction" json:"placement-rules-audit-sample-fraction"`
+
+	// PlacementRulesSampleSize is the count of regions sampled each time to
+	// calculate the ratio of regions satisfying the placement rules.
+	PlacementRulesSampleSize int `toml:"placement-rules-sample-size" json:"placement-rules-sample-size"`
+	// PlacementRulesSampleInterval is the interval to sample the regions.
+	PlacementRulesSampleInterval typeutil.Duration `toml:"placement-rules-sample-interval" json:"placement-rules-sample-interval"`
+
+	// PlacementRulesFitMaxDuration is the time budget of fitting a region to
+	// the rules. The search is aborted after the budget is used up, and the
+	// best fit found so far is used. Zero means no limit.
+	PlacementRulesFitMaxDuration typeutil.Duration `toml:"placement-rules-fit-max-duration" json:"placement-rules-fit-max-duration"`
+	// PlacementRulesFitMaxIterations is the max count of peer combinations
+	// evaluated in fitting a region to the rules. Zero means no limit.
+	PlacementRulesFitMaxIterations int `toml:"placement-rules-fit-max-iterations" json:"placement-rules-fit-max-iterations"`
+	// PlacementRulesFitMaxExactCandidates is the max count of candidates of a
+	// rule whose combinations are enumerated in fitting a region. A rule with
+	// more candidates selects its peers greedily instead, and the fit is only
+	// approximate. Zero means the combinations are always enumerated.
+	PlacementRulesFitMaxExactCandidates int `toml:"placement-rules-fit-max-exact-candidates" json:"placement-rules-fit-max-exact-candidates"`
+	// EnablePlacementRulesFitRetry retries a fit truncated due to the budget
+	// greedily, which selects the peers rule by rule without search. The greedy
+	// fit is used unless it is worse than the truncated one.
+	EnablePlacementRulesFitRetry bool `toml:"enable-placement-rules-fit-retry" json:"enable-placement-rules-fit-retry,string"`
+	// PlacementRulesBusyStorePenalty is the penalty of each peer on a store
+	// reporting that it is busy, such as IO overloaded, in fitting a region to
+	// the rules. The busy stores are deprioritized but not excluded. Zero
+	// disables the penalty.
+	PlacementRulesBusyStorePenalty float64 `toml:"placement-rules-busy-store-penalty" json:"placement-rules-busy-store-penalty"`
+	// PlacementRulesPreferStretchOverOrphan makes a surplus peer, which would
+	// be an orphan since the rules are all fulfilled, stretch a fulfilled rule
+	// matching its role and store instead, so that it is kept.
+	PlacementRulesPreferStretchOverOrphan bool `toml:"placement-rules-prefer-stretch-over-orphan" json:"placement-rules-prefer-stretch-over-orphan,string"`
+	// PlacementRulesVotersOnlyIsolation scores the isolation of the Leader and
+	// Voter rules by their voting peers only, so that the learners waiting to
+	// be promoted do not count.
+	PlacementRulesVotersOnlyIsolation bool `toml:"placement-rules-voters-only-isolation" json:"placement-rules-voters-only-isolation,string"`
+	// PlacementRulesRefitInterval is the interval to coalesce the rule changes
+	// in, so that the key ranges of the rules changed within it are checked
+	// again at once. Zero checks them again on each change.
+	PlacementRulesRefitInterval typeutil.Duration `toml:"placement-rules-refit-interval" json:"placement-rules-refit-interval"`
+	// PlacementRulesSubnetLabel is the location label, usually "zone", derived
+	// from the subnet of the store address for the stores without it. For
+	// example, with 24 mask bits, stores "10.0.1.1:20160" and "10.0.1.2:20160"
+	// are considered in the same zone.
+	PlacementRulesSubnetLabel string `toml:"placement-rules-subnet-label" json:"placement-rules-subnet-label"`
+	// PlacementRulesSubnetMaskBits is the CIDR mask bits of the subnet label.
+	// Zero disables the subnet label.
+	PlacementRulesSubnetMaskBits int `toml:"placement-rules-subnet-mask-bits" json:"placement-rules-subnet-mask-bits"`
+	// PlacementRulesLabelEquivalence maps a location label key to the mapping
+	// from its values to the canonical values, so that the stores with the
+	// values of the same canonical value are scored at the same location for
+	// isolation. For example, with {"rack": {"r1a": "r1", "r1b": "r1"}}, the
+	// physical racks "r1a" and "r1b" of the logical rack "r1" are considered
+	// the same rack. The values are case insensitive.
+	PlacementRulesLabelEquivalence map[string]map[string]string `toml:"placement-rules-label-equivalence" json:"placement-rules-label-equivalence"`
+	// PlacementRulesEmptyLabelPolicy decides how the isolation scoring treats
+	// the stores which lack a location label. With "excluded", the label is
+	// skipped in comparing two stores if any of them lacks it. With
+	// "distinct", a store without the label is at a distinct location from any
+	// other store. With "same", all stores without the label are at the same
+	// location. Default is "excluded".
+	PlacementRulesEmptyLabelPolicy string `toml:"placement-rules-empty-label-policy" json:"placement-rules-empty-label-policy"`
+
+	// EnablePlacementObserverMode makes the placement read-only. The fits are
+	// still computed and reported, but neither the rule checker nor the
+	// schedulers create operators, so the placement health can be observed
+	// before the active scheduling is enabled.
+	EnablePlacementObserverMode bool `toml:"enable-placement-observer-mode" json:"enable-placement-observer-mode,string"`
+
 	// IsolationLevel is used to isolate replicas explicitly and forcibly if it's not empty.
 	// Its value must be empty or one of LocationLabels.
 	// Example:
@@ -1073,6 +1165,15 @@ func (c *ReplicationConfig) Clone() *ReplicationConfig {
 	locationLabels := append(c.LocationLabels[:0:0], c.LocationLabels...)
 	cfg := *c
 	cfg.LocationLabels = locationLabels
+	if c.PlacementRulesLabelEquivalence != nil {
+		cfg.PlacementRulesLabelEquivalence = make(map[string]map[string]string, len(c.PlacementRulesLabelEquivalence))
+		for key, values := range c.PlacementRulesLabelEquivalence {
+			cfg.PlacementRulesLabelEquivalence[key] = make(map[string]string, len(values))
+			for value, to := range values {
+				cfg.PlacementRulesLabelEquivalence[key][value] = to
+			}
+		}
+	}
 	return &cfg
 }
 
@@ -1092,6 +1193,38 @@ func (c *ReplicationConfig) Validate() error {
 	if c.IsolationLevel != "" && !foundIsolationLevel {
 		return errors.New("isolation-level must be one of location-labels or empty")
 	}
+	if c.PlacementRulesAuditSampleFraction < 0 || c.PlacementRulesAuditSampleFraction > 1 {
+		return errors.New("placement-rules-audit-sample-fraction must be in [0, 1]")
+	}
+	if c.PlacementRulesSampleSize < 0 {
+		return errors.New("placement-rules-sample-size must not be negative")
+	}
+	if c.PlacementRulesSampleInterval.Duration <= 0 {
+		return errors.New("placement-rules-sample-interval must be positive")
+	}
+	if c.PlacementRulesFitMaxDuration.Duration < 0 {
+		return errors.New("placement-rules-fit-max-duration must not be negative")
+	}
+	if c.PlacementRulesFitMaxIterations < 0 {
+		return errors.New("placement-rules-fit-max-iterations must not be negative")
+	}
+	if c.PlacementRulesFitMaxExactCandidates < 0 {
+		return errors.New("placement-rules-fit-max-exact-candidates must not be negative")
+	}
+	if c.PlacementRulesBusyStorePenalty < 0 {
+		return errors.New("placement-rules-busy-store-penalty must not be negative")
+	}
+	if c.PlacementRulesRefitInterval.Duration < 0 {
+		return errors.New("placement-rules-refit-interval must not be negative")
+	}
+	if c.PlacementRulesSubnetMaskBits < 0 || c.PlacementRulesSubnetMaskBits > 128 {
+		return errors.New("placement-rules-subnet-mask-bits must be in [0, 128]")
+	}
+	switch c.PlacementRulesEmptyLabelPolicy {
+	case "", "excluded", "distinct", "same":
+	default:
+		return errors.New("placement-rules-empty-label-policy must be one of excluded, distinct and same")
+	}
 	return nil
 }
 
@@ -1106,6 +1239,14 @@ func (c *ReplicationConfig) adjust(meta *configMetaData) error {
 	if !meta.IsDefined("location-labels") {
 		c.LocationLabels = defaultLocationLabels
 	}
+	adjustInt(&c.PlacementRulesSampleSize, defaultPlacementRulesSampleSize)
+	adjustDuration(&c.PlacementRulesSampleInterval, defaultPlacementRulesSampleInterval)
+	adjustInt(&c.PlacementRulesAuditWorkers, defaultPlacementRulesAuditWorkers)
+	adjustFloat64(&c.PlacementRulesAuditSampleFraction, defaultPlacementRulesAuditSampleFraction)
+	if !meta.IsDefined("placement-rules-fit-max-exact-candidates") {
+		c.PlacementRulesFitMaxExactCandidates = defaultPlacementRulesFitMaxExactCandidates
+	}
+	adjustString(&c.PlacementRulesEmptyLabelPolicy, defaultPlacementRulesEmptyLabelPolicy)
 	return c.Validate()
 }
 
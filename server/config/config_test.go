@@ -151,6 +151,47 @@ func TestValidation(t *testing.T) {
 	re.NoError(cfg.Schedule.Validate())
 	cfg.Schedule.TolerantSizeRatio = -0.6
 	re.Error(cfg.Schedule.Validate())
+	// check replication config
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSampleSize = -1
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSampleSize = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSampleInterval.Duration = -time.Second
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSampleInterval.Duration = 0
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSampleInterval.Duration = time.Second
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxDuration.Duration = -time.Second
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxDuration.Duration = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxIterations = -1
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxIterations = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxExactCandidates = -1
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesFitMaxExactCandidates = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesBusyStorePenalty = -1
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesBusyStorePenalty = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesRefitInterval.Duration = -time.Second
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesRefitInterval.Duration = 0
+	re.NoError(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSubnetMaskBits = 129
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesSubnetMaskBits = 24
+	re.NoError(cfg.Replication.Validate())
+	re.Equal("excluded", cfg.Replication.PlacementRulesEmptyLabelPolicy)
+	cfg.Replication.PlacementRulesEmptyLabelPolicy = "unknown"
+	re.Error(cfg.Replication.Validate())
+	cfg.Replication.PlacementRulesEmptyLabelPolicy = "distinct"
+	re.NoError(cfg.Replication.Validate())
 	// check quota
 	re.Equal(defaultQuotaBackendBytes, cfg.QuotaBackendBytes)
 	// check request bytes
@@ -503,6 +544,11 @@ func TestConfigClone(t *testing.T) {
 	replication := &ReplicationConfig{}
 	replication.adjust(emptyConfigMetaData)
 	re.Equal(replication, replication.Clone())
+	replication.PlacementRulesLabelEquivalence = map[string]map[string]string{"rack": {"r1a": "r1"}}
+	clone := replication.Clone()
+	re.Equal(replication, clone)
+	clone.PlacementRulesLabelEquivalence["rack"]["r1b"] = "r1"
+	re.Len(replication.PlacementRulesLabelEquivalence["rack"], 1)
 
 	pdServer := &PDServerConfig{}
 	pdServer.adjust(emptyConfigMetaData)
@@ -171,6 +171,126 @@ func (o *PersistOptions) SetPlacementRulesCacheEnabled(enabled bool) {
 	o.SetReplicationConfig(v)
 }
 
+// GetPlacementRulesCacheSize returns the max count of regions whose fits are cached.
+func (o *PersistOptions) GetPlacementRulesCacheSize() int {
+	return o.GetReplicationConfig().PlacementRulesCacheSize
+}
+
+// IsPlacementRulesAuditEnabled returns if the placement rules audit is enabled
+func (o *PersistOptions) IsPlacementRulesAuditEnabled() bool {
+	return o.GetReplicationConfig().EnablePlacementRulesAudit
+}
+
+// GetPlacementRulesAuditWorkers returns the count of goroutines fitting
+// regions concurrently in the placement rules audit.
+func (o *PersistOptions) GetPlacementRulesAuditWorkers() int {
+	return o.GetReplicationConfig().PlacementRulesAuditWorkers
+}
+
+// GetPlacementRulesAuditSampleFraction returns the fraction of regions checked
+// by the placement rules audit.
+func (o *PersistOptions) GetPlacementRulesAuditSampleFraction() float64 {
+	return o.GetReplicationConfig().PlacementRulesAuditSampleFraction
+}
+
+// IsPlacementObserverModeEnabled returns if the placement is read-only, in
+// which no operator is created.
+func (o *PersistOptions) IsPlacementObserverModeEnabled() bool {
+	return o.GetReplicationConfig().EnablePlacementObserverMode
+}
+
+// SetPlacementObserverModeEnabled sets EnablePlacementObserverMode.
+func (o *PersistOptions) SetPlacementObserverModeEnabled(enabled bool) {
+	v := o.GetReplicationConfig().Clone()
+	v.EnablePlacementObserverMode = enabled
+	o.SetReplicationConfig(v)
+}
+
+// GetPlacementRulesSampleSize returns the count of regions sampled to calculate
+// the ratio of regions satisfying the placement rules.
+func (o *PersistOptions) GetPlacementRulesSampleSize() int {
+	return o.GetReplicationConfig().PlacementRulesSampleSize
+}
+
+// GetPlacementRulesSampleInterval returns the interval to sample the regions.
+func (o *PersistOptions) GetPlacementRulesSampleInterval() time.Duration {
+	return o.GetReplicationConfig().PlacementRulesSampleInterval.Duration
+}
+
+// GetPlacementRulesFitMaxDuration returns the time budget of fitting a region
+// to the rules.
+func (o *PersistOptions) GetPlacementRulesFitMaxDuration() time.Duration {
+	return o.GetReplicationConfig().PlacementRulesFitMaxDuration.Duration
+}
+
+// GetPlacementRulesFitMaxIterations returns the max count of peer combinations
+// evaluated in fitting a region to the rules.
+func (o *PersistOptions) GetPlacementRulesFitMaxIterations() int {
+	return o.GetReplicationConfig().PlacementRulesFitMaxIterations
+}
+
+// GetPlacementRulesFitMaxExactCandidates returns the max count of candidates of
+// a rule whose combinations are enumerated in fitting a region.
+func (o *PersistOptions) GetPlacementRulesFitMaxExactCandidates() int {
+	return o.GetReplicationConfig().PlacementRulesFitMaxExactCandidates
+}
+
+// IsPlacementRulesFitRetryEnabled returns if a truncated fit is retried
+// greedily.
+func (o *PersistOptions) IsPlacementRulesFitRetryEnabled() bool {
+	return o.GetReplicationConfig().EnablePlacementRulesFitRetry
+}
+
+// GetPlacementRulesBusyStorePenalty returns the penalty of each peer on a busy
+// store in fitting a region to the rules.
+func (o *PersistOptions) GetPlacementRulesBusyStorePenalty() float64 {
+	return o.GetReplicationConfig().PlacementRulesBusyStorePenalty
+}
+
+// IsPlacementRulesPreferStretchOverOrphan returns if the surplus peers stretch
+// the fulfilled rules instead of being orphans.
+func (o *PersistOptions) IsPlacementRulesPreferStretchOverOrphan() bool {
+	return o.GetReplicationConfig().PlacementRulesPreferStretchOverOrphan
+}
+
+// IsPlacementRulesVotersOnlyIsolation returns if the isolation of the Leader and
+// Voter rules is scored by their voting peers only.
+func (o *PersistOptions) IsPlacementRulesVotersOnlyIsolation() bool {
+	return o.GetReplicationConfig().PlacementRulesVotersOnlyIsolation
+}
+
+// GetPlacementRulesRefitInterval returns the interval to coalesce the rule
+// changes in.
+func (o *PersistOptions) GetPlacementRulesRefitInterval() time.Duration {
+	return o.GetReplicationConfig().PlacementRulesRefitInterval.Duration
+}
+
+// GetPlacementRulesSubnetLabel returns the location label derived from the
+// subnet of the store address, and the CIDR mask bits of the subnet.
+func (o *PersistOptions) GetPlacementRulesSubnetLabel() (string, int) {
+	cfg := o.GetReplicationConfig()
+	return cfg.PlacementRulesSubnetLabel, cfg.PlacementRulesSubnetMaskBits
+}
+
+// GetPlacementRulesLabelEquivalence returns the mapping from the location label
+// values to the canonical values, keyed by the label key.
+func (o *PersistOptions) GetPlacementRulesLabelEquivalence() map[string]map[string]string {
+	return o.GetReplicationConfig().PlacementRulesLabelEquivalence
+}
+
+// GetPlacementRulesEmptyLabelPolicy returns the policy for the stores without a
+// location label.
+func (o *PersistOptions) GetPlacementRulesEmptyLabelPolicy() string {
+	return o.GetReplicationConfig().PlacementRulesEmptyLabelPolicy
+}
+
+// SetPlacementRulesAuditEnabled set EnablePlacementRulesAudit
+func (o *PersistOptions) SetPlacementRulesAuditEnabled(enabled bool) {
+	v := o.GetReplicationConfig().Clone()
+	v.EnablePlacementRulesAudit = enabled
+	o.SetReplicationConfig(v)
+}
+
 // GetStrictlyMatchLabel returns whether check label strict.
 func (o *PersistOptions) GetStrictlyMatchLabel() bool {
 	return o.GetReplicationConfig().StrictlyMatchLabel
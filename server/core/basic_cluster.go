@@ -211,6 +211,14 @@ func (bc *BasicCluster) RandLeaderRegion(storeID uint64, ranges []KeyRange, opts
 	return bc.selectRegion(regions, opts...)
 }
 
+// RandLeaderRegions returns at most n random regions that has leader on the store.
+// A region may be returned more than once.
+func (bc *BasicCluster) RandLeaderRegions(storeID uint64, ranges []KeyRange, n int) []*RegionInfo {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.Regions.RandLeaderRegions(storeID, ranges, n)
+}
+
 // RandPendingRegion returns a random region that has a pending peer on the store.
 func (bc *BasicCluster) RandPendingRegion(storeID uint64, ranges []KeyRange, opts ...RegionOption) *RegionInfo {
 	bc.RLock()
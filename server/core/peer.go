@@ -114,3 +114,19 @@ func (p *PeerInfo) GetPeerID() uint64 {
 func (p *PeerInfo) GetInterval() uint64 {
 	return p.interval
 }
+
+// PendingPeerChange is a peer change which is not applied to the region yet,
+// such as a step of a running operator.
+type PendingPeerChange struct {
+	// AddPeer is the peer to add. It is nil if no peer is added.
+	AddPeer *metapb.Peer
+	// RemoveStoreID is the store whose peer is removed. It is 0 if no peer is
+	// removed.
+	RemoveStoreID uint64
+	// PromotePeerID is the learner promoted to voter. It is 0 if no peer is
+	// promoted.
+	PromotePeerID uint64
+	// DemotePeerID is the voter demoted to learner. It is 0 if no peer is
+	// demoted.
+	DemotePeerID uint64
+}
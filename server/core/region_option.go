@@ -305,6 +305,17 @@ func WithPromoteLearner(peerID uint64) RegionCreateOption {
 	}
 }
 
+// WithDemoteVoter demotes the voter.
+func WithDemoteVoter(peerID uint64) RegionCreateOption {
+	return func(region *RegionInfo) {
+		for _, p := range region.GetPeers() {
+			if p.GetId() == peerID {
+				p.Role = metapb.PeerRole_Learner
+			}
+		}
+	}
+}
+
 // WithReplacePeerStore replaces a peer's storeID with another ID.
 func WithReplacePeerStore(oldStoreID, newStoreID uint64) RegionCreateOption {
 	return func(region *RegionInfo) {
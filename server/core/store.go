@@ -156,6 +156,12 @@ func (s *StoreInfo) IsTiFlash() bool {
 	return IsStoreContainLabel(s.GetMeta(), EngineKey, EngineTiFlash)
 }
 
+// IsLeaderCapable returns true if the store is able to host leaders. TiFlash
+// stores only serve learners, so they can never be leaders.
+func (s *StoreInfo) IsLeaderCapable() bool {
+	return !s.IsTiFlash()
+}
+
 // IsUp returns true if store is serving or preparing.
 func (s *StoreInfo) IsUp() bool {
 	return s.IsServing() || s.IsPreparing()
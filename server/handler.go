@@ -275,6 +275,21 @@ func (h *Handler) PauseOrResumeScheduler(name string, t int64) error {
 	return err
 }
 
+// SimulateScheduler runs a scheduler in dry run for the rounds, and returns
+// the operators it would create without adding them. If the scheduler is not
+// added, a temporary one is created by the name and args instead.
+func (h *Handler) SimulateScheduler(name string, rounds int, args ...string) ([]*operator.Operator, error) {
+	c, err := h.GetRaftCluster()
+	if err != nil {
+		return nil, err
+	}
+	ops, err := c.SimulateScheduler(name, rounds, args...)
+	if err != nil {
+		log.Error("can not simulate scheduler", zap.String("scheduler-name", name), zap.Strings("scheduler-args", args), errs.ZapError(err))
+	}
+	return ops, err
+}
+
 // PauseOrResumeChecker pauses checker for delay seconds or resume checker
 // t == 0 : resume checker.
 // t > 0 : checker delays t seconds.
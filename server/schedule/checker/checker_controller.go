@@ -94,6 +94,9 @@ func (c *Controller) CheckRegion(region *core.RegionInfo) []*operator.Operator {
 	if c.opts.IsPlacementRulesEnabled() {
 		fit := c.priorityInspector.Inspect(region)
 		if op := c.ruleChecker.CheckWithFit(region, fit); op != nil {
+			if pending := opController.GetOperator(region.GetID()); pending != nil && c.ruleChecker.isFixedByOperator(region, pending) {
+				return nil
+			}
 			if opController.OperatorCount(operator.OpReplica) < c.opts.GetReplicaScheduleLimit() {
 				return []*operator.Operator{op}
 			}
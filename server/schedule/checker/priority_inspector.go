@@ -82,7 +82,7 @@ func (p *PriorityInspector) inspectRegionInPlacementRule(region *core.RegionInfo
 
 	for _, rf := range fit.RuleFits {
 		// skip learn rule
-		if rf.Rule.Role == placement.Learner {
+		if rf.Rule.Role.IsNonVoting() {
 			continue
 		}
 		makeupCount = makeupCount + rf.Rule.Count - len(rf.Peers)
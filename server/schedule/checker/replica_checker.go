@@ -35,6 +35,8 @@ const (
 const (
 	offlineStatus = "offline"
 	downStatus    = "down"
+	// violatingStatus is of a peer whose store does not match its rule.
+	violatingStatus = "violating"
 )
 
 // ReplicaChecker ensures region has the best replicas.
@@ -50,6 +50,12 @@ type RuleChecker struct {
 	regionWaitingList cache.Cache
 	pendingList       cache.Cache
 	record            *recorder
+	// minIsolationGain is the min gain of the isolation score to move a peer
+	// to a better location. Zero means any gain is accepted.
+	minIsolationGain float64
+	// dryRun leaves the cache and the observed fits of the rule manager
+	// untouched, see Preview.
+	dryRun bool
 }
 
 // NewRuleChecker creates a checker instance.
@@ -64,6 +70,12 @@ func NewRuleChecker(cluster schedule.Cluster, ruleManager *placement.RuleManager
 	}
 }
 
+// SetMinIsolationGain sets the min gain of the isolation score to move a peer
+// to a better location, which suppresses the churn caused by marginal gains.
+func (c *RuleChecker) SetMinIsolationGain(gain float64) {
+	c.minIsolationGain = gain
+}
+
 // GetType returns RuleChecker's Type
 func (c *RuleChecker) GetType() string {
 	return "rule-checker"
@@ -76,6 +88,26 @@ func (c *RuleChecker) Check(region *core.RegionInfo) *operator.Operator {
 	return c.CheckWithFit(region, fit)
 }
 
+// Preview is the same as Check, except that it has no side effect. Neither the
+// rule manager nor the lists and records of the checker are updated, so that
+// the operator can be previewed in dry run.
+func (c *RuleChecker) Preview(region *core.RegionInfo) *operator.Operator {
+	if c.IsPaused() {
+		return nil
+	}
+	preview := &RuleChecker{
+		cluster:           c.cluster,
+		ruleManager:       c.ruleManager,
+		name:              c.name,
+		regionWaitingList: cache.NewDefaultCache(1),
+		pendingList:       cache.NewDefaultCache(1),
+		record:            c.record.clone(),
+		minIsolationGain:  c.minIsolationGain,
+		dryRun:            true,
+	}
+	return preview.Check(region)
+}
+
 // CheckWithFit is similar with Checker with placement.RegionFit
 func (c *RuleChecker) CheckWithFit(region *core.RegionInfo, fit *placement.RegionFit) (op *operator.Operator) {
 	if c.IsPaused() {
@@ -94,9 +126,14 @@ func (c *RuleChecker) CheckWithFit(region *core.RegionInfo, fit *placement.Regio
 		panic("cached should be used")
 	})
 
-	// If the fit is calculated by FitRegion, which means we get a new fit result, thus we should
-	// invalid the cache if it exists
-	c.ruleManager.InvalidCache(region.GetID())
+	if !c.dryRun {
+		// If the fit is calculated by FitRegion, which means we get a new fit result, thus we should
+		// invalid the cache if it exists
+		c.ruleManager.InvalidCache(region.GetID())
+		// The fit is of the region as it is, unlike the hypothetical ones fitted
+		// by the filters and the schedulers, so it tracks the satisfied state.
+		c.ruleManager.ObserveFit(region.GetID(), fit)
+	}
 
 	checkerCounter.WithLabelValues("rule_checker", "check").Inc()
 	c.record.refresh(c.cluster)
@@ -107,6 +144,13 @@ func (c *RuleChecker) CheckWithFit(region *core.RegionInfo, fit *placement.Regio
 		// multiple rules.
 		return nil
 	}
+	if c.cluster.GetOpts().IsPlacementObserverModeEnabled() {
+		if !fit.IsSatisfied() {
+			checkerCounter.WithLabelValues("rule_checker", "observed-unsatisfied").Inc()
+			log.Debug("region does not satisfy the placement rules in observer mode", zap.Uint64("region-id", region.GetID()))
+		}
+		return nil
+	}
 	op, err := c.fixOrphanPeers(region, fit)
 	if err != nil {
 		log.Debug("fail to fix orphan peer", errs.ZapError(err))
@@ -125,7 +169,7 @@ func (c *RuleChecker) CheckWithFit(region *core.RegionInfo, fit *placement.Regio
 			return op
 		}
 	}
-	if c.cluster.GetOpts().IsPlacementRulesCacheEnabled() {
+	if !c.dryRun && c.cluster.GetOpts().IsPlacementRulesCacheEnabled() {
 		if placement.ValidateFit(fit) && placement.ValidateRegion(region) && placement.ValidateStores(fit.GetRegionStores()) {
 			// If there is no need to fix, we will cache the fit
 			c.ruleManager.SetRegionFitCache(region, fit)
@@ -137,20 +181,25 @@ func (c *RuleChecker) CheckWithFit(region *core.RegionInfo, fit *placement.Regio
 
 func (c *RuleChecker) fixRulePeer(region *core.RegionInfo, fit *placement.RegionFit, rf *placement.RuleFit) (*operator.Operator, error) {
 	// make up peers.
-	if len(rf.Peers) < rf.Rule.Count {
-		return c.addRulePeer(region, rf)
+	if rf.IsShortOfPeers() {
+		return withViolation(rf, "short-of-peers")(c.addRulePeer(region, rf))
 	}
 	// fix down/offline peers.
 	for _, peer := range rf.Peers {
 		if c.isDownPeer(region, peer) {
 			checkerCounter.WithLabelValues("rule_checker", "replace-down").Inc()
-			return c.replaceUnexpectRulePeer(region, rf, fit, peer, downStatus)
+			return withViolation(rf, downStatus+"-peer")(c.replaceUnexpectRulePeer(region, rf, fit, peer, downStatus))
 		}
 		if c.isOfflinePeer(peer) {
 			checkerCounter.WithLabelValues("rule_checker", "replace-offline").Inc()
-			return c.replaceUnexpectRulePeer(region, rf, fit, peer, offlineStatus)
+			return withViolation(rf, offlineStatus+"-peer")(c.replaceUnexpectRulePeer(region, rf, fit, peer, offlineStatus))
 		}
 	}
+	// fix peers on the stores not matching the constraints anymore.
+	if len(rf.ConstraintViolatingPeers) > 0 {
+		checkerCounter.WithLabelValues("rule_checker", "replace-violating").Inc()
+		return withViolation(rf, violatingStatus+"-peer")(c.replaceUnexpectRulePeer(region, rf, fit, rf.ConstraintViolatingPeers[0], violatingStatus))
+	}
 	// fix loose matched peers.
 	for _, peer := range rf.PeersWithDifferentRole {
 		op, err := c.fixLooseMatchPeer(region, fit, rf, peer)
@@ -158,10 +207,22 @@ func (c *RuleChecker) fixRulePeer(region *core.RegionInfo, fit *placement.Region
 			return nil, err
 		}
 		if op != nil {
-			return op, nil
+			return withViolation(rf, "role-mismatch")(op, nil)
+		}
+	}
+	return withViolation(rf, "isolation")(c.fixBetterLocation(region, rf))
+}
+
+// withViolation returns a function annotating the operator fixing the rule
+// with the violation prompting it, so that the operator can be traced back to
+// the fit.
+func withViolation(rf *placement.RuleFit, violation string) func(*operator.Operator, error) (*operator.Operator, error) {
+	return func(op *operator.Operator, err error) (*operator.Operator, error) {
+		if op != nil {
+			op.SetReason(&operator.OpReason{RuleID: rf.Rule.GroupID + "/" + rf.Rule.ID, Violation: violation})
 		}
+		return op, err
 	}
-	return c.fixBetterLocation(region, rf)
 }
 
 func (c *RuleChecker) addRulePeer(region *core.RegionInfo, rf *placement.RuleFit) (*operator.Operator, error) {
@@ -232,7 +293,7 @@ func (c *RuleChecker) replaceUnexpectRulePeer(region *core.RegionInfo, rf *place
 }
 
 func (c *RuleChecker) fixLooseMatchPeer(region *core.RegionInfo, fit *placement.RegionFit, rf *placement.RuleFit, peer *metapb.Peer) (*operator.Operator, error) {
-	if core.IsLearner(peer) && rf.Rule.Role != placement.Learner {
+	if core.IsLearner(peer) && !rf.Rule.Role.IsNonVoting() {
 		checkerCounter.WithLabelValues("rule_checker", "fix-peer-role").Inc()
 		return operator.CreatePromoteLearnerOperator("fix-peer-role", c.cluster, region, peer)
 	}
@@ -254,7 +315,7 @@ func (c *RuleChecker) fixLooseMatchPeer(region *core.RegionInfo, fit *placement.
 		checkerCounter.WithLabelValues("rule_checker", "no-new-leader").Inc()
 		return nil, errNoNewLeader
 	}
-	if core.IsVoter(peer) && rf.Rule.Role == placement.Learner {
+	if core.IsVoter(peer) && rf.Rule.Role.IsNonVoting() {
 		checkerCounter.WithLabelValues("rule_checker", "demote-voter-role").Inc()
 		return operator.CreateDemoteVoterOperator("fix-demote-voter", c.cluster, region, peer)
 	}
@@ -299,11 +360,41 @@ func (c *RuleChecker) fixBetterLocation(region *core.RegionInfo, rf *placement.R
 		c.handleFilterState(region, filterByTempState)
 		return nil, nil
 	}
+	if !c.isIsolationGainEnough(region, oldStore, newStore) {
+		checkerCounter.WithLabelValues("rule_checker", "marginal-isolation-gain").Inc()
+		return nil, nil
+	}
 	checkerCounter.WithLabelValues("rule_checker", "move-to-better-location").Inc()
 	newPeer := &metapb.Peer{StoreId: newStore, Role: rf.Rule.Role.MetaPeerRole()}
 	return operator.CreateMovePeerOperator("move-to-better-location", c.cluster, region, operator.OpReplica, oldStore, newPeer)
 }
 
+// isIsolationGainEnough checks whether moving the peer from the old store to
+// the new store improves the fit by more than the min isolation gain.
+func (c *RuleChecker) isIsolationGainEnough(region *core.RegionInfo, oldStore, newStore uint64) bool {
+	if c.minIsolationGain <= 0 {
+		return true
+	}
+	oldFit := c.ruleManager.FitRegion(c.cluster, region)
+	newFit := c.ruleManager.FitRegion(c.cluster, region.Clone(core.WithReplacePeerStore(oldStore, newStore)))
+	return placement.CompareRegionFitWithTolerance(newFit, oldFit, c.minIsolationGain) > 0
+}
+
+// isFixedByOperator checks whether the region satisfies the rules after the
+// pending steps of the operator are finished, in which case no new operator is
+// needed to fix the region.
+func (c *RuleChecker) isFixedByOperator(region *core.RegionInfo, op *operator.Operator) bool {
+	changes := op.PendingPeerChanges()
+	if len(changes) == 0 {
+		return false
+	}
+	if c.ruleManager.FitRegionWithPendingChanges(c.cluster, region, changes).IsSatisfied() {
+		checkerCounter.WithLabelValues("rule_checker", "fixed-by-pending-operator").Inc()
+		return true
+	}
+	return false
+}
+
 func (c *RuleChecker) fixOrphanPeers(region *core.RegionInfo, fit *placement.RegionFit) (*operator.Operator, error) {
 	if len(fit.OrphanPeers) == 0 {
 		return nil, nil
@@ -332,7 +423,8 @@ func (c *RuleChecker) fixOrphanPeers(region *core.RegionInfo, fit *placement.Reg
 	}
 	checkerCounter.WithLabelValues("rule_checker", "remove-orphan-peer").Inc()
 	peer := fit.OrphanPeers[0]
-	return operator.CreateRemovePeerOperator("remove-orphan-peer", c.cluster, 0, region, peer.StoreId)
+	return operator.CreateRemovePeerOperator("remove-orphan-peer", c.cluster, 0, region, peer.StoreId,
+		operator.WithReason(&operator.OpReason{Violation: "orphan-peer"}))
 }
 
 func (c *RuleChecker) isDownPeer(region *core.RegionInfo, peer *metapb.Peer) bool {
@@ -371,7 +463,10 @@ func (c *RuleChecker) strategy(region *core.RegionInfo, rule *placement.Rule) *R
 		isolationLevel: rule.IsolationLevel,
 		locationLabels: rule.LocationLabels,
 		region:         region,
-		extraFilters:   []filter.Filter{filter.NewLabelConstaintFilter(c.name, rule.LabelConstraints)},
+		extraFilters: []filter.Filter{
+			filter.NewLabelConstaintFilter(c.name, rule.LabelConstraints),
+			filter.NewForbiddenLabelConstraintFilter(c.name, rule.ForbiddenLabelConstraints),
+		},
 	}
 }
 
@@ -406,6 +501,14 @@ func newRecord() *recorder {
 	}
 }
 
+func (o *recorder) clone() *recorder {
+	counter := make(map[uint64]uint64, len(o.offlineLeaderCounter))
+	for storeID, count := range o.offlineLeaderCounter {
+		counter[storeID] = count
+	}
+	return &recorder{offlineLeaderCounter: counter, lastUpdateTime: o.lastUpdateTime}
+}
+
 func (o *recorder) getOfflineLeaderCount(storeID uint64) uint64 {
 	return o.offlineLeaderCounter[storeID]
 }
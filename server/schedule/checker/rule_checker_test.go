@@ -71,6 +71,86 @@ func (suite *ruleCheckerTestSuite) TestAddRulePeer() {
 	suite.Equal(uint64(3), op.Step(0).(operator.AddLearner).ToStore)
 }
 
+func (suite *ruleCheckerTestSuite) TestAddRulePeerWithWeightedRoles() {
+	for id := uint64(1); id <= 5; id++ {
+		suite.cluster.AddLeaderStore(id, 1)
+	}
+	suite.NoError(suite.ruleManager.SetRule(&placement.Rule{
+		GroupID:     "pd",
+		ID:          "default",
+		Role:        placement.Voter,
+		Count:       3,
+		RoleWeights: map[placement.PeerRoleType]float64{placement.Follower: 0.5},
+	}))
+	// The leader and 2 followers count as 2 replicas.
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3)
+	op := suite.rc.Check(suite.cluster.GetRegion(1))
+	suite.NotNil(op)
+	suite.Equal("add-rule-peer", op.Desc())
+	suite.Equal(uint64(4), op.Step(0).(operator.AddLearner).ToStore)
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3, 4)
+	op = suite.rc.Check(suite.cluster.GetRegion(1))
+	suite.NotNil(op)
+	suite.Equal("add-rule-peer", op.Desc())
+	suite.Equal(uint64(5), op.Step(0).(operator.AddLearner).ToStore)
+	// The 4 followers make up the count.
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3, 4, 5)
+	suite.Nil(suite.rc.Check(suite.cluster.GetRegion(1)))
+}
+
+func (suite *ruleCheckerTestSuite) TestPreview() {
+	suite.cluster.AddLeaderStore(1, 1)
+	suite.cluster.AddLeaderStore(2, 1)
+	suite.cluster.AddLeaderStore(3, 1)
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3)
+	ch := make(chan placement.FitChangeEvent, 10)
+	suite.ruleManager.SubscribeFitChanges(ch)
+	suite.Nil(suite.rc.Check(suite.cluster.GetRegion(1)))
+
+	// The preview does not observe the fit turning unsatisfied.
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2)
+	op := suite.rc.Preview(suite.cluster.GetRegion(1))
+	suite.NotNil(op)
+	suite.Equal("add-rule-peer", op.Desc())
+	suite.Empty(ch)
+	suite.NotNil(suite.rc.Check(suite.cluster.GetRegion(1)))
+	suite.Len(ch, 1)
+}
+
+func (suite *ruleCheckerTestSuite) TestObserverMode() {
+	suite.cluster.AddLeaderStore(1, 1)
+	suite.cluster.AddLeaderStore(2, 1)
+	suite.cluster.AddLeaderStore(3, 1)
+	suite.cluster.AddLeaderStore(4, 1)
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2)
+	suite.cluster.AddLeaderRegionWithRange(2, "a", "b", 1, 2, 3, 4)
+	suite.cluster.SetPlacementObserverModeEnabled(true)
+	suite.Nil(suite.rc.Check(suite.cluster.GetRegion(1)))
+	suite.Nil(suite.rc.Check(suite.cluster.GetRegion(2)))
+
+	suite.cluster.SetPlacementObserverModeEnabled(false)
+	suite.NotNil(suite.rc.Check(suite.cluster.GetRegion(1)))
+	suite.NotNil(suite.rc.Check(suite.cluster.GetRegion(2)))
+}
+
+func (suite *ruleCheckerTestSuite) TestFixedByPendingOperator() {
+	suite.cluster.AddLeaderStore(1, 1)
+	suite.cluster.AddLeaderStore(2, 1)
+	suite.cluster.AddLeaderStore(3, 1)
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2)
+	region := suite.cluster.GetRegion(1)
+	suite.NotNil(suite.rc.Check(region))
+
+	// The pending operator adds the missing peer.
+	op, err := operator.CreateAddPeerOperator("add-rule-peer", suite.cluster, region, &metapb.Peer{Id: 10, StoreId: 3}, operator.OpReplica)
+	suite.NoError(err)
+	suite.True(suite.rc.isFixedByOperator(region, op))
+	// The pending operator only adds a learner.
+	op, err = operator.CreateAddPeerOperator("add-learner", suite.cluster, region, &metapb.Peer{Id: 10, StoreId: 3, Role: metapb.PeerRole_Learner}, operator.OpReplica)
+	suite.NoError(err)
+	suite.False(suite.rc.isFixedByOperator(region, op))
+}
+
 func (suite *ruleCheckerTestSuite) TestAddRulePeerWithIsolationLevel() {
 	suite.cluster.AddLabelsStore(1, 1, map[string]string{"zone": "z1", "rack": "r1", "host": "h1"})
 	suite.cluster.AddLabelsStore(2, 1, map[string]string{"zone": "z1", "rack": "r1", "host": "h2"})
@@ -287,6 +367,35 @@ func (suite *ruleCheckerTestSuite) TestBetterReplacement() {
 	suite.Nil(op)
 }
 
+func (suite *ruleCheckerTestSuite) TestBetterReplacementMinIsolationGain() {
+	suite.cluster.AddLabelsStore(1, 1, map[string]string{"zone": "z1", "host": "host1"})
+	suite.cluster.AddLabelsStore(2, 1, map[string]string{"zone": "z1", "host": "host1"})
+	suite.cluster.AddLabelsStore(3, 1, map[string]string{"zone": "z1", "host": "host2"})
+	suite.cluster.AddLabelsStore(4, 1, map[string]string{"zone": "z1", "host": "host3"})
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3)
+	suite.ruleManager.SetRule(&placement.Rule{
+		GroupID:        "pd",
+		ID:             "test",
+		Index:          100,
+		Override:       true,
+		Role:           placement.Voter,
+		Count:          3,
+		LocationLabels: []string{"zone", "host"},
+	})
+	suite.rc.SetMinIsolationGain(10)
+
+	// Moving the peer to another host gains too little.
+	suite.Nil(suite.rc.Check(suite.cluster.GetRegion(1)))
+
+	// Moving the peer to another zone gains enough.
+	suite.cluster.AddLabelsStore(5, 1, map[string]string{"zone": "z2", "host": "host1"})
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3)
+	op := suite.rc.Check(suite.cluster.GetRegion(1))
+	suite.NotNil(op)
+	suite.Equal("move-to-better-location", op.Desc())
+	suite.Equal(uint64(5), op.Step(0).(operator.AddLearner).ToStore)
+}
+
 func (suite *ruleCheckerTestSuite) TestBetterReplacement2() {
 	suite.cluster.AddLabelsStore(1, 1, map[string]string{"zone": "z1", "host": "host1"})
 	suite.cluster.AddLabelsStore(2, 1, map[string]string{"zone": "z1", "host": "host2"})
@@ -657,6 +766,55 @@ func (suite *ruleCheckerTestSuite) TestRuleCache() {
 	}
 }
 
+func (suite *ruleCheckerTestSuite) TestFixConstraintViolatingPeer() {
+	suite.cluster.AddLabelsStore(1, 1, map[string]string{"zone": "z1"})
+	suite.cluster.AddLabelsStore(2, 1, map[string]string{"zone": "z1"})
+	suite.cluster.AddLabelsStore(3, 1, map[string]string{"zone": "z1"})
+	suite.cluster.AddLabelsStore(4, 1, map[string]string{"zone": "z1"})
+	suite.cluster.AddLeaderRegion(1, 1, 2, 3)
+	suite.ruleManager.SetRule(&placement.Rule{
+		GroupID:  "pd",
+		ID:       "test",
+		Index:    100,
+		Override: true,
+		Role:     placement.Voter,
+		Count:    3,
+		LabelConstraints: []placement.LabelConstraint{
+			{Key: "zone", Op: "in", Values: []string{"z1"}},
+		},
+	})
+	region := suite.cluster.GetRegion(1).Clone(core.WithIncConfVer(), core.WithIncVersion())
+	suite.Nil(suite.rc.Check(region))
+
+	// Relabel store 3 out of z1, and the peer is replaced rather than orphaned.
+	suite.cluster.AddLabelsStore(3, 1, map[string]string{"zone": "z2"})
+	op := suite.rc.Check(region)
+	suite.NotNil(op)
+	suite.Equal("replace-rule-violating-peer", op.Desc())
+	suite.Equal(uint64(4), op.Step(0).(operator.AddLearner).ToStore)
+}
+
+func (suite *ruleCheckerTestSuite) TestObserveFit() {
+	suite.cluster.AddLeaderStore(1, 1)
+	suite.cluster.AddLeaderStore(2, 1)
+	suite.cluster.AddLeaderStore(3, 1)
+	suite.cluster.AddLeaderRegionWithRange(1, "", "", 1, 2, 3)
+	ch := make(chan placement.FitChangeEvent, 10)
+	suite.ruleManager.SubscribeFitChanges(ch)
+	region := suite.cluster.GetRegion(1)
+	suite.Nil(suite.rc.Check(region))
+
+	rule := suite.ruleManager.GetRule("pd", "default")
+	rule.Count = 4
+	suite.NoError(suite.ruleManager.SetRule(rule))
+	// A hypothetical fit is not observed.
+	suite.ruleManager.FitRegion(suite.cluster, region.Clone(core.WithRemoveStorePeer(3)))
+	suite.Empty(ch)
+	suite.rc.Check(region)
+	suite.Len(ch, 1)
+	suite.Equal(placement.FitChangeEvent{RegionID: 1, OldSatisfied: true, NewSatisfied: false}, <-ch)
+}
+
 // Ref https://github.com/tikv/pd/issues/4045
 func (suite *ruleCheckerTestSuite) TestSkipFixOrphanPeerIfSelectedPeerisPendingOrDown() {
 	suite.cluster.AddLabelsStore(1, 1, map[string]string{"host": "host1"})
@@ -162,6 +162,37 @@ func (f *excludedFilter) Target(opt *config.PersistOptions, store *core.StoreInf
 	return !ok
 }
 
+type labelAllowFilter struct {
+	scope      string
+	constraint placement.LabelConstraint
+}
+
+// NewLabelAllowFilter creates a Filter that only keeps the target stores whose
+// label value of the key is in the given values. It does not filter the source
+// stores.
+func NewLabelAllowFilter(scope string, key string, values []string) Filter {
+	return &labelAllowFilter{
+		scope:      scope,
+		constraint: placement.LabelConstraint{Key: key, Op: placement.In, Values: values},
+	}
+}
+
+func (f *labelAllowFilter) Scope() string {
+	return f.scope
+}
+
+func (f *labelAllowFilter) Type() string {
+	return "label-allow-filter"
+}
+
+func (f *labelAllowFilter) Source(opt *config.PersistOptions, store *core.StoreInfo) bool {
+	return true
+}
+
+func (f *labelAllowFilter) Target(opt *config.PersistOptions, store *core.StoreInfo) bool {
+	return f.constraint.MatchStore(store)
+}
+
 type storageThresholdFilter struct{ scope string }
 
 // NewStorageThresholdFilter creates a Filter that filters all stores that are
@@ -460,6 +491,38 @@ func (f labelConstraintFilter) Target(opt *config.PersistOptions, store *core.St
 	return placement.MatchLabelConstraints(store, f.constraints)
 }
 
+// forbiddenLabelConstraintFilter is a filter that rejects stores matching the
+// forbidden constraints.
+type forbiddenLabelConstraintFilter struct {
+	scope       string
+	constraints []placement.LabelConstraint
+}
+
+// NewForbiddenLabelConstraintFilter creates a filter that rejects stores matching the forbidden constraints.
+func NewForbiddenLabelConstraintFilter(scope string, constraints []placement.LabelConstraint) Filter {
+	return forbiddenLabelConstraintFilter{scope: scope, constraints: constraints}
+}
+
+// Scope returns the scheduler or the checker which the filter acts on.
+func (f forbiddenLabelConstraintFilter) Scope() string {
+	return f.scope
+}
+
+// Type returns the name of the filter.
+func (f forbiddenLabelConstraintFilter) Type() string {
+	return "forbidden-label-constraint-filter"
+}
+
+// Source filters stores when select them as schedule source.
+func (f forbiddenLabelConstraintFilter) Source(opt *config.PersistOptions, store *core.StoreInfo) bool {
+	return true
+}
+
+// Target filters stores when select them as schedule target.
+func (f forbiddenLabelConstraintFilter) Target(opt *config.PersistOptions, store *core.StoreInfo) bool {
+	return !placement.MatchForbiddenLabelConstraints(store, f.constraints)
+}
+
 type ruleFitFilter struct {
 	scope       string
 	cluster     *core.BasicCluster
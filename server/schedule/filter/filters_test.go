@@ -93,6 +93,26 @@ func TestLabelConstraintsFilter(t *testing.T) {
 	}
 }
 
+func TestLabelAllowFilter(t *testing.T) {
+	re := require.New(t)
+	opt := config.NewTestOptions()
+	stores := []*core.StoreInfo{
+		core.NewStoreInfoWithLabel(1, 1, map[string]string{"zone": "z1"}),
+		core.NewStoreInfoWithLabel(2, 1, map[string]string{"zone": "z2"}),
+		core.NewStoreInfoWithLabel(3, 1, map[string]string{"zone": "z3"}),
+		core.NewStoreInfoWithLabel(4, 1, map[string]string{"rack": "r1"}),
+	}
+	filter := NewLabelAllowFilter("", "zone", []string{"z1", "z3"})
+	for i, res := range []bool{true, false, true, false} {
+		re.Equal(res, filter.Target(opt, stores[i]))
+		re.True(filter.Source(opt, stores[i]))
+	}
+	targets := NewCandidates(stores).FilterTarget(opt, filter).Stores
+	re.Len(targets, 2)
+	re.Equal(uint64(1), targets[0].GetID())
+	re.Equal(uint64(3), targets[1].GetID())
+}
+
 func TestRuleFitFilter(t *testing.T) {
 	re := require.New(t)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -72,6 +72,7 @@ type Builder struct {
 	useJointConsensus bool
 	lightWeight       bool
 	forceTargetLeader bool
+	reason            *OpReason
 
 	// intermediate states
 	currentPeers                         peersMap
@@ -92,6 +93,14 @@ func SkipOriginJointStateCheck(b *Builder) {
 	b.skipOriginJointStateCheck = true
 }
 
+// WithReason lets the builder annotate the operator with the rule violation
+// that prompts it.
+func WithReason(reason *OpReason) BuilderOption {
+	return func(b *Builder) {
+		b.reason = reason
+	}
+}
+
 // NewBuilder creates a Builder.
 func NewBuilder(desc string, ci ClusterInformer, region *core.RegionInfo, opts ...BuilderOption) *Builder {
 	b := &Builder{
@@ -315,7 +324,7 @@ func (b *Builder) SetExpectedRoles(roles map[uint64]placement.PeerRoleType) *Bui
 			leaderCount++
 		case placement.Voter:
 			voterCount++
-		case placement.Follower, placement.Learner:
+		case placement.Follower, placement.Learner, placement.ReadReplica:
 			if b.targetLeaderStoreID == id {
 				b.targetLeaderStoreID = 0
 			}
@@ -362,7 +371,9 @@ func (b *Builder) Build(kind OpKind) (*Operator, error) {
 		return nil, b.err
 	}
 
-	return NewOperator(b.desc, brief, b.regionID, b.regionEpoch, kind, b.approximateSize, b.steps...), nil
+	op := NewOperator(b.desc, brief, b.regionID, b.regionEpoch, kind, b.approximateSize, b.steps...)
+	op.SetReason(b.reason)
+	return op, nil
 }
 
 // Initialize intermediate states.
@@ -30,36 +30,36 @@ import (
 )
 
 // CreateAddPeerOperator creates an operator that adds a new peer.
-func CreateAddPeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer, kind OpKind) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateAddPeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer, kind OpKind, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		AddPeer(peer).
 		Build(kind)
 }
 
 // CreateDemoteVoterOperator creates an operator that demotes a voter
-func CreateDemoteVoterOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateDemoteVoterOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		DemoteVoter(peer.GetStoreId()).
 		Build(0)
 }
 
 // CreatePromoteLearnerOperator creates an operator that promotes a learner.
-func CreatePromoteLearnerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreatePromoteLearnerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, peer *metapb.Peer, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		PromoteLearner(peer.GetStoreId()).
 		Build(0)
 }
 
 // CreateRemovePeerOperator creates an operator that removes a peer from region.
-func CreateRemovePeerOperator(desc string, ci ClusterInformer, kind OpKind, region *core.RegionInfo, storeID uint64) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateRemovePeerOperator(desc string, ci ClusterInformer, kind OpKind, region *core.RegionInfo, storeID uint64, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		RemovePeer(storeID).
 		Build(kind)
 }
 
 // CreateTransferLeaderOperator creates an operator that transfers the leader from a source store to a target store.
-func CreateTransferLeaderOperator(desc string, ci ClusterInformer, region *core.RegionInfo, sourceStoreID uint64, targetStoreID uint64, targetStoreIDs []uint64, kind OpKind) (*Operator, error) {
-	return NewBuilder(desc, ci, region, SkipOriginJointStateCheck).
+func CreateTransferLeaderOperator(desc string, ci ClusterInformer, region *core.RegionInfo, sourceStoreID uint64, targetStoreID uint64, targetStoreIDs []uint64, kind OpKind, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, append([]BuilderOption{SkipOriginJointStateCheck}, opts...)...).
 		SetLeader(targetStoreID).
 		SetLeaders(targetStoreIDs).
 		Build(kind)
@@ -88,16 +88,67 @@ func CreateMoveRegionOperator(desc string, ci ClusterInformer, region *core.Regi
 }
 
 // CreateMovePeerOperator creates an operator that replaces an old peer with a new peer.
-func CreateMovePeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateMovePeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		RemovePeer(oldStore).
 		AddPeer(peer).
 		Build(kind)
 }
 
+// PeerMove moves the peer on FromStore to ToStore with the same role.
+type PeerMove struct {
+	FromStore uint64
+	ToStore   uint64
+}
+
+// BuildJointOperator creates an operator that applies the moves together. If
+// the cluster supports joint consensus, the moves are packed into one joint
+// confchange, otherwise they fall back to sequential steps. It returns an
+// error if the joint state would lose the quorum of either configuration.
+func BuildJointOperator(desc string, ci ClusterInformer, region *core.RegionInfo, plan []PeerMove, opts ...BuilderOption) (*Operator, error) {
+	if err := checkJointQuorum(region, plan); err != nil {
+		return nil, err
+	}
+	b := NewBuilder(desc, ci, region, opts...)
+	for _, move := range plan {
+		role := metapb.PeerRole_Voter
+		if core.IsLearner(region.GetStorePeer(move.FromStore)) {
+			role = metapb.PeerRole_Learner
+		}
+		b.RemovePeer(move.FromStore).AddPeer(&metapb.Peer{StoreId: move.ToStore, Role: role})
+	}
+	return b.Build(OpRegion)
+}
+
+// checkJointQuorum checks that both the outgoing and the incoming voters of the
+// joint state keep a healthy majority. The incoming voters are promoted from
+// learners that have caught up, so they are considered healthy.
+func checkJointQuorum(region *core.RegionInfo, plan []PeerMove) error {
+	movedOut := make(map[uint64]struct{}, len(plan))
+	for _, move := range plan {
+		movedOut[move.FromStore] = struct{}{}
+	}
+	var oldHealthy, newHealthy int
+	for _, p := range region.GetVoters() {
+		healthy := region.GetDownPeer(p.GetId()) == nil && region.GetPendingPeer(p.GetId()) == nil
+		if healthy {
+			oldHealthy++
+		}
+		// A moved out voter is replaced by an incoming voter.
+		if _, ok := movedOut[p.GetStoreId()]; ok || healthy {
+			newHealthy++
+		}
+	}
+	voters := len(region.GetVoters())
+	if oldHealthy*2 <= voters || newHealthy*2 <= voters {
+		return errors.Errorf("cannot build joint operator for region %d: quorum is not safe", region.GetID())
+	}
+	return nil
+}
+
 // CreateReplaceLeaderPeerOperator creates an operator that replaces an old peer with a new peer, and move leader from old store firstly.
-func CreateReplaceLeaderPeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer, leader *metapb.Peer) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateReplaceLeaderPeerOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer, leader *metapb.Peer, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		RemovePeer(oldStore).
 		AddPeer(peer).
 		SetLeader(leader.GetStoreId()).
@@ -105,8 +156,8 @@ func CreateReplaceLeaderPeerOperator(desc string, ci ClusterInformer, region *co
 }
 
 // CreateMoveLeaderOperator creates an operator that replaces an old leader with a new leader.
-func CreateMoveLeaderOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer) (*Operator, error) {
-	return NewBuilder(desc, ci, region).
+func CreateMoveLeaderOperator(desc string, ci ClusterInformer, region *core.RegionInfo, kind OpKind, oldStore uint64, peer *metapb.Peer, opts ...BuilderOption) (*Operator, error) {
+	return NewBuilder(desc, ci, region, opts...).
 		RemovePeer(oldStore).
 		AddPeer(peer).
 		SetLeader(peer.GetStoreId()).
@@ -1078,3 +1078,84 @@ func (suite *createOperatorTestSuite) TestMoveRegionWithoutJointConsensus() {
 		}
 	}
 }
+
+func (suite *createOperatorTestSuite) TestCreateOperatorWithReason() {
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+		{Id: 3, StoreId: 3, Role: metapb.PeerRole_Voter},
+	}}, &metapb.Peer{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter})
+	reason := &OpReason{RuleID: "pd/default", Violation: "peer count"}
+
+	op, err := CreateTransferLeaderOperator("test", suite.cluster, region, 1, 2, []uint64{}, OpLeader, WithReason(reason))
+	suite.NoError(err)
+	suite.Equal(reason, op.Reason())
+	data, err := op.MarshalJSON()
+	suite.NoError(err)
+	suite.Contains(string(data), "reason:{rule:pd/default, violation:peer count}")
+
+	op, err = CreateMovePeerOperator("test", suite.cluster, region, OpRegion, 3, &metapb.Peer{StoreId: 4}, WithReason(reason))
+	suite.NoError(err)
+	suite.Equal(reason, op.Reason())
+	op, err = CreateRemovePeerOperator("test", suite.cluster, OpRegion, region, 3, WithReason(reason))
+	suite.NoError(err)
+	suite.Equal(reason, op.Reason())
+
+	// The reason is absent if it is not specified.
+	op, err = CreateTransferLeaderOperator("test", suite.cluster, region, 1, 2, []uint64{}, OpLeader)
+	suite.NoError(err)
+	suite.Nil(op.Reason())
+	data, err = op.MarshalJSON()
+	suite.NoError(err)
+	suite.NotContains(string(data), "reason:")
+}
+
+func (suite *createOperatorTestSuite) TestBuildJointOperator() {
+	peers := []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+		{Id: 3, StoreId: 3, Role: metapb.PeerRole_Voter},
+	}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0])
+	plan := []PeerMove{{FromStore: 2, ToStore: 4}, {FromStore: 3, ToStore: 5}}
+
+	// The two swaps are packed into one joint confchange.
+	op, err := BuildJointOperator("test", suite.cluster, region, plan)
+	suite.NoError(err)
+	var enter *ChangePeerV2Enter
+	for i := 0; i < op.Len(); i++ {
+		if step, ok := op.Step(i).(ChangePeerV2Enter); ok {
+			suite.Nil(enter)
+			enter = &step
+		}
+	}
+	suite.NotNil(enter)
+	suite.Len(enter.PromoteLearners, 2)
+	suite.Len(enter.DemoteVoters, 2)
+
+	// The moves fall back to sequential steps without joint consensus.
+	suite.cluster.SetClusterVersion(versioninfo.MinSupportedVersion(versioninfo.Version4_0))
+	op, err = BuildJointOperator("test", suite.cluster, region, plan)
+	suite.NoError(err)
+	var promoted, removed int
+	for i := 0; i < op.Len(); i++ {
+		switch op.Step(i).(type) {
+		case ChangePeerV2Enter, ChangePeerV2Leave:
+			suite.T().Errorf("unexpected joint step: %s", op.Step(i))
+		case PromoteLearner:
+			promoted++
+		case RemovePeer:
+			removed++
+		}
+	}
+	suite.Equal(2, promoted)
+	suite.Equal(2, removed)
+
+	// Two of the three voters are unhealthy, so the joint state has no quorum.
+	unhealthy := region.Clone(
+		core.WithPendingPeers([]*metapb.Peer{peers[1]}),
+		core.WithDownPeers([]*pdpb.PeerStats{{Peer: peers[2]}}),
+	)
+	_, err = BuildJointOperator("test", suite.cluster, unhealthy, plan[:1])
+	suite.Error(err)
+}
@@ -56,6 +56,28 @@ type Operator struct {
 	FinishedCounters []prometheus.Counter
 	AdditionalInfos  map[string]string
 	ApproximateSize  int64
+	reason           *OpReason
+	claim            *PeerClaim
+	// targetInvalid indicates the operator is canceled since the target of
+	// its step becomes invalid, e.g. the target peer is gone.
+	targetInvalid bool
+}
+
+// PeerClaim is the peer of the region corrected by an operator on behalf of
+// the owner, usually a scheduler.
+type PeerClaim struct {
+	Owner  string
+	PeerID uint64
+}
+
+// OpReason annotates the rule violation that prompts an operator.
+type OpReason struct {
+	RuleID    string `json:"rule-id"`
+	Violation string `json:"violation"`
+}
+
+func (r *OpReason) String() string {
+	return fmt.Sprintf("{rule:%s, violation:%s}", r.RuleID, r.Violation)
 }
 
 // NewOperator creates a new operator.
@@ -87,6 +109,9 @@ func (o *Operator) String() string {
 	s := fmt.Sprintf("%s {%s} (kind:%s, region:%v(%v, %v), createAt:%s, startAt:%s, currentStep:%v, size:%d, steps:[%s])",
 		o.desc, o.brief, o.kind, o.regionID, o.regionEpoch.GetVersion(), o.regionEpoch.GetConfVer(), o.GetCreateTime(),
 		o.GetStartTime(), atomic.LoadInt32(&o.currentStep), o.ApproximateSize, strings.Join(stepStrs, ", "))
+	if o.reason != nil {
+		s += " reason:" + o.reason.String()
+	}
 	if o.CheckSuccess() {
 		s += " finished"
 	}
@@ -116,6 +141,42 @@ func (o *Operator) AttachKind(kind OpKind) {
 	o.kind |= kind
 }
 
+// Reason returns the reason annotated to the operator, it is nil if the
+// operator is not created for a rule violation.
+func (o *Operator) Reason() *OpReason {
+	return o.reason
+}
+
+// PeerClaim returns the peer claimed by the operator, it is nil if the operator
+// does not correct a specific peer.
+func (o *Operator) PeerClaim() *PeerClaim {
+	return o.claim
+}
+
+// SetPeerClaim claims the peer corrected by the operator for the owner. Once
+// the operator is added, the operators of other owners claiming the peer are
+// rejected until it is finished.
+func (o *Operator) SetPeerClaim(owner string, peerID uint64) {
+	o.claim = &PeerClaim{Owner: owner, PeerID: peerID}
+}
+
+// MarkTargetInvalid marks the operator is canceled since the target of its
+// step becomes invalid.
+func (o *Operator) MarkTargetInvalid() {
+	o.targetInvalid = true
+}
+
+// IsTargetInvalid returns whether the operator is canceled since the target of
+// its step becomes invalid.
+func (o *Operator) IsTargetInvalid() bool {
+	return o.targetInvalid
+}
+
+// SetReason annotates the operator with the rule violation that prompts it.
+func (o *Operator) SetReason(reason *OpReason) {
+	o.reason = reason
+}
+
 // RegionID returns the region that operator is targeted.
 func (o *Operator) RegionID() uint64 {
 	return o.regionID
@@ -248,6 +309,42 @@ func (o *Operator) Step(i int) OpStep {
 	return nil
 }
 
+// PendingPeerChanges returns the peer changes of the steps which are not
+// finished yet. Only the steps adding, removing, promoting and demoting peers
+// are taken into account, where the voters are only demoted by a joint
+// consensus. A joint consensus is reported by both its entering and leaving
+// steps, and the changes are the same for the final state.
+func (o *Operator) PendingPeerChanges() []core.PendingPeerChange {
+	var changes []core.PendingPeerChange
+	for _, step := range o.steps[atomic.LoadInt32(&o.currentStep):] {
+		switch s := step.(type) {
+		case AddPeer:
+			changes = append(changes, core.PendingPeerChange{AddPeer: &metapb.Peer{Id: s.PeerID, StoreId: s.ToStore, Role: metapb.PeerRole_Voter}})
+		case AddLearner:
+			changes = append(changes, core.PendingPeerChange{AddPeer: &metapb.Peer{Id: s.PeerID, StoreId: s.ToStore, Role: metapb.PeerRole_Learner}})
+		case PromoteLearner:
+			changes = append(changes, core.PendingPeerChange{PromotePeerID: s.PeerID})
+		case RemovePeer:
+			changes = append(changes, core.PendingPeerChange{RemoveStoreID: s.FromStore})
+		case ChangePeerV2Enter:
+			changes = appendJointPeerChanges(changes, s.PromoteLearners, s.DemoteVoters)
+		case ChangePeerV2Leave:
+			changes = appendJointPeerChanges(changes, s.PromoteLearners, s.DemoteVoters)
+		}
+	}
+	return changes
+}
+
+func appendJointPeerChanges(changes []core.PendingPeerChange, promotes []PromoteLearner, demotes []DemoteVoter) []core.PendingPeerChange {
+	for _, pl := range promotes {
+		changes = append(changes, core.PendingPeerChange{PromotePeerID: pl.PeerID})
+	}
+	for _, dv := range demotes {
+		changes = append(changes, core.PendingPeerChange{DemotePeerID: dv.PeerID})
+	}
+	return changes
+}
+
 // getCurrentTimeAndStep returns the start time of the i-th step.
 // opStep is nil if the i-th step is not found.
 func (o *Operator) getCurrentTimeAndStep() (startTime time.Time, opStep OpStep) {
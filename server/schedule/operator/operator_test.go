@@ -524,3 +524,31 @@ func (suite *operatorTestSuite) TestRecord() {
 	suite.Equal(now, ob.FinishTime)
 	suite.Greater(ob.duration.Seconds(), time.Second.Seconds())
 }
+
+func (suite *operatorTestSuite) TestPendingPeerChanges() {
+	op := suite.newTestOperator(1, OpRegion,
+		AddLearner{ToStore: 3, PeerID: 3},
+		ChangePeerV2Enter{
+			PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 1, PeerID: 1}},
+		},
+		ChangePeerV2Leave{
+			PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 1, PeerID: 1}},
+		},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	)
+	changes := op.PendingPeerChanges()
+	suite.Len(changes, 6)
+	suite.Equal(uint64(3), changes[0].AddPeer.GetStoreId())
+	suite.Equal(uint64(3), changes[1].PromotePeerID)
+	suite.Equal(uint64(1), changes[2].DemotePeerID)
+	suite.Equal(uint64(1), changes[5].RemoveStoreID)
+
+	// The finished steps are skipped.
+	atomic.StoreInt32(&op.currentStep, 2)
+	changes = op.PendingPeerChanges()
+	suite.Len(changes, 3)
+	suite.Equal(uint64(3), changes[0].PromotePeerID)
+	suite.Equal(uint64(1), changes[1].DemotePeerID)
+}
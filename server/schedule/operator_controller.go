@@ -55,6 +55,13 @@ var (
 	FastOperatorFinishTime = 10 * time.Second
 )
 
+// StoreThrottle decides whether a store is throttled from receiving new
+// operators, e.g. a store recovering from OOM. Its state is managed externally
+// and it is only consulted by schedulers.
+type StoreThrottle interface {
+	IsThrottled(storeID uint64) bool
+}
+
 // OperatorController is used to limit the speed of scheduling.
 type OperatorController struct {
 	syncutil.RWMutex
@@ -68,6 +75,29 @@ type OperatorController struct {
 	wop             WaitingOperator
 	wopStatus       *WaitingOperatorStatus
 	opNotifierQueue operatorQueue
+	storeThrottle   StoreThrottle
+	peerGuard       *peerOperatorGuard
+	outcomes        struct {
+		syncutil.RWMutex
+		nextID uint64
+		subs   map[uint64]outcomeSubscription
+	}
+}
+
+// outcomeSubscription is a channel receiving the outcomes of the operators
+// with the description.
+type outcomeSubscription struct {
+	desc string
+	ch   chan<- OperatorOutcome
+}
+
+// OperatorOutcome is the end status of an operator fed back to the subscriber.
+type OperatorOutcome struct {
+	Status operator.OpStatus
+	// TargetFailed indicates the operator fails on its target, i.e. it times
+	// out or the target of its step becomes invalid, rather than it is
+	// canceled for other reasons such as the region changes.
+	TargetFailed bool
 }
 
 // NewOperatorController creates a OperatorController.
@@ -83,6 +113,7 @@ func NewOperatorController(ctx context.Context, cluster Cluster, hbStreams *hbst
 		wop:             NewRandBuckets(),
 		wopStatus:       NewWaitingOperatorStatus(),
 		opNotifierQueue: make(operatorQueue, 0),
+		peerGuard:       newPeerOperatorGuard(),
 	}
 }
 
@@ -99,6 +130,71 @@ func (oc *OperatorController) GetCluster() Cluster {
 	return oc.cluster
 }
 
+// SetStoreThrottle sets the StoreThrottle consulted by schedulers.
+func (oc *OperatorController) SetStoreThrottle(throttle StoreThrottle) {
+	oc.Lock()
+	defer oc.Unlock()
+	oc.storeThrottle = throttle
+}
+
+// IsStoreThrottled returns whether the store should not be the target of new operators.
+func (oc *OperatorController) IsStoreThrottled(storeID uint64) bool {
+	oc.RLock()
+	defer oc.RUnlock()
+	return oc.storeThrottle != nil && oc.storeThrottle.IsThrottled(storeID)
+}
+
+// SubscribeOperatorOutcomes registers a channel to receive the outcomes of the
+// operators with the given description, so that the scheduler creating them
+// gets the feedback. The outcome is dropped if the channel is full. It returns
+// the handle to unsubscribe with, so that the subscriptions of the same
+// description, e.g. from a scheduler and its simulated copy, are independent.
+func (oc *OperatorController) SubscribeOperatorOutcomes(desc string, ch chan<- OperatorOutcome) uint64 {
+	oc.outcomes.Lock()
+	defer oc.outcomes.Unlock()
+	if oc.outcomes.subs == nil {
+		oc.outcomes.subs = make(map[uint64]outcomeSubscription)
+	}
+	oc.outcomes.nextID++
+	oc.outcomes.subs[oc.outcomes.nextID] = outcomeSubscription{desc: desc, ch: ch}
+	return oc.outcomes.nextID
+}
+
+// UnsubscribeOperatorOutcomes removes the subscription with the handle returned
+// by SubscribeOperatorOutcomes.
+func (oc *OperatorController) UnsubscribeOperatorOutcomes(id uint64) {
+	oc.outcomes.Lock()
+	defer oc.outcomes.Unlock()
+	delete(oc.outcomes.subs, id)
+}
+
+func (oc *OperatorController) notifyOutcome(op *operator.Operator) {
+	oc.outcomes.RLock()
+	defer oc.outcomes.RUnlock()
+	st := op.Status()
+	outcome := OperatorOutcome{
+		Status:       st,
+		TargetFailed: st == operator.TIMEOUT || (st == operator.CANCELED && op.IsTargetInvalid()),
+	}
+	for _, sub := range oc.outcomes.subs {
+		if sub.desc != op.Desc() {
+			continue
+		}
+		select {
+		case sub.ch <- outcome:
+		default:
+		}
+	}
+}
+
+// IsPeerClaimed checks whether an added operator of another owner claiming the
+// peer of the region is still in flight, see operator.SetPeerClaim. Schedulers
+// consult it before emitting an operator for a peer the fit considers
+// misplaced, and such an operator is rejected when it is added anyway.
+func (oc *OperatorController) IsPeerClaimed(owner string, regionID, peerID uint64) bool {
+	return oc.peerGuard.conflicts(owner, regionID, peerID)
+}
+
 // Dispatch is used to dispatch the operator of a region.
 func (oc *OperatorController) Dispatch(region *core.RegionInfo, source string) {
 	// Check existed operator.
@@ -156,6 +252,7 @@ func (oc *OperatorController) Dispatch(region *core.RegionInfo, source string) {
 func (oc *OperatorController) checkStaleOperator(op *operator.Operator, step operator.OpStep, region *core.RegionInfo) bool {
 	err := step.CheckInProgress(oc.cluster, region)
 	if err != nil {
+		op.MarkTargetInvalid()
 		if oc.RemoveOperator(op, zap.String("reason", err.Error())) {
 			operatorCounter.WithLabelValues(op.Desc(), "stale").Inc()
 			operatorWaitCounter.WithLabelValues(op.Desc(), "promote-stale").Inc()
@@ -401,6 +498,13 @@ func (oc *OperatorController) checkAddOperator(isPromoting bool, ops ...*operato
 			operatorWaitCounter.WithLabelValues(op.Desc(), "already-have").Inc()
 			return false
 		}
+		if claim := op.PeerClaim(); claim != nil && oc.peerGuard.conflicts(claim.Owner, op.RegionID(), claim.PeerID) {
+			log.Debug("peer claimed by another operator, cancel add operator",
+				zap.Uint64("region-id", op.RegionID()),
+				zap.Uint64("peer-id", claim.PeerID))
+			operatorWaitCounter.WithLabelValues(op.Desc(), "peer-claimed").Inc()
+			return false
+		}
 		if op.Status() != operator.CREATED {
 			log.Error("trying to add operator with unexpected status",
 				zap.Uint64("region-id", op.RegionID()),
@@ -472,6 +576,7 @@ func (oc *OperatorController) addOperatorLocked(op *operator.Operator) bool {
 		return false
 	}
 	oc.operators[regionID] = op
+	oc.peerGuard.register(op)
 	operatorCounter.WithLabelValues(op.Desc(), "start").Inc()
 	operatorSizeHist.WithLabelValues(op.Desc()).Observe(float64(op.ApproximateSize))
 	operatorWaitDuration.WithLabelValues(op.Desc()).Observe(op.ElapsedTime().Seconds())
@@ -608,6 +713,7 @@ func (oc *OperatorController) buryOperator(op *operator.Operator, extraFields ..
 	}
 
 	oc.opRecords.Put(op)
+	oc.notifyOutcome(op)
 }
 
 // GetOperatorStatus gets the operator and its status with the specify id.
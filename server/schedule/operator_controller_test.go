@@ -134,6 +134,30 @@ func (suite *operatorControllerTestSuite) TestOperatorStatus() {
 	suite.Equal(pdpb.OperatorStatus_SUCCESS, oc.GetOperatorStatus(2).Status)
 }
 
+func (suite *operatorControllerTestSuite) TestOperatorOutcomeSubscriptions() {
+	opt := config.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewOperatorController(suite.ctx, tc, stream)
+	op := operator.NewTestOperator(1, &metapb.RegionEpoch{}, operator.OpRegion)
+	suite.True(op.Cancel())
+
+	// The subscriptions of the same description do not replace each other.
+	ch1, ch2 := make(chan OperatorOutcome, 1), make(chan OperatorOutcome, 1)
+	id1 := oc.SubscribeOperatorOutcomes(op.Desc(), ch1)
+	id2 := oc.SubscribeOperatorOutcomes(op.Desc(), ch2)
+	suite.NotEqual(id1, id2)
+	oc.notifyOutcome(op)
+	suite.Equal(operator.CANCELED, (<-ch1).Status)
+	suite.Equal(operator.CANCELED, (<-ch2).Status)
+
+	// Unsubscribing one keeps the other.
+	oc.UnsubscribeOperatorOutcomes(id1)
+	oc.notifyOutcome(op)
+	suite.Len(ch1, 0)
+	suite.Len(ch2, 1)
+}
+
 func (suite *operatorControllerTestSuite) TestFastFailOperator() {
 	opt := config.NewTestOptions()
 	tc := mockcluster.NewCluster(suite.ctx, opt)
@@ -0,0 +1,81 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"github.com/tikv/pd/pkg/syncutil"
+	"github.com/tikv/pd/server/schedule/operator"
+)
+
+type regionPeer struct {
+	regionID uint64
+	peerID   uint64
+}
+
+type peerOperator struct {
+	owner string
+	op    *operator.Operator
+}
+
+// peerOperatorGuard records the corrective operator in flight for each peer,
+// so that only one of the schedulers deciding to fix the same misplaced peer
+// emits an operator.
+type peerOperatorGuard struct {
+	mu  syncutil.Mutex
+	ops map[regionPeer]peerOperator
+}
+
+func newPeerOperatorGuard() *peerOperatorGuard {
+	return &peerOperatorGuard{ops: make(map[regionPeer]peerOperator)}
+}
+
+// conflicts checks whether an operator of another owner for the peer is still
+// in flight.
+func (g *peerOperatorGuard) conflicts(owner string, regionID, peerID uint64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	o, ok := g.ops[regionPeer{regionID: regionID, peerID: peerID}]
+	return ok && o.owner != owner
+}
+
+// register records the added operator if it claims a peer. An owner replaces
+// its own operator, since it only proposes one for a peer at a time.
+func (g *peerOperatorGuard) register(op *operator.Operator) {
+	claim := op.PeerClaim()
+	if claim == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ops[regionPeer{regionID: op.RegionID(), peerID: claim.PeerID}] = peerOperator{owner: claim.Owner, op: op}
+}
+
+func (g *peerOperatorGuard) pruneLocked() {
+	for key, o := range g.ops {
+		if !inFlight(o.op) {
+			delete(g.ops, key)
+		}
+	}
+}
+
+// inFlight checks whether the operator is neither finished nor expired before
+// being started. The status is not updated, which is left to the controller.
+func inFlight(op *operator.Operator) bool {
+	if op.IsEnd() {
+		return false
+	}
+	return op.Status() != operator.CREATED || op.ElapsedTime() < operator.OperatorExpireTime
+}
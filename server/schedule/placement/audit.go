@@ -0,0 +1,215 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/tikv/pd/server/core"
+)
+
+const auditBatchSize = 1024
+
+// RegionScanner scans at most limit regions starting from the key.
+type RegionScanner func(startKey []byte, limit int) []*core.RegionInfo
+
+// AuditResult is the aggregated result of checking regions against the rules.
+type AuditResult struct {
+	Total       int
+	Satisfied   int
+	Unsatisfied int
+	// WithOrphans is the count of regions which have orphan peers.
+	WithOrphans int
+	// Samples are the IDs of some unsatisfied regions.
+	Samples []uint64
+	// Truncated indicates the audit stops before scanning all regions because
+	// the time budget is used up.
+	Truncated bool
+	// Skipped is the count of scanned regions which are not sampled.
+	Skipped int
+	// Canceled indicates the audit stops before scanning all regions because
+	// the context is canceled, such as the leadership is lost.
+	Canceled bool
+}
+
+// AuditOptions controls how AuditRegions checks the regions.
+type AuditOptions struct {
+	// Budget is the time budget of the audit.
+	Budget time.Duration
+	// MaxSamples is the max count of unsatisfied region IDs kept.
+	MaxSamples int
+	// Workers is the count of goroutines fitting the regions concurrently.
+	// The regions are fitted one by one if it is not positive.
+	Workers int
+	// SampleFraction is the fraction of the scanned regions which are fitted.
+	// All regions are fitted if it is not in (0, 1).
+	SampleFraction float64
+}
+
+// AuditRegions checks whether the regions satisfy their rules. Regions are
+// scanned in batches, and at most MaxSamples unsatisfied region IDs are kept,
+// so the memory is bounded. The audit stops after the budget is used up or the
+// context is canceled, and yields the processor between batches so that it
+// does not starve the foreground scheduling.
+func (m *RuleManager) AuditRegions(ctx context.Context, stores StoreSet, scan RegionScanner, opts AuditOptions) *AuditResult {
+	res := &AuditResult{}
+	deadline := timeNow().Add(opts.Budget)
+	sampler := newAuditSampler(opts.SampleFraction)
+	var startKey []byte
+	for {
+		if ctx.Err() != nil {
+			res.Canceled = true
+			return res
+		}
+		regions := scan(startKey, auditBatchSize)
+		sampled := make([]*core.RegionInfo, 0, len(regions))
+		for _, region := range regions {
+			if sampler.sample() {
+				sampled = append(sampled, region)
+			} else {
+				res.Skipped++
+			}
+		}
+		for i, fit := range m.fitRegionsConcurrently(stores, sampled, opts.Workers) {
+			res.Total++
+			if fit.IsSatisfied() {
+				res.Satisfied++
+			} else {
+				res.Unsatisfied++
+				if len(res.Samples) < opts.MaxSamples {
+					res.Samples = append(res.Samples, sampled[i].GetID())
+				}
+			}
+			if len(fit.OrphanPeers) > 0 {
+				res.WithOrphans++
+			}
+		}
+		if len(regions) < auditBatchSize || len(regions[len(regions)-1].GetEndKey()) == 0 {
+			return res
+		}
+		if timeNow().After(deadline) {
+			res.Truncated = true
+			return res
+		}
+		startKey = regions[len(regions)-1].GetEndKey()
+		runtime.Gosched()
+	}
+}
+
+// fitRegionsConcurrently fits the regions with at most workers goroutines, and
+// returns the fits in the order of the regions.
+func (m *RuleManager) fitRegionsConcurrently(stores StoreSet, regions []*core.RegionInfo, workers int) []*RegionFit {
+	fits := make([]*RegionFit, len(regions))
+	if workers <= 1 || len(regions) <= 1 {
+		for i, region := range regions {
+			fits[i] = m.FitRegion(stores, region)
+		}
+		return fits
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(regions); i += workers {
+				fits[i] = m.FitRegion(stores, regions[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+	return fits
+}
+
+// auditSampler picks the regions evenly by the fraction, so that the result is
+// deterministic for the same region list.
+type auditSampler struct {
+	fraction float64
+	acc      float64
+}
+
+func newAuditSampler(fraction float64) *auditSampler {
+	if fraction <= 0 || fraction >= 1 {
+		fraction = 1
+	}
+	return &auditSampler{fraction: fraction}
+}
+
+func (s *auditSampler) sample() bool {
+	s.acc += s.fraction
+	if s.acc < 1 {
+		return false
+	}
+	s.acc--
+	return true
+}
+
+// SampleSatisfiedRatio fits the sampled regions and updates the gauge of the
+// ratio of satisfied regions. It returns the ratio, and keeps the gauges
+// unchanged if there is no region sampled.
+// Each unsatisfied region is also attributed to the location label values of
+// the stores hosting its peers, so that the gauges by label tell which
+// location the unsatisfied regions gather in.
+func (m *RuleManager) SampleSatisfiedRatio(stores StoreSet, regions []*core.RegionInfo) float64 {
+	if len(regions) == 0 {
+		return 0
+	}
+	var satisfied int
+	unsatisfied := make(map[labelValue]int)
+	for _, region := range regions {
+		fit := m.FitRegion(stores, region)
+		if fit.IsSatisfied() {
+			satisfied++
+			continue
+		}
+		for l := range fitLabelValues(fit, region) {
+			unsatisfied[l]++
+		}
+	}
+	ratio := float64(satisfied) / float64(len(regions))
+	satisfiedRegionRatioGauge.Set(ratio)
+	unsatisfiedByLabelGauge.Reset()
+	for l, count := range unsatisfied {
+		unsatisfiedByLabelGauge.WithLabelValues(l.key, l.value).Set(float64(count))
+	}
+	return ratio
+}
+
+type labelValue struct {
+	key   string
+	value string
+}
+
+// fitLabelValues returns the distinct values of the rules' location labels of
+// the stores hosting the peers of the region.
+func fitLabelValues(fit *RegionFit, region *core.RegionInfo) map[labelValue]struct{} {
+	values := make(map[labelValue]struct{})
+	for _, rf := range fit.RuleFits {
+		for _, key := range rf.Rule.LocationLabels {
+			for _, p := range region.GetPeers() {
+				store := getStoreByID(fit.regionStores, p.GetStoreId())
+				if store == nil {
+					continue
+				}
+				if value := store.GetLabelValue(key); value != "" {
+					values[labelValue{key: key, value: value}] = struct{}{}
+				}
+			}
+		}
+	}
+	return values
+}
@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"time"
+
+	"github.com/tikv/pd/server/core"
 )
 
 // ruleConfig contains rule and rule group configurations.
@@ -131,6 +133,27 @@ func (p *ruleConfigPatch) iterateRules(f func(*Rule)) {
 	}
 }
 
+// changedKeyRanges returns the key ranges of the rules changed by the patch,
+// both before and after the change, and of the rules in the changed groups.
+func (p *ruleConfigPatch) changedKeyRanges() []core.KeyRange {
+	var keyRanges []core.KeyRange
+	add := func(r *Rule) {
+		if r != nil {
+			keyRanges = append(keyRanges, core.KeyRange{StartKey: r.StartKey, EndKey: r.EndKey})
+		}
+	}
+	for key, r := range p.mut.rules {
+		add(r)
+		add(p.c.getRule(key))
+	}
+	p.iterateRules(func(r *Rule) {
+		if _, ok := p.mut.groups[r.GroupID]; ok {
+			add(r)
+		}
+	})
+	return keyRanges
+}
+
 func (p *ruleConfigPatch) adjust() {
 	// setup rule.group for `buildRuleList` use.
 	p.iterateRules(func(r *Rule) { r.group = p.getGroup(r.GroupID) })
@@ -0,0 +1,51 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+// EmptyLabelPolicy decides how the isolation scoring treats the stores which
+// lack a location label.
+type EmptyLabelPolicy string
+
+const (
+	// EmptyLabelExcluded excludes the label from the comparison of two stores
+	// if any of them lacks it, and the comparison goes on with the next label.
+	// So a store without the label is considered at the same location with any
+	// other store at that level. It is the default policy.
+	EmptyLabelExcluded EmptyLabelPolicy = "excluded"
+	// EmptyLabelDistinct considers a store without the label at a distinct
+	// location from any other store, including the other stores without it.
+	EmptyLabelDistinct EmptyLabelPolicy = "distinct"
+	// EmptyLabelSame considers all stores without the label at the same
+	// location, which differs from the locations of the stores with the label.
+	EmptyLabelSame EmptyLabelPolicy = "same"
+)
+
+// isDefault checks if the policy is EmptyLabelExcluded, which is the default
+// if the policy is not set.
+func (p EmptyLabelPolicy) isDefault() bool {
+	return p == "" || p == EmptyLabelExcluded
+}
+
+// differ returns whether the label values differ when any of them is empty.
+func (p EmptyLabelPolicy) differ(v1, v2 string) bool {
+	switch p {
+	case EmptyLabelDistinct:
+		return true
+	case EmptyLabelSame:
+		return v1 != v2
+	default:
+		return false
+	}
+}
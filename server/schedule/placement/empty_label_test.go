@@ -0,0 +1,55 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/core"
+)
+
+func TestEmptyLabelPolicy(t *testing.T) {
+	re := require.New(t)
+	var location *locationConfig
+	newStore := func(id uint64, labels map[string]string) *fitPeer {
+		store := core.NewStoreInfoWithLabel(id, 0, labels)
+		p := &fitPeer{Peer: &metapb.Peer{Id: id, StoreId: id}, store: store}
+		p.compare = func(s1, s2 *core.StoreInfo, labels []string) int {
+			return location.compare(s1, s2, labels)
+		}
+		return p
+	}
+	a := newStore(1, map[string]string{"zone": "z1", "host": "h1"})
+	b := newStore(2, map[string]string{"host": "h2"})
+	c := newStore(3, map[string]string{"host": "h3"})
+	labels := []string{"zone", "host"}
+
+	// By default, the zone is skipped if any store lacks it.
+	re.Equal([]int{0, 1}, isolationLevels([]*fitPeer{a, b}, labels))
+	re.Equal([]int{0, 1}, isolationLevels([]*fitPeer{b, c}, labels))
+
+	location = newLocationConfig("", 0, nil, EmptyLabelDistinct)
+	re.Equal([]int{1, 0}, isolationLevels([]*fitPeer{a, b}, labels))
+	re.Equal([]int{1, 0}, isolationLevels([]*fitPeer{b, c}, labels))
+	re.Equal([]int{3, 0}, isolationLevels([]*fitPeer{a, b, c}, labels))
+
+	location = newLocationConfig("", 0, nil, EmptyLabelSame)
+	re.Equal([]int{1, 0}, isolationLevels([]*fitPeer{a, b}, labels))
+	re.Equal([]int{0, 1}, isolationLevels([]*fitPeer{b, c}, labels))
+	re.Equal([]int{2, 1}, isolationLevels([]*fitPeer{a, b, c}, labels))
+	re.Greater(isolationScore([]*fitPeer{a, b}, labels), isolationScore([]*fitPeer{b, c}, labels))
+}
@@ -15,10 +15,17 @@
 package placement
 
 import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
 	"math"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/pkg/slice"
 	"github.com/tikv/pd/pkg/syncutil"
 	"github.com/tikv/pd/server/core"
 )
@@ -31,10 +38,31 @@ type RegionFit struct {
 		syncutil.RWMutex
 		cached bool
 	}
-	RuleFits     []*RuleFit
-	OrphanPeers  []*metapb.Peer
+	RuleFits []*RuleFit
+	// OrphanPeers lists the witnesses first. They carry no data, so they are
+	// removed before the others.
+	OrphanPeers []*metapb.Peer
+	// Truncated indicates the search is aborted due to the time or iteration
+	// budget, so the result may be not the best.
+	Truncated bool
+	// Approximate indicates the peers of some rules are selected greedily,
+	// either because the search is truncated or because a rule has too many
+	// candidates to enumerate, so the fit is complete but may be not the best.
+	Approximate  bool
 	regionStores []*core.StoreInfo
 	rules        []*Rule
+	region       *core.RegionInfo
+	location     *locationConfig
+}
+
+// newFitPeer returns the fitPeer of the peer on the store, which compares the
+// locations as the fit does.
+func (f *RegionFit) newFitPeer(peer *metapb.Peer, store *core.StoreInfo) *fitPeer {
+	p := &fitPeer{Peer: peer, store: store}
+	if !f.location.isDefault() {
+		p.compare = f.location.compare
+	}
+	return p
 }
 
 // SetCached indicates this RegionFit is fetch form cache
@@ -62,7 +90,70 @@ func (f *RegionFit) IsSatisfied() bool {
 			return false
 		}
 	}
-	return len(f.OrphanPeers) == 0
+	return len(f.OrphanPeers) == 0 && f.isGroupCountSatisfied()
+}
+
+// areRulesSatisfied returns if the rules are properly satisfied regardless of
+// the orphan peers.
+func (f *RegionFit) areRulesSatisfied() bool {
+	if len(f.RuleFits) == 0 {
+		return false
+	}
+	for _, r := range f.RuleFits {
+		if !r.IsSatisfied() {
+			return false
+		}
+	}
+	return f.isGroupCountSatisfied()
+}
+
+// SafeOrphanRemovals returns the orphan peers which can be removed together
+// without breaking the rules. If the rules are not satisfied, only the orphan
+// peers on the stores matching no rule are returned, since the others may be
+// needed by an alternate assignment once the region is fixed.
+func (f *RegionFit) SafeOrphanRemovals() []*metapb.Peer {
+	if f.region == nil || len(f.OrphanPeers) == 0 {
+		return nil
+	}
+	var safe []*metapb.Peer
+	if !f.areRulesSatisfied() {
+		for _, p := range f.OrphanPeers {
+			store := getStoreByID(f.regionStores, p.GetStoreId())
+			if !slice.AnyOf(f.rules, func(i int) bool { return matchRuleStore(f.rules[i], store) }) {
+				safe = append(safe, p)
+			}
+		}
+		return safe
+	}
+	var removed []uint64
+	for _, p := range f.OrphanPeers {
+		storeIDs := append(removed[:len(removed):len(removed)], p.GetStoreId())
+		if fitRegionWithoutPeers(f.regionStores, f.region, f.rules, storeIDs...).areRulesSatisfied() {
+			removed = storeIDs
+			safe = append(safe, p)
+		}
+	}
+	return safe
+}
+
+// isGroupCountSatisfied checks if the total count of peers of each rule group
+// with group-level count is fulfilled.
+func (f *RegionFit) isGroupCountSatisfied() bool {
+	var counts map[*RuleGroup]int
+	for _, r := range f.RuleFits {
+		if r.Rule.groupCount() > 0 {
+			if counts == nil {
+				counts = make(map[*RuleGroup]int)
+			}
+			counts[r.Rule.group] += len(r.Peers)
+		}
+	}
+	for g, count := range counts {
+		if count != g.Count {
+			return false
+		}
+	}
+	return true
 }
 
 // GetRuleFit returns the RuleFit that contains the peer.
@@ -82,25 +173,458 @@ func (f *RegionFit) GetRegionStores() []*core.StoreInfo {
 	return f.regionStores
 }
 
+// ConflictingPeers returns the peers violating the anti-affinities between
+// rules, which should be moved away.
+func (f *RegionFit) ConflictingPeers() []*metapb.Peer {
+	var peers []*metapb.Peer
+	for _, rf := range f.RuleFits {
+		peers = append(peers, rf.ConflictingPeers...)
+	}
+	return peers
+}
+
+// RuleIsolation is the isolation score of the peers selected by a rule.
+type RuleIsolation struct {
+	GroupID string  `json:"group_id"`
+	ID      string  `json:"id"`
+	Score   float64 `json:"score"`
+}
+
+// IsolationBreakdown returns the isolation scores of the rules, in the order of
+// the RuleFits.
+func (f *RegionFit) IsolationBreakdown() []RuleIsolation {
+	res := make([]RuleIsolation, 0, len(f.RuleFits))
+	for _, rf := range f.RuleFits {
+		res = append(res, RuleIsolation{GroupID: rf.Rule.GroupID, ID: rf.Rule.ID, Score: rf.IsolationScore})
+	}
+	return res
+}
+
+// TotalIsolationScore returns the sum of the isolation scores of the rules.
+func (f *RegionFit) TotalIsolationScore() float64 {
+	var score float64
+	for _, rf := range f.RuleFits {
+		score += rf.IsolationScore
+	}
+	return score
+}
+
+// OrphansByStore groups the orphan peers by the stores they are on.
+func (f *RegionFit) OrphansByStore() map[uint64][]*metapb.Peer {
+	orphans := make(map[uint64][]*metapb.Peer, len(f.OrphanPeers))
+	for _, p := range f.OrphanPeers {
+		orphans[p.GetStoreId()] = append(orphans[p.GetStoreId()], p)
+	}
+	return orphans
+}
+
+// StoresWithExcessOrphans returns the IDs of the stores hosting more than
+// threshold orphan peers across the fits, in ascending order. Orphans gathering
+// on a store, such as after a failed scale-in, make the store over-replicated,
+// so it should be cleaned up first.
+func StoresWithExcessOrphans(fits []*RegionFit, threshold int) []uint64 {
+	counts := make(map[uint64]int)
+	for _, fit := range fits {
+		for storeID, peers := range fit.OrphansByStore() {
+			counts[storeID] += len(peers)
+		}
+	}
+	var storeIDs []uint64
+	for storeID, count := range counts {
+		if count > threshold {
+			storeIDs = append(storeIDs, storeID)
+		}
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+	return storeIDs
+}
+
+// PeersByRemovalSafety returns the peers of the region ordered from the safest
+// to remove to the least safe one. The orphan peers come first, then the peers
+// whose removal costs their rule the least isolation, and the peers which are
+// the only ones fulfilling a rule come last. Peers of the same safety keep the
+// order of the region.
+func (f *RegionFit) PeersByRemovalSafety(region *core.RegionInfo) []*metapb.Peer {
+	const (
+		orphan = iota
+		redundant
+		critical
+	)
+	type peerSafety struct {
+		peer  *metapb.Peer
+		class int
+		loss  float64
+	}
+	peers := make([]peerSafety, 0, len(region.GetPeers()))
+	for _, p := range region.GetPeers() {
+		rf := f.GetRuleFit(p.GetId())
+		switch {
+		case rf == nil:
+			peers = append(peers, peerSafety{peer: p, class: orphan})
+		case len(rf.Peers) == 1:
+			peers = append(peers, peerSafety{peer: p, class: critical})
+		default:
+			rest := make([]*fitPeer, 0, len(rf.Peers)-1)
+			for _, q := range rf.Peers {
+				if q.GetId() != p.GetId() {
+					rest = append(rest, f.newFitPeer(q, getStoreByID(f.regionStores, q.GetStoreId())))
+				}
+			}
+			loss := rf.IsolationScore - levelsScore(isolationLevels(rest, rf.Rule.isolationLabels()))
+			peers = append(peers, peerSafety{peer: p, class: redundant, loss: loss})
+		}
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		if peers[i].class != peers[j].class {
+			return peers[i].class < peers[j].class
+		}
+		return peers[i].loss < peers[j].loss
+	})
+	ordered := make([]*metapb.Peer, 0, len(peers))
+	for _, p := range peers {
+		ordered = append(ordered, p.peer)
+	}
+	return ordered
+}
+
+// LeaderEligiblePeers returns the voters which can become the leader without
+// breaking the rules. If there is a Leader rule, only its peers are eligible,
+// otherwise the voters of the Voter and Replica rules are. The peers on the
+// stores unable to host leaders, such as TiFlash, are never eligible.
+func (f *RegionFit) LeaderEligiblePeers() []*metapb.Peer {
+	hasLeaderRule := slice.AnyOf(f.RuleFits, func(i int) bool { return f.RuleFits[i].Rule.Role == Leader })
+	var peers []*metapb.Peer
+	for _, rf := range f.RuleFits {
+		switch rf.Rule.Role {
+		case Leader: // The peer of the Leader rule is always eligible.
+		case Voter, Replica:
+			if hasLeaderRule {
+				continue
+			}
+		default:
+			continue
+		}
+		for _, p := range rf.Peers {
+			if core.IsLearner(p) {
+				continue
+			}
+			if store := getStoreByID(f.regionStores, p.GetStoreId()); store != nil && store.IsLeaderCapable() {
+				peers = append(peers, p)
+			}
+		}
+	}
+	return peers
+}
+
+// MergeRegionFits combines the fits of the same region to the rules of
+// different groups, which are fitted separately. The RuleFits are
+// concatenated, and a peer is orphan only if it is orphan in all fits.
+func MergeRegionFits(fits ...*RegionFit) *RegionFit {
+	merged := &RegionFit{}
+	orphanCount := make(map[uint64]int)
+	for _, fit := range fits {
+		merged.RuleFits = append(merged.RuleFits, fit.RuleFits...)
+		merged.rules = append(merged.rules, fit.rules...)
+		merged.Truncated = merged.Truncated || fit.Truncated
+		merged.Approximate = merged.Approximate || fit.Approximate
+		if merged.region == nil {
+			merged.regionStores, merged.region, merged.location = fit.regionStores, fit.region, fit.location
+		}
+		for _, p := range fit.OrphanPeers {
+			orphanCount[p.GetId()]++
+		}
+	}
+	if len(fits) > 0 {
+		for _, p := range fits[0].OrphanPeers {
+			if orphanCount[p.GetId()] == len(fits) {
+				merged.OrphanPeers = append(merged.OrphanPeers, p)
+			}
+		}
+	}
+	return merged
+}
+
+// Hash returns a hash of the fit result. It covers the store IDs and roles of
+// the peers of each rule and the store IDs of orphan peers, and it does not
+// depend on the order of the peers, so it can be used to detect whether a
+// recomputed fit is actually changed.
+func (f *RegionFit) Hash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	for i, rf := range f.RuleFits {
+		peers := append(rf.Peers[:0:0], rf.Peers...)
+		sort.Slice(peers, func(i, j int) bool { return peers[i].GetStoreId() < peers[j].GetStoreId() })
+		write(uint64(i))
+		write(uint64(len(peers)))
+		for _, p := range peers {
+			write(p.GetStoreId())
+			write(uint64(p.GetRole()))
+		}
+	}
+	orphanStores := make([]uint64, 0, len(f.OrphanPeers))
+	for _, p := range f.OrphanPeers {
+		orphanStores = append(orphanStores, p.GetStoreId())
+	}
+	sort.Slice(orphanStores, func(i, j int) bool { return orphanStores[i] < orphanStores[j] })
+	write(uint64(len(orphanStores)))
+	for _, storeID := range orphanStores {
+		write(storeID)
+	}
+	return h.Sum64()
+}
+
 // CompareRegionFit determines the superiority of 2 fits.
 // It returns 1 when the first fit result is better.
 func CompareRegionFit(a, b *RegionFit) int {
+	cmp, _, _ := compareRegionFitDimension(a, b, nil)
+	return cmp
+}
+
+// PeerSizeFunc returns the data size of the peer.
+type PeerSizeFunc func(peer *metapb.Peer) int64
+
+// CompareRegionFitBySize is the same as CompareRegionFit, except that the
+// orphan peers are compared by their total data size rather than the count,
+// since removing a large orphan peer costs more than removing a small one.
+func CompareRegionFitBySize(a, b *RegionFit, size PeerSizeFunc) int {
+	cmp, _, _ := compareRegionFitDimension(a, b, size)
+	return cmp
+}
+
+// CompareRegionFitWithCost is the same as CompareRegionFit, except that when
+// the 2 fits are equally good, the one requiring fewer peer movements from the
+// current region is better, which reduces the scheduling churn.
+func CompareRegionFitWithCost(a, b *RegionFit, current *core.RegionInfo) int {
+	if cmp := CompareRegionFit(a, b); cmp != 0 || current == nil {
+		return cmp
+	}
+	ca, cb := moveCount(a, current), moveCount(b, current)
+	switch {
+	case ca < cb:
+		return 1
+	case ca > cb:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// moveCount returns how many peers need to be added or removed to turn the
+// current region into the fit.
+func moveCount(f *RegionFit, current *core.RegionInfo) int {
+	stores := make(map[uint64]struct{})
+	for _, rf := range f.RuleFits {
+		for _, p := range rf.Peers {
+			stores[p.GetStoreId()] = struct{}{}
+		}
+	}
+	for _, p := range f.OrphanPeers {
+		stores[p.GetStoreId()] = struct{}{}
+	}
+	var count int
+	for storeID := range stores {
+		if current.GetStorePeer(storeID) == nil {
+			count++
+		}
+	}
+	for _, p := range current.GetPeers() {
+		if _, ok := stores[p.GetStoreId()]; !ok {
+			count++
+		}
+	}
+	return count
+}
+
+// CompareRegionFitWithTolerance is the same as CompareRegionFit, except that
+// the 2 fits are treated as equally good if the result is determined by the
+// isolation and the difference of the isolation scores does not exceed
+// minIsolationGain, so that a marginal isolation gain does not cause churn.
+// The difference is taken in the integer units of isolationUnits, so that it
+// is exact however large the scores are.
+func CompareRegionFitWithTolerance(a, b *RegionFit, minIsolationGain float64) int {
+	cmp, index, dim := compareRegionFitDimension(a, b, nil)
+	if cmp == 0 || dim != dimIsolation {
+		return cmp
+	}
+	rfA, rfB := a.RuleFits[index], b.RuleFits[index]
+	withCost := rfA.Rule.NetworkCost != nil && rfB.Rule.NetworkCost != nil
+	gain := rfA.isolationUnits(withCost) - rfB.isolationUnits(withCost)
+	if gain < 0 {
+		gain = -gain
+	}
+	if gain <= int64(math.Round(minIsolationGain*isolationScale)) {
+		return 0
+	}
+	return cmp
+}
+
+// ExplainCompare describes which rule and which dimension determine the
+// result of CompareRegionFit, such as "rule 0: peer count" or "orphan count".
+// It returns "equal" if the 2 fits are equally good.
+func ExplainCompare(a, b *RegionFit) string {
+	cmp, index, dim := compareRegionFitDimension(a, b, nil)
+	switch {
+	case cmp == 0:
+		return "equal"
+	case index < 0:
+		return dim
+	default:
+		return fmt.Sprintf("rule %d: %s", index, dim)
+	}
+}
+
+// compareRegionFitDimension compares 2 fits, and returns the index of the rule
+// and the dimension that determine the result as well. The index is -1 if the
+// result is determined by orphan peers. The orphan peers are weighted by size
+// if it is not nil.
+func compareRegionFitDimension(a, b *RegionFit, size PeerSizeFunc) (int, int, string) {
 	for i := range a.RuleFits {
 		if i >= len(b.RuleFits) {
 			break
 		}
-		if cmp := compareRuleFit(a.RuleFits[i], b.RuleFits[i]); cmp != 0 {
-			return cmp
+		if cmp, dim := compareRuleFitDimension(a.RuleFits[i], b.RuleFits[i]); cmp != 0 {
+			return cmp, i, dim
+		}
+	}
+	if size != nil {
+		sa, sb := orphanSize(a, size), orphanSize(b, size)
+		switch {
+		case sa < sb:
+			return 1, -1, dimOrphanSize
+		case sa > sb:
+			return -1, -1, dimOrphanSize
+		default:
+			return 0, -1, dimOrphanSize
 		}
 	}
 	switch {
 	case len(a.OrphanPeers) < len(b.OrphanPeers):
-		return 1
+		return 1, -1, dimOrphanCount
 	case len(a.OrphanPeers) > len(b.OrphanPeers):
-		return -1
+		return -1, -1, dimOrphanCount
 	default:
-		return 0
+		return 0, -1, dimOrphanCount
+	}
+}
+
+// PrioritizeFits returns IDs of the regions whose fits are not ideal, in the
+// order they should be fixed. A region with a worse fit goes first, and the
+// larger region goes first if their fits are equally bad.
+func PrioritizeFits(fits map[uint64]*RegionFit, sizeOf func(uint64) int64) []uint64 {
+	ids := make([]uint64, 0, len(fits))
+	for id, fit := range fits {
+		if CompareRegionFit(fit, idealFit(fit)) < 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if cmp := CompareRegionFit(fits[ids[i]], fits[ids[j]]); cmp != 0 {
+			return cmp < 0
+		}
+		if si, sj := sizeOf(ids[i]), sizeOf(ids[j]); si != sj {
+			return si > sj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// idealFit returns a fit that fulfills all rules of the given fit with the
+// same isolation, which is used as the reference of fit badness.
+func idealFit(f *RegionFit) *RegionFit {
+	ideal := &RegionFit{RuleFits: make([]*RuleFit, 0, len(f.RuleFits))}
+	for _, rf := range f.RuleFits {
+		count := rf.Rule.Count
+		if rf.Rule.isWeighted() && rf.isCountSatisfied() {
+			// The weighted Peers are as many as the fit needs.
+			count = len(rf.Peers)
+		}
+		ideal.RuleFits = append(ideal.RuleFits, &RuleFit{
+			Rule:            rf.Rule,
+			Peers:           make([]*metapb.Peer, count),
+			weightedCount:   float64(rf.Rule.Count),
+			IsolationScore:  rf.IsolationScore,
+			isolationLevels: rf.isolationLevels,
+			TierCompliant:   true,
+		})
+	}
+	return ideal
+}
+
+// RuleFitSpreadInfo describes a rule whose peers are spread across more
+// failure domains than needed.
+type RuleFitSpreadInfo struct {
+	Rule *Rule
+	// Level is the location label that defines the failure domains.
+	Level string
+	// Domains is the number of failure domains the peers span.
+	Domains int
+	// Needed is the minimal number of failure domains to survive the failure of
+	// any one of them.
+	Needed int
+}
+
+// OverSpread returns the rules whose peers span more failure domains than
+// needed to keep the majority of the peers after any one domain fails. The
+// failure domain is defined by the IsolationLevel of the rule, or the most
+// significant location label if it is not set.
+func (f *RegionFit) OverSpread() []RuleFitSpreadInfo {
+	var res []RuleFitSpreadInfo
+	for _, rf := range f.RuleFits {
+		level, depth := spreadLevel(rf.Rule)
+		if depth == 0 {
+			continue
+		}
+		needed := neededDomains(len(rf.Peers))
+		domains := make(map[string]struct{})
+		for _, p := range rf.Peers {
+			store := getStoreByID(f.regionStores, p.GetStoreId())
+			if store == nil {
+				continue
+			}
+			values := make([]string, 0, depth)
+			for _, label := range rf.Rule.LocationLabels[:depth] {
+				values = append(values, store.GetLabelValue(label))
+			}
+			domains[strings.Join(values, "/")] = struct{}{}
+		}
+		if len(domains) > needed {
+			res = append(res, RuleFitSpreadInfo{Rule: rf.Rule, Level: level, Domains: len(domains), Needed: needed})
+		}
 	}
+	return res
+}
+
+// spreadLevel returns the location label that defines the failure domains of
+// the rule, and the number of location labels to identify a domain.
+func spreadLevel(rule *Rule) (string, int) {
+	if len(rule.LocationLabels) == 0 {
+		return "", 0
+	}
+	if rule.IsolationLevel == "" {
+		return rule.LocationLabels[0], 1
+	}
+	for i, label := range rule.LocationLabels {
+		if label == rule.IsolationLevel {
+			return label, i + 1
+		}
+	}
+	return "", 0
+}
+
+// neededDomains returns the minimal number of failure domains for count peers
+// to keep the majority after any one domain fails. It returns count if it is
+// impossible, which means any spread is not redundant.
+func neededDomains(count int) int {
+	tolerable := (count - 1) / 2 // the count of peers that can be lost
+	if tolerable == 0 {
+		return count
+	}
+	return (count + tolerable - 1) / tolerable
 }
 
 // RuleFit is the result of fitting status of a Rule.
@@ -112,46 +636,528 @@ type RuleFit struct {
 	// different Role from configuration (the Role can be migrated to target role
 	// by scheduling).
 	PeersWithDifferentRole []*metapb.Peer
+	// ConstraintViolatingPeers is subset of `Peers`. It contains all Peers whose
+	// stores do not match the LabelConstraints of the Rule, e.g. the store is
+	// relabeled after the peer is placed.
+	ConstraintViolatingPeers []*metapb.Peer
+	// MaintenancePeers is subset of `Peers`. It contains the Peers on the stores
+	// in maintenance which have a different Role or violate the LabelConstraints.
+	// They are tolerated until the maintenance is over, so that they are not
+	// fixed prematurely.
+	MaintenancePeers []*metapb.Peer
 	// IsolationScore indicates at which level of labeling these Peers are
 	// isolated. A larger value is better.
 	IsolationScore float64
+	// isolationLevels records the number of peer pairs isolated at each level
+	// of labeling. Unlike IsolationScore, it is compared level by level, so it
+	// keeps the order even if the label hierarchy is deep.
+	isolationLevels []int
+	// PoorlyIsolatedPeers is subset of `Peers`. It contains the Peers whose
+	// removal would improve the isolation the most, so they are the best ones
+	// to move for a better isolation.
+	PoorlyIsolatedPeers []*metapb.Peer
+	// AffinityViolated indicates that the Peers do not share the declared label
+	// value with the Peers of the rule paired by a RuleAffinity.
+	AffinityViolated bool
+	// affinityRequired indicates that the violated affinity is a hard constraint.
+	affinityRequired bool
+	// ConflictingPeers is subset of `Peers`. It contains the Peers sharing the
+	// label value with the Peers of the rule paired by an anti-affinity.
+	ConflictingPeers []*metapb.Peer
+	// healthyCount is the count of Peers that are neither down nor pending.
+	healthyCount int
+	// OnPreferredLeaderStore indicates the Rule is a leader rule, and its Peer
+	// is on the store hinted to host the leader.
+	OnPreferredLeaderStore bool
+	// groupAffinity is the count of Peers on the stores hosting the sibling
+	// regions in the placement affinity group of the region.
+	groupAffinity int
+	// promotionLag is the replication lag of the learners which need to be
+	// promoted to satisfy the Rule. A fresher learner catches up sooner after
+	// promotion.
+	promotionLag peerLag
+	// SameDeepestLabelExceeded indicates that more Peers than allowed by
+	// MaxSameDeepestLabel of the Rule share the same deepest location.
+	SameDeepestLabelExceeded bool
+	// OnConstraintShortage indicates that fewer Peers than MinOnConstraint of
+	// the Rule are on the stores matching OnLabelConstraints.
+	OnConstraintShortage bool
+	// OverCountPeers is subset of `Peers`. It contains the surplus Peers which
+	// stretch the Rule instead of being orphans, see
+	// ReplicationConfig.PlacementRulesPreferStretchOverOrphan.
+	OverCountPeers []*metapb.Peer
+	// networkCost is the cost of the traffic between the Peers, if the Rule
+	// has a NetworkCost.
+	networkCost float64
+	// busyPenalty is the penalty of the Peers on busy stores, see
+	// ReplicationConfig.PlacementRulesBusyStorePenalty.
+	busyPenalty float64
+	// weightedCount is the count of Peers weighted by the RoleWeights of the
+	// Rule.
+	weightedCount float64
+	// TierCompliant indicates the Peers meet the TierRequirement of the Rule.
+	// It is informational only, and is always true if the Rule has no
+	// TierRequirement.
+	TierCompliant bool
 }
 
 // IsSatisfied returns if the rule is properly satisfied.
 func (f *RuleFit) IsSatisfied() bool {
-	return len(f.Peers) == f.Rule.Count && len(f.PeersWithDifferentRole) == 0
+	return f.isCountSatisfied() && len(f.PeersWithDifferentRole) == 0 &&
+		len(f.ConstraintViolatingPeers) == 0 && !f.brokeRequiredAffinity() &&
+		f.healthyCount >= f.Rule.MinHealthy && !f.SameDeepestLabelExceeded &&
+		!f.OnConstraintShortage
+}
+
+// isCountSatisfied checks the count of peers. If the rule belongs to a group
+// with group-level count, the Count of the rule is only an upper bound and the
+// total count is checked by RegionFit.
+func (f *RuleFit) isCountSatisfied() bool {
+	count := len(f.Peers) - len(f.OverCountPeers)
+	if f.Rule.groupCount() > 0 {
+		return count <= f.Rule.Count
+	}
+	if f.Rule.isWeighted() {
+		// The Peers counting less than a replica are made up by more Peers.
+		return f.weightedCount >= float64(f.Rule.Count)
+	}
+	return count == f.Rule.Count
+}
+
+// IsShortOfPeers returns if more peers are needed to make up the Count of the
+// rule. If the Rule weights the roles, it needs more Peers until the weighted
+// count reaches the Count.
+func (f *RuleFit) IsShortOfPeers() bool {
+	if f.Rule.isWeighted() {
+		return f.weightedCount < float64(f.Rule.Count)
+	}
+	return len(f.Peers) < f.Rule.Count
+}
+
+// cappedWeightedCount returns the weighted count of the Peers up to the Count
+// of the Rule, so that the surplus Peers do not make a fit better.
+func (f *RuleFit) cappedWeightedCount() float64 {
+	return math.Min(f.weightedCount, float64(f.Rule.Count))
+}
+
+func (f *RuleFit) brokeRequiredAffinity() bool {
+	return f.AffinityViolated && f.affinityRequired
+}
+
+// Dimensions that determine the comparison of fits.
+const (
+	dimRequiredAffinity    = "required affinity"
+	dimPeerCount           = "peer count"
+	dimConstraintViolation = "constraint violation"
+	dimRoleMismatch        = "role mismatch"
+	dimDemotion            = "demotion count"
+	dimSameDeepestLabel    = "same deepest label"
+	dimOnConstraint        = "on constraint"
+	dimAffinity            = "affinity"
+	dimIsolation           = "isolation"
+	dimBusyStore           = "busy store"
+	dimGroupAffinity       = "group affinity"
+	dimPreferredLeader     = "preferred leader"
+	dimFreshness           = "promotion freshness"
+	dimOrphanCount         = "orphan count"
+	dimOrphanSize          = "orphan size"
+)
+
+func orphanSize(f *RegionFit, size PeerSizeFunc) int64 {
+	var total int64
+	for _, p := range f.OrphanPeers {
+		total += size(p)
+	}
+	return total
+}
+
+// demotionCount returns how many voters need to be demoted to learners to
+// satisfy the rule. A demoting voter in joint state is already on the way, so
+// it is not counted. Demotion reduces the quorum, so fewer is safer.
+func (f *RuleFit) demotionCount() int {
+	if !f.Rule.Role.IsNonVoting() {
+		return 0
+	}
+	var count int
+	for _, p := range f.PeersWithDifferentRole {
+		if p.GetRole() != metapb.PeerRole_DemotingVoter {
+			count++
+		}
+	}
+	return count
 }
 
 func compareRuleFit(a, b *RuleFit) int {
+	cmp, _ := compareRuleFitDimension(a, b)
+	return cmp
+}
+
+// compareRuleFitDimension compares 2 rule fits, and returns the dimension
+// that determines the result as well.
+func compareRuleFitDimension(a, b *RuleFit) (int, string) {
 	switch {
+	case a.brokeRequiredAffinity() && !b.brokeRequiredAffinity():
+		return -1, dimRequiredAffinity
+	case !a.brokeRequiredAffinity() && b.brokeRequiredAffinity():
+		return 1, dimRequiredAffinity
+	case a.Rule.isWeighted() && a.cappedWeightedCount() < b.cappedWeightedCount():
+		return -1, dimPeerCount
+	case a.Rule.isWeighted() && a.cappedWeightedCount() > b.cappedWeightedCount():
+		return 1, dimPeerCount
+	case a.Rule.isWeighted() && len(a.Peers) > len(b.Peers):
+		// The Peers not needed to reach the Count are left as orphans.
+		return -1, dimPeerCount
+	case a.Rule.isWeighted() && len(a.Peers) < len(b.Peers):
+		return 1, dimPeerCount
 	case len(a.Peers) < len(b.Peers):
-		return -1
+		return -1, dimPeerCount
 	case len(a.Peers) > len(b.Peers):
-		return 1
+		return 1, dimPeerCount
+	case len(a.ConstraintViolatingPeers) > len(b.ConstraintViolatingPeers):
+		// A peer of another role can be fixed in place, while the one on a
+		// store violating the constraints must be moved.
+		return -1, dimConstraintViolation
+	case len(a.ConstraintViolatingPeers) < len(b.ConstraintViolatingPeers):
+		return 1, dimConstraintViolation
 	case len(a.PeersWithDifferentRole) > len(b.PeersWithDifferentRole):
-		return -1
+		return -1, dimRoleMismatch
 	case len(a.PeersWithDifferentRole) < len(b.PeersWithDifferentRole):
-		return 1
-	case a.IsolationScore < b.IsolationScore:
+		return 1, dimRoleMismatch
+	case a.demotionCount() > b.demotionCount():
+		return -1, dimDemotion
+	case a.demotionCount() < b.demotionCount():
+		return 1, dimDemotion
+	case a.SameDeepestLabelExceeded && !b.SameDeepestLabelExceeded:
+		return -1, dimSameDeepestLabel
+	case !a.SameDeepestLabelExceeded && b.SameDeepestLabelExceeded:
+		return 1, dimSameDeepestLabel
+	case a.OnConstraintShortage && !b.OnConstraintShortage:
+		return -1, dimOnConstraint
+	case !a.OnConstraintShortage && b.OnConstraintShortage:
+		return 1, dimOnConstraint
+	case a.AffinityViolated && !b.AffinityViolated:
+		return -1, dimAffinity
+	case !a.AffinityViolated && b.AffinityViolated:
+		return 1, dimAffinity
+	default:
+		if cmp := compareIsolation(a, b); cmp != 0 {
+			return cmp, dimIsolation
+		}
+		switch {
+		case a.busyPenalty > b.busyPenalty:
+			return -1, dimBusyStore
+		case a.busyPenalty < b.busyPenalty:
+			return 1, dimBusyStore
+		case a.groupAffinity < b.groupAffinity:
+			return -1, dimGroupAffinity
+		case a.groupAffinity > b.groupAffinity:
+			return 1, dimGroupAffinity
+		case !a.OnPreferredLeaderStore && b.OnPreferredLeaderStore:
+			return -1, dimPreferredLeader
+		case a.OnPreferredLeaderStore && !b.OnPreferredLeaderStore:
+			return 1, dimPreferredLeader
+		}
+		if cmp := comparePeerLag(a.promotionLag, b.promotionLag); cmp != 0 {
+			return cmp, dimFreshness
+		}
+		return 0, dimIsolation
+	}
+}
+
+func compareIsolation(a, b *RuleFit) int {
+	withCost := a.Rule.NetworkCost != nil && b.Rule.NetworkCost != nil
+	if !withCost && a.isolationLevels != nil && len(a.isolationLevels) == len(b.isolationLevels) {
+		return compareLevels(a.isolationLevels, b.isolationLevels)
+	}
+	unitsA, unitsB := a.isolationUnits(withCost), b.isolationUnits(withCost)
+	switch {
+	case unitsA < unitsB:
 		return -1
-	case a.IsolationScore > b.IsolationScore:
+	case unitsA > unitsB:
 		return 1
 	default:
 		return 0
 	}
 }
 
-// StoreSet represents the store container.
-type StoreSet interface {
-	GetStores() []*core.StoreInfo
-	GetStore(id uint64) *core.StoreInfo
+// isolationScale is the count of units an isolation score of 1 is worth in
+// isolationUnits. The weighted network cost is quantized to the units.
+const isolationScale = 1000
+
+// isolationUnits returns the isolation score of the rule fit as an integer
+// scaled by isolationScale, less the weighted network cost if withCost is
+// true. The score is summed up from the isolation levels in integers, so the
+// fits are compared exactly rather than by the float scores, which lose
+// precision as the label hierarchy gets deep.
+func (rf *RuleFit) isolationUnits(withCost bool) int64 {
+	var units int64
+	if rf.isolationLevels != nil {
+		for _, count := range rf.isolationLevels {
+			units = units*isolationLevelBase + int64(count)
+		}
+	} else {
+		// The levels are absent from the fits decoded from the messages.
+		units = int64(math.Round(rf.IsolationScore))
+	}
+	units *= isolationScale
+	if c := rf.Rule.NetworkCost; withCost && c != nil {
+		units -= int64(math.Round(c.Weight * rf.networkCost * isolationScale))
+	}
+	return units
+}
+
+// StoreSet represents the store container.
+type StoreSet interface {
+	GetStores() []*core.StoreInfo
+	GetStore(id uint64) *core.StoreInfo
+}
+
+// timeNow is used to check the time budget. It can be replaced in tests.
+var timeNow = time.Now
+
+// FitBudget bounds the search of a fit. The search is aborted once either
+// budget is used up, and the best fit found so far is returned with Truncated
+// set. Zero means no limit.
+//
+// The default budget of a RuleManager is read from the replication config,
+// e.g. ReplicationConfig.PlacementRulesFitMaxDuration, rather than set by a
+// package-level setter, so that it is persisted and changed online like the
+// other options, and the clusters in the same process do not share it.
+type FitBudget struct {
+	// MaxDuration is the time budget of fitting a region.
+	MaxDuration time.Duration
+	// MaxIterations is the max count of peer combinations evaluated in fitting
+	// a region.
+	MaxIterations int
+}
+
+// fitConfig is the configuration of fitting a region. The RuleManager reads it
+// from the persisted options, see RuleManager.fitConfig.
+type fitConfig struct {
+	budget FitBudget
+	// maxExactCandidates is the max count of candidates of a rule whose
+	// combinations are enumerated. A rule with more candidates selects its
+	// peers greedily instead, since the combinations grow exponentially, and
+	// the fit is flagged as Approximate. Zero means the combinations are always
+	// enumerated.
+	maxExactCandidates int
+	// busyStorePenalty is the penalty of each peer on a store reporting that it
+	// is busy, such as IO overloaded. A fit with a larger penalty loses to the
+	// others which are equal otherwise, so the busy stores are deprioritized
+	// but not excluded. Zero disables the penalty.
+	busyStorePenalty float64
+	// preferStretchOverOrphan assigns a surplus peer, which would be an orphan
+	// since the rules are all fulfilled, to a fulfilled rule matching its role
+	// and store instead. For example, an extra voter stretches the voter rule
+	// and is kept. Such peers are flagged as OverCountPeers.
+	preferStretchOverOrphan bool
+	// votersOnlyIsolation scores the isolation of the Leader and Voter rules by
+	// their voting peers only, so that the learners waiting to be promoted do
+	// not count. It makes the isolation score reflect the quorum resilience.
+	votersOnlyIsolation bool
+	// retryTruncatedFit retries a fit truncated due to the budget greedily,
+	// which selects the best peers rule by rule without search. The greedy fit
+	// replaces the truncated one unless it is worse, and then it is flagged as
+	// Approximate.
+	retryTruncatedFit bool
+	// location is the configuration of comparing the locations of the stores
+	// for isolation.
+	location *locationConfig
+}
+
+// defaultMaxExactFitCandidates is the default of fitConfig.maxExactCandidates.
+const defaultMaxExactFitCandidates = 20
+
+// defaultFitConfig is used to fit the regions out of a RuleManager.
+var defaultFitConfig = fitConfig{maxExactCandidates: defaultMaxExactFitCandidates}
+
+// isolationPeers returns the peers of the rule which are scored for isolation.
+func (c *fitConfig) isolationPeers(rule *Rule, peers []*fitPeer) []*fitPeer {
+	if !c.votersOnlyIsolation || (rule.Role != Leader && rule.Role != Voter) {
+		return peers
+	}
+	voters := make([]*fitPeer, 0, len(peers))
+	for _, p := range peers {
+		if !core.IsLearner(p.Peer) {
+			voters = append(voters, p)
+		}
+	}
+	return voters
+}
+
+// fitRegion tries to fit peers of a region to the rules.
+func fitRegion(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, opts ...fitPeerOpt) *RegionFit {
+	return fitRegionWithMatchCache(nil, defaultFitConfig, stores, region, rules, opts...)
+}
+
+// fitRegionWithMatchCache is the same as fitRegion, except that it reuses the
+// cached results of matching stores to rules, and it is fitted with the given
+// configuration.
+func fitRegionWithMatchCache(cache *storeMatchCache, cfg fitConfig, stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, opts ...fitPeerOpt) *RegionFit {
+	w := newFitWorker(stores, region, rules, cfg, opts...)
+	w.matchCache = cache
+	return w.fit()
+}
+
+// fitRegionWithContext is the same as fitRegionWithMatchCache, except that the
+// search is also aborted once the context is done.
+func fitRegionWithContext(ctx context.Context, cache *storeMatchCache, cfg fitConfig, stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) *RegionFit {
+	w := newFitWorker(stores, region, rules, cfg)
+	w.matchCache = cache
+	w.ctx = ctx
+	return w.fit()
+}
+
+// fitRegionWithTrace fits the region and records every evaluated peer
+// combination, so that the search can be replayed and diagnosed.
+func fitRegionWithTrace(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) (*RegionFit, *FitTrace) {
+	w := newFitWorker(stores, region, rules, defaultFitConfig)
+	w.trace = &FitTrace{}
+	return w.fit(), w.trace
+}
+
+// FitStats is the cost of fitting a region.
+type FitStats struct {
+	// SearchIterations is the count of evaluated peer combinations.
+	SearchIterations int
+	Duration         time.Duration
+}
+
+// fitRegionWithStats fits the region and measures the cost of the search.
+func fitRegionWithStats(cache *storeMatchCache, cfg fitConfig, stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) (*RegionFit, FitStats) {
+	start := timeNow()
+	w := newFitWorker(stores, region, rules, cfg)
+	w.matchCache = cache
+	fit := w.fit()
+	return fit, FitStats{SearchIterations: w.iterations, Duration: timeNow().Sub(start)}
+}
+
+// FitTrace is the sequence of peer combinations evaluated during the search of
+// a fit, in order. The search is deterministic, so the trace of the same input
+// is always the same.
+type FitTrace struct {
+	Steps []FitTraceStep `json:"steps"`
+}
+
+// FitTraceStep is a peer combination evaluated for a rule.
+type FitTraceStep struct {
+	RuleIndex int      `json:"rule_index"`
+	PeerIDs   []uint64 `json:"peer_ids"`
+	// Compare is the result of comparing with the best RuleFit found so far,
+	// it is 1 if there is no best RuleFit yet.
+	Compare int `json:"compare"`
+}
+
+func (t *FitTrace) record(index int, selected []*fitPeer, cmp int) {
+	ids := make([]uint64, 0, len(selected))
+	for _, p := range selected {
+		ids = append(ids, p.GetId())
+	}
+	t.Steps = append(t.Steps, FitTraceStep{RuleIndex: index, PeerIDs: ids, Compare: cmp})
+}
+
+// fitRegionInOrder fits the rules in the given order instead of the order of
+// the slice, such as by their priorities. The order is the indexes of the rules
+// and must be a permutation of them, otherwise the slice order is used. The
+// RuleFits of the result are still in the slice order, so the output is stable.
+func fitRegionInOrder(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, order []int) *RegionFit {
+	w := newFitWorker(stores, region, rules, defaultFitConfig)
+	if isPermutation(order, len(rules)) {
+		w.order = order
+		w.affinities = resolveAffinities(rules, order)
+	}
+	return w.fit()
+}
+
+func isPermutation(order []int, n int) bool {
+	if len(order) != n {
+		return false
+	}
+	seen := make([]bool, n)
+	for _, i := range order {
+		if i < 0 || i >= n || seen[i] {
+			return false
+		}
+		seen[i] = true
+	}
+	return true
+}
+
+// fitRegionNoLeaderChange fits the region without choosing a placement that
+// implies a leader transfer. The result is unsatisfied if the rules can not be
+// satisfied with the current leader.
+func fitRegionNoLeaderChange(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) *RegionFit {
+	w := newFitWorker(stores, region, rules, defaultFitConfig)
+	w.noLeaderChange = true
+	return w.fit()
+}
+
+// fitRegionAssumingLeader fits the region as if the leader is on the given
+// store, so that the result does not flap with the leader election. No peer is
+// treated as leader if the region has no peer on the store.
+func fitRegionAssumingLeader(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, leaderStoreID uint64) *RegionFit {
+	return fitRegion(stores, region, rules, assumeLeaderOpt(leaderStoreID))
+}
+
+// fitPeerOpt adjusts a peer of the region before fitting.
+type fitPeerOpt func(p *fitPeer)
+
+// RegionGroupStores returns the stores hosting the peers of the sibling regions
+// in the placement affinity group of the region. It returns nil if the region
+// belongs to no group.
+type RegionGroupStores func(region *core.RegionInfo) map[uint64]struct{}
+
+// fitRegionWithLeaderHint fits the region with a soft preference of the
+// leader store. All else being equal, the fit prefers the peer on the store for
+// the leader rule.
+func fitRegionWithLeaderHint(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, leaderStoreID uint64) *RegionFit {
+	return fitRegion(stores, region, rules, leaderHintOpt(leaderStoreID))
+}
+
+func leaderHintOpt(leaderStoreID uint64) fitPeerOpt {
+	return func(p *fitPeer) {
+		p.preferredLeader = p.GetStoreId() == leaderStoreID
+	}
+}
+
+func groupStoresOpt(stores map[uint64]struct{}) fitPeerOpt {
+	return func(p *fitPeer) {
+		_, p.onGroupStore = stores[p.GetStoreId()]
+	}
+}
+
+func assumeLeaderOpt(leaderStoreID uint64) fitPeerOpt {
+	return func(p *fitPeer) {
+		p.isLeader = p.GetStoreId() == leaderStoreID
+	}
 }
 
-// fitRegion tries to fit peers of a region to the rules.
-func fitRegion(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) *RegionFit {
-	w := newFitWorker(stores, region, rules)
-	w.run()
-	return &w.bestFit
+// maintenanceOpt marks the peers on the stores in maintenance, which are
+// tolerated by the rules even if they have a different role or the stores do
+// not match the constraints.
+func maintenanceOpt(stores map[uint64]struct{}) fitPeerOpt {
+	return func(p *fitPeer) {
+		_, p.inMaintenance = stores[p.GetStoreId()]
+	}
+}
+
+// LocationComparator compares the locations of 2 stores by the labels. Like
+// core.StoreInfo.CompareLocation, it returns the index of the first label the
+// locations differ at, or -1 if they are the same.
+type LocationComparator func(a, b *core.StoreInfo, labels []string) int
+
+func locationComparatorOpt(compare LocationComparator) fitPeerOpt {
+	return func(p *fitPeer) {
+		p.compare = compare
+	}
+}
+
+// witnessOpt marks the peers which are witnesses. The peer meta does not tell
+// the witnesses, so they are given by the callers.
+func witnessOpt(peerIDs map[uint64]struct{}) fitPeerOpt {
+	return func(p *fitPeer) {
+		_, p.isWitness = peerIDs[p.GetId()]
+	}
 }
 
 type fitWorker struct {
@@ -159,19 +1165,58 @@ type fitWorker struct {
 	bestFit       RegionFit  // update during execution
 	peers         []*fitPeer // p.selected is updated during execution.
 	rules         []*Rule
+	order         []int            // the indexes of the rules in the order they are fitted.
+	affinities    [][]ruleAffinity // affinities[i] pairs rule i with rules fitted before it.
+	region        *core.RegionInfo
+	selection     [][]*fitPeer // peers selected for each rule in current search path.
 	needIsolation bool
 	exit          bool
+	deadline      time.Time // zero if there is no time budget.
+	matchCache    *storeMatchCache
+	cfg           fitConfig
+	// ctx aborts the search once it is done if it is not nil.
+	ctx context.Context
+	// noLeaderChange pins the current leader, so that it is only selected by
+	// the rules it satisfies as a leader.
+	noLeaderChange bool
+	// trace records the search for debugging if it is not nil.
+	trace *FitTrace
+	// iterations is the count of evaluated peer combinations.
+	iterations int
+	// exhaustive disables the early exit after a satisfied fit is found, so
+	// that all peer combinations are explored. It is used in tests.
+	exhaustive bool
+	// greedy makes every rule enumerate only the candidates selected greedily,
+	// see retryGreedily.
+	greedy bool
+}
+
+// ruleAffinity is a RuleAffinity resolved to the index of the paired rule.
+type ruleAffinity struct {
+	other    int
+	key      string
+	required bool
+	anti     bool
 }
 
-func newFitWorker(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule) *fitWorker {
+func newFitWorker(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, cfg fitConfig, opts ...fitPeerOpt) *fitWorker {
 	regionPeers := region.GetPeers()
 	peers := make([]*fitPeer, 0, len(regionPeers))
 	for _, p := range regionPeers {
-		peers = append(peers, &fitPeer{
+		store := getStoreByID(stores, p.GetStoreId())
+		peer := &fitPeer{
 			Peer:     p,
-			store:    getStoreByID(stores, p.GetStoreId()),
+			store:    store,
 			isLeader: region.GetLeader().GetId() == p.GetId(),
-		})
+			removed:  isStoreRemoved(store),
+		}
+		for _, opt := range opts {
+			opt(peer)
+		}
+		if peer.compare == nil && !cfg.location.isDefault() {
+			peer.compare = cfg.location.compare
+		}
+		peers = append(peers, peer)
 	}
 	// Sort peers to keep the match result deterministic.
 	sort.Slice(peers, func(i, j int) bool {
@@ -180,64 +1225,233 @@ func newFitWorker(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Ru
 		return si > sj || (si == sj && peers[i].GetId() < peers[j].GetId())
 	})
 
+	var deadline time.Time
+	if d := cfg.budget.MaxDuration; d > 0 {
+		deadline = timeNow().Add(d)
+	}
+
+	order := make([]int, len(rules))
+	for i := range order {
+		order[i] = i
+	}
+
+	// The idle stores, the stores of the sibling regions and the hinted leader
+	// store are preferred among the satisfied fits, which needs a full search
+	// as the isolation does.
+	fullSearch := needIsolation(rules) || cfg.busyStorePenalty > 0 ||
+		slice.AnyOf(peers, func(i int) bool { return peers[i].onGroupStore || peers[i].preferredLeader })
+
 	return &fitWorker{
+		region:        region,
 		stores:        stores,
 		bestFit:       RegionFit{RuleFits: make([]*RuleFit, len(rules))},
 		peers:         peers,
-		needIsolation: needIsolation(rules),
+		needIsolation: fullSearch,
 		rules:         rules,
+		order:         order,
+		affinities:    resolveAffinities(rules, order),
+		selection:     make([][]*fitPeer, len(rules)),
+		deadline:      deadline,
+		cfg:           cfg,
+	}
+}
+
+// resolveAffinities attaches each affinity to the rule of the pair fitted
+// later, so that it can be checked after the peers of both rules are selected.
+func resolveAffinities(rules []*Rule, order []int) [][]ruleAffinity {
+	positions := make([]int, len(rules))
+	for pos, i := range order {
+		positions[i] = pos
+	}
+	var affinities [][]ruleAffinity
+	for i, rule := range rules {
+		if rule.Affinity == nil {
+			continue
+		}
+		for j, other := range rules {
+			if i == j || other.GroupID != rule.GroupID || other.ID != rule.Affinity.RuleID {
+				continue
+			}
+			if affinities == nil {
+				affinities = make([][]ruleAffinity, len(rules))
+			}
+			latter, former := i, j
+			if positions[latter] < positions[former] {
+				latter, former = former, latter
+			}
+			affinities[latter] = append(affinities[latter], ruleAffinity{
+				other:    former,
+				key:      rule.Affinity.LabelKey,
+				required: rule.Affinity.Required,
+				anti:     rule.Affinity.Anti,
+			})
+		}
+	}
+	return affinities
+}
+
+func (w *fitWorker) fit() *RegionFit {
+	w.run()
+	if w.bestFit.Truncated && w.cfg.retryTruncatedFit {
+		w.retryGreedily()
 	}
+	w.stretchOrphanPeers()
+	w.markPoorlyIsolatedPeers()
+	w.bestFit.regionStores = w.stores
+	w.bestFit.rules = w.rules
+	w.bestFit.region = w.region
+	w.bestFit.location = w.cfg.location
+	return &w.bestFit
 }
 
 func (w *fitWorker) run() {
+	w.markUnmatchedPeers()
 	w.fitRule(0)
 	w.updateOrphanPeers(0) // All peers go to orphanList when RuleList is empty.
 }
 
+// markUnmatchedPeers marks the peers whose stores match none of the rules, see
+// fitPeer.unmatched.
+func (w *fitWorker) markUnmatchedPeers() {
+	for _, p := range w.peers {
+		p.unmatched = p.store != nil && !p.removed &&
+			slice.NoneOf(w.rules, func(i int) bool { return w.matchCache.match(w.rules[i], p.store) })
+	}
+}
+
 // Pick the most suitable peer combination for the rule.
-// Index specifies the position of the rule.
+// Pos specifies the position of the rule in the fitting order.
 // returns true if it replaces `bestFit` with a better alternative.
-func (w *fitWorker) fitRule(index int) bool {
+func (w *fitWorker) fitRule(pos int) bool {
 	if w.exit {
 		return false
 	}
-	if index >= len(w.rules) {
+	if pos >= len(w.rules) {
 		// If there is no isolation level and we already find one solution, we can early exit searching instead of
 		// searching the whole cases.
-		if !w.needIsolation && w.bestFit.IsSatisfied() {
+		if !w.needIsolation && !w.exhaustive && w.bestFit.IsSatisfied() {
+			w.exit = true
+		}
+		// The bestFit is always complete here, so it is safe to abort. The
+		// greedy search is cheap, so it is not bounded by the budget.
+		if !w.exit && !w.greedy && w.outOfBudget() {
 			w.exit = true
+			w.bestFit.Truncated = true
 		}
 		return false
 	}
 
 	var candidates []*fitPeer
-	if checkRule(w.rules[index], w.stores) {
+	rule := w.rules[w.order[pos]]
+	if slice.AnyOf(w.peers, func(i int) bool { return w.peers[i].inMaintenance || w.peers[i].fallsBackTo(rule) }) ||
+		slice.AnyOf(w.stores, func(i int) bool { return w.matchCache.match(rule, w.stores[i]) }) {
 		// Only consider stores:
-		// 1. Match label constraints
+		// 1. Match label constraints, in maintenance, or matching no rule at all.
 		// 2. Role match, or can match after transformed.
 		// 3. Not selected by other rules.
+		// 4. Not a learner kept by a ReadReplica rule, if the rule is voting.
 		for _, p := range w.peers {
-			if !p.selected && MatchLabelConstraints(p.store, w.rules[index].LabelConstraints) {
+			if w.isCandidate(rule, p) {
 				candidates = append(candidates, p)
 			}
 		}
 	}
 
-	count := w.rules[index].Count
+	count := rule.Count
+	if budget, ok := w.groupBudget(pos); ok && budget < count {
+		count = budget
+	}
 	if len(candidates) < count {
 		count = len(candidates)
 	}
-	return w.enumPeers(candidates, nil, index, count)
+	if w.greedy || (w.cfg.maxExactCandidates > 0 && len(candidates) > w.cfg.maxExactCandidates) {
+		// There are too many combinations to enumerate, so the only one
+		// enumerated is the greedy one.
+		w.bestFit.Approximate = true
+		candidates = greedySelect(rule, candidates, w.greedyCount(rule, candidates, count))
+	}
+	if rule.isWeighted() {
+		// The Peers counting less than a replica are made up by more Peers, so
+		// the selections of more than Count candidates are enumerated as well.
+		var better bool
+		for ; count <= len(candidates) && !w.exit; count++ {
+			better = w.enumPeers(candidates, nil, pos, count) || better
+		}
+		return better
+	}
+	return w.enumPeers(candidates, nil, pos, count)
+}
+
+// outOfBudget checks if the time or iteration budget of the search is used up,
+// or the context of the search is done.
+func (w *fitWorker) outOfBudget() bool {
+	return (!w.deadline.IsZero() && timeNow().After(w.deadline)) ||
+		(w.cfg.budget.MaxIterations > 0 && w.iterations >= w.cfg.budget.MaxIterations) ||
+		(w.ctx != nil && w.ctx.Err() != nil)
+}
+
+// isCandidate checks if the peer can be selected by the rule.
+func (w *fitWorker) isCandidate(rule *Rule, p *fitPeer) bool {
+	return !p.selected && !p.removed && w.keepsLeader(rule, p) &&
+		(p.inMaintenance || w.matchCache.match(rule, p.store) || p.fallsBackTo(rule)) && !w.isReadReplica(rule, p)
+}
+
+// keepsLeader checks if selecting the peer for the rule does not imply a
+// leader transfer when the leader change is forbidden.
+func (w *fitWorker) keepsLeader(rule *Rule, p *fitPeer) bool {
+	if !w.noLeaderChange {
+		return true
+	}
+	switch rule.Role {
+	case Leader:
+		return p.isLeader
+	case Follower, Learner, ReadReplica:
+		return !p.isLeader
+	default:
+		return true
+	}
+}
+
+// isReadReplica checks if the peer is a learner on a store of a ReadReplica
+// rule while the given rule is voting. Such a peer is never selected by the
+// voting rule, otherwise it would be promoted to a voter.
+func (w *fitWorker) isReadReplica(rule *Rule, p *fitPeer) bool {
+	if rule.Role.IsNonVoting() || rule.Role == Replica || !core.IsLearner(p.Peer) {
+		return false
+	}
+	return slice.AnyOf(w.rules, func(i int) bool {
+		return w.rules[i].Role == ReadReplica && w.matchCache.match(w.rules[i], p.store)
+	})
+}
+
+// groupBudget returns how many peers can still be selected by the rules of the
+// same group in current search path, if the rule at the position belongs to a
+// group with group-level count.
+func (w *fitWorker) groupBudget(pos int) (int, bool) {
+	rule := w.rules[w.order[pos]]
+	if rule.groupCount() <= 0 {
+		return 0, false
+	}
+	budget := rule.groupCount()
+	for _, i := range w.order[:pos] {
+		if w.rules[i].group == rule.group {
+			budget -= len(w.selection[i])
+		}
+	}
+	if budget < 0 {
+		budget = 0
+	}
+	return budget, true
 }
 
 // Recursively traverses all feasible peer combinations.
 // For each combination, call `compareBest` to determine whether it is better
 // than the existing option.
 // Returns true if it replaces `bestFit` with a better alternative.
-func (w *fitWorker) enumPeers(candidates, selected []*fitPeer, index int, count int) bool {
+func (w *fitWorker) enumPeers(candidates, selected []*fitPeer, pos int, count int) bool {
 	if len(selected) == count {
 		// We collect enough peers. End recursive.
-		return w.compareBest(selected, index)
+		return w.compareBest(selected, pos)
 	}
 
 	var better bool
@@ -246,7 +1460,7 @@ func (w *fitWorker) enumPeers(candidates, selected []*fitPeer, index int, count
 	for i := 0; i <= indexLimit; i++ {
 		p := candidates[i]
 		p.selected = true
-		better = w.enumPeers(candidates[i+1:], append(selected, p), index, count) || better
+		better = w.enumPeers(candidates[i+1:], append(selected, p), pos, count) || better
 		p.selected = false
 		if w.exit {
 			break
@@ -257,25 +1471,32 @@ func (w *fitWorker) enumPeers(candidates, selected []*fitPeer, index int, count
 
 // compareBest checks if the selected peers is better then previous best.
 // Returns true if it replaces `bestFit` with a better alternative.
-func (w *fitWorker) compareBest(selected []*fitPeer, index int) bool {
-	rf := newRuleFit(w.rules[index], selected)
+func (w *fitWorker) compareBest(selected []*fitPeer, pos int) bool {
+	w.iterations++
+	index := w.order[pos]
+	rf := w.cfg.newRuleFit(w.rules[index], selected, w.region)
+	w.checkAffinity(rf, selected, index)
+	w.selection[index] = selected
 	cmp := 1
 	if best := w.bestFit.RuleFits[index]; best != nil {
 		cmp = compareRuleFit(rf, best)
 	}
+	if w.trace != nil {
+		w.trace.record(index, selected, cmp)
+	}
 
 	switch cmp {
 	case 1:
 		w.bestFit.RuleFits[index] = rf
-		// Reset previous result after position index.
-		for i := index + 1; i < len(w.rules); i++ {
+		// Reset previous result of the rules fitted after this one.
+		for _, i := range w.order[pos+1:] {
 			w.bestFit.RuleFits[i] = nil
 		}
-		w.fitRule(index + 1)
-		w.updateOrphanPeers(index + 1)
+		w.fitRule(pos + 1)
+		w.updateOrphanPeers(pos + 1)
 		return true
 	case 0:
-		if w.fitRule(index + 1) {
+		if w.fitRule(pos + 1) {
 			w.bestFit.RuleFits[index] = rf
 			return true
 		}
@@ -283,55 +1504,448 @@ func (w *fitWorker) compareBest(selected []*fitPeer, index int) bool {
 	return false
 }
 
-// determine the orphanPeers list based on fitPeer.selected flag.
-func (w *fitWorker) updateOrphanPeers(index int) {
-	if index != len(w.rules) {
+// retryGreedily replaces the truncated best fit by the greedy fit unless it is
+// worse. The greedy fit is searched in the same way as the others, except that
+// each rule only enumerates the candidates selected by greedySelect, so that
+// the group budgets, the weights and the affinities of the rules still apply.
+func (w *fitWorker) retryGreedily() {
+	truncated := w.bestFit
+	w.bestFit = RegionFit{RuleFits: make([]*RuleFit, len(w.rules))}
+	w.exit, w.greedy = false, true
+	w.run()
+	if CompareRegionFit(&w.bestFit, &truncated) < 0 {
+		w.bestFit = truncated
+	}
+}
+
+// greedyCount returns the count of the candidates selected greedily for the
+// rule. A weighted rule may need more than count peers, so it keeps the ones
+// making up Count as well, within the limit of the enumerated candidates.
+func (w *fitWorker) greedyCount(rule *Rule, candidates []*fitPeer, count int) int {
+	if !rule.isWeighted() {
+		return count
+	}
+	n := weightedMaxCount(rule, candidates)
+	if limit := w.cfg.maxExactCandidates; limit > 0 && n > limit {
+		n = limit
+	}
+	if n < count {
+		n = count
+	}
+	return n
+}
+
+// greedySelect selects at most count peers from the candidates one by one,
+// preferring the ones matching the role of the rule and then the ones adding
+// the most isolation to the selected ones. The former candidate wins a tie.
+// Unlike the search, it costs linear time in the count of candidates.
+func greedySelect(rule *Rule, candidates []*fitPeer, count int) []*fitPeer {
+	labels := rule.isolationLabels()
+	candidates = append(candidates[:0:0], candidates...)
+	selected := make([]*fitPeer, 0, count)
+	for len(selected) < count && len(candidates) > 0 {
+		var (
+			best      = -1
+			bestRole  bool
+			bestScore float64
+		)
+		for i, p := range candidates {
+			role, score := p.matchRoleStrict(rule.Role), isolationScore(append(selected, p), labels)
+			if best < 0 || (role && !bestRole) || (role == bestRole && score > bestScore) {
+				best, bestRole, bestScore = i, role, score
+			}
+		}
+		selected = append(selected, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+	return selected
+}
+
+// weightedMaxCount returns the max count of the candidates a weighted rule
+// needs to select, that is, the count of the lightest ones making up Count.
+// The candidates counting nothing never help, so they are ignored.
+func weightedMaxCount(rule *Rule, candidates []*fitPeer) int {
+	weights := make([]float64, 0, len(candidates))
+	for _, p := range candidates {
+		if w := p.weight(rule); w > 0 {
+			weights = append(weights, w)
+		}
+	}
+	sort.Float64s(weights)
+	var sum float64
+	for i, w := range weights {
+		if sum += w; sum >= float64(rule.Count) {
+			return i + 1
+		}
+	}
+	return len(candidates)
+}
+
+// stretchOrphanPeers assigns the orphan peers to the first fulfilled rule
+// matching their roles and stores, if it prefers stretching over orphaning.
+func (w *fitWorker) stretchOrphanPeers() {
+	if !w.cfg.preferStretchOverOrphan || len(w.bestFit.OrphanPeers) == 0 {
+		return
+	}
+	var orphans []*metapb.Peer
+	for _, p := range w.bestFit.OrphanPeers {
+		var stretched bool
+		for _, fp := range w.peers {
+			if fp.Peer != p {
+				continue
+			}
+			for _, rf := range w.bestFit.RuleFits {
+				if fp.removed || rf.Rule.groupCount() > 0 || rf.IsShortOfPeers() ||
+					!fp.matchRoleStrict(rf.Rule.Role) || !w.matchCache.match(rf.Rule, fp.store) {
+					continue
+				}
+				rf.Peers = append(rf.Peers, p)
+				rf.OverCountPeers = append(rf.OverCountPeers, p)
+				rf.weightedCount += fp.weight(rf.Rule)
+				rf.TierCompliant = rf.TierCompliant && fp.meetsTier(rf.Rule.TierRequirement)
+				stretched = true
+				break
+			}
+		}
+		if !stretched {
+			orphans = append(orphans, p)
+		}
+	}
+	w.bestFit.OrphanPeers = orphans
+}
+
+// markPoorlyIsolatedPeers fills the PoorlyIsolatedPeers of the best fit. It is
+// done after the search since it is too expensive for every candidate.
+func (w *fitWorker) markPoorlyIsolatedPeers() {
+	for _, rf := range w.bestFit.RuleFits {
+		if rf == nil || len(rf.Rule.LocationLabels) == 0 {
+			continue
+		}
+		peers := make([]*fitPeer, 0, len(rf.Peers))
+		for _, p := range rf.Peers {
+			for _, fp := range w.peers {
+				if fp.Peer == p {
+					peers = append(peers, fp)
+				}
+			}
+		}
+		rf.PoorlyIsolatedPeers = poorlyIsolatedPeers(peers, rf.Rule.isolationLabels())
+	}
+}
+
+// checkAffinity marks the RuleFit if the selected peers do not share the label
+// value with the peers selected by the paired rules in current search path.
+func (w *fitWorker) checkAffinity(rf *RuleFit, selected []*fitPeer, index int) {
+	if w.affinities == nil {
+		return
+	}
+	for _, a := range w.affinities[index] {
+		if a.anti {
+			conflicting := conflictingPeers(selected, w.selection[a.other], a.key)
+			if len(conflicting) == 0 {
+				continue
+			}
+			rf.ConflictingPeers = append(rf.ConflictingPeers, conflicting...)
+		} else if colocated(selected, w.selection[a.other], a.key) {
+			continue
+		}
+		rf.AffinityViolated = true
+		rf.affinityRequired = rf.affinityRequired || a.required
+	}
+}
+
+// conflictingPeers returns the peers sharing the value of the label with any of
+// the others.
+func conflictingPeers(peers, others []*fitPeer, key string) []*metapb.Peer {
+	var res []*metapb.Peer
+	for _, p := range peers {
+		v := p.store.GetLabelValue(key)
+		if v != "" && slice.AnyOf(others, func(i int) bool { return strings.EqualFold(others[i].store.GetLabelValue(key), v) }) {
+			res = append(res, p.Peer)
+		}
+	}
+	return res
+}
+
+// colocated checks if every peer of `peers` shares the label value with at
+// least one of `others`.
+func colocated(peers, others []*fitPeer, key string) bool {
+	if len(peers) == 0 || len(others) == 0 {
+		return false
+	}
+	for _, p := range peers {
+		v := p.store.GetLabelValue(key)
+		if v == "" || slice.NoneOf(others, func(i int) bool { return strings.EqualFold(others[i].store.GetLabelValue(key), v) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// determine the orphanPeers list based on fitPeer.selected flag. The witnesses
+// are listed first, since they are the cheapest to remove.
+func (w *fitWorker) updateOrphanPeers(pos int) {
+	if pos != len(w.rules) {
 		return
 	}
 	w.bestFit.OrphanPeers = w.bestFit.OrphanPeers[:0]
 	for _, p := range w.peers {
-		if !p.selected {
+		if !p.selected && p.isWitness {
+			w.bestFit.OrphanPeers = append(w.bestFit.OrphanPeers, p.Peer)
+		}
+	}
+	for _, p := range w.peers {
+		if !p.selected && !p.isWitness {
 			w.bestFit.OrphanPeers = append(w.bestFit.OrphanPeers, p.Peer)
 		}
 	}
 }
 
-func newRuleFit(rule *Rule, peers []*fitPeer) *RuleFit {
-	rf := &RuleFit{Rule: rule, IsolationScore: isolationScore(peers, rule.LocationLabels)}
+func (c *fitConfig) newRuleFit(rule *Rule, peers []*fitPeer, region *core.RegionInfo) *RuleFit {
+	levels := isolationLevels(c.isolationPeers(rule, peers), rule.isolationLabels())
+	rf := &RuleFit{Rule: rule, IsolationScore: levelsScore(levels), isolationLevels: levels, TierCompliant: true}
+	if rule.NetworkCost != nil {
+		rf.networkCost = networkCost(rule.NetworkCost, peers)
+	}
 	for _, p := range peers {
+		if c.busyStorePenalty > 0 && p.store != nil && p.store.IsBusy() {
+			rf.busyPenalty += c.busyStorePenalty
+		}
 		rf.Peers = append(rf.Peers, p.Peer)
+		rf.weightedCount += p.weight(rule)
+		if !p.meetsTier(rule.TierRequirement) {
+			rf.TierCompliant = false
+		}
+		if p.onGroupStore {
+			rf.groupAffinity++
+		}
+		if p.preferredLeader && rule.Role == Leader {
+			rf.OnPreferredLeaderStore = true
+		}
+		if region != nil && stateScore(region, p.GetId()) == healthyStateScore {
+			rf.healthyCount++
+		}
+		if p.inMaintenance && (!p.matchRoleStrict(rule.Role) || !matchRuleStore(rule, p.store)) {
+			rf.MaintenancePeers = append(rf.MaintenancePeers, p.Peer)
+			continue
+		}
 		if !p.matchRoleStrict(rule.Role) {
 			rf.PeersWithDifferentRole = append(rf.PeersWithDifferentRole, p.Peer)
+			if region != nil && !rule.Role.IsNonVoting() && core.IsLearner(p.Peer) {
+				rf.promotionLag = rf.promotionLag.add(replicationLag(region, p))
+			}
+		}
+		if !matchRuleStore(rule, p.store) {
+			rf.ConstraintViolatingPeers = append(rf.ConstraintViolatingPeers, p.Peer)
 		}
 	}
+	if rule.MaxSameDeepestLabel > 0 {
+		rf.SameDeepestLabelExceeded = maxSameDeepestLocation(c.location, peers, rule.LocationLabels) > rule.MaxSameDeepestLabel
+	}
+	if rule.MinOnConstraint > 0 {
+		rf.OnConstraintShortage = countOnConstraints(peers, rule.OnLabelConstraints) < rule.MinOnConstraint
+	}
 	return rf
 }
 
+// maxSameDeepestLocation returns the max count of peers sharing the same
+// location at the deepest level of labels. The peers whose stores have no
+// value of the deepest label are not counted.
+func maxSameDeepestLocation(location *locationConfig, peers []*fitPeer, labels []string) int {
+	if len(labels) == 0 {
+		return 0
+	}
+	deepest := labels[len(labels)-1]
+	var max int
+	for i, p1 := range peers {
+		if p1.store == nil || location.labelValue(p1.store, deepest) == "" {
+			continue
+		}
+		count := 1
+		for j, p2 := range peers {
+			if i != j && p2.store != nil && location.labelValue(p2.store, deepest) != "" &&
+				p1.compareLocation(p2, labels) == -1 {
+				count++
+			}
+		}
+		if count > max {
+			max = count
+		}
+	}
+	return max
+}
+
+// countOnConstraints returns the count of peers on the stores matching all the
+// constraints.
+func countOnConstraints(peers []*fitPeer, constraints []LabelConstraint) int {
+	var count int
+	for _, p := range peers {
+		if p.store != nil && slice.AllOf(constraints, func(i int) bool { return constraints[i].MatchStore(p.store) }) {
+			count++
+		}
+	}
+	return count
+}
+
 type fitPeer struct {
 	*metapb.Peer
 	store    *core.StoreInfo
 	isLeader bool
 	selected bool
+	// isWitness indicates the peer is a witness, which is weighted by the
+	// Witness role instead of its raft role.
+	isWitness bool
+	// onGroupStore indicates the store hosts peers of the sibling regions in
+	// the placement affinity group.
+	onGroupStore bool
+	// preferredLeader indicates the store is hinted to host the leader.
+	preferredLeader bool
+	// inMaintenance indicates the store is in maintenance, so that the peer is
+	// tolerated by any rule.
+	inMaintenance bool
+	// compare overrides compareLocation to compare the location of the store
+	// with the others.
+	compare LocationComparator
+	// removed indicates the store is tombstone or physically destroyed, so
+	// that the peer is left as an orphan to be removed.
+	removed bool
+	// unmatched indicates the store matches none of the rules, e.g. the store
+	// is relabeled after the peer is placed.
+	unmatched bool
+}
+
+// fallsBackTo checks if the peer is a candidate of the rule although its store
+// does not match the constraints. Rather than being an orphan, a peer on a
+// store matching no rule is kept by a rule of its role, and it is reported in
+// ConstraintViolatingPeers so that it is replaced.
+func (p *fitPeer) fallsBackTo(rule *Rule) bool {
+	return p.unmatched && p.matchRoleStrict(rule.Role)
+}
+
+// meetsTier checks if the store of the peer is on the tiers of the requirement.
+// A peer other than the leader always meets the requirement for the leader.
+func (p *fitPeer) meetsTier(req *TierRequirement) bool {
+	if req == nil || (req.LeaderOnly && !p.isLeader) {
+		return true
+	}
+	return p.store != nil && slice.AnyOf(req.Tiers, func(i int) bool { return req.Tiers[i] == p.store.GetLabelValue(req.LabelKey) })
+}
+
+// weight returns the weight of the peer toward the Count of the rule. The
+// weight of the Leader and Follower roles falls back to the Voter one, and a
+// peer counts as a replica if its role is not weighted.
+func (p *fitPeer) weight(rule *Rule) float64 {
+	if len(rule.RoleWeights) == 0 {
+		return 1
+	}
+	var roles []PeerRoleType
+	switch {
+	case p.isWitness:
+		roles = []PeerRoleType{Witness}
+	case core.IsLearner(p.Peer):
+		roles = []PeerRoleType{Learner}
+	case p.isLeader:
+		roles = []PeerRoleType{Leader, Voter}
+	default:
+		roles = []PeerRoleType{Follower, Voter}
+	}
+	for _, role := range roles {
+		if w, ok := rule.RoleWeights[role]; ok {
+			return w
+		}
+	}
+	return 1
+}
+
+// compareLocation compares the locations of the stores of the peers, with the
+// comparator of the peer if it is set.
+func (p *fitPeer) compareLocation(other *fitPeer, labels []string) int {
+	if p.compare != nil {
+		return p.compare(p.store, other.store, labels)
+	}
+	return p.store.CompareLocation(other.store, labels)
 }
 
 func (p *fitPeer) matchRoleStrict(role PeerRoleType) bool {
 	switch role {
 	case Voter: // Voter matches either Leader or Follower.
 		return !core.IsLearner(p.Peer)
-	case Leader:
-		return p.isLeader
+	case Leader: // Leader only matches the leader on a store able to host leaders.
+		return p.isLeader && (p.store == nil || p.store.IsLeaderCapable())
 	case Follower:
 		return !core.IsLearner(p.Peer) && !p.isLeader
-	case Learner:
+	case Learner, ReadReplica:
 		return core.IsLearner(p.Peer)
+	case Replica: // Replica matches any peer.
+		return true
 	}
 	return false
 }
 
 func isolationScore(peers []*fitPeer, labels []string) float64 {
+	return levelsScore(isolationLevels(peers, labels))
+}
+
+// networkCost sums up the cost between the leader and each of the other peers,
+// or between each pair of peers if there is no leader.
+func networkCost(c *NetworkCost, peers []*fitPeer) float64 {
+	var cost float64
+	for _, p := range peers {
+		if p.isLeader {
+			for _, other := range peers {
+				cost += locationCost(c, p.store, other.store)
+			}
+			return cost
+		}
+	}
+	for i, p := range peers {
+		for _, other := range peers[i+1:] {
+			cost += locationCost(c, p.store, other.store)
+		}
+	}
+	return cost
+}
+
+// locationCost returns the cost between the locations of the stores. The
+// matrix is symmetric, so only one direction needs to be configured.
+func locationCost(c *NetworkCost, s1, s2 *core.StoreInfo) float64 {
+	if s1 == nil || s2 == nil {
+		return 0
+	}
+	v1, v2 := s1.GetLabelValue(c.LabelKey), s2.GetLabelValue(c.LabelKey)
+	if v1 == v2 {
+		return 0
+	}
+	if cost, ok := c.Costs[v1][v2]; ok {
+		return cost
+	}
+	return c.Costs[v2][v1]
+}
+
+// IsolationScoreAtLevel returns the count of store pairs isolated at or above
+// the label at the given level, so that the differences of the deeper labels
+// are ignored. For example, the level of "zone" tells how many pairs are in
+// different zones. It returns 0 if the level is out of range.
+func IsolationScoreAtLevel(stores []*core.StoreInfo, labels []string, level int) float64 {
+	if level < 0 || level >= len(labels) {
+		return 0
+	}
 	var score float64
+	for i, s1 := range stores {
+		for _, s2 := range stores[i+1:] {
+			if s1.CompareLocation(s2, labels[:level+1]) != -1 {
+				score++
+			}
+		}
+	}
+	return score
+}
+
+// isolationLevels returns the number of peer pairs that are isolated at each
+// level of labels. A pair is counted at the first level their locations differ.
+func isolationLevels(peers []*fitPeer, labels []string) []int {
 	if len(labels) == 0 || len(peers) <= 1 {
-		return 0
+		return nil
 	}
 	// NOTE: following loop is partially duplicated with `core.DistinctScore`.
 	// The reason not to call it directly is that core.DistinctScore only
@@ -339,17 +1953,97 @@ func isolationScore(peers []*fitPeer, labels []string) float64 {
 	// here because it is kind of hot path.
 	// After Go supports generics, we will be enable to do some refactor and
 	// reuse `core.DistinctScore`.
-	const replicaBaseScore = 100
+	levels := make([]int, len(labels))
 	for i, p1 := range peers {
 		for _, p2 := range peers[i+1:] {
-			if index := p1.store.CompareLocation(p2.store, labels); index != -1 {
-				score += math.Pow(replicaBaseScore, float64(len(labels)-index-1))
+			if index := p1.compareLocation(p2, labels); index != -1 {
+				levels[index]++
 			}
 		}
 	}
+	return levels
+}
+
+// compareLevels compares 2 isolation levels of the same length level by level.
+func compareLevels(a, b []int) int {
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// poorlyIsolatedPeers returns the peers whose removal improves the isolation
+// the most. It returns nil if removing any of the peers makes no difference,
+// e.g. the peers are isolated evenly.
+func poorlyIsolatedPeers(peers []*fitPeer, labels []string) []*metapb.Peer {
+	if len(labels) == 0 || len(peers) <= 2 {
+		return nil
+	}
+	rest := make([]*fitPeer, 0, len(peers)-1)
+	levels := make([][]int, len(peers))
+	best, worst := 0, 0
+	for i := range peers {
+		rest = append(append(rest[:0], peers[:i]...), peers[i+1:]...)
+		levels[i] = isolationLevels(rest, labels)
+		if compareLevels(levels[i], levels[best]) > 0 {
+			best = i
+		}
+		if compareLevels(levels[i], levels[worst]) < 0 {
+			worst = i
+		}
+	}
+	if compareLevels(levels[best], levels[worst]) == 0 {
+		return nil
+	}
+	var res []*metapb.Peer
+	for i, p := range peers {
+		if compareLevels(levels[i], levels[best]) == 0 {
+			res = append(res, p.Peer)
+		}
+	}
+	return res
+}
+
+// levelsScore folds the isolation levels into a single score. The score may
+// lose precision when there are many levels, so it is only used for display
+// and fits are compared by levels directly.
+// isolationLevelBase is the worth of a pair isolated at a level relative to a
+// pair isolated at the next level.
+const isolationLevelBase = 100
+
+func levelsScore(levels []int) float64 {
+	var score float64
+	for i, count := range levels {
+		if count > 0 {
+			score += float64(count) * math.Pow(isolationLevelBase, float64(len(levels)-i-1))
+		}
+	}
 	return score
 }
 
+// matchRuleStore checks if the store can place peers of the rule. If the rule
+// is pinned to a store, only that store matches as long as it is up, and the
+// label constraints are bypassed.
+func matchRuleStore(rule *Rule, store *core.StoreInfo) bool {
+	if rule.StoreID != 0 {
+		return store != nil && store.GetID() == rule.StoreID && store.IsUp()
+	}
+	return MatchLabelConstraints(store, rule.LabelConstraints) &&
+		!MatchForbiddenLabelConstraints(store, rule.ForbiddenLabelConstraints)
+}
+
+// isStoreRemoved checks if the store is tombstone or physically destroyed. Such
+// a store may still be in the store list given by a stale snapshot, but it can
+// never host the peers of any rule.
+func isStoreRemoved(store *core.StoreInfo) bool {
+	return store != nil && (store.IsRemoved() || store.IsPhysicallyDestroyed())
+}
+
 func needIsolation(rules []*Rule) bool {
 	for _, rule := range rules {
 		if len(rule.LocationLabels) > 0 {
@@ -359,6 +2053,65 @@ func needIsolation(rules []*Rule) bool {
 	return false
 }
 
+// peerLag is the replication lag of peers estimated by the stats reported by
+// the region and the stores.
+type peerLag struct {
+	// downSeconds is how long the peers are down.
+	downSeconds uint64
+	// pending is the count of the peers whose logs are behind the leader.
+	pending int
+	// receivingSnaps is the count of the snapshots received by the stores of
+	// the peers, which are still catching up.
+	receivingSnaps uint64
+}
+
+func replicationLag(region *core.RegionInfo, p *fitPeer) peerLag {
+	var lag peerLag
+	for _, stats := range region.GetDownPeers() {
+		if stats.GetPeer().GetId() == p.GetId() {
+			lag.downSeconds = stats.GetDownSeconds()
+		}
+	}
+	if region.GetPendingPeer(p.GetId()) != nil {
+		lag.pending = 1
+	}
+	if p.store != nil {
+		lag.receivingSnaps = uint64(p.store.GetReceivingSnapCount())
+	}
+	return lag
+}
+
+func (l peerLag) add(other peerLag) peerLag {
+	return peerLag{
+		downSeconds:    l.downSeconds + other.downSeconds,
+		pending:        l.pending + other.pending,
+		receivingSnaps: l.receivingSnaps + other.receivingSnaps,
+	}
+}
+
+// comparePeerLag compares the lags in the order of the down time, the pending
+// peers and the received snapshots. It returns 1 if a is fresher than b, -1 if
+// b is fresher, and 0 if they are the same.
+func comparePeerLag(a, b peerLag) int {
+	switch {
+	case a.downSeconds > b.downSeconds:
+		return -1
+	case a.downSeconds < b.downSeconds:
+		return 1
+	case a.pending > b.pending:
+		return -1
+	case a.pending < b.pending:
+		return 1
+	case a.receivingSnaps > b.receivingSnaps:
+		return -1
+	case a.receivingSnaps < b.receivingSnaps:
+		return 1
+	}
+	return 0
+}
+
+const healthyStateScore = 2
+
 func stateScore(region *core.RegionInfo, peerID uint64) int {
 	switch {
 	case region.GetDownPeer(peerID) != nil:
@@ -366,6 +2119,6 @@ func stateScore(region *core.RegionInfo, peerID uint64) int {
 	case region.GetPendingPeer(peerID) != nil:
 		return 1
 	default:
-		return 2
+		return healthyStateScore
 	}
 }
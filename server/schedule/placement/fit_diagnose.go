@@ -0,0 +1,162 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"github.com/tikv/pd/server/core"
+)
+
+// RuleDiagnosis explains how a rule is fitted by the peers of a region.
+type RuleDiagnosis struct {
+	GroupID   string `json:"group_id"`
+	ID        string `json:"id"`
+	Satisfied bool   `json:"satisfied"`
+	// Count is the count of peers required by the rule, and Candidates is the
+	// count of peers on the stores matching the rule.
+	Count      int `json:"count"`
+	Candidates int `json:"candidates"`
+	// ShortOfCandidates indicates there are fewer candidates than required, so
+	// the rule can only be satisfied by adding peers.
+	ShortOfCandidates bool `json:"short_of_candidates"`
+	// Exclusions are the label constraints of the rule failed by the stores of
+	// the peers.
+	Exclusions []ConstraintExclusion `json:"exclusions,omitempty"`
+	// ForbiddenStoreIDs are the stores of the peers matching the
+	// ForbiddenLabelConstraints of the rule.
+	ForbiddenStoreIDs []uint64 `json:"forbidden_store_ids,omitempty"`
+	// WrongRolePeers is the count of selected peers with a different role.
+	WrongRolePeers int `json:"wrong_role_peers"`
+	// IsolationScore is the isolation of the selected peers, and
+	// BestIsolationScore is the best one achievable with the stores the region
+	// is fitted with, see BestPossibleIsolation.
+	IsolationScore     float64 `json:"isolation_score"`
+	BestIsolationScore float64 `json:"best_isolation_score"`
+}
+
+// ConstraintExclusion is a label constraint and the stores failing it.
+type ConstraintExclusion struct {
+	Constraint LabelConstraint `json:"constraint"`
+	StoreIDs   []uint64        `json:"store_ids"`
+}
+
+// OrphanDiagnosis explains why a peer is not selected by any rule.
+type OrphanDiagnosis struct {
+	PeerID  uint64 `json:"peer_id"`
+	StoreID uint64 `json:"store_id"`
+	// StoreRemoved indicates the store is tombstone or physically destroyed.
+	StoreRemoved bool `json:"store_removed,omitempty"`
+	// MismatchedLabels are the labels of the store failing the label
+	// constraints of the rules, and a missing label has an empty value. If it
+	// is empty, the store matches some rules which have enough peers.
+	MismatchedLabels map[string]string `json:"mismatched_labels,omitempty"`
+}
+
+// Diagnose explains why the region does or does not satisfy each rule, in the
+// order of the RuleFits.
+func (f *RegionFit) Diagnose() []RuleDiagnosis {
+	res := make([]RuleDiagnosis, 0, len(f.RuleFits))
+	for _, rf := range f.RuleFits {
+		rule := rf.Rule
+		d := RuleDiagnosis{
+			GroupID:        rule.GroupID,
+			ID:             rule.ID,
+			Satisfied:      rf.IsSatisfied(),
+			Count:          rule.Count,
+			WrongRolePeers: len(rf.PeersWithDifferentRole),
+			IsolationScore: rf.IsolationScore,
+		}
+		excluded := make([][]uint64, len(rule.LabelConstraints))
+		for _, store := range f.peerStores() {
+			if !isStoreRemoved(store) && matchRuleStore(rule, store) {
+				d.Candidates++
+			}
+			if rule.StoreID != 0 {
+				continue
+			}
+			for i, c := range rule.LabelConstraints {
+				if !c.MatchStore(store) {
+					excluded[i] = append(excluded[i], store.GetID())
+				}
+			}
+			if MatchForbiddenLabelConstraints(store, rule.ForbiddenLabelConstraints) {
+				d.ForbiddenStoreIDs = append(d.ForbiddenStoreIDs, store.GetID())
+			}
+		}
+		for i, storeIDs := range excluded {
+			if len(storeIDs) > 0 {
+				d.Exclusions = append(d.Exclusions, ConstraintExclusion{Constraint: rule.LabelConstraints[i], StoreIDs: storeIDs})
+			}
+		}
+		d.ShortOfCandidates = d.Candidates < rule.Count
+		if f.region != nil {
+			d.BestIsolationScore = BestPossibleIsolation(f.regionStores, f.region, rule)
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// DiagnoseOrphans explains why each orphan peer is not selected by any rule.
+func (f *RegionFit) DiagnoseOrphans() []OrphanDiagnosis {
+	res := make([]OrphanDiagnosis, 0, len(f.OrphanPeers))
+	for _, p := range f.OrphanPeers {
+		d := OrphanDiagnosis{PeerID: p.GetId(), StoreID: p.GetStoreId()}
+		store := getStoreByID(f.regionStores, p.GetStoreId())
+		if store == nil {
+			res = append(res, d)
+			continue
+		}
+		d.StoreRemoved = isStoreRemoved(store)
+		for _, rf := range f.RuleFits {
+			if rf.Rule.StoreID != 0 {
+				continue
+			}
+			for _, c := range rf.Rule.LabelConstraints {
+				if !c.MatchStore(store) {
+					d.addMismatchedLabel(store, c.Key)
+				}
+			}
+			if MatchForbiddenLabelConstraints(store, rf.Rule.ForbiddenLabelConstraints) {
+				for _, c := range rf.Rule.ForbiddenLabelConstraints {
+					d.addMismatchedLabel(store, c.Key)
+				}
+			}
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+func (d *OrphanDiagnosis) addMismatchedLabel(store *core.StoreInfo, key string) {
+	if d.MismatchedLabels == nil {
+		d.MismatchedLabels = make(map[string]string)
+	}
+	d.MismatchedLabels[key] = store.GetLabelValue(key)
+}
+
+// peerStores returns the stores of the peers of the region, in the order of
+// the peers. The peers whose stores are unknown are skipped.
+func (f *RegionFit) peerStores() []*core.StoreInfo {
+	if f.region == nil {
+		return nil
+	}
+	var stores []*core.StoreInfo
+	for _, p := range f.region.GetPeers() {
+		if store := getStoreByID(f.regionStores, p.GetStoreId()); store != nil {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
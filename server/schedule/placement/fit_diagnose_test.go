@@ -0,0 +1,68 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{
+		makeRule("2/voter/zone=zone1/zone,rack,host"),
+		makeRule("1/learner/zone=zone2+zone3/zone"),
+		makeRule("1/voter/zone=zone5/zone"),
+	}
+	for i, id := range []string{"r1", "r2", "r3"} {
+		rules[i].GroupID, rules[i].ID = "pd", id
+	}
+	fit := fitRegion(stores, makeRegion("1111_leader,1112,2111,4111_learner"), rules)
+	diagnosis := fit.Diagnose()
+	re.Len(diagnosis, 3)
+
+	// The peers share the same host, while they can be isolated by rack.
+	re.Equal("r1", diagnosis[0].ID)
+	re.Equal(2, diagnosis[0].Candidates)
+	re.False(diagnosis[0].ShortOfCandidates)
+	re.Equal([]ConstraintExclusion{{Constraint: rules[0].LabelConstraints[0], StoreIDs: []uint64{2111, 4111}}}, diagnosis[0].Exclusions)
+	re.Zero(diagnosis[0].WrongRolePeers)
+	re.Zero(diagnosis[0].IsolationScore)
+	re.Equal(100.0, diagnosis[0].BestIsolationScore)
+
+	// The only candidate is a voter.
+	re.Equal(1, diagnosis[1].Candidates)
+	re.False(diagnosis[1].Satisfied)
+	re.Equal([]uint64{1111, 1112, 4111}, diagnosis[1].Exclusions[0].StoreIDs)
+	re.Equal(1, diagnosis[1].WrongRolePeers)
+
+	// No peer is in zone5.
+	re.Zero(diagnosis[2].Candidates)
+	re.True(diagnosis[2].ShortOfCandidates)
+	re.False(diagnosis[2].Satisfied)
+	re.Equal([]uint64{1111, 1112, 2111, 4111}, diagnosis[2].Exclusions[0].StoreIDs)
+
+	orphans := fit.DiagnoseOrphans()
+	re.Equal([]OrphanDiagnosis{{PeerID: 4111, StoreID: 4111, MismatchedLabels: map[string]string{"zone": "zone4"}}}, orphans)
+
+	data, err := json.Marshal(diagnosis)
+	re.NoError(err)
+	var decoded []RuleDiagnosis
+	re.NoError(json.Unmarshal(data, &decoded))
+	re.Equal(diagnosis, decoded)
+}
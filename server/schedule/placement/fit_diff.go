@@ -0,0 +1,147 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"context"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/tikv/pd/server/core"
+)
+
+// FitDiff describes how the fit of a region changes.
+type FitDiff struct {
+	// UnsatisfiedRules are the rules which are not satisfied after, but are
+	// satisfied or not applied before.
+	UnsatisfiedRules []*Rule
+	// SatisfiedRules are the rules which are satisfied after, but are not
+	// satisfied before.
+	SatisfiedRules []*Rule
+	// NewOrphanPeers are the orphan peers which are not orphan before.
+	NewOrphanPeers []*metapb.Peer
+	// ResolvedOrphanPeers are the peers which are orphan before but not after.
+	ResolvedOrphanPeers []*metapb.Peer
+}
+
+// IsEmpty checks whether the fit does not change at all.
+func (d *FitDiff) IsEmpty() bool {
+	return len(d.UnsatisfiedRules) == 0 && len(d.SatisfiedRules) == 0 &&
+		len(d.NewOrphanPeers) == 0 && len(d.ResolvedOrphanPeers) == 0
+}
+
+// IsDegraded checks whether the fit becomes worse in any way.
+func (d *FitDiff) IsDegraded() bool {
+	return len(d.UnsatisfiedRules) > 0 || len(d.NewOrphanPeers) > 0
+}
+
+// Diff returns how the fit changes to the other fit of the same region.
+func (f *RegionFit) Diff(other *RegionFit) *FitDiff {
+	satisfied := make(map[[2]string]bool, len(f.RuleFits))
+	for _, rf := range f.RuleFits {
+		satisfied[rf.Rule.Key()] = rf.IsSatisfied()
+	}
+	diff := &FitDiff{}
+	for _, rf := range other.RuleFits {
+		before, applied := satisfied[rf.Rule.Key()]
+		switch {
+		case !rf.IsSatisfied() && (before || !applied):
+			diff.UnsatisfiedRules = append(diff.UnsatisfiedRules, rf.Rule)
+		case rf.IsSatisfied() && applied && !before:
+			diff.SatisfiedRules = append(diff.SatisfiedRules, rf.Rule)
+		}
+	}
+	diff.NewOrphanPeers = peersNotIn(other.OrphanPeers, f.OrphanPeers)
+	diff.ResolvedOrphanPeers = peersNotIn(f.OrphanPeers, other.OrphanPeers)
+	return diff
+}
+
+// peersNotIn returns the peers which are not in the other peers.
+func peersNotIn(peers, others []*metapb.Peer) []*metapb.Peer {
+	ids := make(map[uint64]struct{}, len(others))
+	for _, p := range others {
+		ids[p.GetId()] = struct{}{}
+	}
+	var res []*metapb.Peer
+	for _, p := range peers {
+		if _, ok := ids[p.GetId()]; !ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// RulesDiffImpact fits each region under both the old and the new rules, and
+// returns how the fits change, keyed by the region ID. The regions whose fits
+// do not change are omitted. It helps to review the rule changes before they
+// are applied. Like the rule manager, the rules applied to a region are
+// selected by the key range of the region and the groups of the rules. At most
+// limit regions are checked if limit is positive, and the check stops once the
+// context is canceled.
+func RulesDiffImpact(ctx context.Context, oldRules, newRules []*Rule, regions []*core.RegionInfo, stores StoreSet, limit int) (map[uint64]*FitDiff, error) {
+	oldList, err := buildRuleListOf(oldRules)
+	if err != nil {
+		return nil, err
+	}
+	newList, err := buildRuleListOf(newRules)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(regions) > limit {
+		regions = regions[:limit]
+	}
+	storeList := stores.GetStores()
+	diffs := make(map[uint64]*FitDiff)
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			break
+		}
+		start, end := region.GetStartKey(), region.GetEndKey()
+		oldFit := fitRegion(storeList, region, oldList.getRulesForApplyRange(start, end))
+		diff := oldFit.Diff(fitRegion(storeList, region, newList.getRulesForApplyRange(start, end)))
+		if !diff.IsEmpty() {
+			diffs[region.GetID()] = diff
+		}
+	}
+	return diffs, nil
+}
+
+// buildRuleListOf builds the rule list of the rules apart from the rule
+// manager. The rules are cloned, since their groups are set up in building.
+func buildRuleListOf(rules []*Rule) (ruleList, error) {
+	conf := newRuleConfig()
+	for _, r := range rules {
+		if r.group != nil {
+			group := *r.group
+			conf.setGroup(&group)
+		}
+		conf.setRule(r.Clone())
+	}
+	conf.adjust()
+	return buildRuleList(conf)
+}
+
+// ImpactOfStoreDown refits the region as if all peers on the store are down,
+// and returns how the fit changes. It helps to estimate the impact of an
+// expected outage of the store.
+func (f *RegionFit) ImpactOfStoreDown(storeID uint64, region *core.RegionInfo) *FitDiff {
+	downPeers := region.GetDownPeers()
+	for _, p := range region.GetPeers() {
+		if p.GetStoreId() == storeID && region.GetDownPeer(p.GetId()) == nil {
+			downPeers = append(downPeers[:len(downPeers):len(downPeers)], &pdpb.PeerStats{Peer: p})
+		}
+	}
+	return f.Diff(fitRegion(f.regionStores, region.Clone(core.WithDownPeers(downPeers)), f.rules))
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/core"
+)
+
+func TestImpactOfStoreDown(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	leader, voter := makeRule("1/leader/zone=zone1/"), makeRule("2/voter/zone=zone2+zone3/")
+	leader.ID, leader.MinHealthy = "leader", 1
+	voter.ID, voter.MinHealthy = "voter", 2
+	region := makeRegion("1111_leader,2111,3111")
+	fit := fitRegion(stores, region, []*Rule{leader, voter})
+	re.True(fit.IsSatisfied())
+
+	diff := fit.ImpactOfStoreDown(1111, region)
+	re.Equal([]*Rule{leader}, diff.UnsatisfiedRules)
+	re.Empty(diff.NewOrphanPeers)
+	diff = fit.ImpactOfStoreDown(2111, region)
+	re.Equal([]*Rule{voter}, diff.UnsatisfiedRules)
+	re.Empty(diff.NewOrphanPeers)
+	// The store hosts no peer of the region.
+	diff = fit.ImpactOfStoreDown(4111, region)
+	re.Empty(diff.UnsatisfiedRules)
+	re.Empty(diff.NewOrphanPeers)
+
+	// The down peer is replaced by the healthy one in the same zone.
+	region = makeRegion("1111_leader,2111,3111,3211")
+	fit = fitRegion(stores, region, []*Rule{makeRule("3/voter//zone")})
+	re.True(checkPeerMatch(fit.OrphanPeers, "3211"))
+	diff = fit.ImpactOfStoreDown(3111, region)
+	re.Empty(diff.UnsatisfiedRules)
+	re.True(checkPeerMatch(diff.NewOrphanPeers, "3111"))
+}
+
+func TestRulesDiffImpact(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	leader, voter := makeRule("1/leader/zone=zone1/"), makeRule("2/voter/zone=zone2+zone3/")
+	leader.ID, voter.ID = "leader", "voter"
+	moreVoters := voter.Clone()
+	moreVoters.Count = 3
+	oldRules, newRules := []*Rule{leader, voter}, []*Rule{leader, moreVoters}
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(1)),
+		// The voter rule is unsatisfied before and after.
+		makeRegion("1111_leader,2111").Clone(core.WithNewRegionID(2)),
+		makeRegion("1211_leader,2211,3211").Clone(core.WithNewRegionID(3)),
+		// The fit is improved since the orphan peer is used.
+		makeRegion("1111_leader,2111,3111,3211").Clone(core.WithNewRegionID(4)),
+	}
+
+	diffs, err := RulesDiffImpact(context.Background(), oldRules, newRules, regions, stores, 0)
+	re.NoError(err)
+	re.Len(diffs, 3)
+	for _, id := range []uint64{1, 3} {
+		checkRules(t, diffs[id].UnsatisfiedRules, [][2]string{moreVoters.Key()})
+		re.Empty(diffs[id].NewOrphanPeers)
+		re.True(diffs[id].IsDegraded())
+	}
+	re.False(diffs[4].IsDegraded())
+	re.Len(diffs[4].ResolvedOrphanPeers, 1)
+	// Decreasing the count makes orphan peers, and satisfies the rule again.
+	diffs, err = RulesDiffImpact(context.Background(), newRules, oldRules, regions, stores, 0)
+	re.NoError(err)
+	re.Len(diffs, 3)
+	for _, id := range []uint64{1, 3} {
+		checkRules(t, diffs[id].SatisfiedRules, [][2]string{voter.Key()})
+		re.False(diffs[id].IsDegraded())
+	}
+	re.Empty(diffs[4].UnsatisfiedRules)
+	re.Len(diffs[4].NewOrphanPeers, 1)
+
+	// The scan is bounded.
+	diffs, err = RulesDiffImpact(context.Background(), oldRules, newRules, regions, stores, 2)
+	re.NoError(err)
+	re.Len(diffs, 1)
+	re.Contains(diffs, uint64(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	diffs, err = RulesDiffImpact(ctx, oldRules, newRules, regions, stores, 0)
+	re.NoError(err)
+	re.Empty(diffs)
+
+	// The rule set which does not cover all keys is rejected.
+	ranged := moreVoters.Clone()
+	ranged.StartKey = []byte("b")
+	_, err = RulesDiffImpact(context.Background(), oldRules, []*Rule{ranged}, regions, stores, 0)
+	re.Error(err)
+}
+
+func TestRulesDiffImpactWithKeyRangeAndGroup(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	leader, voter := makeRule("1/leader/zone=zone1/"), makeRule("2/voter/zone=zone2+zone3/")
+	leader.ID, voter.ID = "leader", "voter"
+	// The rule only applies to the keys from "b".
+	moreVoters := voter.Clone()
+	moreVoters.ID, moreVoters.Index = "more-voters", 1
+	moreVoters.Count, moreVoters.StartKey = 3, []byte("b")
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(1), core.WithEndKey([]byte("b"))),
+		makeRegion("1211_leader,2211,3211").Clone(core.WithNewRegionID(2), core.WithStartKey([]byte("b"))),
+	}
+	oldRules := []*Rule{leader, voter}
+	diffs, err := RulesDiffImpact(context.Background(), oldRules, []*Rule{leader, voter, moreVoters}, regions, stores, 0)
+	re.NoError(err)
+	re.Len(diffs, 1)
+	checkRules(t, diffs[2].UnsatisfiedRules, [][2]string{moreVoters.Key()})
+
+	// The rules are overridden by the group of higher index.
+	override := makeRule("3/voter//")
+	override.GroupID, override.ID = "override", "override"
+	override.group = &RuleGroup{ID: "override", Index: 1, Override: true}
+	diffs, err = RulesDiffImpact(context.Background(), oldRules, []*Rule{leader, voter, moreVoters, override}, regions, stores, 0)
+	re.NoError(err)
+	// The fit would be unsatisfied if the rules were not overridden.
+	re.Empty(diffs)
+	re.Nil(leader.group)
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"io"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// regionFitPB is the protobuf message of a RegionFit, which is much more
+// compact than JSON for the bulk export.
+//
+//	message RegionFit {
+//	    uint64 region_id = 1;
+//	    repeated RuleFit rule_fits = 2;
+//	    repeated metapb.Peer orphan_peers = 3;
+//	    bool truncated = 4;
+//	    reserved 5;
+//	}
+type regionFitPB struct {
+	RegionID    uint64         `protobuf:"varint,1,opt,name=region_id,json=regionId,proto3"`
+	RuleFits    []*ruleFitPB   `protobuf:"bytes,2,rep,name=rule_fits,json=ruleFits,proto3"`
+	OrphanPeers []*metapb.Peer `protobuf:"bytes,3,rep,name=orphan_peers,json=orphanPeers,proto3"`
+	Truncated   bool           `protobuf:"varint,4,opt,name=truncated,proto3"`
+}
+
+func (m *regionFitPB) Reset()         { *m = regionFitPB{} }
+func (m *regionFitPB) String() string { return proto.CompactTextString(m) }
+func (*regionFitPB) ProtoMessage()    {}
+
+// ruleFitPB is the protobuf message of a RuleFit. The rule is identified by
+// its group and ID only.
+//
+//	message RuleFit {
+//	    string group_id = 1;
+//	    string id = 2;
+//	    repeated metapb.Peer peers = 3;
+//	    repeated metapb.Peer peers_with_different_role = 4;
+//	    repeated metapb.Peer constraint_violating_peers = 5;
+//	    double isolation_score = 6;
+//	}
+type ruleFitPB struct {
+	GroupID                  string         `protobuf:"bytes,1,opt,name=group_id,json=groupId,proto3"`
+	ID                       string         `protobuf:"bytes,2,opt,name=id,proto3"`
+	Peers                    []*metapb.Peer `protobuf:"bytes,3,rep,name=peers,proto3"`
+	PeersWithDifferentRole   []*metapb.Peer `protobuf:"bytes,4,rep,name=peers_with_different_role,json=peersWithDifferentRole,proto3"`
+	ConstraintViolatingPeers []*metapb.Peer `protobuf:"bytes,5,rep,name=constraint_violating_peers,json=constraintViolatingPeers,proto3"`
+	IsolationScore           float64        `protobuf:"fixed64,6,opt,name=isolation_score,json=isolationScore,proto3"`
+}
+
+func (m *ruleFitPB) Reset()         { *m = ruleFitPB{} }
+func (m *ruleFitPB) String() string { return proto.CompactTextString(m) }
+func (*ruleFitPB) ProtoMessage()    {}
+
+// EncodeRegionFit encodes the fit of the region into the RegionFit message.
+func EncodeRegionFit(regionID uint64, f *RegionFit) ([]byte, error) {
+	m := &regionFitPB{
+		RegionID:    regionID,
+		OrphanPeers: f.OrphanPeers,
+		Truncated:   f.Truncated,
+	}
+	for _, rf := range f.RuleFits {
+		m.RuleFits = append(m.RuleFits, &ruleFitPB{
+			GroupID:                  rf.Rule.GroupID,
+			ID:                       rf.Rule.ID,
+			Peers:                    rf.Peers,
+			PeersWithDifferentRole:   rf.PeersWithDifferentRole,
+			ConstraintViolatingPeers: rf.ConstraintViolatingPeers,
+			IsolationScore:           rf.IsolationScore,
+		})
+	}
+	data, err := proto.Marshal(m)
+	return data, errors.WithStack(err)
+}
+
+// DecodeRegionFit decodes the RegionFit message, and returns the region ID and
+// the fit. The Rules of the RuleFits only have the group and ID set.
+func DecodeRegionFit(data []byte) (uint64, *RegionFit, error) {
+	m := &regionFitPB{}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return 0, nil, errors.WithStack(err)
+	}
+	f := &RegionFit{
+		OrphanPeers: m.OrphanPeers,
+		Truncated:   m.Truncated,
+	}
+	for _, rf := range m.RuleFits {
+		f.RuleFits = append(f.RuleFits, &RuleFit{
+			Rule:                     &Rule{GroupID: rf.GroupID, ID: rf.ID},
+			Peers:                    rf.Peers,
+			PeersWithDifferentRole:   rf.PeersWithDifferentRole,
+			ConstraintViolatingPeers: rf.ConstraintViolatingPeers,
+			IsolationScore:           rf.IsolationScore,
+		})
+	}
+	return m.RegionID, f, nil
+}
+
+// WriteRegionFit writes the RegionFit message of the region to w, prefixed
+// with its length, so that a stream of messages can be split again.
+func WriteRegionFit(w io.Writer, regionID uint64, f *RegionFit) error {
+	data, err := EncodeRegionFit(regionID, f)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(proto.EncodeVarint(uint64(len(data)))); err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = w.Write(data)
+	return errors.WithStack(err)
+}
+
+// NextDelimited returns the first message of a stream written by
+// WriteRegionFit, and the rest of the stream.
+func NextDelimited(data []byte) ([]byte, []byte, error) {
+	l, n := proto.DecodeVarint(data)
+	if n == 0 || uint64(len(data)-n) < l {
+		return nil, nil, errors.New("truncated message")
+	}
+	return data[n : n+int(l)], data[n+int(l):], nil
+}
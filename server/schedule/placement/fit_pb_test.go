@@ -0,0 +1,81 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+)
+
+func peerStrings(peers []*metapb.Peer) []string {
+	var res []string
+	for _, p := range peers {
+		res = append(res, p.String())
+	}
+	return res
+}
+
+func TestEncodeRegionFit(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("1/leader/zone=zone1/zone"), makeRule("2/voter//zone,rack")}
+	rules[0].GroupID, rules[0].ID = "pd", "leader"
+	rules[1].GroupID, rules[1].ID = "pd", "voter"
+	fit := fitRegion(stores, makeRegion("1111,2111_leader,3111,4111_learner"), rules)
+	fit.Truncated = true
+
+	data, err := EncodeRegionFit(10, fit)
+	re.NoError(err)
+	regionID, decoded, err := DecodeRegionFit(data)
+	re.NoError(err)
+	re.Equal(uint64(10), regionID)
+	re.True(decoded.Truncated)
+	re.True(checkPeerMatch(decoded.OrphanPeers, "4111"))
+	re.Len(decoded.RuleFits, 2)
+	for i, rf := range decoded.RuleFits {
+		re.Equal(fit.RuleFits[i].Rule.GroupID, rf.Rule.GroupID)
+		re.Equal(fit.RuleFits[i].Rule.ID, rf.Rule.ID)
+		re.Equal(peerStrings(fit.RuleFits[i].Peers), peerStrings(rf.Peers))
+		re.Equal(peerStrings(fit.RuleFits[i].PeersWithDifferentRole), peerStrings(rf.PeersWithDifferentRole))
+		re.Equal(peerStrings(fit.RuleFits[i].ConstraintViolatingPeers), peerStrings(rf.ConstraintViolatingPeers))
+		re.Equal(fit.RuleFits[i].IsolationScore, rf.IsolationScore)
+	}
+	re.True(checkPeerMatch(decoded.RuleFits[0].PeersWithDifferentRole, "1111"))
+	jsonData, err := json.Marshal(fit)
+	re.NoError(err)
+	re.Less(len(data), len(jsonData))
+
+	// A stream of messages can be split again.
+	var buf bytes.Buffer
+	re.NoError(WriteRegionFit(&buf, 10, fit))
+	re.NoError(WriteRegionFit(&buf, 20, &RegionFit{}))
+	msg, stream, err := NextDelimited(buf.Bytes())
+	re.NoError(err)
+	re.Equal(data, msg)
+	msg, stream, err = NextDelimited(stream)
+	re.NoError(err)
+	re.Empty(stream)
+	regionID, decoded, err = DecodeRegionFit(msg)
+	re.NoError(err)
+	re.Equal(uint64(20), regionID)
+	re.Empty(decoded.RuleFits)
+
+	_, _, err = DecodeRegionFit(data[:len(data)-1])
+	re.Error(err)
+}
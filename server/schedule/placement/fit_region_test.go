@@ -336,3 +336,42 @@ func BenchmarkFitRegionWithMoreRulesAndStoreLabels(b *testing.B) {
 		fitRegion(storesSet.GetStores(), region, rules)
 	}
 }
+
+func BenchmarkFitRegionsWithMatchCache(b *testing.B) {
+	rules := addExtraRules(2)
+	storesSet := newMockStoresSet(100)
+	regions := make([]*core.RegionInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		peers := make([]*metapb.Peer, 0, 5)
+		for j := 0; j < 5; j++ {
+			storeID := uint64((i+j)%100 + 1)
+			peers = append(peers, &metapb.Peer{Id: uint64(i*5 + j + 1), StoreId: storeID})
+		}
+		regions = append(regions, core.NewRegionInfo(&metapb.Region{Id: uint64(i + 1), Peers: peers}, peers[0]))
+	}
+	fitRegions := func(b *testing.B, newCache func() *storeMatchCache) {
+		var misses int
+		cache := newCache()
+		for i := 0; i < b.N; i++ {
+			for _, region := range regions {
+				if cache == nil {
+					c := newStoreMatchCache()
+					fitRegionWithMatchCache(c, defaultFitConfig, storesSet.GetStores(), region, rules)
+					misses += c.misses
+				} else {
+					fitRegionWithMatchCache(cache, defaultFitConfig, storesSet.GetStores(), region, rules)
+				}
+			}
+		}
+		if cache != nil {
+			misses = cache.misses
+		}
+		b.ReportMetric(float64(misses)/float64(b.N), "matches/op")
+	}
+	b.Run("without-cache", func(b *testing.B) {
+		fitRegions(b, func() *storeMatchCache { return nil })
+	})
+	b.Run("with-cache", func(b *testing.B) {
+		fitRegions(b, newStoreMatchCache)
+	})
+}
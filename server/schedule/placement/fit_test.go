@@ -15,18 +15,22 @@
 package placement
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tikv/pd/server/core"
 )
 
-func makeStores() StoreSet {
+func makeStores() *core.StoresInfo {
 	stores := core.NewStoresInfo()
 	for zone := 1; zone <= 5; zone++ {
 		for rack := 1; rack <= 5; rack++ {
@@ -187,3 +191,1576 @@ func TestIsolationScore(t *testing.T) {
 		testCase.checker(score1, score2)
 	}
 }
+
+func TestFitRegionInOrder(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+
+	// The rule fitted first takes the only peer.
+	rules := []*Rule{makeRule("1/voter/zone=zone1/"), makeRule("1/voter/zone=zone1/")}
+	region := makeRegion("1111_leader")
+	rf := fitRegionInOrder(stores, region, rules, []int{0, 1})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.Empty(rf.RuleFits[1].Peers)
+	rf = fitRegionInOrder(stores, region, rules, []int{1, 0})
+	re.Same(rules[0], rf.RuleFits[0].Rule)
+	re.Same(rules[1], rf.RuleFits[1].Rule)
+	re.Empty(rf.RuleFits[0].Peers)
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1111"))
+	// An invalid order falls back to the slice order.
+	rf = fitRegionInOrder(stores, region, rules, []int{1, 1})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+
+	// The isolated rule picks its peers first, and the other rule is refitted
+	// once a better choice is found.
+	rules = []*Rule{makeRule("1/voter/zone=zone1/"), makeRule("2/voter//zone")}
+	region = makeRegion("1111_leader,1211,2111")
+	rf = fitRegionInOrder(stores, region, rules, []int{0, 1})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1211,2111"))
+	re.True(rf.IsSatisfied())
+	rf = fitRegionInOrder(stores, region, rules, []int{1, 0})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1211"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1111,2111"))
+	re.Empty(rf.OrphanPeers)
+	re.True(rf.IsSatisfied())
+}
+
+func TestFitRuleAffinity(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+
+	makeRules := func(required bool) []*Rule {
+		voters := makeRule("3/voter//zone")
+		voters.GroupID, voters.ID = "pd", "voters"
+		learner := makeRule("1/learner//")
+		learner.GroupID, learner.ID = "pd", "learner"
+		learner.Affinity = &RuleAffinity{RuleID: "voters", LabelKey: "zone", Required: required}
+		return []*Rule{voters, learner}
+	}
+
+	// Without affinity, the learner with the smallest ID is selected.
+	rules := makeRules(false)
+	rules[1].Affinity = nil
+	region := makeRegion("2111_leader,3111,4111,1112_learner,4112_learner")
+	rf := fitRegion(stores.GetStores(), region, rules)
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1112"))
+	re.False(rf.RuleFits[1].AffinityViolated)
+
+	// The preferred affinity selects the learner co-located with a voter.
+	for _, required := range []bool{false, true} {
+		rf = fitRegion(stores.GetStores(), region, makeRules(required))
+		re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111,3111,4111"))
+		re.True(checkPeerMatch(rf.RuleFits[1].Peers, "4112"))
+		re.False(rf.RuleFits[1].AffinityViolated)
+		re.True(checkPeerMatch(rf.OrphanPeers, "1112"))
+	}
+
+	// If no learner is co-located with a voter, the preferred affinity is
+	// only a bonus, while the required affinity makes the rule unsatisfied.
+	region = makeRegion("2111_leader,3111,4111,1112_learner")
+	rf = fitRegion(stores.GetStores(), region, makeRules(false))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1112"))
+	re.True(rf.RuleFits[1].AffinityViolated)
+	re.True(rf.IsSatisfied())
+
+	rf = fitRegion(stores.GetStores(), region, makeRules(true))
+	re.True(rf.RuleFits[1].AffinityViolated)
+	re.False(rf.RuleFits[1].IsSatisfied())
+	re.False(rf.IsSatisfied())
+}
+
+func TestFitRuleAntiAffinity(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	primary := makeRule("1/voter/zone=zone1/")
+	primary.GroupID, primary.ID = "pd", "primary"
+	others := makeRule("2/voter//")
+	others.GroupID, others.ID = "pd", "others"
+	others.Affinity = &RuleAffinity{RuleID: "primary", LabelKey: "rack", Required: true, Anti: true}
+	rules := []*Rule{primary, others}
+
+	// The peer in rack2 is selected by the primary rule to avoid the conflict.
+	rf := fitRegion(stores.GetStores(), makeRegion("1111_leader,1211,2111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1211"))
+	re.Empty(rf.ConflictingPeers())
+	re.True(rf.IsSatisfied())
+
+	// The peers of both rules are in rack1, so they conflict.
+	rf = fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3211"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].ConflictingPeers, "2111"))
+	re.True(checkPeerMatch(rf.ConflictingPeers(), "2111"))
+	re.True(rf.RuleFits[1].AffinityViolated)
+	re.False(rf.IsSatisfied())
+
+	// A preferred anti-affinity reports the conflict as well.
+	others.Affinity.Required = false
+	rf = fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3211"), rules)
+	re.True(checkPeerMatch(rf.ConflictingPeers(), "2111"))
+	re.True(rf.IsSatisfied())
+}
+
+func TestIsolationScoreAtLevel(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	labels := []string{"zone", "rack", "host"}
+	getStores := func(ids ...uint64) []*core.StoreInfo {
+		var res []*core.StoreInfo
+		for _, id := range ids {
+			res = append(res, stores.GetStore(id))
+		}
+		return res
+	}
+
+	// The stores are in the same zone but different racks and hosts.
+	sameZone := getStores(1111, 1211, 1321)
+	re.Zero(IsolationScoreAtLevel(sameZone, labels, 0))
+	re.Equal(3.0, IsolationScoreAtLevel(sameZone, labels, 1))
+	// The difference of hosts does not matter at the zone and rack levels.
+	hosts := getStores(1111, 1121, 2111)
+	re.Equal(2.0, IsolationScoreAtLevel(hosts, labels, 0))
+	re.Equal(2.0, IsolationScoreAtLevel(hosts, labels, 1))
+	re.Equal(3.0, IsolationScoreAtLevel(hosts, labels, 2))
+	re.Equal(3.0, IsolationScoreAtLevel(getStores(1111, 2111, 3111), labels, 0))
+
+	re.Zero(IsolationScoreAtLevel(hosts, labels, 3))
+	re.Zero(IsolationScoreAtLevel(hosts, labels, -1))
+}
+
+func TestIsolationScoreDeepLabels(t *testing.T) {
+	re := require.New(t)
+	var labels []string
+	for i := 0; i < 10; i++ {
+		labels = append(labels, fmt.Sprintf("l%d", i))
+	}
+	// example: "abaaaaaaaa" means l0=a, l1=b, l2=a, ...
+	makePeers := func(locations ...string) []*fitPeer {
+		var peers []*fitPeer
+		for i, location := range locations {
+			storeLabels := make(map[string]string)
+			for j, v := range location {
+				storeLabels[labels[j]] = string(v)
+			}
+			id := uint64(i + 1)
+			peers = append(peers, &fitPeer{
+				Peer:  &metapb.Peer{Id: id, StoreId: id},
+				store: core.NewStoreInfoWithLabel(id, 0, storeLabels),
+			})
+		}
+		return peers
+	}
+	rule := &Rule{Role: Voter, Count: 4, LocationLabels: labels}
+
+	deepest := defaultFitConfig.newRuleFit(rule, makePeers("aaaaaaaaaa", "baaaaaaaaa", "caaaaaaaaa", "aaaaaaaaab"), nil)
+	deeper := defaultFitConfig.newRuleFit(rule, makePeers("aaaaaaaaaa", "baaaaaaaaa", "caaaaaaaaa", "aaaaaaaaba"), nil)
+	shallow := defaultFitConfig.newRuleFit(rule, makePeers("aaaaaaaaaa", "baaaaaaaaa", "caaaaaaaaa", "abaaaaaaaa"), nil)
+
+	// The difference at deep levels is too small for the folded score.
+	re.Equal(deepest.IsolationScore, deeper.IsolationScore)
+	re.Equal(1, compareRuleFit(deeper, deepest))
+	re.Equal(-1, compareRuleFit(deepest, deeper))
+	re.Equal(1, compareRuleFit(shallow, deeper))
+	re.Equal(-1, compareRuleFit(deepest, shallow))
+	re.Equal(0, compareRuleFit(shallow, shallow))
+}
+
+func TestPrioritizeFits(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	regions := map[uint64]string{
+		1: "1111,2111,3111", // satisfied
+		2: "1111,2111",      // under-replicated, small
+		3: "1111,2111",      // under-replicated, large
+		4: "1111",           // under-replicated more
+	}
+	sizes := map[uint64]int64{1: 100, 2: 10, 3: 1000, 4: 1}
+	fits := make(map[uint64]*RegionFit)
+	for id, def := range regions {
+		fits[id] = fitRegion(stores.GetStores(), makeRegion(def), rules)
+	}
+	ids := PrioritizeFits(fits, func(id uint64) int64 { return sizes[id] })
+	re.Equal([]uint64{4, 3, 2}, ids)
+
+	// Regions with the same size are ordered by ID.
+	sizes[3] = 10
+	ids = PrioritizeFits(fits, func(id uint64) int64 { return sizes[id] })
+	re.Equal([]uint64{4, 2, 3}, ids)
+}
+
+func TestRuleIsolationLabels(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	makePeers := func(ids ...uint64) []*fitPeer {
+		var peers []*fitPeer
+		for _, id := range ids {
+			peers = append(peers, &fitPeer{
+				Peer:  &metapb.Peer{Id: id, StoreId: id},
+				store: stores.GetStore(id),
+			})
+		}
+		return peers
+	}
+	rule := makeRule("2/voter//zone,rack")
+	re.Equal([]string{"zone", "rack"}, rule.isolationLabels())
+	rackIsolated := defaultFitConfig.newRuleFit(rule, makePeers(1111, 1211), nil)
+	zoneIsolated := defaultFitConfig.newRuleFit(rule, makePeers(1111, 2111), nil)
+	re.Equal(1, compareRuleFit(zoneIsolated, rackIsolated))
+
+	// Make rack more significant than zone without changing the label list.
+	rule.LabelWeights = map[string]int{"rack": 2, "zone": 1}
+	re.Equal([]string{"rack", "zone"}, rule.isolationLabels())
+	re.Equal([]string{"zone", "rack"}, rule.LocationLabels)
+	rackIsolated = defaultFitConfig.newRuleFit(rule, makePeers(1111, 1211), nil)
+	zoneIsolated = defaultFitConfig.newRuleFit(rule, makePeers(1111, 2111), nil)
+	re.Equal(-1, compareRuleFit(zoneIsolated, rackIsolated))
+	re.Greater(rackIsolated.IsolationScore, zoneIsolated.IsolationScore)
+}
+
+func TestConstraintViolatingPeers(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rule := makeRule("2/voter/zone=zone1/")
+	region := makeRegion("1111_leader,1211")
+	rf := fitRegion(stores.GetStores(), region, []*Rule{rule})
+	re.True(rf.IsSatisfied())
+	re.Empty(rf.RuleFits[0].ConstraintViolatingPeers)
+
+	// Relabel store 1211 out of zone1 after its peer is assigned to the rule.
+	relabeled := stores.GetStore(1211).Clone(core.SetStoreLabels([]*metapb.StoreLabel{
+		{Key: "zone", Value: "zone2"},
+		{Key: "rack", Value: "rack2"},
+		{Key: "host", Value: "host1"},
+		{Key: "id", Value: "id1"},
+	}))
+	peers := []*fitPeer{
+		{Peer: region.GetStorePeer(1111), store: stores.GetStore(1111), isLeader: true},
+		{Peer: region.GetStorePeer(1211), store: relabeled},
+	}
+	ruleFit := defaultFitConfig.newRuleFit(rule, peers, nil)
+	re.True(checkPeerMatch(ruleFit.ConstraintViolatingPeers, "1211"))
+	re.Empty(ruleFit.PeersWithDifferentRole)
+	re.False(ruleFit.IsSatisfied())
+	re.Equal(-1, compareRuleFit(ruleFit, rf.RuleFits[0]))
+
+	regionStores := make([]*core.StoreInfo, 0, len(stores.GetStores()))
+	for _, store := range stores.GetStores() {
+		if store.GetID() == relabeled.GetID() {
+			store = relabeled
+		}
+		regionStores = append(regionStores, store)
+	}
+	// The peer is kept by the rule and reported as violating rather than
+	// orphaned, with no need to know the previous fit.
+	relabeledFit := fitRegion(regionStores, region, []*Rule{rule})
+	re.Empty(relabeledFit.OrphanPeers)
+	re.True(checkPeerMatch(relabeledFit.RuleFits[0].Peers, "1111,1211"))
+	re.True(checkPeerMatch(relabeledFit.RuleFits[0].ConstraintViolatingPeers, "1211"))
+	re.False(relabeledFit.IsSatisfied())
+	// A peer with another role is not kept.
+	learnerRule := makeRule("2/learner/zone=zone1/")
+	relabeledFit = fitRegion(regionStores, region, []*Rule{learnerRule})
+	re.True(checkPeerMatch(relabeledFit.OrphanPeers, "1211"))
+	re.Empty(relabeledFit.RuleFits[0].ConstraintViolatingPeers)
+	// Nor is a peer on a store matching another rule.
+	zone2 := makeRule("1/learner/zone=zone2/")
+	relabeledFit = fitRegion(regionStores, region, []*Rule{rule, zone2})
+	re.True(checkPeerMatch(relabeledFit.RuleFits[0].Peers, "1111"))
+	re.Empty(relabeledFit.RuleFits[0].ConstraintViolatingPeers)
+	re.True(checkPeerMatch(relabeledFit.RuleFits[1].PeersWithDifferentRole, "1211"))
+}
+
+func TestFitGroupCount(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	group := &RuleGroup{ID: "g", Count: 5}
+	makeRules := func() []*Rule {
+		zone1, zone2 := makeRule("3/voter/zone=zone1/"), makeRule("3/voter/zone=zone2/")
+		zone1.GroupID, zone1.ID, zone1.group = "g", "zone1", group
+		zone2.GroupID, zone2.ID, zone2.group = "g", "zone2", group
+		return []*Rule{zone1, zone2}
+	}
+
+	cases := []struct {
+		region    string
+		fitPeers  string
+		satisfied bool
+	}{
+		{"1111,1211,2111,2211,2311", "1111,1211/2111,2211,2311/", true},
+		{"1111,1211,1311,2111,2211", "1111,1211,1311/2111,2211/", true},
+		{"1111,1211,1311,2111,2211,2311", "1111,1211,1311/2111,2211/2311", false},
+		{"1111,1211,2111,2211", "1111,1211/2111,2211/", false},
+	}
+	for _, cc := range cases {
+		rf := fitRegion(stores.GetStores(), makeRegion(cc.region), makeRules())
+		expects := strings.Split(cc.fitPeers, "/")
+		re.True(checkPeerMatch(rf.RuleFits[0].Peers, expects[0]))
+		re.True(checkPeerMatch(rf.RuleFits[1].Peers, expects[1]))
+		re.True(checkPeerMatch(rf.OrphanPeers, expects[2]))
+		re.True(rf.RuleFits[0].IsSatisfied())
+		re.True(rf.RuleFits[1].IsSatisfied())
+		re.Equal(cc.satisfied, rf.IsSatisfied())
+	}
+}
+
+func TestCompareRegionFitBySize(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	a := fitRegion(stores, makeRegion("1111_leader,2111,3111,4111"), rules)
+	b := fitRegion(stores, makeRegion("1111_leader,2111,3111,4211,5111"), rules)
+	sizes := map[uint64]int64{4111: 100, 4211: 10, 5111: 10}
+	size := func(p *metapb.Peer) int64 { return sizes[p.GetStoreId()] }
+
+	// Fewer orphans are better by default.
+	re.Equal(1, CompareRegionFit(a, b))
+	// The small orphans are cheaper to remove than the large one.
+	re.Equal(-1, CompareRegionFitBySize(a, b, size))
+	re.Equal(1, CompareRegionFitBySize(b, a, size))
+	sizes[4111] = 20
+	re.Equal(0, CompareRegionFitBySize(a, b, size))
+}
+
+func TestCompareRegionFitWithCost(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	current := makeRegion("1111_leader,2111,3111")
+	a := fitRegion(stores, makeRegion("1111_leader,2111,4111"), rules)
+	b := fitRegion(stores, makeRegion("1111_leader,4111,5111"), rules)
+
+	// Both fits are satisfied without orphans.
+	re.Equal(0, CompareRegionFit(a, b))
+	// Reaching a moves one peer while reaching b moves two.
+	re.Equal(1, CompareRegionFitWithCost(a, b, current))
+	re.Equal(-1, CompareRegionFitWithCost(b, a, current))
+	re.Equal(0, CompareRegionFitWithCost(a, a, current))
+	// The standard dimensions take precedence over the cost.
+	c := fitRegion(stores, makeRegion("1111_leader,2111,3111,3211"), rules)
+	re.Equal(-1, CompareRegionFitWithCost(c, b, current))
+}
+
+func TestCompareRegionFitWithTolerance(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone,rack")}
+	current := fitRegion(stores, makeRegion("1111_leader,1211,1212"), rules)
+	// Moving a peer to another rack gains much less than moving it out of the
+	// zone.
+	small := fitRegion(stores, makeRegion("1111_leader,1211,1312"), rules)
+	large := fitRegion(stores, makeRegion("1111_leader,1211,2111"), rules)
+	re.Equal(1, CompareRegionFit(small, current))
+	re.Equal(1, CompareRegionFit(large, current))
+
+	// The tiny gain is suppressed while the large one is accepted.
+	re.Equal(0, CompareRegionFitWithTolerance(small, current, 10))
+	re.Equal(0, CompareRegionFitWithTolerance(current, small, 10))
+	re.Equal(1, CompareRegionFitWithTolerance(large, current, 10))
+	re.Equal(-1, CompareRegionFitWithTolerance(current, large, 10))
+	// Other dimensions are not affected by the tolerance.
+	lacking := fitRegion(stores, makeRegion("1111_leader,1211"), rules)
+	re.Equal(-1, CompareRegionFitWithTolerance(lacking, current, 1000))
+}
+
+func TestCompareIsolationUnits(t *testing.T) {
+	re := require.New(t)
+	rule := &Rule{GroupID: "pd", ID: "default", Role: Voter, Count: 3, LocationLabels: []string{"zone"},
+		NetworkCost: &NetworkCost{LabelKey: "zone", Weight: 1}}
+	makeFit := func(levels []int, cost float64) *RegionFit {
+		return &RegionFit{RuleFits: []*RuleFit{{Rule: rule, isolationLevels: levels, IsolationScore: levelsScore(levels), networkCost: cost}}}
+	}
+	// The costs differ in float arithmetic, but not in the units.
+	a, b := makeFit([]int{3}, 0.3), makeFit([]int{3}, 0.1+0.2)
+	re.NotEqual(a.RuleFits[0].networkCost, b.RuleFits[0].networkCost)
+	re.Equal(0, CompareRegionFit(a, b))
+
+	// The gain equal to the tolerance is suppressed.
+	c := makeFit([]int{3}, 0)
+	re.Equal(1, CompareRegionFit(c, b))
+	re.Equal(0, CompareRegionFitWithTolerance(c, b, 0.3))
+	re.Equal(1, CompareRegionFitWithTolerance(c, b, 0.299))
+}
+
+func TestExplainCompare(t *testing.T) {
+	re := require.New(t)
+	rule := &Rule{Role: Voter, Count: 3}
+	makePeers := func(n int) []*metapb.Peer {
+		var peers []*metapb.Peer
+		for i := 1; i <= n; i++ {
+			peers = append(peers, &metapb.Peer{Id: uint64(i), StoreId: uint64(i)})
+		}
+		return peers
+	}
+	makeFit := func() *RegionFit {
+		return &RegionFit{RuleFits: []*RuleFit{
+			{Rule: rule, Peers: makePeers(3), IsolationScore: 3},
+			{Rule: rule, Peers: makePeers(3), IsolationScore: 3},
+		}}
+	}
+
+	testCases := []struct {
+		modify func(b *RegionFit)
+		expect string
+		cmp    int
+	}{
+		{func(b *RegionFit) {}, "equal", 0},
+		{func(b *RegionFit) { b.RuleFits[0].Peers = makePeers(2) }, "rule 0: peer count", 1},
+		{func(b *RegionFit) { b.RuleFits[1].PeersWithDifferentRole = makePeers(1) }, "rule 1: role mismatch", 1},
+		{func(b *RegionFit) { b.RuleFits[0].ConstraintViolatingPeers = makePeers(1) }, "rule 0: constraint violation", 1},
+		{func(b *RegionFit) { b.RuleFits[1].AffinityViolated = true }, "rule 1: affinity", 1},
+		{func(b *RegionFit) {
+			b.RuleFits[1].AffinityViolated, b.RuleFits[1].affinityRequired = true, true
+			b.RuleFits[1].Peers = makePeers(4)
+		}, "rule 1: required affinity", 1},
+		{func(b *RegionFit) { b.RuleFits[0].IsolationScore = 4 }, "rule 0: isolation", -1},
+		{func(b *RegionFit) { b.OrphanPeers = makePeers(1) }, "orphan count", 1},
+	}
+	for _, testCase := range testCases {
+		a, b := makeFit(), makeFit()
+		testCase.modify(b)
+		re.Equal(testCase.cmp, CompareRegionFit(a, b))
+		re.Equal(testCase.expect, ExplainCompare(a, b))
+		re.Equal(testCase.expect, ExplainCompare(b, a))
+	}
+}
+
+func TestFitPinnedStore(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	pinned := makeRule("1/voter//")
+	pinned.StoreID = 2111
+	rules := []*Rule{pinned, makeRule("2/voter//")}
+
+	rf := fitRegion(stores.GetStores(), makeRegion("1111,1211,2111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1111,1211"))
+	re.True(rf.IsSatisfied())
+
+	// The pinned store bypasses label constraints.
+	pinned.LabelConstraints = []LabelConstraint{{Key: "zone", Op: In, Values: []string{"zone1"}}}
+	rf = fitRegion(stores.GetStores(), makeRegion("1111,1211,2111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111"))
+	re.True(rf.IsSatisfied())
+
+	// The pinned store is unavailable.
+	var storeList []*core.StoreInfo
+	for _, s := range stores.GetStores() {
+		if s.GetID() == 2111 {
+			s = s.Clone(core.TombstoneStore())
+		}
+		storeList = append(storeList, s)
+	}
+	rf = fitRegion(storeList, makeRegion("1111,1211,2111"), rules)
+	re.Empty(rf.RuleFits[0].Peers)
+	re.True(checkPeerMatch(rf.OrphanPeers, "2111"))
+	re.False(rf.IsSatisfied())
+
+	// The region has no peer on the pinned store.
+	rf = fitRegion(stores.GetStores(), makeRegion("1111,1211,1311"), rules)
+	re.Empty(rf.RuleFits[0].Peers)
+	re.False(rf.IsSatisfied())
+}
+
+func TestFitRemovedStore(t *testing.T) {
+	re := require.New(t)
+	var storeList []*core.StoreInfo
+	for _, s := range makeStores().GetStores() {
+		switch s.GetID() {
+		case 1211:
+			s = s.Clone(core.TombstoneStore())
+		case 2111:
+			s = s.Clone(core.OfflineStore(true))
+		}
+		storeList = append(storeList, s)
+	}
+	rules := []*Rule{makeRule("3/voter//")}
+
+	rf := fitRegion(storeList, makeRegion("1111_leader,1211,3111,4111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,3111,4111"))
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211"))
+	re.True(rf.IsSatisfied())
+
+	// The rule is short of peers rather than selecting the removed stores.
+	rf = fitRegion(storeList, makeRegion("1111_leader,1211,2111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211,2111"))
+	re.False(rf.IsSatisfied())
+
+	// The removed stores are not tolerated even in maintenance.
+	maintenance := map[uint64]struct{}{1211: {}, 2111: {}}
+	rf = fitRegion(storeList, makeRegion("1111_leader,1211,2111"), rules, maintenanceOpt(maintenance))
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211,2111"))
+
+	// The orphans are not stretched into the rule either.
+	cfg := fitConfig{preferStretchOverOrphan: true}
+	rf = fitRegionWithMatchCache(nil, cfg, storeList, makeRegion("1111_leader,1211,3111,4111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,3111,4111"))
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211"))
+}
+
+func TestFitLeaderIncapableStore(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	tiflash := core.NewStoreInfoWithLabel(9001, 0, map[string]string{
+		"zone":         "zone9",
+		core.EngineKey: core.EngineTiFlash,
+	})
+	storeList := append(stores.GetStores(), tiflash)
+
+	leaderRule := makeRule("1/leader//")
+	leaderRule.LabelConstraints = []LabelConstraint{{Key: core.EngineKey, Op: NotIn, Values: []string{"foo"}}}
+	followerRule := makeRule("1/follower//")
+	rules := []*Rule{leaderRule, followerRule}
+
+	// The leader is on a normal store.
+	rf := fitRegion(storeList, makeRegion("1111_leader,1211"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.True(rf.IsSatisfied())
+
+	// The leader is transiently on the TiFlash store.
+	rf = fitRegion(storeList, makeRegion("9001_leader,1211"), rules)
+	re.Len(rf.RuleFits[0].Peers, 1)
+	re.Len(rf.RuleFits[0].PeersWithDifferentRole, 1)
+	re.False(rf.IsSatisfied())
+
+	p := &fitPeer{Peer: &metapb.Peer{Id: 9001, StoreId: 9001}, store: tiflash, isLeader: true}
+	re.False(p.matchRoleStrict(Leader))
+	re.True(p.matchRoleStrict(Voter))
+}
+
+func TestRegionFitHash(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rules := []*Rule{makeRule("3/voter//zone"), makeRule("1/learner//")}
+	fit := fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3111,4111_learner,5111"), rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+	hash := fit.Hash()
+	re.Equal(hash, fit.Hash())
+
+	// Permuting the peers within a rule does not change the hash.
+	peers := fit.RuleFits[0].Peers
+	peers[0], peers[2] = peers[2], peers[0]
+	re.Equal(hash, fit.Hash())
+	re.Equal(hash, fitRegion(stores.GetStores(), makeRegion("5111,3111,4111_learner,2111,1111_leader"), rules).Hash())
+
+	// A single changed peer changes the hash.
+	re.NotEqual(hash, fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3211,4111_learner,5111"), rules).Hash())
+	re.NotEqual(hash, fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3111,4111_learner,5211"), rules).Hash())
+	re.NotEqual(hash, fitRegion(stores.GetStores(), makeRegion("1111_leader,2111,3111_learner,4111_learner,5111"), rules).Hash())
+}
+
+func TestFitRegionAssumingLeader(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,3111")
+	rules := []*Rule{makeRule("1/leader/zone=zone2/"), makeRule("2/follower//")}
+
+	re.False(fitRegion(stores, region, rules).IsSatisfied())
+
+	rf := fitRegionAssumingLeader(stores, region, rules, 2111)
+	re.True(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1111,3111"))
+
+	rf = fitRegionAssumingLeader(stores, region, rules, 3111)
+	re.False(rf.IsSatisfied())
+	re.Len(rf.RuleFits[0].PeersWithDifferentRole, 1)
+
+	// No peer is the leader if the assumed leader store has no peer.
+	rf = fitRegionAssumingLeader(stores, region, rules, 4111)
+	re.False(rf.IsSatisfied())
+	re.Len(rf.RuleFits[1].Peers, 2)
+}
+
+func TestFitWitnessOrphans(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("1/voter//")}
+	region := makeRegion("1111_leader,2111,3111,4111")
+
+	fit := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(fit.OrphanPeers, "2111,3111,4111"))
+	// The witnesses are listed first, since they are the cheapest to remove.
+	fit = fitRegion(stores, region, rules, witnessOpt(map[uint64]struct{}{4111: {}}))
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111"))
+	re.Len(fit.OrphanPeers, 3)
+	re.Equal(uint64(4111), fit.OrphanPeers[0].GetStoreId())
+	re.True(checkPeerMatch(fit.OrphanPeers[1:], "2111,3111"))
+
+	// A witness stretched to a rule is not an orphan.
+	cfg := fitConfig{preferStretchOverOrphan: true}
+	fit = fitRegionWithMatchCache(nil, cfg, stores, region, rules, witnessOpt(map[uint64]struct{}{4111: {}}))
+	re.Empty(fit.OrphanPeers)
+}
+
+func TestOverSpread(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+
+	// 5 voters across 5 zones, while 3 zones are enough.
+	rule := makeRule("5/voter//zone,rack,host")
+	rf := fitRegion(stores, makeRegion("1111_leader,2111,3111,4111,5111"), []*Rule{rule})
+	spread := rf.OverSpread()
+	re.Len(spread, 1)
+	re.Equal(RuleFitSpreadInfo{Rule: rule, Level: "zone", Domains: 5, Needed: 3}, spread[0])
+
+	// 5 voters across 3 zones is minimal.
+	rf = fitRegion(stores, makeRegion("1111_leader,1211,2111,2211,3111"), []*Rule{rule})
+	re.Empty(rf.OverSpread())
+
+	// 3 voters need 3 zones.
+	rf = fitRegion(stores, makeRegion("1111_leader,2111,3111"), []*Rule{makeRule("3/voter//zone,rack,host")})
+	re.Empty(rf.OverSpread())
+
+	// The failure domain follows the isolation level.
+	rule = makeRule("5/voter//zone,rack,host")
+	rule.IsolationLevel = "rack"
+	rf = fitRegion(stores, makeRegion("1111_leader,1211,1311,1411,1511"), []*Rule{rule})
+	spread = rf.OverSpread()
+	re.Len(spread, 1)
+	re.Equal(RuleFitSpreadInfo{Rule: rule, Level: "rack", Domains: 5, Needed: 3}, spread[0])
+
+	// No location labels, no failure domains.
+	rf = fitRegion(stores, makeRegion("1111_leader,2111,3111,4111,5111"), []*Rule{makeRule("5/voter//")})
+	re.Empty(rf.OverSpread())
+}
+
+func TestMaxFitSearchDuration(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,1112,1121,2111,2112,3111")
+	rules := []*Rule{makeRule("3/voter//zone,rack,host"), makeRule("3/voter//zone,rack,host")}
+
+	best := fitRegion(stores, region, rules)
+	re.False(best.Truncated)
+
+	// Every time check costs 1 minute, the search is aborted after the first
+	// complete fit.
+	now := time.Now()
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+	cfg := fitConfig{budget: FitBudget{MaxDuration: time.Second}}
+	rf := fitRegionWithMatchCache(nil, cfg, stores, region, rules)
+	re.True(rf.Truncated)
+	re.Len(rf.RuleFits, 2)
+	for _, r := range rf.RuleFits {
+		re.NotNil(r)
+		re.Len(r.Peers, 3)
+	}
+	re.Empty(rf.OrphanPeers)
+	re.Equal(1, CompareRegionFit(best, rf))
+
+	// No budget, no truncation.
+	re.False(fitRegion(stores, region, rules).Truncated)
+}
+
+func TestMaxFitSearchIterations(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,1112,1121,2111,2112,3111")
+	rules := []*Rule{makeRule("3/voter//zone,rack,host"), makeRule("3/voter//zone,rack,host")}
+
+	best, stats := fitRegionWithStats(nil, defaultFitConfig, stores, region, rules)
+	re.False(best.Truncated)
+	fitWithBudget := func(maxIterations int) *RegionFit {
+		cfg := fitConfig{budget: FitBudget{MaxIterations: maxIterations}}
+		return fitRegionWithMatchCache(nil, cfg, stores, region, rules)
+	}
+
+	// The search is aborted after the first complete fit, which is still
+	// valid but worse than the best one.
+	rf := fitWithBudget(1)
+	re.True(rf.Truncated)
+	re.Len(rf.RuleFits, 2)
+	for _, r := range rf.RuleFits {
+		re.NotNil(r)
+		re.Len(r.Peers, 3)
+	}
+	re.Empty(rf.OrphanPeers)
+	re.Equal(1, CompareRegionFit(best, rf))
+
+	// The budget is enough for the whole search.
+	rf = fitWithBudget(stats.SearchIterations + 1)
+	re.False(rf.Truncated)
+	re.Equal(0, CompareRegionFit(best, rf))
+
+	// The search is aborted by the canceled context in the same way.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rf = fitRegionWithContext(ctx, nil, defaultFitConfig, stores, region, rules)
+	re.True(rf.Truncated)
+	re.Empty(rf.OrphanPeers)
+	re.Equal(1, CompareRegionFit(best, rf))
+	rf = fitRegionWithContext(context.Background(), nil, defaultFitConfig, stores, region, rules)
+	re.False(rf.Truncated)
+}
+
+func TestRetryTruncatedFit(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111,1112,2111_leader")
+	rules := []*Rule{makeRule("1/leader//"), makeRule("2/follower//zone,rack,host")}
+
+	// The search is aborted after the first complete fit, which selects 1111
+	// for the leader rule.
+	now := time.Now()
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+	cfg := fitConfig{budget: FitBudget{MaxDuration: time.Second}}
+	fit := fitRegionWithMatchCache(nil, cfg, stores, region, rules)
+	re.True(fit.Truncated)
+	re.False(fit.Approximate)
+	re.False(fit.IsSatisfied())
+
+	cfg.retryTruncatedFit = true
+	fit = fitRegionWithMatchCache(nil, cfg, stores, region, rules)
+	re.False(fit.Truncated)
+	re.True(fit.Approximate)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "2111"))
+	re.True(checkPeerMatch(fit.RuleFits[1].Peers, "1111,1112"))
+
+	// The fit is not retried if it is not truncated.
+	fit = fitRegion(stores, region, rules)
+	re.False(fit.Approximate)
+	re.True(fit.IsSatisfied())
+}
+
+func TestRetryTruncatedFitWithGroupCount(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	group := &RuleGroup{ID: "g", Count: 5}
+	zone1, zone2 := makeRule("3/voter/zone=zone1/"), makeRule("3/voter/zone=zone2/")
+	zone1.GroupID, zone1.ID, zone1.group = "g", "zone1", group
+	zone2.GroupID, zone2.ID, zone2.group = "g", "zone2", group
+	rules := []*Rule{zone1, zone2}
+	region := makeRegion("1111_leader,1211,1311,2111,2211,2311")
+	witnesses := witnessOpt(map[uint64]struct{}{2311: {}})
+
+	now := time.Now()
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+	cfg := fitConfig{budget: FitBudget{MaxDuration: time.Second}}
+	re.True(fitRegionWithMatchCache(nil, cfg, stores, region, rules, witnesses).Truncated)
+
+	// The greedy fit is still bounded by the count of the group.
+	cfg.retryTruncatedFit = true
+	fit := fitRegionWithMatchCache(nil, cfg, stores, region, rules, witnesses)
+	re.False(fit.Truncated)
+	re.True(fit.Approximate)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,1211,1311"))
+	re.True(checkPeerMatch(fit.RuleFits[1].Peers, "2111,2211"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "2311"))
+}
+
+func TestRetryTruncatedWeightedFit(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("2/voter//zone")}
+	rules[0].RoleWeights = map[PeerRoleType]float64{Witness: 0.5}
+	region := makeRegion("1111_leader,2111,3111")
+	witnesses := witnessOpt(map[uint64]struct{}{2111: {}, 3111: {}})
+
+	// The search is aborted after the first complete fit, which selects a
+	// witness only.
+	now := time.Now()
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+	cfg := fitConfig{budget: FitBudget{MaxDuration: time.Second}}
+	fit := fitRegionWithMatchCache(nil, cfg, stores, region, rules, witnesses)
+	re.True(fit.Truncated)
+	re.False(fit.IsSatisfied())
+
+	// The greedy fit selects more than Count peers to make up the weights.
+	cfg.retryTruncatedFit = true
+	fit = fitRegionWithMatchCache(nil, cfg, stores, region, rules, witnesses)
+	re.False(fit.Truncated)
+	re.True(fit.Approximate)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.Empty(fit.OrphanPeers)
+}
+
+func TestFitPreferFewerDemotions(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,1211,2111,3111")
+	// The peer 1211 is already being demoted in joint state.
+	region.GetStorePeer(1211).Role = metapb.PeerRole_DemotingVoter
+	rules := []*Rule{makeRule("3/voter//"), makeRule("1/learner//")}
+
+	rf := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1211"))
+	re.Len(rf.RuleFits[1].PeersWithDifferentRole, 1)
+	re.Equal(0, rf.RuleFits[1].demotionCount())
+
+	// The fit demoting a normal voter has the same score otherwise.
+	other := fitRegion(stores, makeRegion("1111_leader,2111,3111"), rules[:1])
+	demote := defaultFitConfig.newRuleFit(rules[1], []*fitPeer{{Peer: region.GetStorePeer(3111), store: getStoreByID(stores, 3111)}}, region)
+	re.Equal(1, demote.demotionCount())
+	re.Equal(-1, compareRuleFit(demote, rf.RuleFits[1]))
+	re.Equal("rule 1: demotion count", ExplainCompare(
+		&RegionFit{RuleFits: []*RuleFit{other.RuleFits[0], demote}},
+		&RegionFit{RuleFits: []*RuleFit{other.RuleFits[0], rf.RuleFits[1]}},
+	))
+}
+
+func TestPoorlyIsolatedPeers(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone,rack,host")}
+
+	// 1111 and 1121 share the same rack.
+	rf := fitRegion(stores, makeRegion("1111_leader,1121,2111"), rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].PoorlyIsolatedPeers, "1111,1121"))
+
+	// 1111, 1121 and 1211 share the same zone, and 1111 and 1121 share the
+	// same rack as well.
+	rf = fitRegion(stores, makeRegion("1111_leader,1121,1211,2111"), []*Rule{makeRule("4/voter//zone,rack,host")})
+	re.True(checkPeerMatch(rf.RuleFits[0].PoorlyIsolatedPeers, "1111,1121"))
+
+	// The peers are isolated evenly.
+	rf = fitRegion(stores, makeRegion("1111_leader,2111,3111"), rules)
+	re.Empty(rf.RuleFits[0].PoorlyIsolatedPeers)
+
+	// No location labels.
+	rf = fitRegion(stores, makeRegion("1111_leader,1121,2111"), []*Rule{makeRule("3/voter//")})
+	re.Empty(rf.RuleFits[0].PoorlyIsolatedPeers)
+}
+
+func TestFitNoLeaderChange(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,3111")
+
+	// The rules can be satisfied with the current leader.
+	rules := []*Rule{makeRule("1/leader//"), makeRule("2/follower//")}
+	re.True(fitRegion(stores, region, rules).IsSatisfied())
+	re.True(fitRegionNoLeaderChange(stores, region, rules).IsSatisfied())
+
+	// The rules require the leader to be in zone2.
+	rules = []*Rule{makeRule("1/leader/zone=zone2/"), makeRule("2/follower//")}
+	rf := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111"))
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "2111"))
+
+	rf = fitRegionNoLeaderChange(stores, region, rules)
+	re.False(rf.IsSatisfied())
+	re.Empty(rf.RuleFits[0].Peers)
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "2111,3111"))
+	re.Empty(rf.RuleFits[1].PeersWithDifferentRole)
+	re.True(checkPeerMatch(rf.OrphanPeers, "1111"))
+}
+
+func TestFitRegionWithTrace(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,1211,2111")
+	rules := []*Rule{makeRule("2/voter//zone,rack"), makeRule("1/voter//")}
+
+	rf, trace := fitRegionWithTrace(stores, region, rules)
+	re.Equal(fitRegion(stores, region, rules).Hash(), rf.Hash())
+	re.Equal([]FitTraceStep{
+		{RuleIndex: 0, PeerIDs: []uint64{1111, 1211}, Compare: 1},
+		{RuleIndex: 1, PeerIDs: []uint64{2111}, Compare: 1},
+		{RuleIndex: 0, PeerIDs: []uint64{1111, 2111}, Compare: 1},
+		{RuleIndex: 1, PeerIDs: []uint64{1211}, Compare: 1},
+		{RuleIndex: 0, PeerIDs: []uint64{1211, 2111}, Compare: 0},
+		{RuleIndex: 1, PeerIDs: []uint64{1111}, Compare: 0},
+	}, trace.Steps)
+
+	// The search is deterministic.
+	_, again := fitRegionWithTrace(stores, region, rules)
+	re.Equal(trace, again)
+}
+
+func TestFitMinHealthy(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,3111")
+	rule := makeRule("3/voter//")
+	rule.MinHealthy = 2
+	rules := []*Rule{rule}
+	re.True(fitRegion(stores, region, rules).IsSatisfied())
+
+	// One pending peer leaves the rule with 2 healthy peers.
+	mixed := region.Clone(core.WithPendingPeers([]*metapb.Peer{region.GetStorePeer(3111)}))
+	re.True(fitRegion(stores, mixed, rules).IsSatisfied())
+	mixed = mixed.Clone(core.WithDownPeers([]*pdpb.PeerStats{{Peer: region.GetStorePeer(2111)}}))
+	rf := fitRegion(stores, mixed, rules)
+	re.False(rf.IsSatisfied())
+	re.True(rf.RuleFits[0].isCountSatisfied())
+
+	pending := region.Clone(core.WithPendingPeers(region.GetPeers()))
+	re.False(fitRegion(stores, pending, rules).IsSatisfied())
+	rule.MinHealthy = 0
+	re.True(fitRegion(stores, pending, rules).IsSatisfied())
+}
+
+func TestFitReplicaRole(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,2211_learner,2311_learner")
+
+	rules := []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("3/replica/zone=zone2/")}
+	rf := fitRegion(stores, region, rules)
+	re.True(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "2111,2211,2311"))
+	re.Empty(rf.RuleFits[1].PeersWithDifferentRole)
+
+	rules = []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("3/voter/zone=zone2/")}
+	rf = fitRegion(stores, region, rules)
+	re.False(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[1].PeersWithDifferentRole, "2211,2311"))
+
+	// The leader matches the role as well.
+	rf = fitRegion(stores, region, []*Rule{makeRule("4/replica//")})
+	re.True(rf.IsSatisfied())
+	re.Empty(rf.RuleFits[0].PeersWithDifferentRole)
+	re.Equal(metapb.PeerRole_Learner, Replica.MetaPeerRole())
+}
+
+func TestFitReadReplicaRole(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,4111_learner")
+
+	// The learner on a store of the ReadReplica rule is not selected by the
+	// voter rule, so it is never promoted.
+	rf := fitRegion(stores, region, []*Rule{makeRule("3/voter//"), makeRule("1/read-replica/zone=zone4/")})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,2111"))
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "4111"))
+	re.Empty(rf.RuleFits[1].PeersWithDifferentRole)
+	re.Equal(peerLag{}, rf.RuleFits[1].promotionLag)
+	re.Empty(rf.OrphanPeers)
+	// A Learner rule does not keep the learner from the voter rule.
+	rf = fitRegion(stores, region, []*Rule{makeRule("3/voter//"), makeRule("1/learner/zone=zone4/")})
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,2111,4111"))
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "4111"))
+
+	// A voter selected by the ReadReplica rule needs to be demoted.
+	region = makeRegion("1111_leader,2111,3111,4111")
+	rf = fitRegion(stores, region, []*Rule{makeRule("3/voter/zone=zone1+zone2+zone3/"), makeRule("1/read-replica/zone=zone4/")})
+	re.False(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[1].PeersWithDifferentRole, "4111"))
+	re.Equal(1, rf.RuleFits[1].demotionCount())
+	re.True(ReadReplica.IsNonVoting())
+	re.Equal(metapb.PeerRole_Learner, ReadReplica.MetaPeerRole())
+}
+
+func TestFitMaxSameDeepestLabel(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rule := makeRule("3/voter//zone,rack,host")
+	rule.MaxSameDeepestLabel = 2
+	rules := []*Rule{rule}
+
+	// Two peers sharing a host are allowed.
+	rf := fitRegion(stores, makeRegion("1111_leader,1112,2111"), rules)
+	re.True(rf.IsSatisfied())
+	re.False(rf.RuleFits[0].SameDeepestLabelExceeded)
+
+	rf = fitRegion(stores, makeRegion("1111_leader,1112,1113"), rules)
+	re.False(rf.IsSatisfied())
+	re.True(rf.RuleFits[0].SameDeepestLabelExceeded)
+	re.True(rf.RuleFits[0].isCountSatisfied())
+
+	// The same host names in different racks are different locations.
+	rf = fitRegion(stores, makeRegion("1111_leader,1112,1211"), rules)
+	re.True(rf.IsSatisfied())
+}
+
+func TestFitMinOnConstraint(t *testing.T) {
+	re := require.New(t)
+	disks := map[uint64]string{1: "nvme", 2: "ssd", 3: "ssd", 4: "nvme"}
+	var stores []*core.StoreInfo
+	for id := uint64(1); id <= 4; id++ {
+		stores = append(stores, core.NewStoreInfoWithLabel(id, 0, map[string]string{"disk": disks[id]}))
+	}
+	rule := makeRule("3/voter//")
+	rule.OnLabelConstraints = []LabelConstraint{{Key: "disk", Op: In, Values: []string{"nvme"}}}
+	rule.MinOnConstraint = 2
+	rules := []*Rule{rule}
+
+	// Only one voter is on NVMe.
+	rf := fitRegion(stores, makeRegion("1_leader,2,3"), rules)
+	re.False(rf.IsSatisfied())
+	re.True(rf.RuleFits[0].OnConstraintShortage)
+	rf = fitRegion(stores, makeRegion("1_leader,2,4"), rules)
+	re.True(rf.IsSatisfied())
+	re.False(rf.RuleFits[0].OnConstraintShortage)
+
+	// The peers on NVMe are preferred.
+	rf = fitRegion(stores, makeRegion("1_leader,2,3,4"), rules)
+	re.False(rf.RuleFits[0].OnConstraintShortage)
+	re.Len(rf.OrphanPeers, 1)
+	re.NotEqual(uint64(4), rf.OrphanPeers[0].GetStoreId())
+}
+
+func TestFitForbiddenLabelConstraints(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rule := makeRule("3/voter/zone=zone1+zone2/")
+	rule.ForbiddenLabelConstraints = []LabelConstraint{{Key: "zone", Op: In, Values: []string{"zone1"}}, {Key: "rack", Op: In, Values: []string{"rack1"}}}
+	rules := []*Rule{rule}
+
+	// The store 1111 matches both the positive and the forbidden constraints,
+	// so its peer is only kept as a violating one.
+	rf := fitRegion(stores.GetStores(), makeRegion("1111_leader,1211,2111"), rules)
+	re.False(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111,1211,2111"))
+	re.True(checkPeerMatch(rf.RuleFits[0].ConstraintViolatingPeers, "1111"))
+	re.Empty(rf.OrphanPeers)
+	// It is left as an orphan once the rule is fulfilled by the others.
+	rf = fitRegion(stores.GetStores(), makeRegion("1111_leader,1211,1311,2111"), rules)
+	re.True(rf.RuleFits[0].IsSatisfied())
+	re.True(checkPeerMatch(rf.OrphanPeers, "1111"))
+	// The store 2111 is in rack1 but not in zone1, so it is not forbidden.
+	rf = fitRegion(stores.GetStores(), makeRegion("1211_leader,1311,2111"), rules)
+	re.True(rf.IsSatisfied())
+
+	// An existing peer on a forbidden store is flagged.
+	region := makeRegion("1111_leader,1211,2111")
+	peers := []*fitPeer{
+		{Peer: region.GetStorePeer(1111), store: stores.GetStore(1111), isLeader: true},
+		{Peer: region.GetStorePeer(1211), store: stores.GetStore(1211)},
+		{Peer: region.GetStorePeer(2111), store: stores.GetStore(2111)},
+	}
+	ruleFit := defaultFitConfig.newRuleFit(rule, peers, nil)
+	re.True(checkPeerMatch(ruleFit.ConstraintViolatingPeers, "1111"))
+	re.False(ruleFit.IsSatisfied())
+}
+
+func TestLeaderEligiblePeers(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	tiflash := stores.GetStore(3111).Clone(core.SetStoreLabels([]*metapb.StoreLabel{
+		{Key: "zone", Value: "zone3"},
+		{Key: core.EngineKey, Value: core.EngineTiFlash},
+	}))
+	stores.SetStore(tiflash)
+
+	// The voter on the TiFlash store can not be the leader.
+	region := makeRegion("1111_leader,2111,3111")
+	fit := fitRegion(stores.GetStores(), region, []*Rule{makeRule("3/voter//")})
+	re.True(checkPeerMatch(fit.LeaderEligiblePeers(), "1111,2111"))
+	// Only the peer of the Leader rule is eligible.
+	fit = fitRegion(stores.GetStores(), region, []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("2/follower//")})
+	re.True(checkPeerMatch(fit.LeaderEligiblePeers(), "1111"))
+	// Learners and orphan peers are not eligible.
+	region = makeRegion("1111_leader,2111,2211_learner,4111")
+	fit = fitRegion(stores.GetStores(), region, []*Rule{makeRule("2/voter/zone=zone1+zone2/"), makeRule("1/learner//")})
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+	re.True(checkPeerMatch(fit.LeaderEligiblePeers(), "1111,2111"))
+}
+
+func TestIsolationBreakdown(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	voters := makeRule("3/voter//zone")
+	voters.GroupID, voters.ID = "pd", "voters"
+	learner := makeRule("1/learner//zone")
+	learner.GroupID, learner.ID = "pd", "learner"
+
+	fit := fitRegion(stores, makeRegion("1111_leader,1211,2111,3111_learner"), []*Rule{voters, learner})
+	re.Equal([]RuleIsolation{
+		{GroupID: "pd", ID: "voters", Score: 2},
+		{GroupID: "pd", ID: "learner", Score: 0},
+	}, fit.IsolationBreakdown())
+	re.Equal(2.0, fit.TotalIsolationScore())
+}
+
+func TestFitWithLocationComparator(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone,rack")}
+	// zone1 and zone2 are in the same AZ.
+	az := func(s *core.StoreInfo, key string) string {
+		v := s.GetLabelValue(key)
+		if key == "zone" && v == "zone2" {
+			return "zone1"
+		}
+		return v
+	}
+	compare := func(a, b *core.StoreInfo, labels []string) int {
+		for i, key := range labels {
+			if az(a, key) != az(b, key) {
+				return i
+			}
+		}
+		return -1
+	}
+	spread, stacked := makeRegion("1111_leader,2111,3111"), makeRegion("1111_leader,1211,3111")
+
+	re.Greater(fitRegion(stores, spread, rules).RuleFits[0].IsolationScore,
+		fitRegion(stores, stacked, rules).RuleFits[0].IsolationScore)
+	re.Less(fitRegion(stores, spread, rules, locationComparatorOpt(compare)).RuleFits[0].IsolationScore,
+		fitRegion(stores, stacked, rules, locationComparatorOpt(compare)).RuleFits[0].IsolationScore)
+}
+
+func TestFitNetworkCost(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rule := makeRule("3/voter//zone")
+	region := makeRegion("1111_leader,1211,2111,3111")
+
+	// The peers are spread to 3 zones for the best isolation.
+	fit := fitRegion(stores, region, []*Rule{rule})
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "1211"))
+
+	// The traffic to zone3 is expensive, so the peers stay in zone1 and zone2
+	// near the leader.
+	rule.NetworkCost = &NetworkCost{
+		LabelKey: "zone",
+		Costs: map[string]map[string]float64{
+			"zone1": {"zone2": 1, "zone3": 10},
+			"zone2": {"zone3": 10},
+		},
+		Weight: 1,
+	}
+	fit = fitRegion(stores, region, []*Rule{rule})
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,1211,2111"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "3111"))
+
+	// Without the weight, only the isolation matters.
+	rule.NetworkCost.Weight = 0
+	fit = fitRegion(stores, region, []*Rule{rule})
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+}
+
+func TestFitBusyStorePenalty(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	stores.SetStore(stores.GetStore(1111).Clone(core.SetStoreStats(&pdpb.StoreStats{IsBusy: true})))
+	rules := []*Rule{makeRule("1/voter/zone=zone1/")}
+	rules[0].LocationLabels = nil
+	region := makeRegion("1111_leader,1211")
+
+	// Without the penalty, the peer with the smallest ID wins the tie.
+	fit := fitRegion(stores.GetStores(), region, rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111"))
+
+	cfg := fitConfig{busyStorePenalty: 1}
+	fit = fitRegionWithMatchCache(nil, cfg, stores.GetStores(), region, rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1211"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "1111"))
+	// The busy store is still used if there is no other choice.
+	fit = fitRegionWithMatchCache(nil, cfg, stores.GetStores(), makeRegion("1111_leader"), rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111"))
+	re.True(fit.IsSatisfied())
+}
+
+func TestFitPreferStretchOverOrphan(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	region := makeRegion("1111_leader,2111,3111,4111")
+
+	// The extra voter is an orphan by default.
+	fit := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+	re.False(fit.IsSatisfied())
+
+	cfg := fitConfig{preferStretchOverOrphan: true}
+	fit = fitRegionWithMatchCache(nil, cfg, stores, region, rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111,4111"))
+	re.True(checkPeerMatch(fit.RuleFits[0].OverCountPeers, "4111"))
+	re.Empty(fit.OrphanPeers)
+	re.True(fit.IsSatisfied())
+
+	// The extra learner does not match the role of the voter rule.
+	fit = fitRegionWithMatchCache(nil, cfg, stores, makeRegion("1111_leader,2111,3111,4111_learner"), rules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.Empty(fit.RuleFits[0].OverCountPeers)
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+}
+
+func TestStoresWithExcessOrphans(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("2/voter/zone=zone1+zone2/")}
+	fitRegions := func(defs ...string) []*RegionFit {
+		var fits []*RegionFit
+		for _, def := range defs {
+			fits = append(fits, fitRegion(stores, makeRegion(def), rules))
+		}
+		return fits
+	}
+
+	fit := fitRegion(stores, makeRegion("1111_leader,2111,4111,5111"), rules)
+	orphans := fit.OrphansByStore()
+	re.Len(orphans, 2)
+	re.True(checkPeerMatch(orphans[4111], "4111"))
+	re.True(checkPeerMatch(orphans[5111], "5111"))
+	re.Empty(fitRegion(stores, makeRegion("1111_leader,2111"), rules).OrphansByStore())
+
+	// The orphans are distributed.
+	fits := fitRegions("1111_leader,2111,4111", "1111_leader,2111,4211", "1111_leader,2111,5111")
+	re.Empty(StoresWithExcessOrphans(fits, 1))
+	re.Equal([]uint64{4111, 4211, 5111}, StoresWithExcessOrphans(fits, 0))
+	// The orphans are concentrated on 4111.
+	fits = fitRegions("1111_leader,2111,4111", "1211_leader,2211,4111", "1111_leader,2111,4111,5111")
+	re.Equal([]uint64{4111}, StoresWithExcessOrphans(fits, 2))
+	re.Empty(StoresWithExcessOrphans(fits, 3))
+}
+
+func TestPeersByRemovalSafety(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{
+		makeRule("1/leader/zone=zone1/"),
+		makeRule("3/voter/zone=zone2+zone3/zone,rack"),
+	}
+	region := makeRegion("1111_leader,3111,2111,2211,5111")
+	fit := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(fit.OrphanPeers, "5111"))
+	var ids []uint64
+	for _, p := range fit.PeersByRemovalSafety(region) {
+		ids = append(ids, p.GetId())
+	}
+	// The orphan is the safest, removing 3111 breaks the zone isolation of the
+	// voters, and 1111 is the only leader.
+	re.Equal([]uint64{5111, 2111, 2211, 3111, 1111}, ids)
+}
+
+func TestMergeRegionFits(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,3111_learner")
+	voters := fitRegion(stores, region, []*Rule{makeRule("2/voter//")})
+	learners := fitRegion(stores, region, []*Rule{makeRule("1/learner//")})
+	re.True(checkPeerMatch(voters.OrphanPeers, "3111"))
+	re.True(checkPeerMatch(learners.OrphanPeers, "1111,2111"))
+	re.False(voters.IsSatisfied())
+	re.False(learners.IsSatisfied())
+
+	merged := MergeRegionFits(voters, learners)
+	re.Len(merged.RuleFits, 2)
+	re.Empty(merged.OrphanPeers)
+	re.True(merged.IsSatisfied())
+
+	// 2111 is orphan in both fits.
+	leader := fitRegion(stores, region, []*Rule{makeRule("1/leader//")})
+	merged = MergeRegionFits(leader, learners)
+	re.True(checkPeerMatch(merged.OrphanPeers, "2111"))
+	re.False(merged.IsSatisfied())
+}
+
+func TestFitPreferFresherLearnerToPromote(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111,3111_learner,4111_learner")
+	rules := []*Rule{makeRule("3/voter//")}
+	stale := region.Clone(core.WithPendingPeers([]*metapb.Peer{region.GetStorePeer(3111)}))
+
+	peers := func(ids ...uint64) []*fitPeer {
+		var res []*fitPeer
+		for _, id := range ids {
+			res = append(res, &fitPeer{Peer: region.GetStorePeer(id), store: getStoreByID(stores, id)})
+		}
+		return res
+	}
+	fresh, lagging := defaultFitConfig.newRuleFit(rules[0], peers(1111, 2111, 4111), stale), defaultFitConfig.newRuleFit(rules[0], peers(1111, 2111, 3111), stale)
+	cmp, dim := compareRuleFitDimension(fresh, lagging)
+	re.Equal(1, cmp)
+	re.Equal(dimFreshness, dim)
+
+	rf := fitRegion(stores, stale, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "4111"))
+	stale = region.Clone(core.WithPendingPeers([]*metapb.Peer{region.GetStorePeer(4111)}))
+	rf = fitRegion(stores, stale, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "3111"))
+
+	// The learner down for a shorter time is fresher.
+	down := region.Clone(core.WithDownPeers([]*pdpb.PeerStats{
+		{Peer: region.GetStorePeer(3111), DownSeconds: 100},
+		{Peer: region.GetStorePeer(4111), DownSeconds: 50},
+	}))
+	rf = fitRegion(stores, down, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "4111"))
+
+	// The learner whose store is receiving fewer snapshots is fresher.
+	catchingUp := make([]*core.StoreInfo, 0, len(stores))
+	for _, store := range stores {
+		if store.GetID() == 3111 {
+			store = store.Clone(core.SetNewStoreStats(&pdpb.StoreStats{StoreId: 3111, ReceivingSnapCount: 2}))
+		}
+		catchingUp = append(catchingUp, store)
+	}
+	rf = fitRegion(catchingUp, region, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].PeersWithDifferentRole, "4111"))
+}
+
+func TestFitEarlyExitEquivalence(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	ruleDefs := []string{"3/voter//", "1/leader/zone=zone1/", "2/follower/zone=zone1+zone2/", "1/learner//", "2/voter/zone=zone2+zone3/"}
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 200; i++ {
+		var defs []string
+		used := make(map[uint64]struct{})
+		for j := 3 + r.Intn(4); len(defs) < j; {
+			store := stores[r.Intn(len(stores))]
+			if _, ok := used[store.GetID()]; ok {
+				continue
+			}
+			used[store.GetID()] = struct{}{}
+			def := strconv.FormatUint(store.GetID(), 10)
+			switch {
+			case len(defs) == 0:
+				def += "_leader"
+			case r.Intn(3) == 0:
+				def += "_learner"
+			}
+			defs = append(defs, def)
+		}
+		region := makeRegion(strings.Join(defs, ","))
+		var rules []*Rule
+		for j := 1 + r.Intn(2); len(rules) < j; {
+			rules = append(rules, makeRule(ruleDefs[r.Intn(len(ruleDefs))]))
+		}
+
+		early := newFitWorker(stores, region, rules, defaultFitConfig).fit()
+		w := newFitWorker(stores, region, rules, defaultFitConfig)
+		w.exhaustive = true
+		exhaustive := w.fit()
+		re.Equal(exhaustive.Hash(), early.Hash(), "seed %d, region %s, rules %v", seed, defs, rules)
+		re.Equal(exhaustive.IsSatisfied(), early.IsSatisfied())
+	}
+}
+
+func TestSafeOrphanRemovals(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("2/follower/zone=zone2/")}
+
+	rf := fitRegion(stores, makeRegion("1111_leader,2111,2211,1211,2311"), rules)
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211,2311"))
+	re.True(checkPeerMatch(rf.SafeOrphanRemovals(), "1211,2311"))
+
+	// The follower rule is not satisfied. 1211 may be needed by an alternate
+	// assignment, while 5111 matches no rule.
+	rf = fitRegion(stores, makeRegion("1111_leader,2111,1211,5111_learner"), rules)
+	re.True(checkPeerMatch(rf.OrphanPeers, "1211,5111"))
+	re.True(checkPeerMatch(rf.SafeOrphanRemovals(), "5111"))
+
+	rf = fitRegion(stores, makeRegion("1111_leader,2111,2211"), rules)
+	re.Empty(rf.SafeOrphanRemovals())
+}
+
+func TestFitRegionWithLeaderHint(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	// Both 1111 and 1211 can be the leader, with a leader transfer.
+	region := makeRegion("2111_leader,1111,1211")
+	rules := []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("2/voter//")}
+
+	rf := fitRegion(stores, region, rules)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.False(rf.RuleFits[0].OnPreferredLeaderStore)
+
+	rf = fitRegionWithLeaderHint(stores, region, rules, 1211)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1211"))
+	re.True(rf.RuleFits[0].OnPreferredLeaderStore)
+	re.True(checkPeerMatch(rf.RuleFits[1].Peers, "1111,2111"))
+
+	// The hint does not override the current leader.
+	rf = fitRegionWithLeaderHint(stores, makeRegion("1111_leader,1211,2111"), rules, 1211)
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	re.False(rf.RuleFits[0].OnPreferredLeaderStore)
+}
+
+func TestFitPreferencesWithoutIsolation(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,2111")
+	rules := []*Rule{makeRule("1/voter//"), makeRule("1/voter//")}
+	for _, rule := range rules {
+		rule.LocationLabels = nil
+	}
+	re.False(newFitWorker(stores, region, rules, defaultFitConfig).needIsolation)
+
+	rf := fitRegion(stores, region, rules)
+	re.True(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "1111"))
+	// The search does not exit at the first satisfied fit, so that the peer
+	// on the store of the sibling regions is preferred.
+	group := groupStoresOpt(map[uint64]struct{}{2111: {}})
+	re.True(newFitWorker(stores, region, rules, defaultFitConfig, group).needIsolation)
+	rf = fitRegion(stores, region, rules, group)
+	re.True(rf.IsSatisfied())
+	re.True(checkPeerMatch(rf.RuleFits[0].Peers, "2111"))
+	re.True(newFitWorker(stores, region, rules, defaultFitConfig, leaderHintOpt(2111)).needIsolation)
+}
+
+func TestFitWithMaintenance(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	maintenance := maintenanceOpt(map[uint64]struct{}{3111: {}})
+
+	// The store is relabeled to zone3 during maintenance.
+	rules := []*Rule{makeRule("3/voter/zone=zone1+zone2/")}
+	region := makeRegion("1111_leader,2111,3111")
+	fit := fitRegion(stores, region, rules)
+	re.False(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].ConstraintViolatingPeers, "3111"))
+	fit = fitRegion(stores, region, rules, maintenance)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].MaintenancePeers, "3111"))
+
+	// The leader is transferred out of zone1 before the maintenance.
+	rules = []*Rule{makeRule("1/leader/zone=zone1/"), makeRule("2/voter//")}
+	region = makeRegion("2111_leader,1111,3111")
+	fit = fitRegion(stores, region, rules)
+	re.False(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].PeersWithDifferentRole, "1111"))
+	fit = fitRegion(stores, region, rules, maintenanceOpt(map[uint64]struct{}{1111: {}}))
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].MaintenancePeers, "1111"))
+	re.Empty(fit.RuleFits[0].PeersWithDifferentRole)
+}
+
+func TestVotersOnlyIsolation(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	voterRules := []*Rule{makeRule("3/voter//zone")}
+	learnerRules := []*Rule{makeRule("1/leader//"), makeRule("2/learner//zone")}
+	// The learner on 2111 is to be promoted.
+	voterRegion := makeRegion("1111_leader,1211,2111_learner")
+	learnerRegion := makeRegion("1111_leader,2111_learner,3111_learner")
+
+	re.Equal(2.0, fitRegion(stores, voterRegion, voterRules).RuleFits[0].IsolationScore)
+	re.Equal(1.0, fitRegion(stores, learnerRegion, learnerRules).RuleFits[1].IsolationScore)
+
+	cfg := fitConfig{votersOnlyIsolation: true}
+	fit := fitRegionWithMatchCache(nil, cfg, stores, voterRegion, voterRules)
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,1211,2111"))
+	re.Zero(fit.RuleFits[0].IsolationScore)
+	// The learners of a Learner rule are still scored.
+	re.Equal(1.0, fitRegionWithMatchCache(nil, cfg, stores, learnerRegion, learnerRules).RuleFits[1].IsolationScore)
+}
+
+func TestFitTierCompliance(t *testing.T) {
+	re := require.New(t)
+	var stores []*core.StoreInfo
+	for id, tier := range map[uint64]string{1: "gold", 2: "gold", 3: "silver", 4: "silver"} {
+		stores = append(stores, core.NewStoreInfoWithLabel(id, 0, map[string]string{"tier": tier}))
+	}
+	rules := []*Rule{makeRule("3/voter//")}
+
+	// No requirement, always compliant.
+	re.True(fitRegion(stores, makeRegion("3_leader,4,1"), rules).RuleFits[0].TierCompliant)
+
+	// The leader is required on the gold tier.
+	rules[0].TierRequirement = &TierRequirement{LabelKey: "tier", Tiers: []string{"gold"}, LeaderOnly: true}
+	fit := fitRegion(stores, makeRegion("1_leader,3,4"), rules)
+	re.True(fit.RuleFits[0].TierCompliant)
+	fit = fitRegion(stores, makeRegion("1,3_leader,4"), rules)
+	re.False(fit.RuleFits[0].TierCompliant)
+	// It is informational only.
+	re.True(fit.IsSatisfied())
+
+	// All the peers are required on the tiers.
+	rules[0].TierRequirement.LeaderOnly = false
+	re.False(fitRegion(stores, makeRegion("1_leader,2,3"), rules).RuleFits[0].TierCompliant)
+	re.True(fitRegion(stores, makeRegion("1_leader,2"), rules).RuleFits[0].TierCompliant)
+	rules[0].TierRequirement.Tiers = []string{"gold", "silver"}
+	re.True(fitRegion(stores, makeRegion("1_leader,2,3"), rules).RuleFits[0].TierCompliant)
+}
+
+func TestFitWithWeightedRoles(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	rules[0].RoleWeights = map[PeerRoleType]float64{Witness: 0.5}
+	region := makeRegion("1111_leader,2111,3111")
+
+	re.True(fitRegion(stores, region, rules).IsSatisfied())
+	// A witness is half a replica.
+	fit := fitRegion(stores, region, rules, witnessOpt(map[uint64]struct{}{3111: {}}))
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(fit.RuleFits[0].IsShortOfPeers())
+	re.False(fit.IsSatisfied())
+	// The witness is a full replica without the weight.
+	fit = fitRegion(stores, region, []*Rule{makeRule("3/voter//zone")}, witnessOpt(map[uint64]struct{}{3111: {}}))
+	re.False(fit.RuleFits[0].IsShortOfPeers())
+	re.True(fit.IsSatisfied())
+
+	// Two witnesses count as one voter, so the rule selects 4 peers.
+	region = makeRegion("1111_leader,2111,3111,4111")
+	fit = fitRegion(stores, region, rules, witnessOpt(map[uint64]struct{}{3111: {}, 4111: {}}))
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111,4111"))
+	re.Empty(fit.OrphanPeers)
+	re.True(fit.IsSatisfied())
+	// The peers not needed to reach the count are still orphans.
+	fit = fitRegion(stores, region, rules, witnessOpt(map[uint64]struct{}{4111: {}}))
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+	re.True(fit.IsSatisfied())
+	// A learner making up the witness is promoted.
+	fit = fitRegion(stores, makeRegion("1111_leader,2111,3111,4111_learner"), rules, witnessOpt(map[uint64]struct{}{3111: {}}))
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111,4111"))
+	re.True(checkPeerMatch(fit.RuleFits[0].PeersWithDifferentRole, "4111"))
+	re.Empty(fit.OrphanPeers)
+	re.False(fit.IsSatisfied())
+}
+
+func TestFitWithManyCandidates(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	region := makeRegion("1111_leader,1112,1211,2111,2112_learner")
+	fitWith := func(rules []*Rule, maxExactCandidates int, opts ...fitPeerOpt) *RegionFit {
+		cfg := fitConfig{maxExactCandidates: maxExactCandidates}
+		return fitRegionWithMatchCache(nil, cfg, stores, region, rules, opts...)
+	}
+
+	for _, maxExactCandidates := range []int{0, 4} {
+		fit := fitWith([]*Rule{makeRule("3/voter//zone,rack,host")}, maxExactCandidates)
+		re.Equal(maxExactCandidates > 0, fit.Approximate)
+		re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,1211,2111"))
+		re.Empty(fit.RuleFits[0].PeersWithDifferentRole)
+		re.Equal(20100.0, fit.RuleFits[0].IsolationScore)
+		re.True(checkPeerMatch(fit.OrphanPeers, "1112,2112"))
+
+		// The learner is selected at last, and it is still to be promoted.
+		fit = fitWith([]*Rule{makeRule("5/voter//zone,rack,host")}, maxExactCandidates)
+		re.True(checkPeerMatch(fit.RuleFits[0].PeersWithDifferentRole, "2112"))
+		re.Equal(fitRegion(stores, region, []*Rule{makeRule("5/voter//zone,rack,host")}).RuleFits[0].IsolationScore, fit.RuleFits[0].IsolationScore)
+	}
+
+	// The rules with few candidates are still enumerated.
+	fit := fitWith([]*Rule{makeRule("1/leader/zone=zone1/"), makeRule("2/voter/zone=zone2/")}, 4)
+	re.False(fit.Approximate)
+
+	// A weighted rule keeps more than Count greedy candidates, so that the
+	// witnesses counting half a replica make up the Count.
+	region = makeRegion("1111_leader,2111,3111,4111,5111")
+	witnesses := witnessOpt(map[uint64]struct{}{2111: {}, 3111: {}, 4111: {}, 5111: {}})
+	rule := makeRule("2/voter//")
+	rule.RoleWeights = map[PeerRoleType]float64{Witness: 0.5}
+	for _, maxExactCandidates := range []int{0, 4} {
+		fit = fitWith([]*Rule{rule}, maxExactCandidates, witnesses)
+		re.Equal(maxExactCandidates > 0, fit.Approximate)
+		re.True(fit.IsSatisfied())
+		re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	}
+}
@@ -92,3 +92,10 @@ func MatchLabelConstraints(store *core.StoreInfo, constraints []LabelConstraint)
 
 	return slice.AllOf(constraints, func(i int) bool { return constraints[i].MatchStore(store) })
 }
+
+// MatchForbiddenLabelConstraints checks if the store matches all the forbidden
+// constraints. An empty constraint list forbids nothing.
+func MatchForbiddenLabelConstraints(store *core.StoreInfo, constraints []LabelConstraint) bool {
+	return len(constraints) > 0 && store != nil &&
+		slice.AllOf(constraints, func(i int) bool { return constraints[i].MatchStore(store) })
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import "strings"
+
+// labelEquivalence maps a location label key to the mapping from its values to
+// the canonical values. The values are in lower case.
+type labelEquivalence map[string]map[string]string
+
+// newLabelEquivalence returns the equivalence treating some values of a
+// location label as the same location. It is used by the deployments whose
+// physical racks within a logical fault domain are safe to co-locate. For
+// example, with {"rack": {"r1a": "r1", "r1b": "r1"}}, stores in rack "r1a" and
+// "r1b" are considered in the same rack. It returns nil if the map is empty.
+func newLabelEquivalence(m map[string]map[string]string) labelEquivalence {
+	if len(m) == 0 {
+		return nil
+	}
+	eq := make(labelEquivalence, len(m))
+	for key, values := range m {
+		canonical := make(map[string]string, len(values))
+		for value, to := range values {
+			canonical[strings.ToLower(value)] = strings.ToLower(to)
+		}
+		eq[key] = canonical
+	}
+	return eq
+}
+
+// canonical returns the canonical value of the label value.
+func (eq labelEquivalence) canonical(key, value string) string {
+	if to, ok := eq[key][strings.ToLower(value)]; ok {
+		return to
+	}
+	return value
+}
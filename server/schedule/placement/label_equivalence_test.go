@@ -0,0 +1,70 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/config"
+	"github.com/tikv/pd/server/storage"
+)
+
+func TestLabelEquivalence(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	peer := func(id uint64) *fitPeer {
+		return &fitPeer{store: stores.GetStore(id)}
+	}
+	labels := []string{"zone", "rack", "host"}
+	sameDomain := []*fitPeer{peer(1111), peer(1221)}
+	crossDomain := []*fitPeer{peer(1111), peer(1311)}
+	re.Equal([]int{0, 1, 0}, isolationLevels(sameDomain, labels))
+	re.Equal(isolationScore(sameDomain, labels), isolationScore(crossDomain, labels))
+
+	// rack1 and rack2 are physical racks of the same logical domain.
+	location := newLocationConfig("", 0, map[string]map[string]string{"rack": {"rack1": "domain1", "Rack2": "domain1"}}, "")
+	for _, p := range append(sameDomain, crossDomain...) {
+		p.compare = location.compare
+	}
+	re.Equal([]int{0, 0, 1}, isolationLevels(sameDomain, labels))
+	re.Equal([]int{0, 1, 0}, isolationLevels(crossDomain, labels))
+	re.Less(isolationScore(sameDomain, labels), isolationScore(crossDomain, labels))
+}
+
+func TestLabelEquivalenceConfig(t *testing.T) {
+	re := require.New(t)
+	opt := config.NewTestOptions()
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opt)
+	re.True(manager.fitConfig().location.isDefault())
+
+	cfg := opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesLabelEquivalence = map[string]map[string]string{"rack": {"R1a": "r1"}}
+	opt.SetReplicationConfig(cfg)
+	location := manager.fitConfig().location
+	re.Equal(labelEquivalence{"rack": {"r1a": "r1"}}, location.equivalence)
+	re.Same(location, manager.fitConfig().location)
+
+	cfg = opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesLabelEquivalence["rack"]["r1b"] = "r1"
+	opt.SetReplicationConfig(cfg)
+	location = manager.fitConfig().location
+	re.Equal(labelEquivalence{"rack": {"r1a": "r1", "r1b": "r1"}}, location.equivalence)
+
+	cfg = opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesLabelEquivalence = nil
+	opt.SetReplicationConfig(cfg)
+	re.True(manager.fitConfig().location.isDefault())
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"github.com/tikv/pd/pkg/syncutil"
+	"github.com/tikv/pd/server/core"
+)
+
+// storeMatchCache caches whether a store is a candidate of a rule, so that the
+// result can be reused by the regions sharing the same rules.
+// A StoreInfo is cloned whenever the store is changed, and a Rule is replaced
+// whenever it is updated, so the entries are keyed by the pointers and an
+// outdated entry can never be hit. The cache is reset when rules are changed
+// to drop the entries of the removed rules.
+type storeMatchCache struct {
+	mu      syncutil.RWMutex
+	matches map[*Rule]map[uint64]storeMatch
+	// misses counts how many times the match is computed, for test.
+	misses int
+}
+
+type storeMatch struct {
+	store *core.StoreInfo
+	match bool
+}
+
+func newStoreMatchCache() *storeMatchCache {
+	return &storeMatchCache{matches: make(map[*Rule]map[uint64]storeMatch)}
+}
+
+// match returns whether the store matches the rule. A nil cache computes the
+// result directly.
+func (c *storeMatchCache) match(rule *Rule, store *core.StoreInfo) bool {
+	if c == nil || store == nil {
+		return matchRuleStore(rule, store)
+	}
+	c.mu.RLock()
+	m, ok := c.matches[rule][store.GetID()]
+	c.mu.RUnlock()
+	if ok && m.store == store {
+		return m.match
+	}
+
+	match := matchRuleStore(rule, store)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	if c.matches[rule] == nil {
+		c.matches[rule] = make(map[uint64]storeMatch)
+	}
+	c.matches[rule][store.GetID()] = storeMatch{store: store, match: match}
+	return match
+}
+
+func (c *storeMatchCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.matches = make(map[*Rule]map[uint64]storeMatch)
+}
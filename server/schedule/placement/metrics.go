@@ -0,0 +1,49 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	satisfiedRegionRatioGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "placement",
+			Name:      "satisfied_region_ratio",
+			Help:      "The ratio of sampled regions which satisfy the placement rules.",
+		})
+
+	unsatisfiedByLabelGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd",
+			Subsystem: "placement",
+			Name:      "unsatisfied_by_label",
+			Help:      "The count of sampled unsatisfied regions attributed to the location label values of their stores.",
+		}, []string{"label", "value"})
+
+	fitCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd",
+			Subsystem: "placement",
+			Name:      "fit_cache_count",
+			Help:      "Counter of the hits, misses and evictions of the region fit cache.",
+		}, []string{"type"})
+)
+
+func init() {
+	prometheus.MustRegister(satisfiedRegionRatioGauge)
+	prometheus.MustRegister(unsatisfiedByLabelGauge)
+	prometheus.MustRegister(fitCacheCounter)
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"sort"
+
+	"github.com/tikv/pd/server/core"
+)
+
+// OffloadMove moves the peer of the region on FromStore to ToStore.
+type OffloadMove struct {
+	RegionID  uint64
+	FromStore uint64
+	ToStore   uint64
+}
+
+// RecommendOffloads plans at most budget peer moves off the hot store. Each
+// move keeps the region satisfied and passes ReplaceReason, and moves the peer
+// to the store with the fewest regions, as long as the target is not more
+// loaded than the hot store after the planned moves. The regions which can not
+// be moved safely are skipped.
+func RecommendOffloads(storeID uint64, regions []*core.RegionInfo, stores StoreSet, rules []*Rule, budget int) []OffloadMove {
+	hot := stores.GetStore(storeID)
+	if hot == nil || budget <= 0 {
+		return nil
+	}
+	added := make(map[uint64]int)
+	regionCount := func(store *core.StoreInfo) int {
+		return store.GetRegionCount() + added[store.GetID()]
+	}
+	var moves []OffloadMove
+	for _, region := range regions {
+		if len(moves) >= budget {
+			break
+		}
+		if region.GetStorePeer(storeID) == nil {
+			continue
+		}
+		var targets []*core.StoreInfo
+		for _, store := range stores.GetStores() {
+			if store.IsUp() && region.GetStorePeer(store.GetID()) == nil &&
+				regionCount(store) < hot.GetRegionCount()-len(moves)-1 {
+				targets = append(targets, store)
+			}
+		}
+		sort.SliceStable(targets, func(i, j int) bool {
+			ci, cj := regionCount(targets[i]), regionCount(targets[j])
+			return ci < cj || (ci == cj && targets[i].GetID() < targets[j].GetID())
+		})
+		fit := fitRegion(stores.GetStores(), region, rules)
+		for _, target := range targets {
+			if ok, _ := fit.ReplaceReason(storeID, target, region); !ok {
+				continue
+			}
+			moved := region.Clone(core.WithReplacePeerStore(storeID, target.GetID()))
+			if !fitRegion(stores.GetStores(), moved, rules).IsSatisfied() {
+				continue
+			}
+			added[target.GetID()]++
+			moves = append(moves, OffloadMove{RegionID: region.GetID(), FromStore: storeID, ToStore: target.GetID()})
+			break
+		}
+	}
+	return moves
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/core"
+)
+
+func TestRecommendOffloads(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	stores := core.NewStoresInfo()
+	counts := map[uint64]int{1111: 10, 2111: 5, 2211: 5, 3111: 1}
+	for id, count := range counts {
+		stores.SetStore(all.GetStore(id).Clone(core.SetRegionCount(count)))
+	}
+	rules := []*Rule{makeRule("3/voter//zone")}
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,2111,2211").Clone(core.WithNewRegionID(1)),
+		// Moving the peer to 2211 puts 2 peers in zone2.
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(2)),
+		// The region has no peer on the hot store.
+		makeRegion("2111_leader,2211,3111").Clone(core.WithNewRegionID(3)),
+		makeRegion("1111_leader,2111,2211").Clone(core.WithNewRegionID(4)),
+	}
+
+	moves := RecommendOffloads(1111, regions, stores, rules, 10)
+	re.Equal([]OffloadMove{
+		{RegionID: 1, FromStore: 1111, ToStore: 3111},
+		{RegionID: 4, FromStore: 1111, ToStore: 3111},
+	}, moves)
+	re.Len(RecommendOffloads(1111, regions, stores, rules, 1), 1)
+	re.Empty(RecommendOffloads(1111, regions, stores, rules, 0))
+	re.Empty(RecommendOffloads(5111, regions, stores, rules, 10))
+
+	// The move which makes the target as loaded as the hot store is skipped.
+	stores.SetStore(stores.GetStore(3111).Clone(core.SetRegionCount(7)))
+	moves = RecommendOffloads(1111, regions, stores, rules, 10)
+	re.Equal([]OffloadMove{{RegionID: 1, FromStore: 1111, ToStore: 3111}}, moves)
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import "github.com/tikv/pd/server/core"
+
+// projectRegion returns the region after the changes are applied.
+func projectRegion(region *core.RegionInfo, changes []core.PendingPeerChange) *core.RegionInfo {
+	if len(changes) == 0 {
+		return region
+	}
+	opts := make([]core.RegionCreateOption, 0, len(changes))
+	for _, c := range changes {
+		if c.RemoveStoreID != 0 {
+			opts = append(opts, core.WithRemoveStorePeer(c.RemoveStoreID))
+		}
+		if c.AddPeer != nil {
+			opts = append(opts, core.WithAddPeer(c.AddPeer))
+		}
+		if c.PromotePeerID != 0 {
+			opts = append(opts, core.WithPromoteLearner(c.PromotePeerID))
+		}
+		if c.DemotePeerID != 0 {
+			opts = append(opts, core.WithDemoteVoter(c.DemotePeerID))
+		}
+	}
+	return region.Clone(opts...)
+}
+
+// FitRegionWithPendingChanges fits the region as if the pending changes are
+// applied, so that the fit reflects the near-future state of the region. The
+// result is not cached.
+func (m *RuleManager) FitRegionWithPendingChanges(storeSet StoreSet, region *core.RegionInfo, changes []core.PendingPeerChange) *RegionFit {
+	projected := projectRegion(region, changes)
+	regionStores := getStoresByRegion(storeSet, projected)
+	rules := m.GetRulesForApplyRegion(projected)
+	fit := fitRegionWithMatchCache(m.matchCache, m.fitConfig(), regionStores, projected, rules)
+	fit.rules = rules
+	return fit
+}
@@ -16,9 +16,12 @@ package placement
 
 import (
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/tikv/pd/pkg/cache"
 	"github.com/tikv/pd/pkg/slice"
 	"github.com/tikv/pd/pkg/syncutil"
 	"github.com/tikv/pd/server/core"
+	"go.uber.org/zap"
 )
 
 // RegionRuleFitCacheManager stores each region's RegionFit Result and involving variables
@@ -34,23 +37,152 @@ import (
 // 5. stores topology is changed
 // 6. any store label is changed
 // 7. any store state is changed
+// At most maxEntries regions are cached, and the least recently accessed one
+// is evicted once the bound is exceeded.
 type RegionRuleFitCacheManager struct {
-	mu     syncutil.RWMutex
-	caches map[uint64]*RegionRuleFitCache
+	mu         syncutil.RWMutex
+	caches     cache.Cache // region ID -> *RegionRuleFitCache
+	maxEntries int
+	notifier   fitChangeNotifier
 }
 
-// NewRegionRuleFitCacheManager returns RegionRuleFitCacheManager
-func NewRegionRuleFitCacheManager() *RegionRuleFitCacheManager {
+// DefaultFitCacheMaxEntries is the default max count of regions whose fits are
+// cached.
+const DefaultFitCacheMaxEntries = 1 << 20
+
+// NewRegionRuleFitCacheManager returns RegionRuleFitCacheManager which caches
+// the fits of at most maxEntries regions. A non-positive maxEntries uses
+// DefaultFitCacheMaxEntries.
+func NewRegionRuleFitCacheManager(maxEntries int) *RegionRuleFitCacheManager {
+	if maxEntries <= 0 {
+		maxEntries = DefaultFitCacheMaxEntries
+	}
 	return &RegionRuleFitCacheManager{
-		caches: map[uint64]*RegionRuleFitCache{},
+		caches:     cache.NewCache(maxEntries, cache.LRUCache),
+		maxEntries: maxEntries,
+		notifier:   fitChangeNotifier{maxEntries: maxEntries},
 	}
 }
 
+// FitChangeEvent is emitted when an observed fit of a region changes its
+// satisfied state.
+type FitChangeEvent struct {
+	RegionID     uint64
+	OldSatisfied bool
+	NewSatisfied bool
+}
+
+// SubscribeFitChanges registers a channel to receive FitChangeEvent.
+// The delivery is non-blocking: if the channel is full, the event is dropped
+// for this subscriber, so a buffered channel is recommended.
+func (manager *RegionRuleFitCacheManager) SubscribeFitChanges(ch chan<- FitChangeEvent) {
+	manager.notifier.subscribe(ch)
+}
+
+// FitTransitionHandler is called when an observed fit of a region turns from
+// satisfied to unsatisfied.
+type FitTransitionHandler func(regionID uint64, fit *RegionFit)
+
+// RegisterFitTransitionHandler registers a handler which is called
+// synchronously when an observed fit of a region turns from satisfied to
+// unsatisfied. The handlers are called without holding any lock, and a panic in
+// a handler is logged rather than propagated.
+func (manager *RegionRuleFitCacheManager) RegisterFitTransitionHandler(handler FitTransitionHandler) {
+	manager.notifier.register(handler)
+}
+
+// ObserveFit records the satisfied state of the fit of a region, and notifies
+// the subscribers and the handlers if the state changes. Only the fits of the
+// regions as they are should be observed, not the hypothetical ones.
+func (manager *RegionRuleFitCacheManager) ObserveFit(regionID uint64, fit *RegionFit) {
+	for _, handler := range manager.notifier.observe(regionID, fit.IsSatisfied()) {
+		runFitTransitionHandler(handler, regionID, fit)
+	}
+}
+
+func runFitTransitionHandler(handler FitTransitionHandler, regionID uint64, fit *RegionFit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("fit transition handler failed", zap.Uint64("region-id", regionID), zap.Reflect("error", r))
+		}
+	}()
+	handler(regionID, fit)
+}
+
+// fitChangeNotifier tracks the satisfied states of regions once there is any
+// subscriber or handler. It uses its own lock to avoid blocking the cache. At
+// most maxEntries regions are tracked, and the least recently observed one is
+// forgotten once the bound is exceeded, e.g. after it is merged.
+type fitChangeNotifier struct {
+	mu          syncutil.Mutex
+	maxEntries  int
+	subscribers []chan<- FitChangeEvent
+	handlers    []FitTransitionHandler
+	satisfied   cache.Cache // region ID -> bool
+}
+
+func (n *fitChangeNotifier) subscribe(ch chan<- FitChangeEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+	n.subscribers = append(n.subscribers, ch)
+}
+
+func (n *fitChangeNotifier) register(handler FitTransitionHandler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+	n.handlers = append(n.handlers, handler)
+}
+
+func (n *fitChangeNotifier) initLocked() {
+	if n.satisfied == nil {
+		if n.maxEntries <= 0 {
+			n.maxEntries = DefaultFitCacheMaxEntries
+		}
+		n.satisfied = cache.NewCache(n.maxEntries, cache.LRUCache)
+	}
+}
+
+// observe records the satisfied state and notifies the subscribers if the state
+// changes. It returns the handlers to call if the region turns unsatisfied.
+func (n *fitChangeNotifier) observe(regionID uint64, satisfied bool) []FitTransitionHandler {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.subscribers) == 0 && len(n.handlers) == 0 {
+		return nil
+	}
+	v, ok := n.satisfied.Get(regionID)
+	n.satisfied.Put(regionID, satisfied)
+	if !ok || v.(bool) == satisfied {
+		return nil
+	}
+	old := v.(bool)
+	event := FitChangeEvent{RegionID: regionID, OldSatisfied: old, NewSatisfied: satisfied}
+	for _, ch := range n.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	if satisfied {
+		return nil
+	}
+	return append([]FitTransitionHandler(nil), n.handlers...)
+}
+
 // Invalid invalid cache by regionID
 func (manager *RegionRuleFitCacheManager) Invalid(regionID uint64) {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
-	delete(manager.caches, regionID)
+	manager.caches.Remove(regionID)
+}
+
+// InvalidAll invalids the caches of all regions.
+func (manager *RegionRuleFitCacheManager) InvalidAll() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.caches = cache.NewCache(manager.maxEntries, cache.LRUCache)
 }
 
 // CheckAndGetCache checks whether the region and rules are changed for the stored cache
@@ -63,11 +195,14 @@ func (manager *RegionRuleFitCacheManager) CheckAndGetCache(region *core.RegionIn
 	}
 	manager.mu.RLock()
 	defer manager.mu.RUnlock()
-	if cache, ok := manager.caches[region.GetID()]; ok && cache.bestFit != nil {
-		if cache.IsUnchanged(region, rules, stores) {
-			return true, cache.bestFit
+	// Get updates the recency of the region.
+	if v, ok := manager.caches.Get(region.GetID()); ok {
+		if c := v.(*RegionRuleFitCache); c.bestFit != nil && c.IsUnchanged(region, rules, stores) {
+			fitCacheCounter.WithLabelValues("hit").Inc()
+			return true, c.bestFit
 		}
 	}
+	fitCacheCounter.WithLabelValues("miss").Inc()
 	return false, nil
 }
 
@@ -79,7 +214,10 @@ func (manager *RegionRuleFitCacheManager) SetCache(region *core.RegionInfo, fit
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 	fit.SetCached(true)
-	manager.caches[region.GetID()] = toRegionRuleFitCache(region, fit)
+	if _, ok := manager.caches.Peek(region.GetID()); !ok && manager.caches.Len() >= manager.maxEntries {
+		fitCacheCounter.WithLabelValues("eviction").Inc()
+	}
+	manager.caches.Put(region.GetID(), toRegionRuleFitCache(region, fit))
 }
 
 // RegionRuleFitCache stores regions RegionFit result and involving variables
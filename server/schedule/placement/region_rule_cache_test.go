@@ -192,6 +192,22 @@ func TestRegionRuleFitCache(t *testing.T) {
 	re.False(cache.IsUnchanged(originRegion, originRules, originStores))
 }
 
+func TestFitChangeNotifierBound(t *testing.T) {
+	re := require.New(t)
+	n := fitChangeNotifier{maxEntries: 2}
+	ch := make(chan FitChangeEvent, 10)
+	n.subscribe(ch)
+	for id := uint64(1); id <= 3; id++ {
+		re.Nil(n.observe(id, true))
+	}
+	re.Equal(2, n.satisfied.Len())
+	// Region 1 is forgotten, so it is tracked from scratch.
+	n.observe(1, false)
+	re.Empty(ch)
+	n.observe(3, false)
+	re.Equal(FitChangeEvent{RegionID: 3, OldSatisfied: true, NewSatisfied: false}, <-ch)
+}
+
 func mockRegionRuleFitCache(region *core.RegionInfo, rules []*Rule, regionStores []*core.StoreInfo) *RegionRuleFitCache {
 	return &RegionRuleFitCache{
 		region:       toRegionCache(region),
@@ -0,0 +1,198 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"sort"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/server/core"
+)
+
+// The reasons why ReplaceReason rejects a replacement.
+const (
+	ReplaceNoRule          = "no rule for source"
+	ReplaceLabelConstraint = "label constraint failed"
+	ReplaceIsolationDrop   = "isolation would drop"
+	ReplaceCapacity        = "insufficient capacity"
+)
+
+// ReplaceReason checks whether the peer on the source store can be replaced by
+// a peer on the destination store without breaking the rule it is fitted to.
+// On rejection, it returns the reason as well, so that the caller can tell
+// which rule or constraint blocks the move.
+func (f *RegionFit) ReplaceReason(srcStoreID uint64, dstStore *core.StoreInfo, region *core.RegionInfo) (bool, string) {
+	var rf *RuleFit
+	if peer := region.GetStorePeer(srcStoreID); peer != nil {
+		rf = f.GetRuleFit(peer.GetId())
+	}
+	if rf == nil {
+		return false, ReplaceNoRule
+	}
+	if !matchRuleStore(rf.Rule, dstStore) {
+		return false, ReplaceLabelConstraint
+	}
+	if dstStore.GetCapacity() > 0 && dstStore.GetAvailable() < uint64(region.GetApproximateSize())<<20 {
+		return false, ReplaceCapacity
+	}
+	peers := make([]*fitPeer, 0, len(rf.Peers))
+	for _, p := range rf.Peers {
+		store := dstStore
+		if p.GetStoreId() != srcStoreID {
+			store = getStoreByID(f.regionStores, p.GetStoreId())
+		}
+		peers = append(peers, f.newFitPeer(p, store))
+	}
+	// The levels are compared instead of the scores, which may lose precision.
+	// They differ in length only if the rule fit scores no isolation.
+	if levels := isolationLevels(peers, rf.Rule.isolationLabels()); len(levels) == len(rf.isolationLevels) &&
+		compareLevels(levels, rf.isolationLevels) < 0 {
+		return false, ReplaceIsolationDrop
+	}
+	return true, ""
+}
+
+// BestReplacementBalanced returns the least loaded candidate store which can
+// replace the peer on the source store, that is, the replacement passes
+// ReplaceReason so that the isolation does not decrease. It returns the load of
+// the store as well, or nil if no candidate fits.
+func (f *RegionFit) BestReplacementBalanced(srcStoreID uint64, candidates []*core.StoreInfo, region *core.RegionInfo, loadOf func(uint64) float64) (*core.StoreInfo, float64) {
+	var (
+		best     *core.StoreInfo
+		bestLoad float64
+	)
+	for _, store := range candidates {
+		if store == nil || region.GetStorePeer(store.GetID()) != nil {
+			continue
+		}
+		if ok, _ := f.ReplaceReason(srcStoreID, store, region); !ok {
+			continue
+		}
+		if load := loadOf(store.GetID()); best == nil || load < bestLoad {
+			best, bestLoad = store, load
+		}
+	}
+	return best, bestLoad
+}
+
+// BestAddTarget returns the candidate store which maximizes the isolation score
+// of the rule at ruleIndex once a peer is added on it. The stores not matching
+// the rule or already hosting a peer of the region are skipped, and the former
+// one wins a tie. It returns nil if the rule is already full or no candidate
+// fits.
+func (f *RegionFit) BestAddTarget(ruleIndex int, candidates []*core.StoreInfo, region *core.RegionInfo) *core.StoreInfo {
+	if ruleIndex < 0 || ruleIndex >= len(f.RuleFits) {
+		return nil
+	}
+	rf := f.RuleFits[ruleIndex]
+	if len(rf.Peers) >= rf.Rule.Count {
+		return nil
+	}
+	peers := make([]*fitPeer, 0, len(rf.Peers)+1)
+	for _, p := range rf.Peers {
+		peers = append(peers, f.newFitPeer(p, getStoreByID(f.regionStores, p.GetStoreId())))
+	}
+	var (
+		best      *core.StoreInfo
+		bestScore float64
+	)
+	for _, store := range candidates {
+		if store == nil || region.GetStorePeer(store.GetID()) != nil || !matchRuleStore(rf.Rule, store) {
+			continue
+		}
+		candidate := f.newFitPeer(&metapb.Peer{StoreId: store.GetID()}, store)
+		score := isolationScore(append(peers, candidate), rf.Rule.isolationLabels())
+		if best == nil || score > bestScore {
+			best, bestScore = store, score
+		}
+	}
+	return best
+}
+
+// CandidateScore is the isolation gained by adding a peer on the store.
+type CandidateScore struct {
+	StoreID uint64  `json:"store_id"`
+	Score   float64 `json:"score"`
+}
+
+// RankCandidates returns all stores matching the constraints of the rule and
+// not hosting a peer of the region, ranked by the isolation each of them adds
+// to the peers fitted to the rule, the store with the lower ID goes first on a
+// tie. Unlike BestAddTarget, the alternatives are kept, so that the options of
+// placement can be visualized.
+func RankCandidates(stores []*core.StoreInfo, region *core.RegionInfo, rule *Rule) []CandidateScore {
+	fit := fitRegion(stores, region, []*Rule{rule})
+	peers := make([]*fitPeer, 0, len(fit.RuleFits[0].Peers)+1)
+	for _, p := range fit.RuleFits[0].Peers {
+		peers = append(peers, &fitPeer{Peer: p, store: getStoreByID(stores, p.GetStoreId())})
+	}
+	labels := rule.isolationLabels()
+	var res []CandidateScore
+	for _, store := range stores {
+		if store == nil || region.GetStorePeer(store.GetID()) != nil || !matchRuleStore(rule, store) {
+			continue
+		}
+		candidate := &fitPeer{Peer: &metapb.Peer{StoreId: store.GetID()}, store: store}
+		res = append(res, CandidateScore{StoreID: store.GetID(), Score: isolationGain(peers, candidate, labels)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].StoreID < res[j].StoreID
+	})
+	return res
+}
+
+// BestPossibleIsolation returns the max isolation score the rule can achieve
+// with the given stores, regardless of where the peers of the region are now,
+// so that comparing it with the current score tells whether a rebalance could
+// help. The stores hosting the peers of the region are candidates as long as
+// they match the rule, while the other stores must not be removing to host a
+// new peer. The peers are picked greedily by the isolation they add, which is
+// exact rather than a lower bound: the locations nest, and the score only
+// loses as more peers share a location, so a peer picked earlier never needs to
+// be swapped out for a better total.
+func BestPossibleIsolation(stores []*core.StoreInfo, region *core.RegionInfo, rule *Rule) float64 {
+	var candidates []*fitPeer
+	for _, store := range stores {
+		if store == nil || !matchRuleStore(rule, store) {
+			continue
+		}
+		if region.GetStorePeer(store.GetID()) == nil && (store.IsRemoving() || store.IsRemoved()) {
+			continue
+		}
+		candidates = append(candidates, &fitPeer{Peer: &metapb.Peer{StoreId: store.GetID()}, store: store})
+	}
+	labels := rule.isolationLabels()
+	peers := make([]*fitPeer, 0, rule.Count)
+	for len(peers) < rule.Count && len(candidates) > 0 {
+		best, bestGain := 0, -1.0
+		for i, candidate := range candidates {
+			if gain := isolationGain(peers, candidate, labels); gain > bestGain {
+				best, bestGain = i, gain
+			}
+		}
+		peers = append(peers, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+	return isolationScore(peers, labels)
+}
+
+// isolationGain returns how much the isolation score of the peers increases
+// once the candidate is added.
+func isolationGain(peers []*fitPeer, candidate *fitPeer, labels []string) float64 {
+	return isolationScore(append(peers[:len(peers):len(peers)], candidate), labels) - isolationScore(peers, labels)
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/core"
+)
+
+func TestReplaceReason(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rules := []*Rule{makeRule("3/voter/zone=zone1+zone2+zone3/zone")}
+	region := makeRegion("1111_leader,2111,3111,4111").Clone(core.SetApproximateSize(10))
+	fit := fitRegion(stores.GetStores(), region, rules)
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+
+	ok, reason := fit.ReplaceReason(2111, stores.GetStore(2211), region)
+	re.True(ok)
+	re.Empty(reason)
+	// The source store hosts no peer or an orphan peer.
+	_, reason = fit.ReplaceReason(5111, stores.GetStore(2211), region)
+	re.Equal(ReplaceNoRule, reason)
+	_, reason = fit.ReplaceReason(4111, stores.GetStore(2211), region)
+	re.Equal(ReplaceNoRule, reason)
+	// The store in zone4 does not match the label constraints.
+	_, reason = fit.ReplaceReason(2111, stores.GetStore(4211), region)
+	re.Equal(ReplaceLabelConstraint, reason)
+	// Moving the peer into zone1 makes 2 peers share the zone.
+	_, reason = fit.ReplaceReason(2111, stores.GetStore(1211), region)
+	re.Equal(ReplaceIsolationDrop, reason)
+	// The store has not enough space for the region.
+	full := stores.GetStore(2211).Clone(core.SetNewStoreStats(&pdpb.StoreStats{Capacity: 100 << 20, Available: 5 << 20}))
+	ok, reason = fit.ReplaceReason(2111, full, region)
+	re.False(ok)
+	re.Equal(ReplaceCapacity, reason)
+}
+
+func TestBestReplacementBalanced(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rules := []*Rule{makeRule("3/voter//zone")}
+	region := makeRegion("1111_leader,2111,3111")
+	fit := fitRegion(stores.GetStores(), region, rules)
+	loads := map[uint64]float64{4111: 10, 5111: 5, 2211: 1, 3111: 0}
+	loadOf := func(id uint64) float64 { return loads[id] }
+
+	// The stores in zone4 and zone5 keep the isolation, and the lighter one
+	// wins. The store in zone2 is the lightest but drops the isolation, and the
+	// store 3111 already hosts a peer.
+	candidates := []*core.StoreInfo{stores.GetStore(4111), stores.GetStore(5111), stores.GetStore(2211), stores.GetStore(3111)}
+	store, load := fit.BestReplacementBalanced(1111, candidates, region, loadOf)
+	re.Equal(uint64(5111), store.GetID())
+	re.Equal(5.0, load)
+	loads[4111] = 1
+	store, load = fit.BestReplacementBalanced(1111, candidates, region, loadOf)
+	re.Equal(uint64(4111), store.GetID())
+	re.Equal(1.0, load)
+
+	store, _ = fit.BestReplacementBalanced(1111, candidates[2:], region, loadOf)
+	re.Nil(store)
+}
+
+func TestBestAddTarget(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores()
+	rules := []*Rule{makeRule("3/voter//zone,rack"), makeRule("1/learner/zone=zone5/")}
+	region := makeRegion("1111_leader,1211")
+	fit := fitRegion(stores.GetStores(), region, rules)
+	re.Len(fit.RuleFits[0].Peers, 2)
+
+	// The store in another zone isolates the peers better than the store in
+	// another rack of the same zone.
+	candidates := []*core.StoreInfo{stores.GetStore(1311), stores.GetStore(2111)}
+	re.Equal(uint64(2111), fit.BestAddTarget(0, candidates, region).GetID())
+	re.Equal(uint64(1311), fit.BestAddTarget(0, candidates[:1], region).GetID())
+	// The store hosting a peer of the region can not be added.
+	re.Nil(fit.BestAddTarget(0, []*core.StoreInfo{stores.GetStore(1211)}, region))
+	// The candidates do not match the constraints of the learner rule.
+	re.Nil(fit.BestAddTarget(1, candidates, region))
+	re.Equal(uint64(5111), fit.BestAddTarget(1, append(candidates, stores.GetStore(5111)), region).GetID())
+	re.Nil(fit.BestAddTarget(2, candidates, region))
+
+	// The rule is already full.
+	region = makeRegion("1111_leader,1211,2111")
+	fit = fitRegion(stores.GetStores(), region, rules)
+	re.Nil(fit.BestAddTarget(0, []*core.StoreInfo{stores.GetStore(3111)}, region))
+}
+
+func TestRankCandidates(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rule := makeRule("3/voter/zone=zone1+zone2/zone,rack")
+	region := makeRegion("1111_leader,1211")
+
+	ranking := RankCandidates(stores, region, rule)
+	// The stores of zone1 and zone2 match the rule, except the 2 hosting peers.
+	re.Len(ranking, 248)
+	for i := 1; i < len(ranking); i++ {
+		re.GreaterOrEqual(ranking[i-1].Score, ranking[i].Score)
+	}
+	// The stores of zone2 isolate the peers at the zone level, and the stores
+	// in a third rack of zone1 come next.
+	re.Equal(CandidateScore{StoreID: 2111, Score: 200}, ranking[0])
+	re.Equal(CandidateScore{StoreID: 1311, Score: 2}, ranking[125])
+	re.Equal(CandidateScore{StoreID: 1112, Score: 1}, ranking[200])
+	for _, c := range ranking {
+		re.NotEqual(uint64(3111), c.StoreID)
+		re.NotEqual(uint64(1111), c.StoreID)
+	}
+}
+
+func TestBestPossibleIsolation(t *testing.T) {
+	re := require.New(t)
+	stores := makeStores().GetStores()
+	rule := makeRule("3/voter//zone,rack,host")
+	region := makeRegion("1111_leader,1211,2111")
+
+	// Two peers share zone1, while each could be in a zone of its own.
+	fit := fitRegion(stores, region, []*Rule{rule})
+	re.Equal(20100.0, fit.RuleFits[0].IsolationScore)
+	re.Equal(30000.0, BestPossibleIsolation(stores, region, rule))
+	re.Equal(30000.0, BestPossibleIsolation(stores, makeRegion("1111_leader"), rule))
+
+	// The peers are limited to zone1, where they can be in different racks.
+	rule = makeRule("3/voter/zone=zone1/zone,rack,host")
+	region = makeRegion("1111_leader,1121,1211")
+	fit = fitRegion(stores, region, []*Rule{rule})
+	re.Equal(201.0, fit.RuleFits[0].IsolationScore)
+	re.Equal(300.0, BestPossibleIsolation(stores, region, rule))
+
+	// The current placement is the best one when all candidates share a host.
+	rule = makeRule("3/voter/zone=zone1,rack=rack1,host=host1/zone,rack,host")
+	region = makeRegion("1111_leader,1112,1113")
+	re.Equal(0.0, BestPossibleIsolation(stores, region, rule))
+}
+
+func TestBestPossibleIsolationIsExact(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	var stores []*core.StoreInfo
+	for _, id := range []uint64{1111, 1112, 1121, 1211, 2111, 2112, 2211, 3111, 3112} {
+		stores = append(stores, all.GetStore(id))
+	}
+	for count := 2; count <= 6; count++ {
+		rule := makeRule(strconv.Itoa(count) + "/voter//zone,rack,host")
+		// Enumerate all placements of the peers for the max.
+		var (
+			best float64
+			enum func(candidates, selected []*fitPeer)
+		)
+		enum = func(candidates, selected []*fitPeer) {
+			if len(selected) == count {
+				if score := isolationScore(selected, rule.isolationLabels()); score > best {
+					best = score
+				}
+				return
+			}
+			for i, p := range candidates {
+				enum(candidates[i+1:], append(selected[:len(selected):len(selected)], p))
+			}
+		}
+		candidates := make([]*fitPeer, 0, len(stores))
+		for _, store := range stores {
+			candidates = append(candidates, &fitPeer{Peer: &metapb.Peer{StoreId: store.GetID()}, store: store})
+		}
+		enum(candidates, nil)
+		re.Equal(best, BestPossibleIsolation(stores, makeRegion("1111_leader"), rule), "count %d", count)
+	}
+}
@@ -34,15 +34,31 @@ const (
 	Follower PeerRoleType = "follower"
 	// Learner matches a learner.
 	Learner PeerRoleType = "learner"
+	// Replica matches any peer, either a voter or a learner.
+	Replica PeerRoleType = "replica"
+	// ReadReplica matches a learner serving follower reads. Different from
+	// Learner, it is never expected to be promoted to a voter.
+	ReadReplica PeerRoleType = "read-replica"
+	// Witness is the role of the peers without the data, which only vote. It
+	// is only used to weight the peers by the RoleWeights of a rule.
+	Witness PeerRoleType = "witness"
 )
 
 func validateRole(s PeerRoleType) bool {
-	return s == Voter || s == Leader || s == Follower || s == Learner
+	return s == Voter || s == Leader || s == Follower || s == Learner || s == Replica || s == ReadReplica
+}
+
+// IsNonVoting returns whether the peers of the role never participate in the
+// quorum, so that they are never promoted and the voters are demoted.
+func (s PeerRoleType) IsNonVoting() bool {
+	return s == Learner || s == ReadReplica
 }
 
 // MetaPeerRole converts placement.PeerRoleType to metapb.PeerRole.
+// A missing peer of a Replica rule is added as a learner, which does not
+// change the quorum.
 func (s PeerRoleType) MetaPeerRole() metapb.PeerRole {
-	if s == Learner {
+	if s.IsNonVoting() || s == Replica {
 		return metapb.PeerRole_Learner
 	}
 	return metapb.PeerRole_Voter
@@ -54,22 +70,33 @@ func (s PeerRoleType) MetaPeerRole() metapb.PeerRole {
 //
 // NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
 type Rule struct {
-	GroupID          string            `json:"group_id"`                    // mark the source that add the rule
-	ID               string            `json:"id"`                          // unique ID within a group
-	Index            int               `json:"index,omitempty"`             // rule apply order in a group, rule with less ID is applied first when indexes are equal
-	Override         bool              `json:"override,omitempty"`          // when it is true, all rules with less indexes are disabled
-	StartKey         []byte            `json:"-"`                           // range start key
-	StartKeyHex      string            `json:"start_key"`                   // hex format start key, for marshal/unmarshal
-	EndKey           []byte            `json:"-"`                           // range end key
-	EndKeyHex        string            `json:"end_key"`                     // hex format end key, for marshal/unmarshal
-	Role             PeerRoleType      `json:"role"`                        // expected role of the peers
-	Count            int               `json:"count"`                       // expected count of the peers
-	LabelConstraints []LabelConstraint `json:"label_constraints,omitempty"` // used to select stores to place peers
-	LocationLabels   []string          `json:"location_labels,omitempty"`   // used to make peers isolated physically
-	IsolationLevel   string            `json:"isolation_level,omitempty"`   // used to isolate replicas explicitly and forcibly
-	Version          uint64            `json:"version,omitempty"`           // only set at runtime, add 1 each time rules updated, begin from 0.
-	CreateTimestamp  uint64            `json:"create_timestamp,omitempty"`  // only set at runtime, recorded rule create timestamp
-	group            *RuleGroup        // only set at runtime, no need to {,un}marshal or persist.
+	GroupID                   string                   `json:"group_id"`                              // mark the source that add the rule
+	ID                        string                   `json:"id"`                                    // unique ID within a group
+	Index                     int                      `json:"index,omitempty"`                       // rule apply order in a group, rule with less ID is applied first when indexes are equal
+	Override                  bool                     `json:"override,omitempty"`                    // when it is true, all rules with less indexes are disabled
+	StartKey                  []byte                   `json:"-"`                                     // range start key
+	StartKeyHex               string                   `json:"start_key"`                             // hex format start key, for marshal/unmarshal
+	EndKey                    []byte                   `json:"-"`                                     // range end key
+	EndKeyHex                 string                   `json:"end_key"`                               // hex format end key, for marshal/unmarshal
+	Role                      PeerRoleType             `json:"role"`                                  // expected role of the peers
+	Count                     int                      `json:"count"`                                 // expected count of the peers
+	MinHealthy                int                      `json:"min_healthy,omitempty"`                 // minimal count of the peers that are neither down nor pending
+	LabelConstraints          []LabelConstraint        `json:"label_constraints,omitempty"`           // used to select stores to place peers
+	ForbiddenLabelConstraints []LabelConstraint        `json:"forbidden_label_constraints,omitempty"` // used to exclude stores from placing peers even if they match LabelConstraints
+	StoreID                   uint64                   `json:"store_id,omitempty"`                    // used to pin peers to a specific store instead of selecting by label constraints
+	LocationLabels            []string                 `json:"location_labels,omitempty"`             // used to make peers isolated physically
+	LabelWeights              map[string]int           `json:"label_weights,omitempty"`               // used to override the significance of location labels when scoring isolation
+	RoleWeights               map[PeerRoleType]float64 `json:"role_weights,omitempty"`                // used to count the peers of the roles as a fraction of a replica toward Count
+	IsolationLevel            string                   `json:"isolation_level,omitempty"`             // used to isolate replicas explicitly and forcibly
+	MaxSameDeepestLabel       int                      `json:"max_same_deepest_label,omitempty"`      // used to limit the count of peers sharing the location of the deepest location label
+	OnLabelConstraints        []LabelConstraint        `json:"on_label_constraints,omitempty"`        // used to select the stores counted by MinOnConstraint
+	MinOnConstraint           int                      `json:"min_on_constraint,omitempty"`           // minimal count of the peers on the stores matching OnLabelConstraints
+	Affinity                  *RuleAffinity            `json:"affinity,omitempty"`                    // used to co-locate peers with the peers of another rule
+	NetworkCost               *NetworkCost             `json:"network_cost,omitempty"`                // used to prefer the placements with less cross-location traffic
+	TierRequirement           *TierRequirement         `json:"tier_requirement,omitempty"`            // used to report whether the peers are on the required capacity tiers
+	Version                   uint64                   `json:"version,omitempty"`                     // only set at runtime, add 1 each time rules updated, begin from 0.
+	CreateTimestamp           uint64                   `json:"create_timestamp,omitempty"`            // only set at runtime, recorded rule create timestamp
+	group                     *RuleGroup               // only set at runtime, no need to {,un}marshal or persist.
 }
 
 func (r *Rule) String() string {
@@ -96,6 +123,20 @@ func (r *Rule) StoreKey() string {
 	return hex.EncodeToString([]byte(r.GroupID)) + "-" + hex.EncodeToString([]byte(r.ID))
 }
 
+// isolationLabels returns the location labels ordered by significance for
+// scoring isolation. Labels with larger weights are more significant, and
+// labels with the same weight keep the order in LocationLabels.
+func (r *Rule) isolationLabels() []string {
+	if len(r.LabelWeights) == 0 {
+		return r.LocationLabels
+	}
+	labels := append(r.LocationLabels[:0:0], r.LocationLabels...)
+	sort.SliceStable(labels, func(i, j int) bool {
+		return r.LabelWeights[labels[i]] > r.LabelWeights[labels[j]]
+	})
+	return labels
+}
+
 func (r *Rule) groupIndex() int {
 	if r.group != nil {
 		return r.group.Index
@@ -103,16 +144,68 @@ func (r *Rule) groupIndex() int {
 	return 0
 }
 
+func (r *Rule) groupCount() int {
+	if r.group != nil {
+		return r.group.Count
+	}
+	return 0
+}
+
+// isWeighted checks if the Count of the rule is met by the peers weighted by
+// the RoleWeights. The rules of a group with group-level count are not.
+func (r *Rule) isWeighted() bool {
+	return len(r.RoleWeights) > 0 && r.groupCount() <= 0
+}
+
+// RuleAffinity declares that the peers selected by a rule should be placed
+// together with the peers selected by another rule of the same group, that is,
+// they should share the same value of the given label. An anti-affinity
+// declares the opposite, that no peer should share the value.
+// NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
+type RuleAffinity struct {
+	RuleID   string `json:"rule_id"`            // ID of the paired rule in the same group
+	LabelKey string `json:"label_key"`          // the label whose value should be shared
+	Required bool   `json:"required,omitempty"` // when it is true, the rule is not satisfied if the affinity is broken
+	Anti     bool   `json:"anti,omitempty"`     // when it is true, the label value should not be shared
+}
+
+// NetworkCost is the cost model of the traffic between the locations, such as
+// zones. The cost between the leader and each peer is summed up, or the cost
+// between each pair of peers if the rule has no leader. It is subtracted from
+// the isolation score after multiplied by the weight, so it balances the
+// spread of the peers against the cost of the traffic.
+// NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
+type NetworkCost struct {
+	LabelKey string                        `json:"label_key"` // the label identifying the locations
+	Costs    map[string]map[string]float64 `json:"costs"`     // the cost between two label values, the same value costs nothing
+	Weight   float64                       `json:"weight"`    // the weight of the cost against the isolation score
+}
+
+// TierRequirement is the capacity tiers required for the peers of a rule, e.g.
+// the leader on the gold tier. Unlike the label constraints, it does not
+// affect the fit, and whether it is met is only reported by the TierCompliant
+// of the RuleFit.
+// NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
+type TierRequirement struct {
+	LabelKey   string   `json:"label_key"`             // the label marking the tiers of the stores
+	Tiers      []string `json:"tiers"`                 // the tiers meeting the requirement
+	LeaderOnly bool     `json:"leader_only,omitempty"` // when it is true, only the leader is required to be on the tiers
+}
+
 // RuleGroup defines properties of a rule group.
 // NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
 type RuleGroup struct {
 	ID       string `json:"id,omitempty"`
 	Index    int    `json:"index,omitempty"`
 	Override bool   `json:"override,omitempty"`
+	// Count is the expected total count of peers of all rules in the group.
+	// When it is set, the Count of each rule is only an upper bound, so the
+	// peers can be distributed flexibly among the rules.
+	Count int `json:"count,omitempty"`
 }
 
 func (g *RuleGroup) isDefault() bool {
-	return g.Index == 0 && !g.Override
+	return g.Index == 0 && !g.Override && g.Count == 0
 }
 
 func (g *RuleGroup) String() string {
@@ -185,6 +278,7 @@ type GroupBundle struct {
 	ID       string  `json:"group_id"`
 	Index    int     `json:"group_index"`
 	Override bool    `json:"group_override"`
+	Count    int     `json:"group_count,omitempty"`
 	Rules    []*Rule `json:"rules"`
 }
 
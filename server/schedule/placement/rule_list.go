@@ -24,25 +24,78 @@ import (
 	"github.com/tikv/pd/server/schedule/rangelist"
 )
 
+var (
+	errMultipleLeaders    = errors.New("multiple leader replicas")
+	errNoVoter            = errors.New("needs at least one leader or voter")
+	errMultiplePinned     = errors.New("multiple peers pinned to the same store")
+	errGroupCountTooLarge = errors.New("rule group count exceeds the total count of its rules")
+	errTooManyWitnesses   = errors.New("witnesses may outnumber the voters with data")
+)
+
 func checkApplyRules(rules []*Rule) error {
+	if errs := ValidateRuleSet(rules); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateRuleSet checks whether the roles and counts of the rules applied to
+// the same range can ever be satisfied together, so that an impossible rule
+// set is rejected instead of leaving orphans forever. It returns all the
+// problems found.
+func ValidateRuleSet(rules []*Rule) []error {
+	var res []error
 	// check raft constraint
 	// one and only one leader
 	leaderCount := 0
 	voterCount := 0
+	// The peers of the voter rules weighting witnesses may be witnesses, which
+	// must not outnumber the voters with data.
+	witnessCount := 0
+	dataVoterCount := 0
+	pinned := make(map[uint64]int)
+	groupCounts := make(map[*RuleGroup]int)
 	for _, rule := range rules {
 		if rule.Role == Leader {
 			leaderCount += rule.Count
 		} else if rule.Role == Voter {
 			voterCount += rule.Count
 		}
-		if leaderCount > 1 {
-			return errors.New("multiple leader replicas")
+		if _, ok := rule.RoleWeights[Witness]; ok && (rule.Role == Voter || rule.Role == Follower) {
+			witnessCount += rule.Count
+		} else if rule.Role == Leader || rule.Role == Voter || rule.Role == Follower {
+			dataVoterCount += rule.Count
+		}
+		// A store hosts at most one peer of a region.
+		if rule.StoreID != 0 {
+			pinned[rule.StoreID] += rule.Count
+		}
+		if rule.groupCount() > 0 {
+			groupCounts[rule.group] += rule.Count
 		}
 	}
+	if leaderCount > 1 {
+		res = append(res, errMultipleLeaders)
+	}
 	if (leaderCount + voterCount) < 1 {
-		return errors.New("needs at least one leader or voter")
+		res = append(res, errNoVoter)
 	}
-	return nil
+	for _, count := range pinned {
+		if count > 1 {
+			res = append(res, errMultiplePinned)
+			break
+		}
+	}
+	for g, count := range groupCounts {
+		if g.Count > count {
+			res = append(res, errGroupCountTooLarge)
+			break
+		}
+	}
+	if witnessCount > dataVoterCount {
+		res = append(res, errTooManyWitnesses)
+	}
+	return res
 }
 
 type rangeRules struct {
@@ -124,6 +177,16 @@ func (rl ruleList) getRulesByKey(key []byte) []*Rule {
 	return rl.ranges[i].rules
 }
 
+// getRulesForRange returns all the rules covering the range, and the rules
+// selected to apply to it.
+func (rl ruleList) getRulesForRange(start, end []byte) (rules, applyRules []*Rule) {
+	i, data := rl.rangeList.GetData(start, end)
+	if i < 0 || len(data) == 0 {
+		return nil, nil
+	}
+	return rl.ranges[i].rules, rl.ranges[i].applyRules
+}
+
 func (rl ruleList) getRulesForApplyRange(start, end []byte) []*Rule {
 	i, data := rl.rangeList.GetData(start, end)
 	if i < 0 || len(data) == 0 {
@@ -16,12 +16,14 @@ package placement
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -49,16 +51,33 @@ type RuleManager struct {
 	storeSetInformer core.StoreSetInformer
 	cache            *RegionRuleFitCacheManager
 	opt              *config.PersistOptions
+	refits           *refitCoalescer
+	matchCache       *storeMatchCache
+	groupStores      RegionGroupStores
+	// overrides are the rules replacing the rules applied to the regions by
+	// key range.
+	overrides map[uint64][]*Rule
+	// location is the configuration of comparing the locations of the stores
+	// in the latest fit, see locationConfig.
+	locationMu syncutil.Mutex
+	location   *locationConfig
 }
 
 // NewRuleManager creates a RuleManager instance.
 func NewRuleManager(storage endpoint.RuleStorage, storeSetInformer core.StoreSetInformer, opt *config.PersistOptions) *RuleManager {
+	var cacheSize int
+	if opt != nil {
+		cacheSize = opt.GetPlacementRulesCacheSize()
+	}
 	return &RuleManager{
 		storage:          storage,
 		storeSetInformer: storeSetInformer,
 		opt:              opt,
 		ruleConfig:       newRuleConfig(),
-		cache:            NewRegionRuleFitCacheManager(),
+		cache:            NewRegionRuleFitCacheManager(cacheSize),
+		refits:           newRefitCoalescer(),
+		matchCache:       newStoreMatchCache(),
+		overrides:        make(map[uint64][]*Rule),
 	}
 }
 
@@ -77,6 +96,9 @@ func (m *RuleManager) Initialize(maxReplica int, locationLabels []string) error
 	if err := m.loadGroups(); err != nil {
 		return err
 	}
+	if err := m.loadOverrides(); err != nil {
+		return err
+	}
 	if len(m.ruleConfig.rules) == 0 {
 		// migrate from old config.
 		defaultRule := &Rule{
@@ -204,7 +226,37 @@ func (m *RuleManager) adjustRule(r *Rule, groupID string) (err error) {
 	if r.Role == Leader && r.Count > 1 {
 		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("define multiple leaders by count %d", r.Count))
 	}
-	for _, c := range r.LabelConstraints {
+	if r.MinHealthy < 0 || r.MinHealthy > r.Count {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid min healthy %d", r.MinHealthy))
+	}
+	if r.MaxSameDeepestLabel < 0 || (r.MaxSameDeepestLabel > 0 && len(r.LocationLabels) == 0) {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid max same deepest label %d", r.MaxSameDeepestLabel))
+	}
+	if r.MinOnConstraint < 0 || r.MinOnConstraint > r.Count || (r.MinOnConstraint > 0 && len(r.OnLabelConstraints) == 0) {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid min on constraint %d", r.MinOnConstraint))
+	}
+	if c := r.NetworkCost; c != nil && (c.LabelKey == "" || c.Weight < 0) {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid network cost of label %q and weight %v", c.LabelKey, c.Weight))
+	}
+	if a := r.Affinity; a != nil && (a.LabelKey == "" || a.RuleID == r.ID) {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid affinity to rule %q of label %q", a.RuleID, a.LabelKey))
+	}
+	if t := r.TierRequirement; t != nil && (t.LabelKey == "" || len(t.Tiers) == 0) {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid tier requirement of label %q and tiers %v", t.LabelKey, t.Tiers))
+	}
+	for key, weight := range r.LabelWeights {
+		if weight < 0 || slice.NoneOf(r.LocationLabels, func(i int) bool { return r.LocationLabels[i] == key }) {
+			return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid weight %d of label %s", weight, key))
+		}
+	}
+	for role, weight := range r.RoleWeights {
+		weighted := role == Voter || role == Leader || role == Follower || role == Learner || role == Witness
+		if !weighted || weight <= 0 || weight > 1 {
+			return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid weight %v of role %s", weight, role))
+		}
+	}
+	constraints := append(r.LabelConstraints[:len(r.LabelConstraints):len(r.LabelConstraints)], r.OnLabelConstraints...)
+	for _, c := range append(constraints, r.ForbiddenLabelConstraints...) {
 		if !validateOp(c.Op) {
 			return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid op %s", c.Op))
 		}
@@ -305,12 +357,48 @@ func (m *RuleManager) GetRulesByKey(key []byte) []*Rule {
 }
 
 // GetRulesForApplyRegion returns the rules list that should be applied to a region.
+// The rule override of the region takes precedence over the rules by key range.
 func (m *RuleManager) GetRulesForApplyRegion(region *core.RegionInfo) []*Rule {
 	m.RLock()
 	defer m.RUnlock()
+	if rules, ok := m.overrides[region.GetID()]; ok {
+		return append(rules[:0:0], rules...)
+	}
 	return m.ruleList.getRulesForApplyRange(region.GetStartKey(), region.GetEndKey())
 }
 
+// AppliedRules explains where the rules applied to a region come from.
+// NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
+type AppliedRules struct {
+	// Overridden indicates the rules are set for the region by
+	// SetRuleOverride, instead of being selected by the key range.
+	Overridden bool    `json:"overridden"`
+	Rules      []*Rule `json:"rules"`
+	// Shadowed are the rules covering the key range of the region but not
+	// applied, which are replaced by the override of the region or by the
+	// rule groups with Override set.
+	Shadowed []*Rule `json:"shadowed,omitempty"`
+}
+
+// ExplainRulesForApplyRegion returns the rules applied to the region along
+// with where they come from, so that it can be told why a rule is applied or
+// not.
+func (m *RuleManager) ExplainRulesForApplyRegion(region *core.RegionInfo) *AppliedRules {
+	m.RLock()
+	defer m.RUnlock()
+	rules, applyRules := m.ruleList.getRulesForRange(region.GetStartKey(), region.GetEndKey())
+	if overrides, ok := m.overrides[region.GetID()]; ok {
+		return &AppliedRules{Overridden: true, Rules: append(overrides[:0:0], overrides...), Shadowed: rules}
+	}
+	applied := &AppliedRules{Rules: applyRules}
+	for _, rule := range rules {
+		if slice.NoneOf(applyRules, func(i int) bool { return applyRules[i] == rule }) {
+			applied.Shadowed = append(applied.Shadowed, rule)
+		}
+	}
+	return applied
+}
+
 // GetRulesForApplyRange returns the rules list that should be applied to a range.
 func (m *RuleManager) GetRulesForApplyRange(start, end []byte) []*Rule {
 	m.RLock()
@@ -322,22 +410,200 @@ func (m *RuleManager) GetRulesForApplyRange(start, end []byte) []*Rule {
 func (m *RuleManager) FitRegion(storeSet StoreSet, region *core.RegionInfo) *RegionFit {
 	regionStores := getStoresByRegion(storeSet, region)
 	rules := m.GetRulesForApplyRegion(region)
-	if m.opt.IsPlacementRulesCacheEnabled() {
+	m.RLock()
+	groupStores := m.groupStores
+	m.RUnlock()
+	var opts []fitPeerOpt
+	if groupStores != nil {
+		if stores := groupStores(region); len(stores) > 0 {
+			// The fit depends on the sibling regions, so it can not be cached.
+			opts = append(opts, groupStoresOpt(stores))
+		}
+	}
+	// The configuration is read first, which invalidates the cached fits once
+	// it changes.
+	cfg := m.fitConfig()
+	if len(opts) == 0 && m.opt.IsPlacementRulesCacheEnabled() {
 		if ok, fit := m.cache.CheckAndGetCache(region, rules, regionStores); fit != nil && ok {
 			return fit
 		}
 	}
-	fit := fitRegion(regionStores, region, rules)
+	fit := fitRegionWithMatchCache(m.matchCache, cfg, regionStores, region, rules, opts...)
 	fit.regionStores = regionStores
 	fit.rules = rules
 	return fit
 }
 
+// FitRegionWithBudget fits a region to the rules it matches within the given
+// budget instead of the configured one, and the best fit found so far is
+// returned with Truncated set once the budget is used up. The cache of fits is
+// bypassed.
+func (m *RuleManager) FitRegionWithBudget(storeSet StoreSet, region *core.RegionInfo, budget FitBudget) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	cfg := m.fitConfig()
+	cfg.budget = budget
+	fit := fitRegionWithMatchCache(m.matchCache, cfg, getStoresByRegion(storeSet, region), region, rules)
+	fit.rules = rules
+	return fit
+}
+
+// fitConfig returns the configuration of fitting the regions, read from the
+// persisted options.
+func (m *RuleManager) fitConfig() fitConfig {
+	cfg := defaultFitConfig
+	if m.opt == nil {
+		return cfg
+	}
+	cfg.budget = FitBudget{
+		MaxDuration:   m.opt.GetPlacementRulesFitMaxDuration(),
+		MaxIterations: m.opt.GetPlacementRulesFitMaxIterations(),
+	}
+	cfg.maxExactCandidates = m.opt.GetPlacementRulesFitMaxExactCandidates()
+	cfg.retryTruncatedFit = m.opt.IsPlacementRulesFitRetryEnabled()
+	cfg.busyStorePenalty = m.opt.GetPlacementRulesBusyStorePenalty()
+	cfg.preferStretchOverOrphan = m.opt.IsPlacementRulesPreferStretchOverOrphan()
+	cfg.votersOnlyIsolation = m.opt.IsPlacementRulesVotersOnlyIsolation()
+	cfg.location = m.locationConfig()
+	return cfg
+}
+
+// locationConfig returns the configuration of comparing the locations of the
+// stores, read from the persisted options. The caches are reset once it
+// changes, since they hold the results of the previous one.
+func (m *RuleManager) locationConfig() *locationConfig {
+	label, maskBits := m.opt.GetPlacementRulesSubnetLabel()
+	equivalence := m.opt.GetPlacementRulesLabelEquivalence()
+	emptyPolicy := EmptyLabelPolicy(m.opt.GetPlacementRulesEmptyLabelPolicy())
+	m.locationMu.Lock()
+	defer m.locationMu.Unlock()
+	if m.location.builtFrom(label, maskBits, equivalence, emptyPolicy) {
+		return m.location
+	}
+	m.location = newLocationConfig(label, maskBits, equivalence, emptyPolicy)
+	m.matchCache.reset()
+	m.cache.InvalidAll()
+	return m.location
+}
+
+// FitRegionWithLeaderHint fits a region to the rules it matches, preferring
+// the given store for the leader when it does not make the fit worse. The
+// result is not cached.
+func (m *RuleManager) FitRegionWithLeaderHint(storeSet StoreSet, region *core.RegionInfo, leaderStoreID uint64) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	return fitRegionWithMatchCache(m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules, leaderHintOpt(leaderStoreID))
+}
+
+// FitRegionAssumingLeader fits a region to the rules it matches as if the
+// leader is on the given store, e.g. to check a leader transfer in advance.
+// No peer is treated as leader if the region has no peer on the store. The
+// result is not cached.
+func (m *RuleManager) FitRegionAssumingLeader(storeSet StoreSet, region *core.RegionInfo, leaderStoreID uint64) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	fit := fitRegionWithMatchCache(m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules, assumeLeaderOpt(leaderStoreID))
+	fit.rules = rules
+	return fit
+}
+
+// FitRegionWithMaintenance fits a region to the rules it matches, tolerating
+// the peers on the stores in maintenance, which are reported in the
+// MaintenancePeers of the RuleFits instead. Once the maintenance is over, the
+// region should be fitted by FitRegion again. The result is not cached.
+func (m *RuleManager) FitRegionWithMaintenance(storeSet StoreSet, region *core.RegionInfo, maintenanceStores map[uint64]struct{}) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	fit := fitRegionWithMatchCache(m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules, maintenanceOpt(maintenanceStores))
+	fit.rules = rules
+	return fit
+}
+
+// FitRegionWithLocationComparator fits a region to the rules it matches, with
+// the locations of the stores compared by the given comparator to score the
+// isolation, e.g. to treat the AZ and the rack as a composite location. The
+// result is not cached.
+func (m *RuleManager) FitRegionWithLocationComparator(storeSet StoreSet, region *core.RegionInfo, compare LocationComparator) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	fit := fitRegionWithMatchCache(m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules, locationComparatorOpt(compare))
+	fit.rules = rules
+	return fit
+}
+
+// FitRegionWithStats fits a region to the rules it matches, and measures the
+// cost of the search. The cache of fits is bypassed.
+func (m *RuleManager) FitRegionWithStats(storeSet StoreSet, region *core.RegionInfo) (*RegionFit, FitStats) {
+	rules := m.GetRulesForApplyRegion(region)
+	fit, stats := fitRegionWithStats(m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules)
+	fit.rules = rules
+	return fit, stats
+}
+
+// FitRegionWithContext fits a region to the rules it matches. The search is
+// aborted once the context is done, and the best fit found so far is returned
+// with Truncated set, so that a caller on a hot path can bound the latency.
+// The cache of fits is bypassed.
+func (m *RuleManager) FitRegionWithContext(ctx context.Context, storeSet StoreSet, region *core.RegionInfo) *RegionFit {
+	rules := m.GetRulesForApplyRegion(region)
+	fit := fitRegionWithContext(ctx, m.matchCache, m.fitConfig(), getStoresByRegion(storeSet, region), region, rules)
+	fit.rules = rules
+	return fit
+}
+
 // SetRegionFitCache sets RegionFitCache
 func (m *RuleManager) SetRegionFitCache(region *core.RegionInfo, fit *RegionFit) {
 	m.cache.SetCache(region, fit)
 }
 
+// ObserveFit records the satisfied state of the fit of a region, which must be
+// fitted as it is rather than as a hypothetical variant, and notifies the
+// subscribers and the handlers if the state changes.
+func (m *RuleManager) ObserveFit(regionID uint64, fit *RegionFit) {
+	m.cache.ObserveFit(regionID, fit)
+}
+
+// SubscribeFitChanges registers a channel to receive the satisfied state
+// changes of observed fits. See RegionRuleFitCacheManager.SubscribeFitChanges.
+func (m *RuleManager) SubscribeFitChanges(ch chan<- FitChangeEvent) {
+	m.cache.SubscribeFitChanges(ch)
+}
+
+// RegisterFitTransitionHandler registers a handler called when an observed fit
+// of a region turns from satisfied to unsatisfied. See
+// RegionRuleFitCacheManager.RegisterFitTransitionHandler.
+func (m *RuleManager) RegisterFitTransitionHandler(handler FitTransitionHandler) {
+	m.cache.RegisterFitTransitionHandler(handler)
+}
+
+// SetRefitHandler registers the handler to refit the regions after the rules
+// are changed. The changes committed within the configured refit interval are
+// coalesced, so the handler is called once for them. Without an interval, the
+// handler is called with the lock of the RuleManager held, so it must not call
+// back into the RuleManager.
+func (m *RuleManager) SetRefitHandler(handler RefitHandler) {
+	m.refits.setHandler(handler)
+}
+
+// refitInterval returns the interval to coalesce the rule changes in.
+func (m *RuleManager) refitInterval() time.Duration {
+	if m.opt == nil {
+		return 0
+	}
+	return m.opt.GetPlacementRulesRefitInterval()
+}
+
+// SetRegionGroupStores sets the function to get the stores hosting the peers
+// of the sibling regions in the placement affinity group of a region. The fit
+// prefers the peers on these stores, so that the regions of a group converge
+// on overlapping stores.
+func (m *RuleManager) SetRegionGroupStores(f RegionGroupStores) {
+	m.Lock()
+	defer m.Unlock()
+	m.groupStores = f
+}
+
+// GetRuleFingerprint returns the fingerprint of the rules, which is bumped
+// once for each coalesced refit.
+func (m *RuleManager) GetRuleFingerprint() uint64 {
+	return m.refits.getFingerprint()
+}
+
 // InvalidCache invalids the cache.
 func (m *RuleManager) InvalidCache(regionID uint64) {
 	m.cache.Invalid(regionID)
@@ -356,6 +622,7 @@ func (m *RuleManager) tryCommitPatch(patch *ruleConfigPatch) error {
 	}
 
 	patch.trim()
+	keyRanges := patch.changedKeyRanges()
 
 	// save updates
 	err = m.savePatch(patch.mut)
@@ -366,6 +633,8 @@ func (m *RuleManager) tryCommitPatch(patch *ruleConfigPatch) error {
 	// update in-memory state
 	patch.commit()
 	m.ruleList = ruleList
+	m.matchCache.reset()
+	m.refits.notify(m.refitInterval(), keyRanges)
 	return nil
 }
 
@@ -505,8 +774,19 @@ func (m *RuleManager) GetRuleGroups() []*RuleGroup {
 	return groups
 }
 
+// adjustRuleGroup checks the content of a RuleGroup.
+func adjustRuleGroup(group *RuleGroup) error {
+	if group.Count < 0 {
+		return errs.ErrRuleContent.FastGenByArgs(fmt.Sprintf("invalid count %d of group %s", group.Count, group.ID))
+	}
+	return nil
+}
+
 // SetRuleGroup updates a RuleGroup.
 func (m *RuleManager) SetRuleGroup(group *RuleGroup) error {
+	if err := adjustRuleGroup(group); err != nil {
+		return err
+	}
 	m.Lock()
 	defer m.Unlock()
 	p := m.beginPatch()
@@ -542,6 +822,7 @@ func (m *RuleManager) GetAllGroupBundles() []GroupBundle {
 			ID:       g.ID,
 			Index:    g.Index,
 			Override: g.Override,
+			Count:    g.Count,
 		})
 	}
 	for _, r := range m.ruleConfig.rules {
@@ -567,7 +848,7 @@ func (m *RuleManager) GetGroupBundle(id string) (b GroupBundle) {
 	defer m.RUnlock()
 	b.ID = id
 	if g := m.ruleConfig.groups[id]; g != nil {
-		b.Index, b.Override = g.Index, g.Override
+		b.Index, b.Override, b.Count = g.Index, g.Override, g.Count
 		for _, r := range m.ruleConfig.rules {
 			if r.GroupID == id {
 				b.Rules = append(b.Rules, r)
@@ -602,11 +883,16 @@ func (m *RuleManager) SetAllGroupBundles(groups []GroupBundle, override bool) er
 		}
 	}
 	for _, g := range groups {
-		p.setGroup(&RuleGroup{
+		group := &RuleGroup{
 			ID:       g.ID,
 			Index:    g.Index,
 			Override: g.Override,
-		})
+			Count:    g.Count,
+		}
+		if err := adjustRuleGroup(group); err != nil {
+			return err
+		}
+		p.setGroup(group)
 		for _, r := range g.Rules {
 			if err := m.adjustRule(r, g.ID); err != nil {
 				return err
@@ -634,11 +920,16 @@ func (m *RuleManager) SetGroupBundle(group GroupBundle) error {
 			}
 		}
 	}
-	p.setGroup(&RuleGroup{
+	g := &RuleGroup{
 		ID:       group.ID,
 		Index:    group.Index,
 		Override: group.Override,
-	})
+		Count:    group.Count,
+	}
+	if err := adjustRuleGroup(g); err != nil {
+		return err
+	}
+	p.setGroup(g)
 	for _, r := range group.Rules {
 		if err := m.adjustRule(r, group.ID); err != nil {
 			return err
@@ -695,7 +986,7 @@ func (m *RuleManager) IsInitialized() bool {
 // in order to reduce the calculation.
 func checkRule(rule *Rule, stores []*core.StoreInfo) bool {
 	return slice.AnyOf(stores, func(idx int) bool {
-		return MatchLabelConstraints(stores[idx], rule.LabelConstraints)
+		return matchRuleStore(rule, stores[idx])
 	})
 }
 
@@ -16,11 +16,16 @@ package placement
 
 import (
 	"encoding/hex"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"github.com/tikv/pd/pkg/codec"
+	"github.com/tikv/pd/pkg/typeutil"
+	"github.com/tikv/pd/server/config"
 	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/storage"
 	"github.com/tikv/pd/server/storage/endpoint"
@@ -64,6 +69,15 @@ func TestAdjustRule(t *testing.T) {
 		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 0},
 		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: -1},
 		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, LabelConstraints: []LabelConstraint{{Op: "foo"}}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, NetworkCost: &NetworkCost{Weight: 1}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, NetworkCost: &NetworkCost{LabelKey: "zone", Weight: -1}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, RoleWeights: map[PeerRoleType]float64{Witness: 1.5}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, RoleWeights: map[PeerRoleType]float64{Replica: 0.5}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, TierRequirement: &TierRequirement{LabelKey: "tier"}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, Affinity: &RuleAffinity{RuleID: "witness"}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, Affinity: &RuleAffinity{RuleID: "id", LabelKey: "zone"}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, LocationLabels: []string{"zone", "host"}, LabelWeights: map[string]int{"zone": -1}},
+		{GroupID: "group", ID: "id", StartKeyHex: "123abc", EndKeyHex: "123abf", Role: "voter", Count: 3, LocationLabels: []string{"zone", "host"}, LabelWeights: map[string]int{"rack": 1}},
 	}
 	re.NoError(manager.adjustRule(&rules[0], "group"))
 
@@ -302,6 +316,11 @@ func TestGroupConfig(t *testing.T) {
 	re.Equal(g1, manager.GetRuleGroup("g"))
 	re.Equal([]*RuleGroup{g1, pd2}, manager.GetRuleGroups())
 
+	// the count of a group can not be negative
+	re.Error(manager.SetRuleGroup(&RuleGroup{ID: "g", Count: -1}))
+	re.Error(manager.SetGroupBundle(GroupBundle{ID: "g", Count: -1}))
+	re.Equal(g1, manager.GetRuleGroup("g"))
+
 	// update group g
 	g2 := &RuleGroup{ID: "g", Index: 2, Override: true}
 	err = manager.SetRuleGroup(g2)
@@ -417,3 +436,502 @@ func dhex(hk string) []byte {
 	}
 	return k
 }
+
+func TestValidateRuleSet(t *testing.T) {
+	re := require.New(t)
+	re.Empty(ValidateRuleSet([]*Rule{
+		{Role: Leader, Count: 1, StoreID: 1},
+		{Role: Voter, Count: 1, StoreID: 2},
+		{Role: Learner, Count: 1},
+	}))
+
+	re.Equal([]error{errMultipleLeaders}, ValidateRuleSet([]*Rule{{Role: Leader, Count: 1}, {Role: Leader, Count: 1}}))
+	re.Equal([]error{errNoVoter}, ValidateRuleSet([]*Rule{{Role: Follower, Count: 2}, {Role: ReadReplica, Count: 1}}))
+	re.Equal([]error{errMultiplePinned}, ValidateRuleSet([]*Rule{{Role: Voter, Count: 2, StoreID: 1}}))
+	re.Equal([]error{errMultiplePinned}, ValidateRuleSet([]*Rule{
+		{Role: Leader, Count: 1, StoreID: 1},
+		{Role: Learner, Count: 1, StoreID: 1},
+	}))
+	group := &RuleGroup{ID: "g", Count: 4}
+	re.Equal([]error{errGroupCountTooLarge}, ValidateRuleSet([]*Rule{
+		{GroupID: "g", Role: Voter, Count: 2, group: group},
+		{GroupID: "g", Role: Learner, Count: 1, group: group},
+	}))
+	witnesses := map[PeerRoleType]float64{Witness: 0.5}
+	re.Empty(ValidateRuleSet([]*Rule{
+		{Role: Leader, Count: 1},
+		{Role: Voter, Count: 1},
+		{Role: Follower, Count: 2, RoleWeights: witnesses},
+	}))
+	re.Equal([]error{errTooManyWitnesses}, ValidateRuleSet([]*Rule{
+		{Role: Leader, Count: 1},
+		{Role: Voter, Count: 2, RoleWeights: witnesses},
+	}))
+	// The learners are not voters.
+	re.Equal([]error{errTooManyWitnesses}, ValidateRuleSet([]*Rule{
+		{Role: Leader, Count: 1},
+		{Role: Learner, Count: 2},
+		{Role: Follower, Count: 2, RoleWeights: witnesses},
+	}))
+	// All the problems are reported.
+	re.Equal([]error{errMultipleLeaders, errMultiplePinned}, ValidateRuleSet([]*Rule{{Role: Leader, Count: 2, StoreID: 1}}))
+
+	// The rule set is validated when the rules are written.
+	_, manager := newTestManager(t)
+	err := manager.SetRule(&Rule{GroupID: "pd", ID: "pinned", Role: Learner, Count: 2, StoreID: 1})
+	re.Error(err)
+	re.Contains(err.Error(), errMultiplePinned.Error())
+}
+
+func TestSubscribeFitChanges(t *testing.T) {
+	re := require.New(t)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, config.NewTestOptions())
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := newMockStoresSet(3)
+	region := mockRegion(3, 0)
+	observe := func() bool {
+		fit := manager.FitRegion(stores, region)
+		manager.ObserveFit(region.GetID(), fit)
+		return fit.IsSatisfied()
+	}
+
+	ch := make(chan FitChangeEvent, 10)
+	manager.SubscribeFitChanges(ch)
+	re.True(observe())
+	re.True(observe())
+	re.Empty(ch)
+
+	rule := manager.GetRule("pd", "default")
+	rule.Count = 4
+	re.NoError(manager.SetRule(rule))
+	re.False(observe())
+	re.Len(ch, 1)
+	re.Equal(FitChangeEvent{RegionID: region.GetID(), OldSatisfied: true, NewSatisfied: false}, <-ch)
+
+	// Only the observed fits are tracked.
+	rule = manager.GetRule("pd", "default")
+	rule.Count = 3
+	re.NoError(manager.SetRule(rule))
+	re.True(manager.FitRegion(stores, region).IsSatisfied())
+	re.Empty(ch)
+	rule = manager.GetRule("pd", "default")
+	rule.Count = 4
+	re.NoError(manager.SetRule(rule))
+
+	rule = manager.GetRule("pd", "default")
+	rule.Count = 3
+	re.NoError(manager.SetRule(rule))
+	re.True(observe())
+	re.Len(ch, 1)
+	re.Equal(FitChangeEvent{RegionID: region.GetID(), OldSatisfied: false, NewSatisfied: true}, <-ch)
+
+	// Events are dropped rather than blocking when the channel is full.
+	full := make(chan FitChangeEvent)
+	manager.SubscribeFitChanges(full)
+	rule = manager.GetRule("pd", "default")
+	rule.Count = 4
+	re.NoError(manager.SetRule(rule))
+	re.False(observe())
+	re.Len(ch, 1)
+}
+
+func TestExplainRulesForApplyRegion(t *testing.T) {
+	re := require.New(t)
+	_, manager := newTestManager(t)
+	region := mockRegion(3, 0)
+	applied := manager.ExplainRulesForApplyRegion(region)
+	re.False(applied.Overridden)
+	re.Len(applied.Rules, 1)
+	re.Empty(applied.Shadowed)
+
+	// The rule group with Override set shadows the default rule.
+	re.NoError(manager.SetRuleGroup(&RuleGroup{ID: "g", Index: 1, Override: true}))
+	re.NoError(manager.SetRule(&Rule{GroupID: "g", ID: "r", Role: Voter, Count: 5}))
+	applied = manager.ExplainRulesForApplyRegion(region)
+	re.False(applied.Overridden)
+	re.Len(applied.Rules, 1)
+	re.Equal("r", applied.Rules[0].ID)
+	re.Len(applied.Shadowed, 1)
+	re.Equal("default", applied.Shadowed[0].ID)
+
+	// The override of the region shadows all the rules.
+	re.NoError(manager.SetRuleOverride(region.GetID(), []*Rule{{GroupID: "pd", ID: "meta", Role: Voter, Count: 3}}))
+	applied = manager.ExplainRulesForApplyRegion(region)
+	re.True(applied.Overridden)
+	re.Len(applied.Rules, 1)
+	re.Equal("meta", applied.Rules[0].ID)
+	re.Len(applied.Shadowed, 2)
+}
+
+func TestRuleOverride(t *testing.T) {
+	re := require.New(t)
+	store := storage.NewStorageWithMemoryBackend()
+	manager := NewRuleManager(store, nil, config.NewTestOptions())
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := newMockStoresSet(5)
+	region := mockRegion(3, 0)
+	other := region.Clone(core.WithNewRegionID(2))
+
+	re.Error(manager.SetRuleOverride(region.GetID(), nil))
+	re.Error(manager.SetRuleOverride(region.GetID(), []*Rule{{GroupID: "pd", ID: "meta", Role: "learner", Count: 1}}))
+	re.Nil(manager.GetRuleOverride(region.GetID()))
+
+	override := []*Rule{{GroupID: "pd", ID: "meta", Role: "voter", Count: 5}}
+	re.NoError(manager.SetRuleOverride(region.GetID(), override))
+	rules := manager.GetRulesForApplyRegion(region)
+	re.Len(rules, 1)
+	re.Equal("meta", rules[0].ID)
+	re.Equal("default", manager.GetRulesForApplyRegion(other)[0].ID)
+	re.False(manager.FitRegion(stores, region).IsSatisfied())
+	re.True(manager.FitRegion(stores, other).IsSatisfied())
+
+	// The override is persisted.
+	m2 := NewRuleManager(store, nil, config.NewTestOptions())
+	re.NoError(m2.Initialize(3, []string{"zone", "rack", "host"}))
+	re.Len(m2.GetRuleOverride(region.GetID()), 1)
+	re.Equal(5, m2.GetRuleOverride(region.GetID())[0].Count)
+
+	// The rules returned can be changed without affecting the override.
+	rules[0] = nil
+	re.Equal("meta", manager.GetRulesForApplyRegion(region)[0].ID)
+
+	re.NotEmpty(manager.matchCache.matches)
+	re.NoError(manager.DeleteRuleOverride(region.GetID()))
+	re.Nil(manager.GetRuleOverride(region.GetID()))
+	// The store matches of the replaced rules are dropped.
+	re.Empty(manager.matchCache.matches)
+	re.True(manager.FitRegion(stores, region).IsSatisfied())
+	m3 := NewRuleManager(store, nil, config.NewTestOptions())
+	re.NoError(m3.Initialize(3, []string{"zone", "rack", "host"}))
+	re.Nil(m3.GetRuleOverride(region.GetID()))
+
+	// The override is dropped with the region merged or split.
+	re.NoError(manager.SetRuleOverride(region.GetID(), override))
+	re.NoError(manager.DropRuleOverrides(other.GetID(), region.GetID()))
+	re.Nil(manager.GetRuleOverride(region.GetID()))
+	m4 := NewRuleManager(store, nil, config.NewTestOptions())
+	re.NoError(m4.Initialize(3, []string{"zone", "rack", "host"}))
+	re.Nil(m4.GetRuleOverride(region.GetID()))
+}
+
+func TestFitTransitionHandler(t *testing.T) {
+	re := require.New(t)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, config.NewTestOptions())
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := newMockStoresSet(3)
+	region := mockRegion(3, 0)
+	observe := func() bool {
+		fit := manager.FitRegion(stores, region)
+		manager.ObserveFit(region.GetID(), fit)
+		return fit.IsSatisfied()
+	}
+
+	var calls []uint64
+	manager.RegisterFitTransitionHandler(func(regionID uint64, fit *RegionFit) {
+		re.False(fit.IsSatisfied())
+		calls = append(calls, regionID)
+	})
+	// A panic in a handler does not affect the others.
+	manager.RegisterFitTransitionHandler(func(uint64, *RegionFit) { panic("handler failed") })
+	re.True(observe())
+	re.Empty(calls)
+
+	setCount := func(count int) {
+		rule := manager.GetRule("pd", "default")
+		rule.Count = count
+		re.NoError(manager.SetRule(rule))
+	}
+	setCount(4)
+	re.False(observe())
+	re.Equal([]uint64{region.GetID()}, calls)
+	// Staying unsatisfied does not fire the handler again.
+	re.False(observe())
+	re.Len(calls, 1)
+
+	// Turning satisfied does not fire the handler.
+	setCount(3)
+	re.True(observe())
+	re.Len(calls, 1)
+	setCount(4)
+	re.False(observe())
+	re.Len(calls, 2)
+}
+
+func TestCoalesceRefits(t *testing.T) {
+	re := require.New(t)
+	opts := config.NewTestOptions()
+	cfg := opts.GetReplicationConfig().Clone()
+	cfg.PlacementRulesRefitInterval = typeutil.NewDuration(time.Second)
+	opts.SetReplicationConfig(cfg)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opts)
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	var (
+		timers    []func()
+		intervals []time.Duration
+	)
+	manager.refits.afterFunc = func(d time.Duration, f func()) {
+		intervals = append(intervals, d)
+		timers = append(timers, f)
+	}
+	var (
+		refits    []uint64
+		keyRanges []core.KeyRange
+	)
+	manager.SetRefitHandler(func(fingerprint uint64, ranges []core.KeyRange) {
+		refits = append(refits, fingerprint)
+		keyRanges = ranges
+	})
+
+	for i := 0; i < 3; i++ {
+		re.NoError(manager.SetRule(&Rule{GroupID: "g", ID: fmt.Sprintf("r%d", i), StartKeyHex: fmt.Sprintf("%02d", i), EndKeyHex: fmt.Sprintf("%02d", i+1), Role: Voter, Count: 1}))
+	}
+	re.Len(timers, 1)
+	re.Equal([]time.Duration{time.Second}, intervals)
+	re.Empty(refits)
+	re.Equal(uint64(0), manager.GetRuleFingerprint())
+
+	timers[0]()
+	re.Equal([]uint64{1}, refits)
+	re.Equal(uint64(1), manager.GetRuleFingerprint())
+	re.Len(keyRanges, 3)
+	for i, r := range keyRanges {
+		re.Equal([]byte{byte(i)}, r.StartKey)
+		re.Equal([]byte{byte(i + 1)}, r.EndKey)
+	}
+
+	// A change after the refit schedules another one.
+	re.NoError(manager.DeleteRule("g", "r0"))
+	re.Len(timers, 2)
+	timers[1]()
+	re.Equal([]uint64{1, 2}, refits)
+	re.Equal([]core.KeyRange{{StartKey: []byte{0}, EndKey: []byte{1}}}, keyRanges)
+
+	// The changes are refitted at once without an interval.
+	cfg = opts.GetReplicationConfig().Clone()
+	cfg.PlacementRulesRefitInterval = typeutil.NewDuration(0)
+	opts.SetReplicationConfig(cfg)
+	re.NoError(manager.DeleteRule("g", "r1"))
+	re.Len(timers, 2)
+	re.Equal([]uint64{1, 2, 3}, refits)
+	re.Equal([]core.KeyRange{{StartKey: []byte{1}, EndKey: []byte{2}}}, keyRanges)
+}
+
+func TestStoreMatchCache(t *testing.T) {
+	re := require.New(t)
+	cache := newStoreMatchCache()
+	rule := &Rule{GroupID: "pd", ID: "default", Role: Voter, Count: 3,
+		LabelConstraints: []LabelConstraint{{Key: "zone", Op: In, Values: []string{"z1"}}}}
+	store := core.NewStoreInfoWithLabel(1, 0, map[string]string{"zone": "z1"})
+
+	re.True(cache.match(rule, store))
+	re.True(cache.match(rule, store))
+	re.Equal(1, cache.misses)
+
+	// The store is changed.
+	store = store.Clone(core.SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z2"}}))
+	re.False(cache.match(rule, store))
+	re.Equal(2, cache.misses)
+
+	// The rule is replaced.
+	rule = rule.Clone()
+	rule.LabelConstraints[0].Values = []string{"z2"}
+	re.True(cache.match(rule, store))
+	re.Equal(3, cache.misses)
+
+	cache.reset()
+	re.True(cache.match(rule, store))
+	re.Equal(4, cache.misses)
+}
+
+func TestFitRegionWithPendingChanges(t *testing.T) {
+	re := require.New(t)
+	_, manager := newTestManager(t)
+	stores := makeStores()
+	region := makeRegion("1111_leader,2111")
+	re.False(manager.FitRegionWithPendingChanges(stores, region, nil).IsSatisfied())
+
+	// A pending add-peer step makes up the missing voter.
+	addLearner := core.PendingPeerChange{AddPeer: &metapb.Peer{Id: 3111, StoreId: 3111, Role: metapb.PeerRole_Learner}}
+	fit := manager.FitRegionWithPendingChanges(stores, region, []core.PendingPeerChange{addLearner})
+	re.False(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].PeersWithDifferentRole, "3111"))
+	fit = manager.FitRegionWithPendingChanges(stores, region, []core.PendingPeerChange{addLearner, {PromotePeerID: 3111}})
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+	re.Len(region.GetPeers(), 2)
+	fit = manager.FitRegionWithPendingChanges(stores, region, []core.PendingPeerChange{addLearner, {PromotePeerID: 3111}, {DemotePeerID: 2111}})
+	re.True(checkPeerMatch(fit.RuleFits[0].PeersWithDifferentRole, "2111"))
+
+	fit = manager.FitRegionWithPendingChanges(stores, region, []core.PendingPeerChange{addLearner, {PromotePeerID: 3111}, {RemoveStoreID: 2111}})
+	re.False(fit.IsSatisfied())
+}
+
+func TestRegionGroupAffinity(t *testing.T) {
+	re := require.New(t)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, config.NewTestOptions())
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := makeStores()
+	sibling := makeRegion("1111_leader,2111,5111").Clone(core.WithNewRegionID(1))
+	region := makeRegion("1111_leader,2111,4111,5111").Clone(core.WithNewRegionID(2))
+	re.True(checkPeerMatch(manager.FitRegion(stores, region).OrphanPeers, "5111"))
+
+	// The regions are in the same group, so the peer not shared with the
+	// sibling is the orphan.
+	manager.SetRegionGroupStores(func(r *core.RegionInfo) map[uint64]struct{} {
+		if r.GetID() != region.GetID() {
+			return nil
+		}
+		stores := make(map[uint64]struct{})
+		for _, p := range sibling.GetPeers() {
+			stores[p.GetStoreId()] = struct{}{}
+		}
+		return stores
+	})
+	re.True(checkPeerMatch(manager.FitRegion(stores, region).OrphanPeers, "4111"))
+	re.True(manager.FitRegion(stores, sibling).IsSatisfied())
+}
+
+func TestRegionGroupAffinityCache(t *testing.T) {
+	re := require.New(t)
+	opts := config.NewTestOptions()
+	opts.SetPlacementRulesCacheEnabled(true)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opts)
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := newMockStoresSet(3)
+	grouped := mockRegion(3, 0).Clone(core.WithNewRegionID(1))
+	ungrouped := mockRegion(3, 0).Clone(core.WithNewRegionID(2))
+	manager.SetRegionGroupStores(func(r *core.RegionInfo) map[uint64]struct{} {
+		if r.GetID() != grouped.GetID() {
+			return nil
+		}
+		return map[uint64]struct{}{1: {}}
+	})
+	for _, region := range []*core.RegionInfo{grouped, ungrouped} {
+		fit := manager.FitRegion(stores, region)
+		re.False(fit.IsCached())
+		manager.SetRegionFitCache(region, fit)
+	}
+	// Only the fit of the region in a group depends on the sibling regions.
+	re.False(manager.FitRegion(stores, grouped).IsCached())
+	re.True(manager.FitRegion(stores, ungrouped).IsCached())
+}
+
+func TestFitBudgetConfig(t *testing.T) {
+	re := require.New(t)
+	opts := config.NewTestOptions()
+	cfg := opts.GetReplicationConfig().Clone()
+	cfg.PlacementRulesFitMaxIterations = 1
+	opts.SetReplicationConfig(cfg)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opts)
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := makeStores()
+	region := makeRegion("1111_leader,1112,2111,3111")
+
+	// The configured budget is used up after the first peer combination.
+	re.True(manager.FitRegion(stores, region).Truncated)
+	// The budget of the call takes precedence.
+	fit := manager.FitRegionWithBudget(stores, region, FitBudget{})
+	re.False(fit.Truncated)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,3111"))
+}
+
+func TestRuleManagerFitAssumingLeader(t *testing.T) {
+	re := require.New(t)
+	_, manager := newTestManager(t)
+	rule := manager.GetRule("pd", "default")
+	rule.Role, rule.Count = Follower, 2
+	re.NoError(manager.SetRule(rule))
+	leader := makeRule("1/leader/zone=zone1/")
+	leader.GroupID, leader.ID = "pd", "leader"
+	re.NoError(manager.SetRule(leader))
+	stores := makeStores()
+	region := makeRegion("1111_leader,2111,3111")
+
+	re.True(manager.FitRegion(stores, region).IsSatisfied())
+	fit := manager.FitRegionAssumingLeader(stores, region, 2111)
+	re.False(fit.IsSatisfied())
+	re.False(fit.IsCached())
+	re.True(manager.FitRegionAssumingLeader(stores, region, 1111).IsSatisfied())
+}
+
+func TestSampleSatisfiedRatio(t *testing.T) {
+	re := require.New(t)
+	_, manager := newTestManager(t)
+	stores := makeStores()
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(1)),
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(2)),
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(3)),
+		makeRegion("1111_leader,2111").Clone(core.WithNewRegionID(4)),
+	}
+	re.Equal(0.75, manager.SampleSatisfiedRatio(stores, regions))
+	re.Equal(0.75, testutil.ToFloat64(satisfiedRegionRatioGauge))
+
+	re.Equal(0.5, manager.SampleSatisfiedRatio(stores, regions[2:]))
+	re.Equal(0.5, testutil.ToFloat64(satisfiedRegionRatioGauge))
+	// The gauge is kept if no region is sampled.
+	re.Zero(manager.SampleSatisfiedRatio(stores, nil))
+	re.Equal(0.5, testutil.ToFloat64(satisfiedRegionRatioGauge))
+}
+
+func TestSampleUnsatisfiedByLabel(t *testing.T) {
+	re := require.New(t)
+	_, manager := newTestManager(t)
+	stores := makeStores()
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(1)),
+		makeRegion("1111_leader,2111").Clone(core.WithNewRegionID(2)),
+		makeRegion("1111_leader,3211").Clone(core.WithNewRegionID(3)),
+		makeRegion("2111_leader,3111").Clone(core.WithNewRegionID(4)),
+	}
+	// Regions 2, 3 and 4 lack a peer.
+	manager.SampleSatisfiedRatio(stores, regions)
+	gauge := func(label, value string) float64 {
+		return testutil.ToFloat64(unsatisfiedByLabelGauge.WithLabelValues(label, value))
+	}
+	re.Equal(2.0, gauge("zone", "zone1"))
+	re.Equal(2.0, gauge("zone", "zone2"))
+	re.Equal(2.0, gauge("zone", "zone3"))
+	re.Equal(3.0, gauge("rack", "rack1"))
+	re.Equal(1.0, gauge("rack", "rack2"))
+
+	// The gauges of the label values without unsatisfied regions are dropped.
+	manager.SampleSatisfiedRatio(stores, regions[:2])
+	re.Equal(1.0, gauge("zone", "zone1"))
+	re.Zero(gauge("zone", "zone3"))
+}
+
+func TestFitCacheEviction(t *testing.T) {
+	re := require.New(t)
+	opts := config.NewTestOptions()
+	opts.SetPlacementRulesCacheEnabled(true)
+	cfg := opts.GetReplicationConfig().Clone()
+	cfg.PlacementRulesCacheSize = 2
+	opts.SetReplicationConfig(cfg)
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opts)
+	re.NoError(manager.Initialize(3, []string{"zone", "rack", "host"}))
+	stores := newMockStoresSet(3)
+	var regions []*core.RegionInfo
+	for id := uint64(1); id <= 3; id++ {
+		regions = append(regions, mockRegion(3, 0).Clone(core.WithNewRegionID(id)))
+	}
+	cacheFit := func(region *core.RegionInfo) {
+		fit := manager.FitRegion(stores, region)
+		re.False(fit.IsCached())
+		manager.SetRegionFitCache(region, fit)
+	}
+	evictions := testutil.ToFloat64(fitCacheCounter.WithLabelValues("eviction"))
+
+	cacheFit(regions[0])
+	cacheFit(regions[1])
+	// Accessing region 1 makes region 2 the least recently used one.
+	re.True(manager.FitRegion(stores, regions[0]).IsCached())
+	cacheFit(regions[2])
+	re.Equal(evictions+1, testutil.ToFloat64(fitCacheCounter.WithLabelValues("eviction")))
+
+	// The evicted region is recomputed.
+	re.False(manager.FitRegion(stores, regions[1]).IsCached())
+	re.True(manager.FitRegion(stores, regions[0]).IsCached())
+	re.True(manager.FitRegion(stores, regions[2]).IsCached())
+}
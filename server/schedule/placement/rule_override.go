@@ -0,0 +1,152 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pingcap/log"
+	"github.com/tikv/pd/pkg/errs"
+	"go.uber.org/zap"
+)
+
+// loadOverrides loads the rule overrides of the regions from storage. The
+// overrides in bad format are dropped.
+func (m *RuleManager) loadOverrides() error {
+	var toDelete []uint64
+	err := m.storage.LoadRuleOverrides(func(k, v string) {
+		regionID, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			log.Error("invalid region id of rule override", zap.String("key", k), errs.ZapError(errs.ErrLoadRule, err))
+			return
+		}
+		var rules []*Rule
+		if err := json.Unmarshal([]byte(v), &rules); err != nil {
+			log.Error("failed to unmarshal rule override", zap.Uint64("region-id", regionID), zap.String("value", v), errs.ZapError(errs.ErrLoadRule, err))
+			toDelete = append(toDelete, regionID)
+			return
+		}
+		if err := m.adjustOverride(rules); err != nil {
+			log.Error("rule override is in bad format", zap.Uint64("region-id", regionID), zap.String("value", v), errs.ZapError(errs.ErrLoadRule, err))
+			toDelete = append(toDelete, regionID)
+			return
+		}
+		m.overrides[regionID] = rules
+	})
+	if err != nil {
+		return err
+	}
+	for _, regionID := range toDelete {
+		if err := m.storage.DeleteRuleOverride(regionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustOverride checks and adjusts the rules of an override, which should
+// make up a valid rule set on their own.
+func (m *RuleManager) adjustOverride(rules []*Rule) error {
+	if len(rules) == 0 {
+		return errs.ErrRuleContent.FastGenByArgs("override should have at least one rule")
+	}
+	for _, r := range rules {
+		if err := m.adjustRule(r, ""); err != nil {
+			return err
+		}
+	}
+	if problems := ValidateRuleSet(rules); len(problems) > 0 {
+		return errs.ErrRuleContent.FastGenByArgs(problems[0].Error())
+	}
+	return nil
+}
+
+// GetRuleOverride returns the rules overriding the placement of the region, or
+// nil if the region is not overridden.
+func (m *RuleManager) GetRuleOverride(regionID uint64) []*Rule {
+	m.RLock()
+	defer m.RUnlock()
+	rules := m.overrides[regionID]
+	if rules == nil {
+		return nil
+	}
+	res := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		res = append(res, r.Clone())
+	}
+	return res
+}
+
+// SetRuleOverride makes the rules replace the rules applied to the region by
+// key range, e.g. to pin the placement of a critical metadata region. The
+// rules are applied in the given order, and their key ranges are ignored.
+func (m *RuleManager) SetRuleOverride(regionID uint64, rules []*Rule) error {
+	if err := m.adjustOverride(rules); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if err := m.storage.SaveRuleOverride(regionID, rules); err != nil {
+		return err
+	}
+	m.overrides[regionID] = rules
+	m.cache.Invalid(regionID)
+	// The store matches of the replaced rules are keyed by their pointers.
+	m.matchCache.reset()
+	log.Info("placement rule override updated", zap.Uint64("region-id", regionID), zap.String("rules", fmt.Sprint(rules)))
+	return nil
+}
+
+// DeleteRuleOverride removes the rule override of the region, so that the
+// region is applied the rules by key range again.
+func (m *RuleManager) DeleteRuleOverride(regionID uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.storage.DeleteRuleOverride(regionID); err != nil {
+		return err
+	}
+	delete(m.overrides, regionID)
+	m.cache.Invalid(regionID)
+	m.matchCache.reset()
+	log.Info("placement rule override is removed", zap.Uint64("region-id", regionID))
+	return nil
+}
+
+// DropRuleOverrides removes the rule overrides of the regions if any. An
+// override is set for the key range of the region, so it is dropped once the
+// region is merged away or its key range is changed by a split or merge.
+func (m *RuleManager) DropRuleOverrides(regionIDs ...uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	dropped := false
+	for _, regionID := range regionIDs {
+		if _, ok := m.overrides[regionID]; !ok {
+			continue
+		}
+		if err := m.storage.DeleteRuleOverride(regionID); err != nil {
+			return err
+		}
+		delete(m.overrides, regionID)
+		m.cache.Invalid(regionID)
+		dropped = true
+		log.Info("placement rule override is dropped since the region is merged or split", zap.Uint64("region-id", regionID))
+	}
+	if dropped {
+		m.matchCache.reset()
+	}
+	return nil
+}
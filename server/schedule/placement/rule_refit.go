@@ -0,0 +1,78 @@
+package placement
+
+import (
+	"time"
+
+	"github.com/tikv/pd/pkg/syncutil"
+	"github.com/tikv/pd/server/core"
+)
+
+// RefitHandler is called to refit the regions in the key ranges of the rules
+// changed. The fingerprint identifies the version of the rules being refitted.
+type RefitHandler func(fingerprint uint64, keyRanges []core.KeyRange)
+
+// refitCoalescer coalesces the rule changes committed within an interval, so
+// that several rule edits submitted in quick succession trigger only one
+// refit.
+type refitCoalescer struct {
+	mu          syncutil.Mutex
+	handler     RefitHandler
+	pending     bool
+	keyRanges   []core.KeyRange
+	fingerprint uint64
+	// afterFunc schedules f to be called after d. It can be replaced in tests.
+	afterFunc func(d time.Duration, f func())
+}
+
+func newRefitCoalescer() *refitCoalescer {
+	return &refitCoalescer{
+		afterFunc: func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+	}
+}
+
+func (c *refitCoalescer) setHandler(handler RefitHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+func (c *refitCoalescer) getFingerprint() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fingerprint
+}
+
+// notify records a rule change affecting the key ranges. The refit is
+// scheduled with the first change of the interval, and the later changes are
+// accumulated into it. A non-positive interval refits at once.
+func (c *refitCoalescer) notify(interval time.Duration, keyRanges []core.KeyRange) {
+	c.mu.Lock()
+	if c.handler == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.keyRanges = append(c.keyRanges, keyRanges...)
+	if c.pending {
+		c.mu.Unlock()
+		return
+	}
+	c.pending = true
+	c.mu.Unlock()
+	if interval <= 0 {
+		c.fire()
+		return
+	}
+	c.afterFunc(interval, c.fire)
+}
+
+func (c *refitCoalescer) fire() {
+	c.mu.Lock()
+	c.pending = false
+	c.fingerprint++
+	handler, fingerprint, keyRanges := c.handler, c.fingerprint, c.keyRanges
+	c.keyRanges = nil
+	c.mu.Unlock()
+	if handler != nil {
+		handler(fingerprint, keyRanges)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"github.com/tikv/pd/server/core"
+)
+
+// StoreSafeToRemove checks whether the store can be removed without making any
+// rule unsatisfiable. For each region with a peer on the store, it fits the
+// region without the peer, and checks the missing peers can be placed on the
+// other stores. It returns false with the IDs of the regions which would become
+// unsatisfiable.
+func StoreSafeToRemove(storeID uint64, regions []*core.RegionInfo, stores StoreSet, rules []*Rule) (bool, []uint64) {
+	var unsatisfiable []uint64
+	for _, region := range regions {
+		if region.GetStorePeer(storeID) == nil {
+			continue
+		}
+		fit := fitRegionWithoutPeers(stores.GetStores(), region, rules, storeID)
+		if !canFillRules(fit, region, stores.GetStores(), storeID) {
+			unsatisfiable = append(unsatisfiable, region.GetID())
+		}
+	}
+	return len(unsatisfiable) == 0, unsatisfiable
+}
+
+// fitRegionWithoutPeers fits the region as if its peers on the given stores are
+// removed.
+func fitRegionWithoutPeers(stores []*core.StoreInfo, region *core.RegionInfo, rules []*Rule, storeIDs ...uint64) *RegionFit {
+	opts := make([]core.RegionCreateOption, 0, len(storeIDs))
+	for _, id := range storeIDs {
+		opts = append(opts, core.WithRemoveStorePeer(id))
+	}
+	return fitRegion(stores, region.Clone(opts...), rules)
+}
+
+// canFillRules checks whether the missing peers of every rule can be placed on
+// the stores which neither host a peer of the region nor are being removed.
+// Each store is used for at most one missing peer, so the missing peers are
+// matched to the stores with augmenting paths rather than assigned in order,
+// which may use up the only store another rule can take.
+func canFillRules(fit *RegionFit, region *core.RegionInfo, stores []*core.StoreInfo, removedStoreID uint64) bool {
+	// owners maps a store to the index of the rule fit whose missing peer it
+	// is assigned to.
+	owners := make(map[uint64]int)
+	for i, rf := range fit.RuleFits {
+		for missing := rf.Rule.Count - len(rf.Peers); missing > 0; missing-- {
+			if !assignMissingPeer(fit, region, stores, removedStoreID, i, owners, make(map[uint64]struct{})) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// assignMissingPeer finds a store for a missing peer of the i-th rule fit. A
+// store already assigned can be taken over if its owner can be moved to another
+// store not visited yet in this search.
+func assignMissingPeer(fit *RegionFit, region *core.RegionInfo, stores []*core.StoreInfo, removedStoreID uint64, i int, owners map[uint64]int, visited map[uint64]struct{}) bool {
+	rule := fit.RuleFits[i].Rule
+	for _, store := range stores {
+		id := store.GetID()
+		if _, ok := visited[id]; ok || !canFillStore(rule, region, store, removedStoreID) {
+			continue
+		}
+		visited[id] = struct{}{}
+		if owner, ok := owners[id]; !ok || assignMissingPeer(fit, region, stores, removedStoreID, owner, owners, visited) {
+			owners[id] = i
+			return true
+		}
+	}
+	return false
+}
+
+// canFillStore checks whether a missing peer of the rule can be placed on the
+// store.
+func canFillStore(rule *Rule, region *core.RegionInfo, store *core.StoreInfo, removedStoreID uint64) bool {
+	return store.GetID() != removedStoreID && !store.IsRemoving() && !store.IsRemoved() &&
+		region.GetStorePeer(store.GetID()) == nil && matchRuleStore(rule, store)
+}
+
+// unsatisfiableDrainRisk is the drain risk of a region which would become
+// unsatisfiable, so that such a region outweighs any number of refittable ones
+// in practice.
+const unsatisfiableDrainRisk = 100
+
+// StoreDrainRisk measures how hard it is to refit the regions with a peer on
+// the store once the store is removed, so that the stores being decommissioned
+// can be drained in order, the lower risk the earlier. A region refittable to
+// fewer candidate stores costs more, and an unsatisfiable one costs
+// unsatisfiableDrainRisk.
+func StoreDrainRisk(storeID uint64, regions []*core.RegionInfo, stores StoreSet, rules []*Rule) float64 {
+	var risk float64
+	for _, region := range regions {
+		if region.GetStorePeer(storeID) == nil {
+			continue
+		}
+		fit := fitRegionWithoutPeers(stores.GetStores(), region, rules, storeID)
+		if !canFillRules(fit, region, stores.GetStores(), storeID) {
+			risk += unsatisfiableDrainRisk
+			continue
+		}
+		for _, rf := range fit.RuleFits {
+			missing := rf.Rule.Count - len(rf.Peers)
+			if missing <= 0 {
+				continue
+			}
+			var candidates int
+			for _, store := range stores.GetStores() {
+				if canFillStore(rf.Rule, region, store, storeID) {
+					candidates++
+				}
+			}
+			risk += float64(missing) / float64(candidates)
+		}
+	}
+	return risk
+}
+
+// FitAfterStoreChanges fits the region against the store set after a batch of
+// planned changes, which adds the stores in addStores and removes the stores in
+// removeStoreIDs. The peers on the removed stores are dropped from the region
+// before fitting, so the result shows what the region looks like once the
+// changes are applied and before any peer is replaced.
+func FitAfterStoreChanges(region *core.RegionInfo, currentStores, addStores []*core.StoreInfo, removeStoreIDs []uint64, rules []*Rule) *RegionFit {
+	removed := make(map[uint64]struct{}, len(removeStoreIDs))
+	for _, id := range removeStoreIDs {
+		removed[id] = struct{}{}
+	}
+	stores := make([]*core.StoreInfo, 0, len(currentStores)+len(addStores))
+	for _, store := range append(currentStores[:len(currentStores):len(currentStores)], addStores...) {
+		if _, ok := removed[store.GetID()]; !ok {
+			stores = append(stores, store)
+		}
+	}
+	var storeIDs []uint64
+	for _, id := range removeStoreIDs {
+		if region.GetStorePeer(id) != nil {
+			storeIDs = append(storeIDs, id)
+		}
+	}
+	return fitRegionWithoutPeers(stores, region, rules, storeIDs...)
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/core"
+)
+
+func TestStoreSafeToRemove(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	stores := core.NewStoresInfo()
+	for _, id := range []uint64{1111, 1211, 2111, 2211, 3111} {
+		stores.SetStore(all.GetStore(id))
+	}
+	rules := []*Rule{makeRule("1/voter/zone=zone3/"), makeRule("3/voter/zone=zone1+zone2/")}
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,1211,2111,3111").Clone(core.WithNewRegionID(1)),
+		makeRegion("1111_leader,1211,2111,2211,3111").Clone(core.WithNewRegionID(2)),
+	}
+
+	// The peer on 2111 of region 1 can be moved to 2211.
+	safe, unsatisfiable := StoreSafeToRemove(2111, regions[:1], stores, rules)
+	re.True(safe)
+	re.Empty(unsatisfiable)
+	// Region 2 still has 3 peers in zone1 and zone2 without it.
+	safe, unsatisfiable = StoreSafeToRemove(2111, regions, stores, rules)
+	re.True(safe)
+	re.Empty(unsatisfiable)
+	// There is no other store in zone3.
+	safe, unsatisfiable = StoreSafeToRemove(3111, regions, stores, rules)
+	re.False(safe)
+	re.Equal([]uint64{1, 2}, unsatisfiable)
+	// The store hosts no peer.
+	safe, unsatisfiable = StoreSafeToRemove(2211, regions[:1], stores, rules)
+	re.True(safe)
+	re.Empty(unsatisfiable)
+}
+
+func TestCanFillRules(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	region := makeRegion("3111_leader")
+	fit := &RegionFit{RuleFits: []*RuleFit{
+		{Rule: makeRule("1/voter//")},
+		{Rule: makeRule("1/voter/zone=zone2/")},
+	}}
+
+	// Assigning in order would give 2111 to the first rule and leave nothing
+	// for the second one, while 1111 can take the first rule instead.
+	stores := []*core.StoreInfo{all.GetStore(2111), all.GetStore(1111)}
+	re.True(canFillRules(fit, region, stores, 0))
+	// Both rules can only use 2111.
+	re.False(canFillRules(fit, region, stores[:1], 0))
+	// The store hosting a peer or being removed can not be used.
+	re.False(canFillRules(fit, region, []*core.StoreInfo{all.GetStore(2111), all.GetStore(3111)}, 0))
+	re.False(canFillRules(fit, region, stores, 1111))
+}
+
+func TestStoreDrainRisk(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	stores := core.NewStoresInfo()
+	for _, id := range []uint64{1111, 1211, 2111, 2211, 3111} {
+		stores.SetStore(all.GetStore(id))
+	}
+	rules := []*Rule{makeRule("1/voter/zone=zone3/"), makeRule("3/voter/zone=zone1+zone2/")}
+	regions := []*core.RegionInfo{
+		makeRegion("1111_leader,1211,2111,3111").Clone(core.WithNewRegionID(1)),
+		makeRegion("1111_leader,2111,3111").Clone(core.WithNewRegionID(2)),
+	}
+
+	// The peer on 1211 of region 1 can only be moved to 2211.
+	re.Equal(1.0, StoreDrainRisk(1211, regions, stores, rules))
+	// Region 2 misses 2 peers without 2111, which can be placed on 1211 and 2211.
+	re.Equal(2.0, StoreDrainRisk(2111, regions, stores, rules))
+	// Both regions would be unsatisfiable without the only store in zone3.
+	re.Equal(2.0*unsatisfiableDrainRisk, StoreDrainRisk(3111, regions, stores, rules))
+	re.Zero(StoreDrainRisk(2211, regions, stores, rules))
+}
+
+func TestFitAfterStoreChanges(t *testing.T) {
+	re := require.New(t)
+	all := makeStores()
+	var current []*core.StoreInfo
+	for _, id := range []uint64{1111, 1211, 2111, 3111} {
+		current = append(current, all.GetStore(id))
+	}
+	rules := []*Rule{makeRule("3/voter//zone,rack,host")}
+	region := makeRegion("1111_leader,2111,3111,4111")
+
+	// The store 4111 is unknown yet, so its peer can not match the rule.
+	fit := FitAfterStoreChanges(region, current, nil, nil, rules)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.OrphanPeers, "4111"))
+
+	// Adding 4111 and removing 3111 makes the peer on 4111 replace the one on
+	// 3111.
+	fit = FitAfterStoreChanges(region, current, []*core.StoreInfo{all.GetStore(4111)}, []uint64{3111}, rules)
+	re.True(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111,2111,4111"))
+	re.Empty(fit.OrphanPeers)
+	re.Len(region.GetPeers(), 4)
+
+	// Removing the added store with others leaves the rule unsatisfied, and
+	// the store 1211 does not host any peer.
+	fit = FitAfterStoreChanges(region, current, []*core.StoreInfo{all.GetStore(4111)}, []uint64{1211, 2111, 3111, 4111}, rules)
+	re.False(fit.IsSatisfied())
+	re.True(checkPeerMatch(fit.RuleFits[0].Peers, "1111"))
+	re.Empty(fit.OrphanPeers)
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tikv/pd/server/core"
+)
+
+// subnetPseudoLabel derives the value of a location label from the subnet of
+// the store address, for the stores without the label.
+type subnetPseudoLabel struct {
+	label    string
+	maskBits int
+}
+
+// newSubnetPseudoLabel returns the pseudo label treating the stores without
+// the given location label (usually "zone") as if the label is set to the
+// subnet of their addresses, with the given CIDR mask bits. It returns nil if
+// maskBits is not positive.
+func newSubnetPseudoLabel(label string, maskBits int) *subnetPseudoLabel {
+	if label == "" || maskBits <= 0 {
+		return nil
+	}
+	return &subnetPseudoLabel{label: label, maskBits: maskBits}
+}
+
+// locationConfig is the configuration of comparing the locations of the
+// stores in fitting a region. A nil one compares them by their labels as
+// core.StoreInfo.CompareLocation does.
+type locationConfig struct {
+	pseudo      *subnetPseudoLabel
+	equivalence labelEquivalence
+	emptyPolicy EmptyLabelPolicy
+	// rawEquivalence is a copy of the configured equivalence, which tells if
+	// the configuration changes.
+	rawEquivalence map[string]map[string]string
+}
+
+// newLocationConfig returns the configuration of comparing the locations with
+// the subnet pseudo label, the label equivalence and the empty label policy.
+func newLocationConfig(subnetLabel string, subnetMaskBits int, equivalence map[string]map[string]string, emptyPolicy EmptyLabelPolicy) *locationConfig {
+	raw := make(map[string]map[string]string, len(equivalence))
+	for key, values := range equivalence {
+		raw[key] = make(map[string]string, len(values))
+		for value, to := range values {
+			raw[key][value] = to
+		}
+	}
+	return &locationConfig{
+		pseudo:         newSubnetPseudoLabel(subnetLabel, subnetMaskBits),
+		equivalence:    newLabelEquivalence(equivalence),
+		rawEquivalence: raw,
+		emptyPolicy:    emptyPolicy,
+	}
+}
+
+func (c *locationConfig) subnetPseudoLabel() *subnetPseudoLabel {
+	if c == nil {
+		return nil
+	}
+	return c.pseudo
+}
+
+func (c *locationConfig) labelEquivalence() labelEquivalence {
+	if c == nil {
+		return nil
+	}
+	return c.equivalence
+}
+
+func (c *locationConfig) emptyLabelPolicy() EmptyLabelPolicy {
+	if c == nil {
+		return EmptyLabelExcluded
+	}
+	return c.emptyPolicy
+}
+
+// isDefault checks if the locations are compared by the labels only.
+func (c *locationConfig) isDefault() bool {
+	return c.subnetPseudoLabel() == nil && c.labelEquivalence() == nil && c.emptyLabelPolicy().isDefault()
+}
+
+// builtFrom checks if the configuration is the same as the one built from the
+// given options.
+func (c *locationConfig) builtFrom(subnetLabel string, subnetMaskBits int, equivalence map[string]map[string]string, emptyPolicy EmptyLabelPolicy) bool {
+	p1, p2 := c.subnetPseudoLabel(), newSubnetPseudoLabel(subnetLabel, subnetMaskBits)
+	if p1 != p2 && (p1 == nil || p2 == nil || *p1 != *p2) {
+		return false
+	}
+	if policy := c.emptyLabelPolicy(); policy != emptyPolicy && !(policy.isDefault() && emptyPolicy.isDefault()) {
+		return false
+	}
+	if c == nil || len(c.rawEquivalence) == 0 {
+		return len(equivalence) == 0
+	}
+	return reflect.DeepEqual(c.rawEquivalence, equivalence)
+}
+
+// labelValue returns the value of the location label of the store.
+func (c *locationConfig) labelValue(store *core.StoreInfo, label string) string {
+	return locationLabelValue(store, label, c.subnetPseudoLabel())
+}
+
+// locationLabelValue returns the value of the location label of the store. It
+// falls back to the subnet pseudo label if the label is absent.
+func locationLabelValue(store *core.StoreInfo, label string, pseudo *subnetPseudoLabel) string {
+	if v := store.GetLabelValue(label); v != "" || pseudo == nil || pseudo.label != label {
+		return v
+	}
+	return subnetOf(store.GetAddress(), pseudo.maskBits)
+}
+
+// subnetOf returns the subnet of the address in CIDR notation, or an empty
+// string if the host of the address is not an IP.
+func subnetOf(address string, maskBits int) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return ""
+	}
+	bits := 8 * net.IPv6len
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 8*net.IPv4len
+	}
+	if maskBits > bits {
+		maskBits = bits
+	}
+	return ip.Mask(net.CIDRMask(maskBits, bits)).String() + "/" + strconv.Itoa(maskBits)
+}
+
+// compare is the same as core.StoreInfo.CompareLocation, except that it takes
+// the subnet pseudo label, the label equivalence and the empty label policy
+// into account.
+func (c *locationConfig) compare(s1, s2 *core.StoreInfo, labels []string) int {
+	if c.isDefault() {
+		return s1.CompareLocation(s2, labels)
+	}
+	pseudo, eq, policy := c.subnetPseudoLabel(), c.labelEquivalence(), c.emptyLabelPolicy()
+	for i, key := range labels {
+		v1, v2 := locationLabelValue(s1, key, pseudo), locationLabelValue(s2, key, pseudo)
+		v1, v2 = eq.canonical(key, v1), eq.canonical(key, v2)
+		if v1 == "" || v2 == "" {
+			if policy.differ(v1, v2) {
+				return i
+			}
+			continue
+		}
+		if !strings.EqualFold(v1, v2) {
+			return i
+		}
+	}
+	return -1
+}
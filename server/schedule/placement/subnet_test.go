@@ -0,0 +1,90 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/server/config"
+	"github.com/tikv/pd/server/core"
+	"github.com/tikv/pd/server/storage"
+)
+
+func TestSubnetOf(t *testing.T) {
+	re := require.New(t)
+	re.Equal("10.0.1.0/24", subnetOf("10.0.1.2:20160", 24))
+	re.Equal("10.0.0.0/16", subnetOf("10.0.1.2:20160", 16))
+	re.Equal("10.0.1.2/32", subnetOf("10.0.1.2", 40))
+	re.Equal("fd00::/64", subnetOf("[fd00::1]:20160", 64))
+	re.Equal("", subnetOf("tikv-0.tikv:20160", 24))
+}
+
+func TestSubnetPseudoZone(t *testing.T) {
+	re := require.New(t)
+	location := newLocationConfig("zone", 24, nil, "")
+	newStore := func(id uint64, address, host string) *fitPeer {
+		store := core.NewStoreInfo(&metapb.Store{
+			Id:      id,
+			Address: address,
+			Labels:  []*metapb.StoreLabel{{Key: "host", Value: host}},
+		})
+		return &fitPeer{Peer: &metapb.Peer{Id: id, StoreId: id}, store: store}
+	}
+	a := newStore(1, "10.0.1.1:20160", "h1")
+	b := newStore(2, "10.0.1.2:20160", "h2")
+	c := newStore(3, "10.0.2.1:20160", "h3")
+	labels := []string{"zone", "host"}
+
+	// Without the pseudo label, all stores are in the same zone.
+	re.Equal(isolationScore([]*fitPeer{a, b}, labels), isolationScore([]*fitPeer{a, c}, labels))
+
+	for _, p := range []*fitPeer{a, b, c} {
+		p.compare = location.compare
+	}
+	re.Equal([]int{0, 1}, isolationLevels([]*fitPeer{a, b}, labels))
+	re.Equal([]int{1, 0}, isolationLevels([]*fitPeer{a, c}, labels))
+	re.Greater(isolationScore([]*fitPeer{a, c}, labels), isolationScore([]*fitPeer{a, b}, labels))
+
+	// An explicit zone label takes precedence over the subnet.
+	d := newStore(4, "10.0.1.3:20160", "h4")
+	d.store = d.store.Clone(core.SetStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "host", Value: "h4"}}))
+	d.compare = location.compare
+	re.Equal([]int{1, 0}, isolationLevels([]*fitPeer{a, d}, labels))
+}
+
+func TestSubnetPseudoLabelConfig(t *testing.T) {
+	re := require.New(t)
+	opt := config.NewTestOptions()
+	manager := NewRuleManager(storage.NewStorageWithMemoryBackend(), nil, opt)
+	re.True(manager.fitConfig().location.isDefault())
+	rule := &Rule{GroupID: "pd", ID: "default", Role: Voter, Count: 3}
+	re.True(manager.matchCache.match(rule, core.NewStoreInfoWithLabel(1, 0, nil)))
+	re.Len(manager.matchCache.matches, 1)
+
+	cfg := opt.GetReplicationConfig().Clone()
+	cfg.PlacementRulesSubnetLabel = "zone"
+	cfg.PlacementRulesSubnetMaskBits = 24
+	opt.SetReplicationConfig(cfg)
+	location := manager.fitConfig().location
+	re.Equal(&subnetPseudoLabel{label: "zone", maskBits: 24}, location.pseudo)
+	// The caches are reset once the configuration changes.
+	re.Empty(manager.matchCache.matches)
+
+	re.True(manager.matchCache.match(rule, core.NewStoreInfoWithLabel(1, 0, nil)))
+	re.Same(location, manager.fitConfig().location)
+	re.Len(manager.matchCache.matches, 1)
+}
@@ -45,6 +45,46 @@ type Scheduler interface {
 	IsScheduleAllowed(cluster Cluster) bool
 }
 
+// RunIntervalLimiter is implemented by the schedulers which should not run more
+// often than an interval, no matter how frequently they are invoked.
+type RunIntervalLimiter interface {
+	GetMinRunInterval() time.Duration
+}
+
+// RuntimeStatePersister is implemented by the schedulers which keep their
+// runtime state across restarts and leader changes. The state is saved apart
+// from the config, since it changes as the scheduler runs.
+type RuntimeStatePersister interface {
+	GetName() string
+	EncodeRuntimeState() ([]byte, error)
+	DecodeRuntimeState(data []byte) error
+}
+
+// OutcomeUnsubscriber is implemented by the schedulers which subscribe to the
+// outcomes of their operators, see OperatorController.SubscribeOperatorOutcomes.
+type OutcomeUnsubscriber interface {
+	UnsubscribeOperatorOutcomes()
+}
+
+// SaveRuntimeState saves the runtime state of the scheduler to the storage.
+func SaveRuntimeState(storage endpoint.ConfigStorage, s RuntimeStatePersister) error {
+	data, err := s.EncodeRuntimeState()
+	if err != nil {
+		return err
+	}
+	return storage.SaveScheduleState(s.GetName(), data)
+}
+
+// LoadRuntimeState loads the runtime state of the scheduler from the storage.
+// It does nothing if no state is saved.
+func LoadRuntimeState(storage endpoint.ConfigStorage, s RuntimeStatePersister) error {
+	data, err := storage.LoadScheduleState(s.GetName())
+	if err != nil || data == "" {
+		return err
+	}
+	return s.DecodeRuntimeState([]byte(data))
+}
+
 // EncodeConfig encode the custom config for each scheduler.
 func EncodeConfig(v interface{}) ([]byte, error) {
 	marshaled, err := json.Marshal(v)
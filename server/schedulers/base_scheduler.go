@@ -21,8 +21,10 @@ import (
 
 	"github.com/pingcap/log"
 	"github.com/tikv/pd/pkg/errs"
+	"github.com/tikv/pd/pkg/syncutil"
 	"github.com/tikv/pd/pkg/typeutil"
 	"github.com/tikv/pd/server/schedule"
+	"github.com/tikv/pd/server/schedule/operator"
 )
 
 // options for interval of schedulers
@@ -57,9 +59,61 @@ func intervalGrow(x time.Duration, maxInterval time.Duration, typ intervalGrowth
 	return 0
 }
 
+// circuitBreaker stops a scheduler for a cooldown once a number of its
+// operators fail in a row, e.g. the target store keeps rejecting the conf
+// changes, so that the scheduler backs off instead of hammering.
+type circuitBreaker struct {
+	mu        syncutil.Mutex
+	threshold int
+	cooldown  time.Duration
+	// outcomes receives the outcomes of the operators.
+	outcomes chan schedule.OperatorOutcome
+	// subscription is the handle of the outcome subscription.
+	subscription uint64
+	// now is the clock, which can be replaced in tests.
+	now       func() time.Time
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		outcomes:  make(chan schedule.OperatorOutcome, 1024),
+		now:       time.Now,
+	}
+}
+
+// allow consumes the reported outcomes, and checks whether the scheduler can
+// run. A finished operator resets the count of failures. Only the operators
+// failing on their targets are counted, while the ones canceled for other
+// reasons, such as the region changes, are not counted either way.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		select {
+		case outcome := <-b.outcomes:
+			switch {
+			case outcome.Status == operator.SUCCESS:
+				b.failures = 0
+			case outcome.TargetFailed:
+				if b.failures++; b.failures >= b.threshold {
+					b.failures = 0
+					b.openUntil = b.now().Add(b.cooldown)
+				}
+			}
+		default:
+			return !b.now().Before(b.openUntil)
+		}
+	}
+}
+
 // BaseScheduler is a basic scheduler for all other complex scheduler
 type BaseScheduler struct {
 	OpController *schedule.OperatorController
+	breaker      *circuitBreaker
 }
 
 // NewBaseScheduler returns a basic scheduler
@@ -71,6 +125,29 @@ func (s *BaseScheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "not implements")
 }
 
+// EnableCircuitBreaker makes the scheduler back off for the cooldown once
+// threshold operators with the given description fail in a row. The outcomes
+// are fed back by the operator controller.
+func (s *BaseScheduler) EnableCircuitBreaker(desc string, threshold int, cooldown time.Duration) {
+	s.DisableCircuitBreaker()
+	s.breaker = newCircuitBreaker(threshold, cooldown)
+	s.breaker.subscription = s.OpController.SubscribeOperatorOutcomes(desc, s.breaker.outcomes)
+}
+
+// DisableCircuitBreaker stops the scheduler from backing off.
+func (s *BaseScheduler) DisableCircuitBreaker() {
+	if s.breaker != nil {
+		s.OpController.UnsubscribeOperatorOutcomes(s.breaker.subscription)
+		s.breaker = nil
+	}
+}
+
+// isCircuitOpen checks whether the scheduler is backing off due to the
+// failures of its operators.
+func (s *BaseScheduler) isCircuitOpen() bool {
+	return s.breaker != nil && !s.breaker.allow()
+}
+
 // GetMinInterval returns the minimal interval for the scheduler
 func (s *BaseScheduler) GetMinInterval() time.Duration {
 	return MinScheduleInterval
@@ -90,4 +167,14 @@ func (s *BaseScheduler) GetNextInterval(interval time.Duration) time.Duration {
 func (s *BaseScheduler) Prepare(cluster schedule.Cluster) error { return nil }
 
 // Cleanup does some cleanup work
-func (s *BaseScheduler) Cleanup(cluster schedule.Cluster) {}
+func (s *BaseScheduler) Cleanup(cluster schedule.Cluster) {
+	s.UnsubscribeOperatorOutcomes()
+}
+
+// UnsubscribeOperatorOutcomes drops the outcome subscription of the circuit
+// breaker, which stops feeding it.
+func (s *BaseScheduler) UnsubscribeOperatorOutcomes() {
+	if s.breaker != nil {
+		s.OpController.UnsubscribeOperatorOutcomes(s.breaker.subscription)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedulers
+
+import (
+	"strconv"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/pkg/cache"
+	"github.com/tikv/pd/pkg/errs"
+	"github.com/tikv/pd/pkg/syncutil"
+	"github.com/tikv/pd/server/core"
+	"github.com/tikv/pd/server/schedule"
+	"github.com/tikv/pd/server/schedule/checker"
+	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/plan"
+	"github.com/tikv/pd/server/storage/endpoint"
+)
+
+const (
+	// FixPlacementName is fix placement scheduler name.
+	FixPlacementName = "fix-placement-scheduler"
+	// FixPlacementType is fix placement scheduler type.
+	FixPlacementType = "fix-placement"
+	// defaultFixPlacementBatchSize is the default count of operators emitted
+	// in a round.
+	defaultFixPlacementBatchSize = 8
+	// fixPlacementScanLimit is the count of regions scanned in a round.
+	fixPlacementScanLimit = 128
+)
+
+func init() {
+	schedule.RegisterSliceDecoderBuilder(FixPlacementType, func(args []string) schedule.ConfigDecoder {
+		return func(v interface{}) error {
+			conf, ok := v.(*fixPlacementSchedulerConfig)
+			if !ok {
+				return errs.ErrScheduleConfigNotExist.FastGenByArgs()
+			}
+			conf.BatchSize = defaultFixPlacementBatchSize
+			if len(args) > 0 && args[0] != "" {
+				batchSize, err := strconv.Atoi(args[0])
+				if err != nil {
+					return errs.ErrStrconvParseInt.Wrap(err).FastGenWithCause()
+				}
+				if batchSize <= 0 {
+					return errs.ErrSchedulerConfig.FastGenByArgs("batch-size")
+				}
+				conf.BatchSize = batchSize
+			}
+			if len(args) > 1 && args[1] != "" {
+				minGain, err := strconv.ParseFloat(args[1], 64)
+				if err != nil {
+					return errs.ErrStrconvParseFloat.Wrap(err).FastGenWithCause()
+				}
+				if minGain < 0 {
+					return errs.ErrSchedulerConfig.FastGenByArgs("min-isolation-gain")
+				}
+				conf.MinIsolationGain = minGain
+			}
+			conf.Name = FixPlacementName
+			return nil
+		}
+	})
+
+	schedule.RegisterScheduler(FixPlacementType, func(opController *schedule.OperatorController, storage endpoint.ConfigStorage, decoder schedule.ConfigDecoder) (schedule.Scheduler, error) {
+		conf := &fixPlacementSchedulerConfig{}
+		if err := decoder(conf); err != nil {
+			return nil, err
+		}
+		return newFixPlacementScheduler(opController, conf), nil
+	})
+}
+
+type fixPlacementSchedulerConfig struct {
+	Name string `json:"name"`
+	// BatchSize is the max count of operators emitted in a round, so that the
+	// operator controller is not overwhelmed when many regions need fixing.
+	BatchSize int `json:"batch-size"`
+	// MinIsolationGain is the min gain of the isolation score to move a peer
+	// to a better location, which suppresses the churn caused by marginal
+	// gains. Zero means any gain is accepted.
+	MinIsolationGain float64 `json:"min-isolation-gain"`
+}
+
+type fixPlacementScheduler struct {
+	*BaseScheduler
+	conf *fixPlacementSchedulerConfig
+
+	mu          syncutil.Mutex
+	ruleChecker *checker.RuleChecker
+	waitingList cache.Cache
+	// cursor is the start key of the regions to scan in the next round, so
+	// that every round makes progress instead of scanning from the start.
+	cursor []byte
+	// inProgress records the operators emitted to fix the regions, so that
+	// the regions are not fixed again until the operators end.
+	inProgress map[uint64]*operator.Operator
+}
+
+// newFixPlacementScheduler creates a scheduler that fixes the regions not
+// satisfying the placement rules. Unlike the rule checker patrolling all
+// regions, it yields the operators in batches across rounds.
+func newFixPlacementScheduler(opController *schedule.OperatorController, conf *fixPlacementSchedulerConfig) schedule.Scheduler {
+	return &fixPlacementScheduler{
+		BaseScheduler: NewBaseScheduler(opController),
+		conf:          conf,
+		waitingList:   cache.NewDefaultCache(checker.DefaultCacheSize),
+		inProgress:    make(map[uint64]*operator.Operator),
+	}
+}
+
+func (s *fixPlacementScheduler) GetName() string {
+	return s.conf.Name
+}
+
+func (s *fixPlacementScheduler) GetType() string {
+	return FixPlacementType
+}
+
+func (s *fixPlacementScheduler) EncodeConfig() ([]byte, error) {
+	return schedule.EncodeConfig(s.conf)
+}
+
+func (s *fixPlacementScheduler) IsScheduleAllowed(cluster schedule.Cluster) bool {
+	allowed := s.OpController.OperatorCount(operator.OpReplica) < cluster.GetOpts().GetReplicaScheduleLimit()
+	if !allowed {
+		operator.OperatorLimitCounter.WithLabelValues(s.GetType(), operator.OpReplica.String()).Inc()
+	}
+	return allowed
+}
+
+func (s *fixPlacementScheduler) Schedule(cluster schedule.Cluster, dryRun bool) ([]*operator.Operator, []plan.Plan) {
+	schedulerCounter.WithLabelValues(s.GetName(), "schedule").Inc()
+	if !cluster.GetOpts().IsPlacementRulesEnabled() {
+		schedulerCounter.WithLabelValues(s.GetName(), "placement-rules-disabled").Inc()
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ruleChecker == nil {
+		s.ruleChecker = checker.NewRuleChecker(cluster, cluster.GetRuleManager(), s.waitingList)
+		s.ruleChecker.SetMinIsolationGain(s.conf.MinIsolationGain)
+	}
+	for id, op := range s.inProgress {
+		if op.IsEnd() {
+			delete(s.inProgress, id)
+		}
+	}
+
+	cursor := s.cursor
+	regions := cluster.ScanRegions(cursor, nil, fixPlacementScanLimit)
+	var ops []*operator.Operator
+	for _, region := range regions {
+		if len(ops) >= s.conf.BatchSize {
+			break
+		}
+		cursor = region.GetEndKey()
+		if _, ok := s.inProgress[region.GetID()]; ok || s.OpController.GetOperator(region.GetID()) != nil {
+			schedulerCounter.WithLabelValues(s.GetName(), "in-progress").Inc()
+			continue
+		}
+		var op *operator.Operator
+		if dryRun {
+			op = s.ruleChecker.Preview(region)
+		} else {
+			op = s.ruleChecker.Check(region)
+		}
+		if op == nil {
+			continue
+		}
+		if peer := correctedPeer(region, op); peer != nil {
+			if s.OpController.IsPeerClaimed(s.GetName(), region.GetID(), peer.GetId()) {
+				schedulerCounter.WithLabelValues(s.GetName(), "peer-in-flight").Inc()
+				continue
+			}
+			op.SetPeerClaim(s.GetName(), peer.GetId())
+		}
+		op.Counters = append(op.Counters, schedulerCounter.WithLabelValues(s.GetName(), "new-operator"))
+		ops = append(ops, op)
+		if !dryRun {
+			s.inProgress[region.GetID()] = op
+		}
+	}
+	if !dryRun {
+		// The scan starts from the beginning again after reaching the end.
+		if len(regions) == 0 {
+			cursor = nil
+		}
+		s.cursor = cursor
+	}
+	if len(ops) == 0 {
+		schedulerCounter.WithLabelValues(s.GetName(), "no-region").Inc()
+	}
+	return ops, nil
+}
+
+// correctedPeer returns the existing peer of the region the operator removes,
+// demotes or moves the leader away from, or nil if it only adds peers.
+func correctedPeer(region *core.RegionInfo, op *operator.Operator) *metapb.Peer {
+	for i := 0; i < op.Len(); i++ {
+		switch s := op.Step(i).(type) {
+		case operator.RemovePeer:
+			return region.GetStorePeer(s.FromStore)
+		case operator.TransferLeader:
+			return region.GetStorePeer(s.FromStore)
+		case operator.ChangePeerV2Enter:
+			// The voters are only demoted by a joint consensus.
+			if len(s.DemoteVoters) > 0 {
+				return region.GetStorePeer(s.DemoteVoters[0].ToStore)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedulers
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/tikv/pd/pkg/mock/mockcluster"
+	"github.com/tikv/pd/server/config"
+	"github.com/tikv/pd/server/schedule"
+	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/placement"
+	"github.com/tikv/pd/server/storage"
+	"github.com/tikv/pd/server/versioninfo"
+)
+
+var _ = Suite(&testFixPlacementSuite{})
+
+type testFixPlacementSuite struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	tc     *mockcluster.Cluster
+	oc     *schedule.OperatorController
+}
+
+func (s *testFixPlacementSuite) SetUpTest(c *C) {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.tc = mockcluster.NewCluster(s.ctx, config.NewTestOptions())
+	s.tc.SetClusterVersion(versioninfo.MinSupportedVersion(versioninfo.Version4_0))
+	s.tc.SetEnablePlacementRules(true)
+	s.oc = schedule.NewOperatorController(s.ctx, nil, nil)
+}
+
+func (s *testFixPlacementSuite) TearDownTest(c *C) {
+	s.cancel()
+}
+
+func (s *testFixPlacementSuite) TestScheduleInBatches(c *C) {
+	for id := uint64(1); id <= 4; id++ {
+		s.tc.AddLeaderStore(id, 1)
+	}
+	// All regions lack a peer.
+	s.tc.AddLeaderRegionWithRange(1, "", "b", 1, 2)
+	s.tc.AddLeaderRegionWithRange(2, "b", "c", 1, 2)
+	s.tc.AddLeaderRegionWithRange(3, "c", "", 1, 2)
+	sl, err := schedule.CreateScheduler(FixPlacementType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(FixPlacementType, []string{"1"}))
+	c.Assert(err, IsNil)
+	fs := sl.(*fixPlacementScheduler)
+	c.Assert(fs.IsScheduleAllowed(s.tc), IsTrue)
+
+	// The dry run does not advance the cursor.
+	ops, _ := sl.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].RegionID(), Equals, uint64(1))
+	c.Assert(fs.cursor, IsNil)
+	c.Assert(fs.inProgress, HasLen, 0)
+
+	// Each round yields one operator and advances the cursor.
+	for _, id := range []uint64{1, 2, 3} {
+		ops, _ = sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		c.Assert(ops[0].RegionID(), Equals, id)
+		c.Assert(ops[0].Desc(), Equals, "add-rule-peer")
+		c.Assert(ops[0].Reason(), DeepEquals, &operator.OpReason{RuleID: "pd/default", Violation: "short-of-peers"})
+		c.Assert(string(fs.cursor), Equals, string(s.tc.GetRegion(id).GetEndKey()))
+	}
+	c.Assert(fs.inProgress, HasLen, 3)
+
+	// The scan starts over, and the regions being fixed are not fixed again.
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+	c.Assert(fs.cursor, HasLen, 0)
+
+	// Region 1 is fixed, and the operator of region 2 is canceled.
+	s.tc.AddLeaderRegionWithRange(1, "", "b", 1, 2, 3)
+	c.Assert(fs.inProgress[1].Cancel(), IsTrue)
+	c.Assert(fs.inProgress[2].Cancel(), IsTrue)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].RegionID(), Equals, uint64(2))
+	c.Assert(fs.inProgress, HasLen, 2)
+}
+
+func (s *testFixPlacementSuite) TestClaimCorrectedPeer(c *C) {
+	for id := uint64(1); id <= 4; id++ {
+		s.tc.AddLeaderStore(id, 1)
+	}
+	// The peer on store 4 is an orphan.
+	s.tc.AddLeaderRegion(1, 1, 2, 3, 4)
+	sl, err := schedule.CreateScheduler(FixPlacementType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(FixPlacementType, nil))
+	c.Assert(err, IsNil)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].Desc(), Equals, "remove-orphan-peer")
+	c.Assert(ops[0].Reason(), DeepEquals, &operator.OpReason{Violation: "orphan-peer"})
+	claim := ops[0].PeerClaim()
+	c.Assert(claim, NotNil)
+	c.Assert(claim.Owner, Equals, sl.GetName())
+	c.Assert(claim.PeerID, Equals, s.tc.GetRegion(1).GetStorePeer(4).GetId())
+}
+
+func (s *testFixPlacementSuite) TestMinIsolationGain(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"zone": "z1", "host": "host1"})
+	s.tc.AddLabelsStore(2, 1, map[string]string{"zone": "z1", "host": "host1"})
+	s.tc.AddLabelsStore(3, 1, map[string]string{"zone": "z1", "host": "host2"})
+	s.tc.AddLabelsStore(4, 1, map[string]string{"zone": "z1", "host": "host3"})
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	c.Assert(s.tc.GetRuleManager().SetRule(&placement.Rule{
+		GroupID:        "pd",
+		ID:             "test",
+		Index:          100,
+		Override:       true,
+		Role:           placement.Voter,
+		Count:          3,
+		LocationLabels: []string{"zone", "host"},
+	}), IsNil)
+
+	_, err := schedule.CreateScheduler(FixPlacementType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(FixPlacementType, []string{"", "-1"}))
+	c.Assert(err, NotNil)
+	sl, err := schedule.CreateScheduler(FixPlacementType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(FixPlacementType, []string{"", "10"}))
+	c.Assert(err, IsNil)
+	// Moving the peer to another host gains too little.
+	ops, _ := sl.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 0)
+
+	// Without the threshold any gain is accepted.
+	other, err := schedule.CreateScheduler(FixPlacementType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(FixPlacementType, nil))
+	c.Assert(err, IsNil)
+	ops, _ = other.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].Step(0).(operator.AddLearner).ToStore, Equals, uint64(4))
+
+	// Moving the peer to another zone gains enough.
+	s.tc.AddLabelsStore(5, 1, map[string]string{"zone": "z2", "host": "host1"})
+	ops, _ = sl.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].Desc(), Equals, "move-to-better-location")
+	c.Assert(ops[0].Step(0).(operator.AddLearner).ToStore, Equals, uint64(5))
+}
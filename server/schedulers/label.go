@@ -15,15 +15,32 @@
 package schedulers
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/tikv/pd/pkg/errs"
+	"github.com/tikv/pd/pkg/reflectutil"
+	"github.com/tikv/pd/pkg/syncutil"
+	"github.com/tikv/pd/pkg/typeutil"
 	"github.com/tikv/pd/server/config"
 	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/schedule"
 	"github.com/tikv/pd/server/schedule/filter"
 	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/placement"
 	"github.com/tikv/pd/server/schedule/plan"
 	"github.com/tikv/pd/server/storage/endpoint"
+	"github.com/unrolled/render"
 	"go.uber.org/zap"
 )
 
@@ -32,6 +49,17 @@ const (
 	LabelName = "label-scheduler"
 	// LabelType is label scheduler type.
 	LabelType = "label"
+	// requiredLabelArgPrefix is the prefix of the arg that sets the label key
+	// required by the stores hosting leaders.
+	requiredLabelArgPrefix = "required-label="
+	// labelRejectLeaderDesc is the description of the operators.
+	labelRejectLeaderDesc = "label-reject-leader"
+	// deadEndRegionTTL is how long a dead end region is remembered, so that the
+	// regions which are gone or resolved without being seen again are
+	// forgotten, and the ones still stuck are logged again.
+	deadEndRegionTTL = 10 * time.Minute
+	// maxDeadEndRegions bounds the number of dead end regions remembered.
+	maxDeadEndRegions = 4096
 )
 
 func init() {
@@ -41,6 +69,29 @@ func init() {
 			if !ok {
 				return errs.ErrScheduleConfigNotExist.FastGenByArgs()
 			}
+			// A "required-label=<key>" arg makes the scheduler also move the
+			// leaders out of the stores lacking the label key.
+			rest := make([]string, 0, len(args))
+			for _, arg := range args {
+				if strings.HasPrefix(arg, requiredLabelArgPrefix) {
+					conf.RequiredLabel = strings.TrimPrefix(arg, requiredLabelArgPrefix)
+					continue
+				}
+				rest = append(rest, arg)
+			}
+			args = rest
+			// The args are pairs of key range, optionally followed by a
+			// comma-separated list of store IDs to limit the scheduler to.
+			if len(args)%2 == 1 {
+				for _, id := range strings.Split(args[len(args)-1], ",") {
+					storeID, err := strconv.ParseUint(id, 10, 64)
+					if err != nil {
+						return errs.ErrStrconvParseUint.Wrap(err).FastGenWithCause()
+					}
+					conf.StoreIDs = append(conf.StoreIDs, storeID)
+				}
+				args = args[:len(args)-1]
+			}
 			ranges, err := getKeyRanges(args)
 			if err != nil {
 				return err
@@ -56,28 +107,191 @@ func init() {
 		if err := decoder(conf); err != nil {
 			return nil, err
 		}
-		return newLabelScheduler(opController, conf), nil
+		s := newLabelScheduler(opController, storage, conf)
+		if err := schedule.LoadRuntimeState(storage, s); err != nil {
+			log.Warn("label scheduler fails to load the runtime state", errs.ZapError(err))
+		}
+		return s, nil
 	})
 }
 
 type labelSchedulerConfig struct {
+	// mu protects the fields which can be updated by the API, i.e. the
+	// intervals and the circuit breaker.
+	mu     syncutil.RWMutex
 	Name   string          `json:"name"`
 	Ranges []core.KeyRange `json:"ranges"`
+	// StoreIDs limits the stores whose leaders are moved out. All stores are
+	// considered if it is empty.
+	StoreIDs []uint64 `json:"store-ids,omitempty"`
+	// MinRunInterval limits how often the scheduler runs, so that it churns
+	// less than the balance schedulers. Zero means no limit.
+	MinRunInterval typeutil.Duration `json:"min-run-interval"`
+	// RequiredLabel is the label key which the stores must have to host
+	// leaders. The leaders on the stores lacking it are moved out as well as
+	// the ones on reject leader stores. Empty means no label is required.
+	RequiredLabel string `json:"required-label,omitempty"`
+	// RegionCooldown is the time during which a region is not scheduled again
+	// after its leader is moved out. Zero means no cooldown.
+	RegionCooldown typeutil.Duration `json:"region-cooldown"`
+	// FailureThreshold is the count of operators failing in a row which stops
+	// the scheduler for FailureCooldown, e.g. when the target stores are flaky.
+	// Zero means the scheduler never backs off.
+	FailureThreshold int               `json:"failure-threshold,omitempty"`
+	FailureCooldown  typeutil.Duration `json:"failure-cooldown,omitempty"`
+}
+
+// validate checks the fields which can be updated by the API.
+func (conf *labelSchedulerConfig) validate() bool {
+	return conf.MinRunInterval.Duration >= 0 && conf.RegionCooldown.Duration >= 0 &&
+		conf.FailureThreshold >= 0 && conf.FailureCooldown.Duration >= 0
+}
+
+// sameScope checks whether the fields which can not be updated by the API are
+// the same as the other config.
+func (conf *labelSchedulerConfig) sameScope(other *labelSchedulerConfig) bool {
+	return conf.Name == other.Name && reflect.DeepEqual(conf.Ranges, other.Ranges) &&
+		reflect.DeepEqual(conf.StoreIDs, other.StoreIDs) && conf.RequiredLabel == other.RequiredLabel
+}
+
+func (conf *labelSchedulerConfig) getRegionCooldown() time.Duration {
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	return conf.RegionCooldown.Duration
+}
+
+func (conf *labelSchedulerConfig) containsStore(storeID uint64) bool {
+	if len(conf.StoreIDs) == 0 {
+		return true
+	}
+	for _, id := range conf.StoreIDs {
+		if id == storeID {
+			return true
+		}
+	}
+	return false
+}
+
+// lacksRequiredLabel checks whether the store does not have the required label
+// key at all.
+func (conf *labelSchedulerConfig) lacksRequiredLabel(store *core.StoreInfo) bool {
+	if conf.RequiredLabel == "" {
+		return false
+	}
+	for _, l := range store.GetLabels() {
+		if l.GetKey() == conf.RequiredLabel {
+			return false
+		}
+	}
+	return true
 }
 
 type labelScheduler struct {
 	*BaseScheduler
-	conf *labelSchedulerConfig
+	conf    *labelSchedulerConfig
+	handler http.Handler
+	storage endpoint.ConfigStorage
+
+	mu syncutil.Mutex
+	// deadEndRegions records when the regions whose followers are all reject
+	// leader stores are logged, so that the condition is logged only once per
+	// region within deadEndRegionTTL.
+	deadEndRegions map[uint64]time.Time
+	// cooldowns records when the regions can be scheduled again. It is the
+	// runtime state persisted to the storage.
+	cooldowns map[uint64]time.Time
+	// proposed records the operators emitted but not known to be added yet.
+	// The cooldown of a region starts once its operator is added.
+	proposed map[uint64]*operator.Operator
+	// lingering records when the leaders are first seen on the reject leader
+	// stores, so that the longest lingering ones are moved out first.
+	lingering map[lingeringLeader]time.Time
+	// now is the clock, which can be replaced in tests.
+	now func() time.Time
+}
+
+type lingeringLeader struct {
+	regionID uint64
+	storeID  uint64
+}
+
+// labelSchedulerState is the persisted runtime state of the label scheduler.
+// The store allowlist is persisted with the config.
+type labelSchedulerState struct {
+	Cooldowns map[uint64]time.Time `json:"cooldowns,omitempty"`
 }
 
 // LabelScheduler is mainly based on the store's label information for scheduling.
 // Now only used for reject leader schedule, that will move the leader out of
-// the store with the specific label.
-func newLabelScheduler(opController *schedule.OperatorController, conf *labelSchedulerConfig) schedule.Scheduler {
-	return &labelScheduler{
-		BaseScheduler: NewBaseScheduler(opController),
-		conf:          conf,
+// the store with the specific label, or the store lacking the required label.
+func newLabelScheduler(opController *schedule.OperatorController, storage endpoint.ConfigStorage, conf *labelSchedulerConfig) *labelScheduler {
+	s := &labelScheduler{
+		BaseScheduler:  NewBaseScheduler(opController),
+		conf:           conf,
+		storage:        storage,
+		deadEndRegions: make(map[uint64]time.Time),
+		cooldowns:      make(map[uint64]time.Time),
+		proposed:       make(map[uint64]*operator.Operator),
+		lingering:      make(map[lingeringLeader]time.Time),
+		now:            time.Now,
+	}
+	s.handler = newLabelHandler(s)
+	s.resetCircuitBreakerLocked()
+	return s
+}
+
+// resetCircuitBreakerLocked rebuilds the circuit breaker from the config.
+func (s *labelScheduler) resetCircuitBreakerLocked() {
+	if s.conf.FailureThreshold > 0 {
+		s.EnableCircuitBreaker(labelRejectLeaderDesc, s.conf.FailureThreshold, s.conf.FailureCooldown.Duration)
+	} else {
+		s.DisableCircuitBreaker()
+	}
+}
+
+// updateConfig updates the intervals and the circuit breaker of the config,
+// and persists it. The other fields can not be updated.
+func (s *labelScheduler) updateConfig(data []byte) (int, interface{}) {
+	s.conf.mu.Lock()
+	defer s.conf.mu.Unlock()
+	oldc, _ := json.Marshal(s.conf)
+	conf := &labelSchedulerConfig{}
+	if err := json.Unmarshal(oldc, conf); err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	newc, _ := json.Marshal(conf)
+	if bytes.Equal(oldc, newc) {
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(data, &m); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		if reflectutil.FindSameFieldByJSON(conf, m) {
+			return http.StatusOK, "no changed"
+		}
+		return http.StatusBadRequest, "config item not found"
+	}
+	if !conf.sameScope(s.conf) {
+		return http.StatusBadRequest, "only the intervals and the circuit breaker can be updated"
 	}
+	if !conf.validate() {
+		return http.StatusBadRequest, "invalid negative interval or threshold"
+	}
+	if err := s.storage.SaveScheduleConfig(s.GetName(), newc); err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	s.conf.MinRunInterval = conf.MinRunInterval
+	s.conf.RegionCooldown = conf.RegionCooldown
+	s.conf.FailureThreshold = conf.FailureThreshold
+	s.conf.FailureCooldown = conf.FailureCooldown
+	s.resetCircuitBreakerLocked()
+	return http.StatusOK, "success"
+}
+
+func (s *labelScheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
 }
 
 func (s *labelScheduler) GetName() string {
@@ -89,10 +303,164 @@ func (s *labelScheduler) GetType() string {
 }
 
 func (s *labelScheduler) EncodeConfig() ([]byte, error) {
+	s.conf.mu.RLock()
+	defer s.conf.mu.RUnlock()
 	return schedule.EncodeConfig(s.conf)
 }
 
+func (s *labelScheduler) GetMinRunInterval() time.Duration {
+	s.conf.mu.RLock()
+	defer s.conf.mu.RUnlock()
+	return s.conf.MinRunInterval.Duration
+}
+
+// EncodeRuntimeState encodes the cooldowns which are not expired yet.
+func (s *labelScheduler) EncodeRuntimeState() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	state := labelSchedulerState{Cooldowns: make(map[uint64]time.Time, len(s.cooldowns))}
+	for id, until := range s.cooldowns {
+		if until.After(now) {
+			state.Cooldowns[id] = until
+		}
+	}
+	return schedule.EncodeConfig(state)
+}
+
+// DecodeRuntimeState restores the cooldowns.
+func (s *labelScheduler) DecodeRuntimeState(data []byte) error {
+	var state labelSchedulerState
+	if err := schedule.DecodeConfig(data, &state); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, until := range state.Cooldowns {
+		s.cooldowns[id] = until
+	}
+	return nil
+}
+
+// inCooldown checks whether the region is scheduled recently, including by an
+// operator added but not settled yet. It changes no state, so that a dry run
+// sees the same cooldowns.
+func (s *labelScheduler) inCooldown(regionID uint64) bool {
+	cooldown := s.conf.getRegionCooldown()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if until, ok := s.cooldowns[regionID]; ok && now.Before(until) {
+		return true
+	}
+	op, ok := s.proposed[regionID]
+	return ok && op.HasStarted() && now.Before(op.GetStartTime().Add(cooldown))
+}
+
+// propose records the operator emitted, whose cooldown is started once it is
+// added by the operator controller.
+func (s *labelScheduler) propose(op *operator.Operator) {
+	if s.conf.getRegionCooldown() <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proposed[op.RegionID()] = op
+}
+
+// settleProposals starts the cooldowns of the regions whose operators are
+// added, and persists them, so that the cooldowns still apply after PD
+// restarts or the leader changes. The operators never added are dropped once
+// they expire, and so are the expired cooldowns.
+func (s *labelScheduler) settleProposals() {
+	cooldown := s.conf.getRegionCooldown()
+	s.mu.Lock()
+	now := s.now()
+	for id, until := range s.cooldowns {
+		if !now.Before(until) {
+			delete(s.cooldowns, id)
+		}
+	}
+	started := false
+	for id, op := range s.proposed {
+		switch {
+		case op.HasStarted():
+			s.cooldowns[id] = op.GetStartTime().Add(cooldown)
+			started = true
+		case !op.IsEnd() && op.ElapsedTime() < operator.OperatorExpireTime:
+			continue
+		}
+		delete(s.proposed, id)
+	}
+	s.mu.Unlock()
+	if !started {
+		return
+	}
+	if err := schedule.SaveRuntimeState(s.storage, s); err != nil {
+		log.Warn("label scheduler fails to save the runtime state", errs.ZapError(err))
+	}
+}
+
+type labelHandler struct {
+	rd        *render.Render
+	scheduler *labelScheduler
+}
+
+// ScheduleRegion handles the region given by the admin immediately, and adds
+// the created operator to the waiting queue.
+func (handler *labelHandler) ScheduleRegion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["region_id"], 10, 64)
+	if err != nil {
+		handler.rd.JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := handler.scheduler
+	cluster := s.OpController.GetCluster()
+	if cluster.GetRegion(id) == nil {
+		handler.rd.JSON(w, http.StatusNotFound, errors.Errorf("region %v not found", id).Error())
+		return
+	}
+	ops := s.ScheduleRegion(cluster, id)
+	if len(ops) > 0 {
+		s.OpController.AddWaitingOperator(ops...)
+	}
+	handler.rd.JSON(w, http.StatusOK, ops)
+}
+
+func (handler *labelHandler) UpdateConfig(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	httpCode, v := handler.scheduler.updateConfig(data)
+	handler.rd.JSON(w, httpCode, v)
+}
+
+func (handler *labelHandler) ListConfig(w http.ResponseWriter, r *http.Request) {
+	conf := handler.scheduler.conf
+	conf.mu.RLock()
+	defer conf.mu.RUnlock()
+	handler.rd.JSON(w, http.StatusOK, conf)
+}
+
+func newLabelHandler(s *labelScheduler) http.Handler {
+	h := &labelHandler{
+		scheduler: s,
+		rd:        render.New(render.Options{IndentJSON: true}),
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/config", h.UpdateConfig).Methods(http.MethodPost)
+	router.HandleFunc("/list", h.ListConfig).Methods(http.MethodGet)
+	router.HandleFunc("/region/{region_id}", h.ScheduleRegion).Methods(http.MethodPost)
+	return router
+}
+
 func (s *labelScheduler) IsScheduleAllowed(cluster schedule.Cluster) bool {
+	s.conf.mu.RLock()
+	open := s.isCircuitOpen()
+	s.conf.mu.RUnlock()
+	if open {
+		schedulerCounter.WithLabelValues(s.GetName(), "circuit-open").Inc()
+		return false
+	}
 	allowed := s.OpController.OperatorCount(operator.OpLeader) < cluster.GetOpts().GetLeaderScheduleLimit()
 	if !allowed {
 		operator.OperatorLimitCounter.WithLabelValues(s.GetType(), operator.OpLeader.String()).Inc()
@@ -104,9 +472,9 @@ func (s *labelScheduler) Schedule(cluster schedule.Cluster, dryRun bool) ([]*ope
 	schedulerCounter.WithLabelValues(s.GetName(), "schedule").Inc()
 	stores := cluster.GetStores()
 	rejectLeaderStores := make(map[uint64]struct{})
-	for _, s := range stores {
-		if cluster.GetOpts().CheckLabelProperty(config.RejectLeader, s.GetLabels()) {
-			rejectLeaderStores[s.GetID()] = struct{}{}
+	for _, store := range stores {
+		if s.isRejectLeaderStore(cluster, store) {
+			rejectLeaderStores[store.GetID()] = struct{}{}
 		}
 	}
 	if len(rejectLeaderStores) == 0 {
@@ -114,36 +482,218 @@ func (s *labelScheduler) Schedule(cluster schedule.Cluster, dryRun bool) ([]*ope
 		return nil, nil
 	}
 	log.Debug("label scheduler reject leader store list", zap.Reflect("stores", rejectLeaderStores))
+	if !dryRun {
+		s.settleProposals()
+		s.pruneDeadEndRegions()
+	}
+	var observed []lingeringLeader
 	for id := range rejectLeaderStores {
 		if region := cluster.RandLeaderRegion(id, s.conf.Ranges); region != nil {
-			log.Debug("label scheduler selects region to transfer leader", zap.Uint64("region-id", region.GetID()))
-			excludeStores := make(map[uint64]struct{})
-			for _, p := range region.GetDownPeers() {
-				excludeStores[p.GetPeer().GetStoreId()] = struct{}{}
-			}
-			for _, p := range region.GetPendingPeers() {
-				excludeStores[p.GetStoreId()] = struct{}{}
-			}
-			f := filter.NewExcludedFilter(s.GetName(), nil, excludeStores)
-
-			target := filter.NewCandidates(cluster.GetFollowerStores(region)).
-				FilterTarget(cluster.GetOpts(), &filter.StoreStateFilter{ActionScope: LabelName, TransferLeader: true}, f).
-				RandomPick()
-			if target == nil {
-				log.Debug("label scheduler no target found for region", zap.Uint64("region-id", region.GetID()))
-				schedulerCounter.WithLabelValues(s.GetName(), "no-target").Inc()
-				continue
-			}
-
-			op, err := operator.CreateTransferLeaderOperator("label-reject-leader", cluster, region, id, target.GetID(), []uint64{}, operator.OpLeader)
-			if err != nil {
-				log.Debug("fail to create transfer label reject leader operator", errs.ZapError(err))
-				return nil, nil
-			}
-			op.Counters = append(op.Counters, schedulerCounter.WithLabelValues(s.GetName(), "new-operator"))
-			return []*operator.Operator{op}, nil
+			observed = append(observed, lingeringLeader{regionID: region.GetID(), storeID: id})
+		}
+	}
+	for _, l := range s.lingeringLeaders(cluster, rejectLeaderStores, observed, dryRun) {
+		if s.inCooldown(l.regionID) {
+			continue
+		}
+		log.Debug("label scheduler selects region to transfer leader", zap.Uint64("region-id", l.regionID))
+		op, err := s.transferLeaderOut(cluster, cluster.GetRegion(l.regionID), l.storeID, dryRun)
+		if err != nil {
+			return nil, nil
+		}
+		if op == nil {
+			continue
 		}
+		if !dryRun {
+			s.propose(op)
+		}
+		return []*operator.Operator{op}, nil
 	}
 	schedulerCounter.WithLabelValues(s.GetName(), "no-region").Inc()
 	return nil, nil
 }
+
+// lingeringLeaders records the time the observed leaders are first seen on the
+// reject leader stores, and returns the leaders still on the reject leader
+// stores, the longest lingering first. The leaders which are moved out are
+// forgotten. A dry run works on a copy, so that the state is not changed.
+func (s *labelScheduler) lingeringLeaders(cluster schedule.Cluster, rejectLeaderStores map[uint64]struct{}, observed []lingeringLeader, dryRun bool) []lingeringLeader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lingering := s.lingering
+	if dryRun {
+		lingering = make(map[lingeringLeader]time.Time, len(s.lingering)+len(observed))
+		for l, since := range s.lingering {
+			lingering[l] = since
+		}
+	}
+	now := s.now()
+	for _, l := range observed {
+		if _, ok := lingering[l]; !ok {
+			lingering[l] = now
+		}
+	}
+	leaders := make([]lingeringLeader, 0, len(lingering))
+	for l := range lingering {
+		region := cluster.GetRegion(l.regionID)
+		_, rejected := rejectLeaderStores[l.storeID]
+		if region == nil || region.GetLeader().GetStoreId() != l.storeID || !rejected {
+			delete(lingering, l)
+			continue
+		}
+		leaders = append(leaders, l)
+	}
+	sort.Slice(leaders, func(i, j int) bool {
+		ti, tj := lingering[leaders[i]], lingering[leaders[j]]
+		return ti.Before(tj) || (ti.Equal(tj) && leaders[i].regionID < leaders[j].regionID)
+	})
+	return leaders
+}
+
+// ScheduleRegion applies the reject leader logic to the given region only. It
+// returns nil if the leader of the region is not on a reject leader store, or
+// there is no proper target store.
+func (s *labelScheduler) ScheduleRegion(cluster schedule.Cluster, regionID uint64) []*operator.Operator {
+	region := cluster.GetRegion(regionID)
+	if region == nil || inObserverMode(cluster, s.GetName()) {
+		return nil
+	}
+	s.settleProposals()
+	if s.inCooldown(regionID) {
+		return nil
+	}
+	leaderStore := cluster.GetStore(region.GetLeader().GetStoreId())
+	if leaderStore == nil || !s.isRejectLeaderStore(cluster, leaderStore) {
+		return nil
+	}
+	op, err := s.transferLeaderOut(cluster, region, leaderStore.GetID(), false)
+	if err != nil || op == nil {
+		return nil
+	}
+	s.propose(op)
+	return []*operator.Operator{op}
+}
+
+func (s *labelScheduler) isRejectLeaderStore(cluster schedule.Cluster, store *core.StoreInfo) bool {
+	return s.conf.containsStore(store.GetID()) && s.rejectsLeader(cluster, store)
+}
+
+// rejectsLeader checks whether the store should not host leaders, either by the
+// reject leader label property or by lacking the required label.
+func (s *labelScheduler) rejectsLeader(cluster schedule.Cluster, store *core.StoreInfo) bool {
+	return cluster.GetOpts().CheckLabelProperty(config.RejectLeader, store.GetLabels()) || s.conf.lacksRequiredLabel(store)
+}
+
+// transferLeaderOut creates an operator to transfer the leader of the region
+// out of the source store. It returns nil if there is no proper target store.
+func (s *labelScheduler) transferLeaderOut(cluster schedule.Cluster, region *core.RegionInfo, sourceStoreID uint64, dryRun bool) (*operator.Operator, error) {
+	if s.allFollowersRejectLeader(cluster, region, dryRun) {
+		schedulerCounter.WithLabelValues(s.GetName(), "all-followers-reject").Inc()
+		return nil, nil
+	}
+	if s.OpController.IsPeerClaimed(s.GetName(), region.GetID(), region.GetLeader().GetId()) {
+		schedulerCounter.WithLabelValues(s.GetName(), "peer-in-flight").Inc()
+		return nil, nil
+	}
+	excludeStores := make(map[uint64]struct{})
+	for _, store := range cluster.GetFollowerStores(region) {
+		if isUnhealthyLeaderTarget(store) || s.OpController.IsStoreThrottled(store.GetID()) || s.conf.lacksRequiredLabel(store) {
+			excludeStores[store.GetID()] = struct{}{}
+		}
+	}
+	var fit *placement.RegionFit
+	if cluster.GetOpts().IsPlacementRulesEnabled() {
+		fit = cluster.GetRuleManager().FitRegion(cluster, region)
+	}
+	target := SelectLeaderTransferTarget(cluster, region, fit, filter.NewExcludedFilter(s.GetName(), nil, excludeStores))
+	if target == nil {
+		log.Debug("label scheduler no target found for region", zap.Uint64("region-id", region.GetID()))
+		schedulerCounter.WithLabelValues(s.GetName(), "no-target").Inc()
+		return nil, nil
+	}
+
+	op, err := operator.CreateTransferLeaderOperator(labelRejectLeaderDesc, cluster, region, sourceStoreID, target.GetID(), []uint64{}, operator.OpLeader)
+	if err != nil {
+		log.Debug("fail to create transfer label reject leader operator", errs.ZapError(err))
+		return nil, err
+	}
+	op.SetPeerClaim(s.GetName(), region.GetLeader().GetId())
+	op.Counters = append(op.Counters, schedulerCounter.WithLabelValues(s.GetName(), "new-operator"))
+	return op, nil
+}
+
+// allFollowersRejectLeader checks whether every follower of the region is on a
+// reject leader store. Such a region can never be handled by transferring the
+// leader, which is logged once until the condition is resolved. A dry run
+// neither logs it nor records it.
+func (s *labelScheduler) allFollowersRejectLeader(cluster schedule.Cluster, region *core.RegionInfo, dryRun bool) bool {
+	deadEnd := true
+	for _, store := range cluster.GetFollowerStores(region) {
+		if !s.rejectsLeader(cluster, store) {
+			deadEnd = false
+			break
+		}
+	}
+	if dryRun {
+		return deadEnd
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !deadEnd {
+		delete(s.deadEndRegions, region.GetID())
+		return false
+	}
+	now := s.now()
+	since, ok := s.deadEndRegions[region.GetID()]
+	if ok && now.Sub(since) < deadEndRegionTTL {
+		return true
+	}
+	if !ok && len(s.deadEndRegions) >= maxDeadEndRegions {
+		s.pruneDeadEndRegionsLocked(now)
+		if len(s.deadEndRegions) >= maxDeadEndRegions {
+			s.evictOldestDeadEndRegionLocked()
+		}
+	}
+	s.deadEndRegions[region.GetID()] = now
+	log.Warn("label scheduler cannot move the leader out since all followers are on reject leader stores",
+		zap.Uint64("region-id", region.GetID()),
+		zap.Uint64("leader-store-id", region.GetLeader().GetStoreId()))
+	return true
+}
+
+// pruneDeadEndRegions forgets the dead end regions logged longer than
+// deadEndRegionTTL ago.
+func (s *labelScheduler) pruneDeadEndRegions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneDeadEndRegionsLocked(s.now())
+}
+
+func (s *labelScheduler) pruneDeadEndRegionsLocked(now time.Time) {
+	for id, since := range s.deadEndRegions {
+		if now.Sub(since) >= deadEndRegionTTL {
+			delete(s.deadEndRegions, id)
+		}
+	}
+}
+
+// evictOldestDeadEndRegionLocked forgets the dead end region logged earliest.
+func (s *labelScheduler) evictOldestDeadEndRegionLocked() {
+	var (
+		oldest      uint64
+		oldestSince time.Time
+	)
+	for id, since := range s.deadEndRegions {
+		if oldestSince.IsZero() || since.Before(oldestSince) {
+			oldest, oldestSince = id, since
+		}
+	}
+	delete(s.deadEndRegions, oldest)
+}
+
+// isUnhealthyLeaderTarget checks the store-level health. A store that is slow
+// or long missing heartbeats is a poor leader host, even if the peer of the
+// region on it is healthy.
+func isUnhealthyLeaderTarget(store *core.StoreInfo) bool {
+	return store.IsSlow() || store.IsUnhealthy()
+}
@@ -0,0 +1,552 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedulers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/tikv/pd/pkg/mock/mockcluster"
+	"github.com/tikv/pd/pkg/testutil"
+	"github.com/tikv/pd/pkg/typeutil"
+	"github.com/tikv/pd/server/config"
+	"github.com/tikv/pd/server/core"
+	"github.com/tikv/pd/server/schedule"
+	"github.com/tikv/pd/server/schedule/hbstream"
+	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/placement"
+	"github.com/tikv/pd/server/storage"
+)
+
+var _ = Suite(&testLabelSchedulerSuite{})
+
+type testLabelSchedulerSuite struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	tc     *mockcluster.Cluster
+	oc     *schedule.OperatorController
+}
+
+func (s *testLabelSchedulerSuite) SetUpTest(c *C) {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	opts := config.NewTestOptions()
+	opts.SetLabelPropertyConfig(config.LabelPropertyConfig{
+		config.RejectLeader: {{Key: "noleader", Value: "true"}},
+	})
+	s.tc = mockcluster.NewCluster(s.ctx, opts)
+	s.oc = schedule.NewOperatorController(s.ctx, nil, nil)
+}
+
+func (s *testLabelSchedulerSuite) TearDownTest(c *C) {
+	s.cancel()
+}
+
+func (s *testLabelSchedulerSuite) newScheduler(c *C, args ...string) schedule.Scheduler {
+	if len(args) == 0 {
+		args = []string{"", ""}
+	}
+	sl, err := schedule.CreateScheduler(LabelType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(LabelType, args))
+	c.Assert(err, IsNil)
+	return sl
+}
+
+type mockStoreThrottle map[uint64]struct{}
+
+func (t mockStoreThrottle) IsThrottled(storeID uint64) bool {
+	_, ok := t[storeID]
+	return ok
+}
+
+func (s *testLabelSchedulerSuite) TestThrottledTarget(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	sl := s.newScheduler(c)
+
+	throttle := mockStoreThrottle{2: {}}
+	s.oc.SetStoreThrottle(throttle)
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 3)
+	}
+
+	throttle[3] = struct{}{}
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+}
+
+func (s *testLabelSchedulerSuite) TestUnhealthyTargetStore(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	sl := s.newScheduler(c)
+
+	// The peer on store 2 is healthy for region 1, but store 2 is slow overall.
+	s.tc.PutStore(s.tc.GetStore(2).Clone(core.SetNewStoreStats(&pdpb.StoreStats{SlowScore: 100})))
+	c.Assert(s.tc.GetRegion(1).GetDownPeers(), HasLen, 0)
+	c.Assert(s.tc.GetRegion(1).GetPendingPeers(), HasLen, 0)
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 3)
+	}
+
+	s.tc.PutStore(s.tc.GetStore(3).Clone(core.SetNewStoreStats(&pdpb.StoreStats{SlowScore: 100})))
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+}
+
+func (s *testLabelSchedulerSuite) TestStoreAllowlist(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(2, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderStore(4, 0)
+	s.tc.AddLeaderRegion(1, 1, 3, 4)
+	s.tc.AddLeaderRegion(2, 2, 3, 4)
+	sl := s.newScheduler(c, "", "", "2")
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		c.Assert(ops[0].RegionID(), Equals, uint64(2))
+	}
+
+	// The allowlist is kept after the scheduler is recreated from the persisted config.
+	data, err := sl.EncodeConfig()
+	c.Assert(err, IsNil)
+	sl, err = schedule.CreateScheduler(LabelType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigJSONDecoder(data))
+	c.Assert(err, IsNil)
+	c.Assert(sl.(*labelScheduler).conf.StoreIDs, DeepEquals, []uint64{2})
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		c.Assert(ops[0].RegionID(), Equals, uint64(2))
+	}
+
+	_, err = schedule.CreateScheduler(LabelType, s.oc, storage.NewStorageWithMemoryBackend(), schedule.ConfigSliceDecoder(LabelType, []string{"", "", "a"}))
+	c.Assert(err, NotNil)
+}
+
+func (s *testLabelSchedulerSuite) TestRequiredLabel(c *C) {
+	s.tc.AddLeaderStore(1, 1)
+	s.tc.AddLabelsStore(2, 1, map[string]string{"production": "true"})
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLabelsStore(4, 0, map[string]string{"production": "false"})
+	s.tc.AddLeaderRegion(1, 1, 3, 4)
+	s.tc.AddLeaderRegion(2, 2, 3, 4)
+
+	// No leader is moved by the label presence only.
+	sl := s.newScheduler(c)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+
+	// The leader on store 1 lacking the label is moved to store 4 having it,
+	// while the leader on store 2 is kept.
+	sl = s.newScheduler(c, "", "", "required-label=production")
+	c.Assert(sl.(*labelScheduler).conf.RequiredLabel, Equals, "production")
+	for i := 0; i < 10; i++ {
+		ops, _ = sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 1)
+		testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 4)
+	}
+
+	// The arg works with the store allowlist.
+	sl = s.newScheduler(c, "", "", "required-label=production", "2")
+	c.Assert(sl.(*labelScheduler).conf.StoreIDs, DeepEquals, []uint64{2})
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+}
+
+func (s *testLabelSchedulerSuite) TestRuntimeState(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	stateStorage := storage.NewStorageWithMemoryBackend()
+	create := func() *labelScheduler {
+		sl, err := schedule.CreateScheduler(LabelType, s.oc, stateStorage, schedule.ConfigSliceDecoder(LabelType, []string{"", ""}))
+		c.Assert(err, IsNil)
+		sl.(*labelScheduler).conf.RegionCooldown = typeutil.NewDuration(time.Hour)
+		return sl.(*labelScheduler)
+	}
+
+	sl := create()
+	// Neither a dry run nor an operator not added yet starts the cooldown.
+	ops, _ := sl.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(sl.proposed, HasLen, 0)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(sl.proposed, HasLen, 1)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(sl.cooldowns, HasLen, 0)
+	data, err := stateStorage.LoadScheduleState(sl.GetName())
+	c.Assert(err, IsNil)
+	c.Assert(data, Equals, "")
+
+	// The region is in cooldown once the operator is added.
+	c.Assert(ops[0].Start(), IsTrue)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+	c.Assert(sl.proposed, HasLen, 0)
+
+	// The cooldown still applies after the scheduler is recreated.
+	sl = create()
+	c.Assert(sl.cooldowns, HasLen, 1)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+	c.Assert(sl.ScheduleRegion(s.tc, 1), HasLen, 0)
+
+	// The expired cooldown is not persisted.
+	sl.cooldowns[1] = time.Now().Add(-time.Minute)
+	state, err := sl.EncodeRuntimeState()
+	c.Assert(err, IsNil)
+	c.Assert(sl.DecodeRuntimeState(state), IsNil)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+}
+
+func (s *testLabelSchedulerSuite) TestDryRunKeepsState(c *C) {
+	s.tc.AddLabelsStore(1, 2, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(2, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	// All followers of region 2 are on reject leader stores.
+	s.tc.AddLeaderRegion(2, 1, 2)
+	stateStorage := storage.NewStorageWithMemoryBackend()
+	sched, err := schedule.CreateScheduler(LabelType, s.oc, stateStorage, schedule.ConfigSliceDecoder(LabelType, []string{"", ""}))
+	c.Assert(err, IsNil)
+	sl := sched.(*labelScheduler)
+	sl.conf.RegionCooldown = typeutil.NewDuration(time.Hour)
+	now := time.Now()
+	sl.now = func() time.Time { return now }
+	// An expired cooldown, and an operator added but not settled yet.
+	sl.cooldowns[3] = now.Add(-time.Minute)
+	op, err := sl.transferLeaderOut(s.tc, s.tc.GetRegion(1), 1, false)
+	c.Assert(err, IsNil)
+	c.Assert(op.Start(), IsTrue)
+	sl.proposed[1] = op
+
+	// The operator not settled yet still puts the region in cooldown.
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, true)
+		c.Assert(ops, HasLen, 0)
+	}
+	c.Assert(sl.cooldowns, HasLen, 1)
+	c.Assert(sl.proposed, HasLen, 1)
+	c.Assert(sl.deadEndRegions, HasLen, 0)
+	data, err := stateStorage.LoadScheduleState(sl.GetName())
+	c.Assert(err, IsNil)
+	c.Assert(data, Equals, "")
+
+	// The real run settles the operator and sweeps the expired cooldown.
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+	c.Assert(sl.proposed, HasLen, 0)
+	c.Assert(sl.cooldowns, HasLen, 1)
+	c.Assert(sl.cooldowns, HasKey, uint64(1))
+	data, err = stateStorage.LoadScheduleState(sl.GetName())
+	c.Assert(err, IsNil)
+	c.Assert(data, Not(Equals), "")
+}
+
+func (s *testLabelSchedulerSuite) TestLingeringLeaderFirst(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(2, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderStore(4, 0)
+	s.tc.AddLeaderRegion(2, 2, 4)
+	sl := s.newScheduler(c).(*labelScheduler)
+	now := time.Now()
+	sl.now = func() time.Time { return now }
+
+	// The leader of region 2 is seen first, but it can not be moved out yet.
+	throttle := mockStoreThrottle{4: {}}
+	s.oc.SetStoreThrottle(throttle)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+
+	now = now.Add(time.Minute)
+	delete(throttle, 4)
+	s.tc.AddLeaderRegion(1, 1, 3)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 2, 4)
+	c.Assert(sl.lingering, HasLen, 2)
+
+	// The leader moved out is forgotten.
+	s.tc.AddLeaderRegion(2, 4, 2)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 3)
+	c.Assert(sl.lingering, HasLen, 1)
+
+	// A dry run does not record the leaders seen.
+	s.tc.AddLeaderRegion(3, 2, 4)
+	ops, _ = sl.Schedule(s.tc, true)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(sl.lingering, HasLen, 1)
+}
+
+func (s *testLabelSchedulerSuite) TestObserverMode(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	sl := s.newScheduler(c).(*labelScheduler)
+
+	// The region given by the admin is not scheduled in observer mode.
+	s.tc.SetPlacementObserverModeEnabled(true)
+	c.Assert(sl.ScheduleRegion(s.tc, 1), HasLen, 0)
+
+	s.tc.SetPlacementObserverModeEnabled(false)
+	c.Assert(sl.ScheduleRegion(s.tc, 1), HasLen, 1)
+}
+
+func (s *testLabelSchedulerSuite) TestCircuitBreaker(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	s.oc = schedule.NewOperatorController(s.ctx, s.tc, hbstream.NewTestHeartbeatStreams(s.ctx, s.tc.ID, s.tc, false))
+	sl := s.newScheduler(c).(*labelScheduler)
+	sl.EnableCircuitBreaker(labelRejectLeaderDesc, 2, time.Minute)
+	defer sl.Cleanup(s.tc)
+	now := time.Now()
+	sl.breaker.now = func() time.Time { return now }
+	add := func() *operator.Operator {
+		ops := sl.ScheduleRegion(s.tc, 1)
+		c.Assert(ops, HasLen, 1)
+		c.Assert(s.oc.AddOperator(ops[0]), IsTrue)
+		return ops[0]
+	}
+	// The operator fails since the target peer is gone.
+	fail := func() {
+		op := add()
+		target := op.Step(0).(operator.TransferLeader).ToStore
+		s.oc.Dispatch(s.tc.GetRegion(1).Clone(core.WithRemoveStorePeer(target)), schedule.DispatchFromHeartBeat)
+		c.Assert(op.Status(), Equals, operator.CANCELED)
+	}
+
+	// The operators canceled for other reasons are not counted.
+	for i := 0; i < 3; i++ {
+		c.Assert(s.oc.RemoveOperator(add()), IsTrue)
+	}
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsTrue)
+	// A finished operator resets the count of failures.
+	fail()
+	sl.breaker.outcomes <- schedule.OperatorOutcome{Status: operator.SUCCESS}
+	fail()
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsTrue)
+	// The scheduler backs off after 2 failures in a row.
+	fail()
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsFalse)
+	now = now.Add(30 * time.Second)
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsFalse)
+	now = now.Add(30 * time.Second)
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsTrue)
+	// The breaker is reset once the cooldown is over.
+	fail()
+	c.Assert(sl.IsScheduleAllowed(s.tc), IsTrue)
+}
+
+func (s *testLabelSchedulerSuite) TestUpdateConfig(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	s.oc = schedule.NewOperatorController(s.ctx, s.tc, hbstream.NewTestHeartbeatStreams(s.ctx, s.tc.ID, s.tc, false))
+	confStorage := storage.NewStorageWithMemoryBackend()
+	ls, err := schedule.CreateScheduler(LabelType, s.oc, confStorage, schedule.ConfigSliceDecoder(LabelType, []string{"", ""}))
+	c.Assert(err, IsNil)
+	sl := ls.(*labelScheduler)
+	defer sl.Cleanup(s.tc)
+	c.Assert(sl.breaker, IsNil)
+
+	code, _ := sl.updateConfig([]byte(`{"min-run-interval":"1m","region-cooldown":"1h","failure-threshold":2,"failure-cooldown":"5m"}`))
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(sl.GetMinRunInterval(), Equals, time.Minute)
+	c.Assert(sl.conf.getRegionCooldown(), Equals, time.Hour)
+	c.Assert(sl.breaker, NotNil)
+	c.Assert(sl.breaker.threshold, Equals, 2)
+	c.Assert(sl.breaker.cooldown, Equals, 5*time.Minute)
+	// The config is persisted.
+	_, data, err := confStorage.LoadAllScheduleConfig()
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 1)
+	conf := &labelSchedulerConfig{}
+	c.Assert(schedule.DecodeConfig([]byte(data[0]), conf), IsNil)
+	c.Assert(conf.FailureThreshold, Equals, 2)
+
+	code, _ = sl.updateConfig([]byte(`{"failure-threshold":2}`))
+	c.Assert(code, Equals, http.StatusOK)
+	code, _ = sl.updateConfig([]byte(`{"unknown":2}`))
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = sl.updateConfig([]byte(`{"failure-threshold":-1}`))
+	c.Assert(code, Equals, http.StatusBadRequest)
+	code, _ = sl.updateConfig([]byte(`{"required-label":"zone"}`))
+	c.Assert(code, Equals, http.StatusBadRequest)
+	c.Assert(sl.conf.RequiredLabel, Equals, "")
+	c.Assert(sl.conf.FailureThreshold, Equals, 2)
+
+	// The breaker is removed once the threshold is reset.
+	code, _ = sl.updateConfig([]byte(`{"failure-threshold":0}`))
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(sl.breaker, IsNil)
+}
+
+func (s *testLabelSchedulerSuite) TestScheduleRegion(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 1)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	s.tc.AddLeaderRegion(2, 2, 1, 3)
+	sl := s.newScheduler(c).(*labelScheduler)
+
+	// The leader of region 1 is on a reject leader store.
+	ops := sl.ScheduleRegion(s.tc, 1)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(ops[0].RegionID(), Equals, uint64(1))
+	c.Assert(ops[0].Step(0).(operator.TransferLeader).FromStore, Equals, uint64(1))
+	// The leader of region 2 is on a normal store.
+	c.Assert(sl.ScheduleRegion(s.tc, 2), HasLen, 0)
+	// The region does not exist.
+	c.Assert(sl.ScheduleRegion(s.tc, 3), HasLen, 0)
+}
+
+func (s *testLabelSchedulerSuite) TestAllFollowersRejectLeader(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(2, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(3, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(4, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	sl := s.newScheduler(c).(*labelScheduler)
+
+	for i := 0; i < 10; i++ {
+		ops, _ := sl.Schedule(s.tc, false)
+		c.Assert(ops, HasLen, 0)
+	}
+	c.Assert(sl.deadEndRegions, HasLen, 1)
+	c.Assert(sl.ScheduleRegion(s.tc, 1), HasLen, 0)
+
+	// The region is no longer a dead end after a follower is moved to a normal store.
+	s.tc.AddLeaderRegion(1, 1, 2, 4)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 4)
+	c.Assert(sl.deadEndRegions, HasLen, 0)
+}
+
+func (s *testLabelSchedulerSuite) TestDeadEndRegionsPruned(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(2, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLabelsStore(3, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	sl := s.newScheduler(c).(*labelScheduler)
+	now := time.Now()
+	sl.now = func() time.Time { return now }
+
+	c.Assert(sl.allFollowersRejectLeader(s.tc, s.tc.GetRegion(1), false), IsTrue)
+	c.Assert(sl.deadEndRegions, HasLen, 1)
+	// The region is forgotten once the TTL passes.
+	now = now.Add(deadEndRegionTTL)
+	sl.pruneDeadEndRegions()
+	c.Assert(sl.deadEndRegions, HasLen, 0)
+
+	// The oldest region is evicted once the map is full.
+	for id := uint64(2); id < maxDeadEndRegions+2; id++ {
+		sl.deadEndRegions[id] = now.Add(time.Duration(id) * time.Millisecond)
+	}
+	now = now.Add(time.Minute)
+	c.Assert(sl.allFollowersRejectLeader(s.tc, s.tc.GetRegion(1), false), IsTrue)
+	c.Assert(sl.deadEndRegions, HasLen, maxDeadEndRegions)
+	c.Assert(sl.deadEndRegions, HasKey, uint64(1))
+	c.Assert(sl.deadEndRegions, Not(HasKey), uint64(2))
+	c.Assert(sl.deadEndRegions, HasKey, uint64(3))
+}
+
+func (s *testLabelSchedulerSuite) TestPeerOperatorInFlight(c *C) {
+	s.tc.AddLabelsStore(1, 1, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(2, 0)
+	s.tc.AddLeaderStore(3, 0)
+	s.tc.AddLeaderRegion(1, 1, 2, 3)
+	s.oc = schedule.NewOperatorController(s.ctx, s.tc, hbstream.NewTestHeartbeatStreams(s.ctx, s.tc.ID, s.tc, false))
+	sl := s.newScheduler(c)
+	region := s.tc.GetRegion(1)
+	leaderID := region.GetLeader().GetId()
+
+	// Another scheduler is moving the leader peer.
+	op, err := operator.CreateTransferLeaderOperator("fix-placement", s.tc, region, 1, 2, []uint64{}, operator.OpLeader)
+	c.Assert(err, IsNil)
+	op.SetPeerClaim("fix-placement", leaderID)
+	c.Assert(s.oc.AddOperator(op), IsTrue)
+	c.Assert(s.oc.IsPeerClaimed(sl.GetName(), 1, leaderID), IsTrue)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 0)
+
+	// The label scheduler takes over once the operator is finished, and its
+	// claim is registered only after its operator is added.
+	c.Assert(s.oc.RemoveOperator(op), IsTrue)
+	c.Assert(s.oc.IsPeerClaimed(sl.GetName(), 1, leaderID), IsFalse)
+	ops, _ = sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	c.Assert(s.oc.IsPeerClaimed("fix-placement", 1, leaderID), IsFalse)
+	c.Assert(s.oc.AddOperator(ops[0]), IsTrue)
+	c.Assert(s.oc.IsPeerClaimed("fix-placement", 1, leaderID), IsTrue)
+	c.Assert(s.oc.IsPeerClaimed(sl.GetName(), 1, leaderID), IsFalse)
+}
+
+func (s *testLabelSchedulerSuite) TestSelectLeaderTransferTarget(c *C) {
+	s.tc.AddLabelsStore(1, 10, map[string]string{"noleader": "true", "leader": "ok"})
+	s.tc.UpdateLeaderCount(1, 10)
+	// Store 2 has the fewest leaders, but it rejects leaders.
+	s.tc.AddLabelsStore(2, 0, map[string]string{"noleader": "true"})
+	s.tc.AddLeaderStore(3, 5)
+	s.tc.AddLabelsStore(4, 8, map[string]string{"leader": "ok"})
+	s.tc.UpdateLeaderCount(4, 8)
+	region := s.tc.AddLeaderRegion(1, 1, 2, 3, 4)
+
+	target := SelectLeaderTransferTarget(s.tc, region, nil)
+	c.Assert(target, NotNil)
+	c.Assert(target.GetID(), Equals, uint64(3))
+
+	// Store 3 makes the fit worse once the leader is required to be on the
+	// stores labeled with leader=ok.
+	c.Assert(s.tc.RuleManager.SetRule(&placement.Rule{
+		GroupID: "pd", ID: "leader", Role: placement.Leader, Count: 1,
+		LabelConstraints: []placement.LabelConstraint{{Key: "leader", Op: placement.In, Values: []string{"ok"}}},
+	}), IsNil)
+	fit := s.tc.RuleManager.FitRegion(s.tc, region)
+	c.Assert(fit.IsSatisfied(), IsTrue)
+	target = SelectLeaderTransferTarget(s.tc, region, fit)
+	c.Assert(target, NotNil)
+	c.Assert(target.GetID(), Equals, uint64(4))
+
+	// The label scheduler selects the same target.
+	sl := s.newScheduler(c)
+	ops, _ := sl.Schedule(s.tc, false)
+	c.Assert(ops, HasLen, 1)
+	testutil.CheckTransferLeader(c, ops[0], operator.OpLeader, 1, 4)
+
+	// There is no target once store 4 is disconnected.
+	s.tc.SetStoreDisconnect(4)
+	c.Assert(SelectLeaderTransferTarget(s.tc, region, fit), IsNil)
+}
@@ -16,6 +16,7 @@ package schedulers
 
 import (
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
@@ -23,7 +24,9 @@ import (
 	"github.com/tikv/pd/pkg/errs"
 	"github.com/tikv/pd/server/core"
 	"github.com/tikv/pd/server/schedule"
+	"github.com/tikv/pd/server/schedule/filter"
 	"github.com/tikv/pd/server/schedule/operator"
+	"github.com/tikv/pd/server/schedule/placement"
 	"github.com/tikv/pd/server/statistics"
 	"go.uber.org/zap"
 )
@@ -36,6 +39,7 @@ const (
 	influenceAmp                 int64   = 100
 	defaultMinRetryLimit                 = 1
 	defaultRetryQuotaAttenuation         = 2
+	leaderTransferTargetScope            = "leader-transfer-target"
 )
 
 type balancePlan struct {
@@ -179,6 +183,62 @@ func adjustTolerantRatio(cluster schedule.Cluster, kind core.ScheduleKind) float
 	return tolerantSizeRatio
 }
 
+// inObserverMode checks whether the placement is read-only, in which the
+// scheduler must not create any operator. The coordinator stops all the
+// schedulers in observer mode, so it is only checked by the paths bypassing
+// the coordinator, such as the admin API.
+func inObserverMode(cluster schedule.Cluster, name string) bool {
+	if !cluster.GetOpts().IsPlacementObserverModeEnabled() {
+		return false
+	}
+	schedulerCounter.WithLabelValues(name, "observer-mode").Inc()
+	return true
+}
+
+// SelectLeaderTransferTarget selects the follower store to transfer the leader
+// of the region to, which has the lowest leader score to balance the leaders.
+// The stores rejecting leaders, the stores of the down or pending peers and
+// the stores filtered out by the extra filters are skipped, and so are the
+// stores which make the fit worse than currentFit. currentFit is nil if
+// placement rules are disabled. It returns nil if there is no proper target.
+func SelectLeaderTransferTarget(cluster schedule.Cluster, region *core.RegionInfo, currentFit *placement.RegionFit, filters ...filter.Filter) *core.StoreInfo {
+	excludeStores := make(map[uint64]struct{})
+	for _, p := range region.GetDownPeers() {
+		excludeStores[p.GetPeer().GetStoreId()] = struct{}{}
+	}
+	for _, p := range region.GetPendingPeers() {
+		excludeStores[p.GetStoreId()] = struct{}{}
+	}
+	filters = append([]filter.Filter{
+		&filter.StoreStateFilter{ActionScope: leaderTransferTargetScope, TransferLeader: true},
+		filter.NewExcludedFilter(leaderTransferTargetScope, nil, excludeStores),
+	}, filters...)
+	opts := cluster.GetOpts()
+	targets := filter.SelectTargetStores(cluster.GetFollowerStores(region), filters, opts)
+	policy := opts.GetLeaderSchedulePolicy()
+	sort.Slice(targets, func(i, j int) bool {
+		si, sj := targets[i].LeaderScore(policy, 0), targets[j].LeaderScore(policy, 0)
+		return si < sj || (si == sj && targets[i].GetID() < targets[j].GetID())
+	})
+	for _, target := range targets {
+		if currentFit != nil && !keepsFitAfterLeaderTransfer(cluster, region, currentFit, target) {
+			continue
+		}
+		return target
+	}
+	return nil
+}
+
+// keepsFitAfterLeaderTransfer checks whether the fit of the region is not worse
+// after the leader is transferred to the target store.
+func keepsFitAfterLeaderTransfer(cluster schedule.Cluster, region *core.RegionInfo, currentFit *placement.RegionFit, target *core.StoreInfo) bool {
+	if region.GetStorePeer(target.GetID()) == nil {
+		return false
+	}
+	newFit := cluster.GetRuleManager().FitRegionAssumingLeader(cluster, region, target.GetID())
+	return placement.CompareRegionFit(currentFit, newFit) <= 0
+}
+
 func getKeyRanges(args []string) ([]core.KeyRange, error) {
 	var ranges []core.KeyRange
 	for len(args) > 1 {
@@ -171,7 +171,7 @@ func (r *RegionStatistics) Observe(region *core.RegionInfo, stores []*core.Store
 		rules := r.ruleManager.GetRulesForApplyRegion(region)
 		for _, rule := range rules {
 			desiredReplicas += rule.Count
-			if rule.Role != placement.Learner {
+			if !rule.Role.IsNonVoting() {
 				desiredVoters += rule.Count
 			}
 		}
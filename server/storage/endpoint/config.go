@@ -29,6 +29,9 @@ type ConfigStorage interface {
 	LoadAllScheduleConfig() ([]string, []string, error)
 	SaveScheduleConfig(scheduleName string, data []byte) error
 	RemoveScheduleConfig(scheduleName string) error
+	LoadScheduleState(scheduleName string) (string, error)
+	SaveScheduleState(scheduleName string, data []byte) error
+	RemoveScheduleState(scheduleName string) error
 }
 
 var _ ConfigStorage = (*StorageEndpoint)(nil)
@@ -74,3 +77,18 @@ func (se *StorageEndpoint) SaveScheduleConfig(scheduleName string, data []byte)
 func (se *StorageEndpoint) RemoveScheduleConfig(scheduleName string) error {
 	return se.Remove(scheduleConfigPath(scheduleName))
 }
+
+// LoadScheduleState loads the runtime state of scheduler.
+func (se *StorageEndpoint) LoadScheduleState(scheduleName string) (string, error) {
+	return se.Load(scheduleStatePath(scheduleName))
+}
+
+// SaveScheduleState saves the runtime state of scheduler.
+func (se *StorageEndpoint) SaveScheduleState(scheduleName string, data []byte) error {
+	return se.Save(scheduleStatePath(scheduleName), string(data))
+}
+
+// RemoveScheduleState removes the runtime state of scheduler.
+func (se *StorageEndpoint) RemoveScheduleState(scheduleName string) error {
+	return se.Remove(scheduleStatePath(scheduleName))
+}
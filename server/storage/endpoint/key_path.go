@@ -28,8 +28,10 @@ const (
 	rulesPath                  = "rules"
 	ruleGroupPath              = "rule_group"
 	regionLabelPath            = "region_label"
+	ruleOverridePath           = "rule_override"
 	replicationPath            = "replication_mode"
 	customScheduleConfigPath   = "scheduler_config"
+	customScheduleStatePath    = "scheduler_state"
 	gcWorkerServiceSafePointID = "gc_worker"
 	minResolvedTS              = "min_resolved_ts"
 	keySpaceSafePointPrefix    = "key_space/gc_safepoint"
@@ -55,6 +57,10 @@ func scheduleConfigPath(scheduleName string) string {
 	return path.Join(customScheduleConfigPath, scheduleName)
 }
 
+func scheduleStatePath(scheduleName string) string {
+	return path.Join(customScheduleStatePath, scheduleName)
+}
+
 // StorePath returns the store meta info key path with the given store ID.
 func StorePath(storeID uint64) string {
 	return path.Join(clusterPath, "s", fmt.Sprintf("%020d", storeID))
@@ -81,6 +87,10 @@ func ruleGroupIDPath(groupID string) string {
 	return path.Join(ruleGroupPath, groupID)
 }
 
+func ruleOverrideRegionPath(regionID uint64) string {
+	return path.Join(ruleOverridePath, fmt.Sprintf("%020d", regionID))
+}
+
 func regionLabelKeyPath(ruleKey string) string {
 	return path.Join(regionLabelPath, ruleKey)
 }
@@ -15,6 +15,7 @@
 package endpoint
 
 import (
+	"fmt"
 	"strings"
 
 	"go.etcd.io/etcd/clientv3"
@@ -31,6 +32,9 @@ type RuleStorage interface {
 	LoadRegionRules(f func(k, v string)) error
 	SaveRegionRule(ruleKey string, rule interface{}) error
 	DeleteRegionRule(ruleKey string) error
+	LoadRuleOverrides(f func(k, v string)) error
+	SaveRuleOverride(regionID uint64, rules interface{}) error
+	DeleteRuleOverride(regionID uint64) error
 }
 
 var _ RuleStorage = (*StorageEndpoint)(nil)
@@ -75,6 +79,23 @@ func (se *StorageEndpoint) DeleteRegionRule(ruleKey string) error {
 	return se.Remove(regionLabelKeyPath(ruleKey))
 }
 
+// LoadRuleOverrides loads the rules overriding the placement of the regions
+// from storage. The keys are the region IDs.
+func (se *StorageEndpoint) LoadRuleOverrides(f func(k, v string)) error {
+	return se.loadRangeByPrefix(ruleOverridePath+"/", f)
+}
+
+// SaveRuleOverride stores the rules overriding the placement of the region.
+func (se *StorageEndpoint) SaveRuleOverride(regionID uint64, rules interface{}) error {
+	return se.saveJSON(ruleOverridePath, fmt.Sprintf("%020d", regionID), rules)
+}
+
+// DeleteRuleOverride removes the rules overriding the placement of the region
+// from storage.
+func (se *StorageEndpoint) DeleteRuleOverride(regionID uint64) error {
+	return se.Remove(ruleOverrideRegionPath(regionID))
+}
+
 // LoadRules loads placement rules from storage.
 func (se *StorageEndpoint) LoadRules(f func(k, v string)) error {
 	return se.loadRangeByPrefix(rulesPath+"/", f)